@@ -2,21 +2,10 @@ package app
 
 import (
 	"bookget/config"
-	"bookget/pkg/chttp"
+	"bookget/pkg/httpclient"
 )
 
-func BuildRequestHeader() map[string]string {
-	httpHeaders := map[string]string{"User-Agent": config.Conf.UserAgent}
-	cookies, _ := chttp.ReadCookiesFromFile(config.Conf.CookieFile)
-	if cookies != "" {
-		httpHeaders["Cookie"] = cookies
-	}
-
-	headers, err := chttp.ReadHeadersFromFile(config.Conf.HeaderFile)
-	if err == nil {
-		for key, value := range headers {
-			httpHeaders[key] = value
-		}
-	}
-	return httpHeaders
+// BuildRequestHeader 按 user-agent、cookies 与 headers 文件生成请求头
+func BuildRequestHeader(opts *config.Input) map[string]string {
+	return httpclient.Headers(opts)
 }
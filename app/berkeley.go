@@ -3,36 +3,53 @@ package app
 import (
 	"bookget/config"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"time"
 )
 
 type Berkeley struct {
 	dt *DownloadTask
+
+	ctx  context.Context
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:       "berkeley",
+		Name:     "[美國]柏克萊加州大學東亞圖書館",
+		Country:  "US",
+		Hosts:    []string{"digicoll.lib.berkeley.edu"},
+		Examples: []string{"https://digicoll.lib.berkeley.edu/record/{id}"},
+		New:      func(ctx context.Context, opts *config.Input) RouterInit { return NewBerkeley(ctx, opts) },
+	})
 }
 
-func NewBerkeley() *Berkeley {
+func NewBerkeley(ctx context.Context, opts *config.Input) *Berkeley {
 	return &Berkeley{
 		// 初始化字段
-		dt: new(DownloadTask),
+		ctx:  ctx,
+		opts: opts,
+		dt:   new(DownloadTask),
 	}
 }
 
-func (r *Berkeley) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Berkeley) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 type BerkeleyResponse struct {
@@ -46,6 +63,7 @@ func (r *Berkeley) Run(sUrl string) (msg string, err error) {
 	r.dt.UrlParsed, err = url.Parse(sUrl)
 	r.dt.Url = sUrl
 	r.dt.BookId = r.getBookId(r.dt.Url)
+	r.res.SetBook(r.dt.BookId, "")
 	if r.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -62,14 +80,14 @@ func (r *Berkeley) getBookId(sUrl string) (bookId string) {
 }
 
 func (r *Berkeley) download() (msg string, err error) {
-	log.Printf("Get %s\n", r.dt.Url)
+	r.res.Log().Info("获取图书信息", logger.KeyUrl, r.dt.Url)
 
-	r.dt.SavePath = config.Conf.Directory
+	r.dt.SavePath = r.opts.Directory
 	canvases, err := r.getCanvases(r.dt.Url, r.dt.Jar)
 	if err != nil || canvases == nil {
 		return "requested URL was not found.", err
 	}
-	log.Printf(" %d files \n", len(canvases))
+	r.res.Log().Info("解析完成", "files", len(canvases))
 	r.do(canvases)
 	return "", nil
 }
@@ -82,31 +100,32 @@ func (r *Berkeley) do(canvases []string) (msg string, err error) {
 	referer := r.dt.Url
 	size := len(canvases)
 	for i, dUrl := range canvases {
-		if dUrl == "" || !config.PageRange(i, size) {
+		if dUrl == "" || !r.opts.PageRange(i, size) {
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
 		ext := filepath.Ext(dUrl)
 		filename := sortId + ext
 		dest := path.Join(r.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !r.res.Pending(dUrl, dest) {
 			continue
 		}
-		log.Printf("Get %d/%d,  URL: %s\n", i+1, size, dUrl)
-		ctx := context.Background()
+		r.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", size, logger.KeyUrl, dUrl)
+		ctx := r.ctx
 		opts := gohttp.Options{
 			DestFile:    dest,
 			Overwrite:   false,
 			Concurrency: 1,
-			CookieFile:  config.Conf.CookieFile,
-			HeaderFile:  config.Conf.HeaderFile,
+			CookieFile:  r.opts.CookieFile,
+			HeaderFile:  r.opts.HeaderFile,
 			CookieJar:   r.dt.Jar,
 			Headers: map[string]interface{}{
-				"User-Agent": config.Conf.UserAgent,
+				"User-Agent": r.opts.UserAgent,
 				"Referer":    referer,
 			},
 		}
-		gohttp.FastGet(ctx, dUrl, opts)
+		_, err := gohttp.FastGet(ctx, dUrl, opts)
+		r.res.Record(sortId, dUrl, dest, err)
 		fmt.Println()
 	}
 	return "", err
@@ -120,7 +139,7 @@ func (r *Berkeley) getVolumes(sUrl string, jar *cookiejar.Jar) (volumes []string
 func (r *Berkeley) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []string, err error) {
 
 	apiUrl := "https://" + r.dt.UrlParsed.Host + "/api/v1/file?recid=" + r.dt.BookId +
-		"&file_types=%5B%5D&hidden_types=%5B%22pdf%3Bpdfa%22%2C%22hocr%22%5D&ln=en&hr=1&_=" + string(time.Now().Unix())
+		"&file_types=%5B%5D&hidden_types=%5B%22pdf%3Bpdfa%22%2C%22hocr%22%5D&ln=en&hr=1&_=" + strconv.FormatInt(time.Now().Unix(), 10)
 	bs, err := r.getBody(apiUrl, jar)
 	if err != nil {
 		return
@@ -128,7 +147,7 @@ func (r *Berkeley) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []stri
 
 	var resT = make([]BerkeleyResponse, 0, 64)
 	if err = json.Unmarshal(bs, &resT); err != nil {
-		log.Printf("json.Unmarshal failed: %s\n", err)
+		r.res.Log().Error("解析 JSON 失败", "error", err)
 		return
 	}
 	for _, ret := range resT {
@@ -139,12 +158,12 @@ func (r *Berkeley) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []stri
 
 func (r *Berkeley) getBody(apiUrl string, jar *cookiejar.Jar) ([]byte, error) {
 	referer := url.QueryEscape(apiUrl)
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": r.opts.UserAgent,
 			"Referer":    referer,
 		},
 	})
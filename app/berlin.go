@@ -5,21 +5,19 @@ import (
 	"bookget/model/iiif"
 	"bookget/pkg/chttp"
 	"bookget/pkg/downloader"
+	"bookget/pkg/httpclient"
+	"bookget/pkg/logger"
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
-	"net/http/cookiejar"
 	"net/url"
 	"os"
 	"path"
 	"regexp"
 	"strings"
-	"time"
 )
 
 type Berlin struct {
@@ -38,39 +36,45 @@ type Berlin struct {
 	parsedUrl *url.URL
 	savePath  string
 	bookId    string
+
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:           "berlin",
+		Name:         "[德国]柏林国立图书馆",
+		Country:      "DE",
+		Hosts:        []string{"digital.staatsbibliothek-berlin.de"},
+		Capabilities: CapIIIF | CapDZI,
+		Examples:     []string{"https://digital.staatsbibliothek-berlin.de/werkansicht?PPN={id}"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewBerlin(ctx, opts) },
+	})
 }
 
-func NewBerlin() *Berlin {
-	ctx, cancel := context.WithCancel(context.Background())
-	dm := downloader.NewDownloadManager(ctx, cancel, config.Conf.MaxConcurrent)
+func NewBerlin(ctx context.Context, opts *config.Input) *Berlin {
+	ctx, cancel := context.WithCancel(ctx)
+	dm := downloader.NewDownloadManager(ctx, cancel, opts.MaxConcurrent)
+	dm.SetRetries(opts.Retries)
 
-	// 创建自定义 Transport 忽略 SSL 验证
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-	}
-	jar, _ := cookiejar.New(nil)
 	return &Berlin{
 		// 初始化字段
+		opts:   opts,
 		dm:     dm,
-		client: &http.Client{Timeout: config.Conf.Timeout * time.Second, Jar: jar, Transport: tr},
+		client: httpclient.ForSite(opts),
 		ctx:    ctx,
 		cancel: cancel,
 	}
 }
 
-func (r *Berlin) GetRouterInit(rawUrl string) (map[string]interface{}, error) {
+func (r *Berlin) GetRouterInit(rawUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, rawUrl)
 	r.rawUrl = rawUrl
 	r.parsedUrl, _ = url.Parse(rawUrl)
 	err := r.Run()
-	if err != nil {
-		return nil, err
-	}
-	return map[string]interface{}{
-		"type": "",
-		"url":  rawUrl,
-	}, nil
+	r.res.SetBook(r.bookId, "")
+	return r.res.Finish("", err)
 }
 
 func (r *Berlin) getBookId(sUrl string) (bookId string) {
@@ -83,10 +87,11 @@ func (r *Berlin) getBookId(sUrl string) (bookId string) {
 
 func (r *Berlin) Run() (err error) {
 	r.bookId = r.getBookId(r.rawUrl)
+	r.res.SetBook(r.bookId, "")
 	if r.bookId == "" {
 		return err
 	}
-	r.savePath = config.Conf.Directory
+	r.savePath = r.opts.Directory
 	r.urlsFile = path.Join(r.savePath, "urls.txt")
 
 	apiUrl := fmt.Sprintf("https://content.staatsbibliothek-berlin.de/dc/%s/manifest", r.bookId)
@@ -96,7 +101,7 @@ func (r *Berlin) Run() (err error) {
 	}
 	r.do(canvases)
 
-	err = os.WriteFile(r.urlsFile, []byte(r.bufBuilder.String()), os.ModePerm)
+	err = writeFile(r.opts, r.urlsFile, []byte(r.bufBuilder.String()), os.ModePerm)
 	if err != nil {
 		return err
 	}
@@ -116,15 +121,15 @@ func (r *Berlin) do(canvases []string) (err error) {
 	}
 	size := len(canvases)
 	// 创建下载器实例
-	iiifDownloader := downloader.NewIIIFDownloader(&config.Conf)
+	iiifDownloader := downloader.NewIIIFDownloader(r.opts)
 	for i, uri := range canvases {
-		if uri == "" || !config.PageRange(i, size) {
+		if uri == "" || !r.opts.PageRange(i, size) {
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
-		filename := sortId + config.Conf.FileExt
+		filename := sortId + r.opts.FileExt
 		dest := path.Join(r.savePath, filename)
-		if FileExist(dest) {
+		if !r.res.Pending(uri, dest) {
 			continue
 		}
 		r.bufBody, err = r.getBody(uri)
@@ -135,8 +140,9 @@ func (r *Berlin) do(canvases []string) (err error) {
 		r.bufBuilder.Write(r.bufBody)
 		r.bufBuilder.WriteString("\n")
 
-		log.Printf("Get %d/%d  %s\n", i+1, size, dziUrl)
-		iiifDownloader.Dezoomify(r.ctx, dziUrl, dest, args)
+		r.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", size, logger.KeyUrl, dziUrl)
+		err := iiifDownloader.Dezoomify(r.ctx, dziUrl, dest, args)
+		r.res.Record(sortId, dziUrl, dest, err)
 	}
 	return nil
 }
@@ -148,7 +154,7 @@ func (r *Berlin) getCanvases(sUrl string) (canvases []string, err error) {
 	}
 	var manifest = new(iiif.ManifestResponse)
 	if err = json.Unmarshal(bs, manifest); err != nil {
-		log.Printf("json.Unmarshal failed: %s\n", err)
+		r.res.Log().Error("解析 JSON 失败", "error", err)
 		return
 	}
 	if len(manifest.Sequences) == 0 {
@@ -173,12 +179,12 @@ func (r *Berlin) getBody(rawUrl string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", config.Conf.UserAgent)
-	cookies, _ := chttp.ReadCookiesFromFile(config.Conf.CookieFile)
+	req.Header.Set("User-Agent", r.opts.UserAgent)
+	cookies, _ := chttp.ReadCookiesFromFile(r.opts.CookieFile)
 	if cookies != "" {
 		req.Header.Set("Cookie", cookies)
 	}
-	headers, err := chttp.ReadHeadersFromFile(config.Conf.HeaderFile)
+	headers, err := chttp.ReadHeadersFromFile(r.opts.HeaderFile)
 	if err == nil {
 		for key, value := range headers {
 			req.Header.Set(key, value)
@@ -190,7 +196,7 @@ func (r *Berlin) getBody(rawUrl string) ([]byte, error) {
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			log.Printf("close body err=%v", err)
+			r.res.Log().Warn("关闭响应失败", "error", err)
 		}
 	}()
 
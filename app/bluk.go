@@ -4,11 +4,11 @@ import (
 	"bookget/config"
 	"bookget/pkg/downloader"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
 	"bookget/pkg/util"
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"path"
@@ -20,28 +20,44 @@ import (
 type Bluk struct {
 	dt  *DownloadTask
 	ctx context.Context
+
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:           "bluk",
+		Name:         "[英国]图书馆文本手稿",
+		Country:      "GB",
+		Hosts:        []string{"bl.uk"},
+		Capabilities: CapDZI,
+		Examples:     []string{"http://www.bl.uk/manuscripts/Viewer.aspx?ref={id}"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewBluk(ctx, opts) },
+	})
 }
 
-func NewBluk() *Bluk {
+func NewBluk(ctx context.Context, opts *config.Input) *Bluk {
 	return &Bluk{
 		// 初始化字段
-		dt:  new(DownloadTask),
-		ctx: context.Background(),
+		opts: opts,
+		dt:   new(DownloadTask),
+		ctx:  ctx,
 	}
 }
 
-func (r *Bluk) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Bluk) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (r *Bluk) Run(sUrl string) (msg string, err error) {
 	r.dt.UrlParsed, err = url.Parse(sUrl)
 	r.dt.Url = sUrl
 	r.dt.BookId = r.getBookId(r.dt.Url)
+	r.res.SetBook(r.dt.BookId, "")
 	if r.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -58,38 +74,39 @@ func (r *Bluk) getBookId(sUrl string) (bookId string) {
 }
 
 func (r *Bluk) download() (msg string, err error) {
-	log.Printf("Get %s\n", r.dt.Url)
+	r.res.Log().Info("获取图书信息", logger.KeyUrl, r.dt.Url)
 
 	respVolume, err := r.getVolumes(r.dt.Url, r.dt.Jar)
+	r.res.SetVolumes(len(respVolume))
 	if err != nil {
-		fmt.Println(err)
+		r.res.Log().Error("获取册列表失败", "error", err)
 		return "getVolumes", err
 	}
 	sizeVol := len(respVolume)
 	for i, vol := range respVolume {
-		if !config.VolumeRange(i) {
+		if !r.opts.VolumeRange(i) {
 			continue
 		}
 		if sizeVol == 1 {
-			r.dt.SavePath = config.Conf.Directory
+			r.dt.SavePath = r.opts.Directory
 		} else {
 			vid := fmt.Sprintf("%04d", i+1)
-			r.dt.SavePath = CreateDirectory(vid)
+			r.dt.SavePath = CreateDirectory(r.opts, vid)
 		}
 
 		canvases, err := r.getCanvases(vol, r.dt.Jar)
 		if err != nil || canvases == nil {
-			fmt.Println(err)
+			r.res.Log().Error("获取页面列表失败", "error", err)
 			continue
 		}
-		log.Printf(" %d/%d volume, %d pages \n", i+1, sizeVol, len(canvases))
+		r.res.Log().Info("下载分册", logger.KeyVolume, i+1, "volumes", sizeVol, "pages", len(canvases))
 		r.do(canvases)
 	}
 	return "", nil
 }
 
 func (r *Bluk) do(imgUrls []string) (msg string, err error) {
-	if config.Conf.UseDzi {
+	if r.opts.UseDzi {
 		r.doDezoomify(imgUrls)
 	} else {
 		r.doNormal(imgUrls)
@@ -133,12 +150,12 @@ func (r *Bluk) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []string,
 
 func (r *Bluk) getBody(sUrl string, jar *cookiejar.Jar) ([]byte, error) {
 	referer := url.QueryEscape(sUrl)
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": r.opts.UserAgent,
 			"Referer":    referer,
 		},
 	})
@@ -170,19 +187,20 @@ func (r *Bluk) doDezoomify(iiifUrls []string) bool {
 	}
 	size := len(iiifUrls)
 	// 创建下载器实例
-	iiifDownloader := downloader.NewIIIFDownloader(&config.Conf)
+	iiifDownloader := downloader.NewIIIFDownloader(r.opts)
 	for i, uri := range iiifUrls {
-		if uri == "" || !config.PageRange(i, size) {
+		if uri == "" || !r.opts.PageRange(i, size) {
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
-		filename := sortId + config.Conf.FileExt
+		filename := sortId + r.opts.FileExt
 		dest := path.Join(r.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !r.res.Pending(uri, dest) {
 			continue
 		}
-		log.Printf("Get %d/%d  %s\n", i+1, size, uri)
-		iiifDownloader.Dezoomify(r.ctx, uri, dest, args)
+		r.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", size, logger.KeyUrl, uri)
+		err := iiifDownloader.Dezoomify(r.ctx, uri, dest, args)
+		r.res.Record(sortId, uri, dest, err)
 
 	}
 	return true
@@ -195,37 +213,38 @@ func (r *Bluk) doNormal(imgUrls []string) bool {
 	size := len(imgUrls)
 	fmt.Println()
 	var wg sync.WaitGroup
-	q := QueueNew(int(config.Conf.Threads))
+	q := QueueNew(int(r.opts.Threads))
 	for i, uri := range imgUrls {
-		if uri == "" || !config.PageRange(i, size) {
+		if uri == "" || !r.opts.PageRange(i, size) {
 			continue
 		}
 		ext := util.FileExt(uri)
 		sortId := fmt.Sprintf("%04d", i+1)
 		filename := sortId + ext
 		dest := path.Join(r.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !r.res.Pending(uri, dest) {
 			continue
 		}
 		imgUrl := uri
 		fmt.Println()
-		log.Printf("Get %d/%d  %s\n", i+1, size, imgUrl)
+		r.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", size, logger.KeyUrl, imgUrl)
 		wg.Add(1)
 		q.Go(func() {
 			defer wg.Done()
-			ctx := context.Background()
+			ctx := r.ctx
 			opts := gohttp.Options{
 				DestFile:    dest,
 				Overwrite:   false,
 				Concurrency: 1,
-				CookieFile:  config.Conf.CookieFile,
-				HeaderFile:  config.Conf.HeaderFile,
+				CookieFile:  r.opts.CookieFile,
+				HeaderFile:  r.opts.HeaderFile,
 				CookieJar:   r.dt.Jar,
 				Headers: map[string]interface{}{
-					"User-Agent": config.Conf.UserAgent,
+					"User-Agent": r.opts.UserAgent,
 				},
 			}
-			gohttp.FastGet(ctx, imgUrl, opts)
+			_, err := gohttp.FastGet(ctx, imgUrl, opts)
+			r.res.Record(sortId, imgUrl, dest, err)
 			fmt.Println()
 		})
 	}
@@ -4,15 +4,14 @@ import (
 	"bookget/config"
 	"bookget/model/cuhk"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/httpclient"
 	"bookget/pkg/progressbar"
 	"bookget/pkg/sharedmemory"
 	"bookget/pkg/util"
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
@@ -39,27 +38,37 @@ type Cuhk struct {
 	parsedUrl *url.URL
 	savePath  string
 	bookId    string
+
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:           "cuhk",
+		Name:         "[中国]香港中文大学图书馆",
+		Country:      "CN",
+		Hosts:        []string{"repository.lib.cuhk.edu.hk"},
+		Capabilities: CapIIIF | CapGUI,
+		Examples:     []string{"https://repository.lib.cuhk.edu.hk/sc/item/{id}"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewCuhk(ctx, opts) },
+	})
 }
 
-func NewCuhk() *Cuhk {
-	ctx, cancel := context.WithCancel(context.Background())
+func NewCuhk(ctx context.Context, opts *config.Input) *Cuhk {
+	ctx, cancel := context.WithCancel(ctx)
 
-	// 创建自定义 Transport 忽略 SSL 验证
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-	}
-	jar, _ := cookiejar.New(nil)
 	return &Cuhk{
 		// 初始化字段
-		client: &http.Client{Timeout: config.Conf.Timeout * time.Second, Jar: jar, Transport: tr},
+		opts:   opts,
+		client: httpclient.ForSite(opts),
 		ctx:    ctx,
 		cancel: cancel,
 	}
 }
 
-func (r *Cuhk) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Cuhk) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	lastPos := strings.Index(sUrl, "#")
 	if lastPos > 0 {
 		r.rawUrl = strings.Replace(sUrl[:lastPos], "hk/sc/", "hk/en/", -1)
@@ -68,10 +77,8 @@ func (r *Cuhk) GetRouterInit(sUrl string) (map[string]interface{}, error) {
 	}
 	r.parsedUrl, _ = url.Parse(r.rawUrl)
 	msg, err := r.Run()
-	return map[string]interface{}{
-		"url": r.rawUrl,
-		"msg": msg,
-	}, err
+	r.res.SetBook(r.bookId, "")
+	return r.res.Finish(msg, err)
 }
 
 func (r *Cuhk) getBookId() string {
@@ -101,10 +108,11 @@ func (r *Cuhk) getBookId() string {
 
 func (r *Cuhk) Run() (msg string, err error) {
 	r.bookId = r.getBookId()
+	r.res.SetBook(r.bookId, "")
 	if r.bookId == "" {
-		return "[err=getBookId]", err
+		return "requested URL was not found.", err
 	}
-	r.savePath = config.Conf.Directory
+	r.savePath = r.opts.Directory
 
 	if util.OpenWebBrowser([]string{"-i", r.rawUrl}) {
 		fmt.Println("已启动 bookget-gui 浏览器，请注意完成「真人验证」。")
@@ -120,13 +128,13 @@ func (r *Cuhk) Run() (msg string, err error) {
 		return "", err
 	}
 
-	r.savePath = config.Conf.Directory
+	r.savePath = r.opts.Directory
 	r.urlsFile = path.Join(r.savePath, "urls.txt")
-	err = os.WriteFile(r.urlsFile, []byte(r.bufBuilder.String()), os.ModePerm)
+	err = writeFile(r.opts, r.urlsFile, []byte(r.bufBuilder.String()), os.ModePerm)
 	if err != nil {
 		return "", err
 	}
-	fmt.Printf("\n已生成图片URLs文件[%s]\n 可复制到 bookget-gui.exe 目录下，或使用其它软件下载。\n", r.urlsFile)
+	r.res.Log().Info("已生成图片 URL 列表，可复制到 bookget-gui.exe 目录下，或使用其它软件下载", "file", r.urlsFile)
 
 	r.do(r.canvases)
 	return "", nil
@@ -137,18 +145,19 @@ func (r *Cuhk) do(canvases []string) (msg string, err error) {
 	sizeVol := len(canvases)
 	bar := progressbar.Default(int64(sizeVol), "downloading")
 	for i, uri := range canvases {
-		if uri == "" || !config.PageRange(i, sizeVol) {
+		if uri == "" || !r.opts.PageRange(i, sizeVol) {
 			bar.Add(1)
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
-		filename := sortId + config.Conf.FileExt
+		filename := sortId + r.opts.FileExt
 		targetFilePath := path.Join(r.savePath, filename)
-		if FileExist(targetFilePath) {
+		if !r.res.Pending(uri, targetFilePath) {
 			bar.Add(1)
 			continue
 		}
 		ok, err := r.imageDownloader(uri, targetFilePath)
+		r.res.Record(sortId, uri, targetFilePath, err)
 		if err == nil && ok {
 			bar.Add(1)
 		}
@@ -185,19 +194,19 @@ func (r *Cuhk) getCanvases(sUrl string) (canvases []string, err error) {
 	var resp cuhk.ResponsePage
 	matches := regexp.MustCompile(`"pages":([^]]+)]`).FindSubmatch(r.responseBody)
 	if matches == nil {
-		return nil, errors.New("[err=getCanvases]")
+		return nil, errors.New("未找到页面列表")
 	}
 	data := []byte("{\"pages\":" + string(matches[1]) + "]}")
 	if err = json.Unmarshal(data, &resp); err != nil {
-		log.Printf("json.Unmarshal failed: %s\n", err)
+		r.res.Log().Error("解析 JSON 失败", "error", err)
 	}
 	for _, page := range resp.ImagePage {
 		var imgUrl string
-		//if config.Conf.UseDzi {
+		//if r.opts.UseDzi {
 		//	//dezoomify-rs URL
 		//	imgUrl = fmt.Sprintf("https://%s/iiif/2/%s/info.json", r.parsedUrl.Host, page.Identifier)
 		//} else {
-		imgUrl = fmt.Sprintf("https://%s/iiif/2/%s/%s", r.parsedUrl.Host, page.Identifier, config.Conf.Format)
+		imgUrl = fmt.Sprintf("https://%s/iiif/2/%s/%s", r.parsedUrl.Host, page.Identifier, r.opts.Format)
 		//}
 		r.bufBuilder.WriteString(imgUrl)
 		r.bufBuilder.WriteString("\n")
@@ -209,7 +218,7 @@ func (r *Cuhk) getCanvases(sUrl string) (canvases []string, err error) {
 func (r *Cuhk) getBodyByGui(apiUrl string) (bs []byte, err error) {
 	err = sharedmemory.WriteURLToSharedMemory(apiUrl)
 	if err != nil {
-		fmt.Println("Failed to write to shared memory:", err)
+		r.res.Log().Error("写入共享内存失败", "error", err)
 		return
 	}
 	for i := 0; i < 300; i++ {
@@ -225,7 +234,7 @@ func (r *Cuhk) getBodyByGui(apiUrl string) (bs []byte, err error) {
 func (r *Cuhk) imageDownloader(imgUrl, targetFilePath string) (ok bool, err error) {
 	err = sharedmemory.WriteURLImagePathToSharedMemory(imgUrl, targetFilePath)
 	if err != nil {
-		fmt.Println("Failed to write to shared memory:", err)
+		r.res.Log().Error("写入共享内存失败", "error", err)
 		return
 	}
 	for i := 0; i < 300; i++ {
@@ -241,12 +250,12 @@ func (r *Cuhk) imageDownloader(imgUrl, targetFilePath string) (ok bool, err erro
 
 func (r *Cuhk) getBody(apiUrl string, jar *cookiejar.Jar) ([]byte, error) {
 	referer := url.QueryEscape(apiUrl)
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": r.opts.UserAgent,
 			"Referer":    referer,
 		},
 	})
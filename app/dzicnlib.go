@@ -5,12 +5,12 @@ import (
 	"bookget/model/iiif"
 	"bookget/pkg/downloader"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
 	"bookget/pkg/util"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"path"
@@ -30,23 +30,38 @@ type DziCnLib struct {
 	ctx       context.Context
 
 	Canvases map[int]string
+
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:           "dzicnlib",
+		Name:         "DeepZoom 古籍平台（湖北、四川、云南等）",
+		Country:      "CN",
+		Paths:        []string{`/tiles/infos\.json`},
+		Priority:     20,
+		Capabilities: CapDZI,
+		Examples:     []string{"http://gjpt.library.hb.cn:8991/f-medias/1840/tiles/infos.json", "https://guji.sclib.org/medias/1122/tiles/infos.json", "http://msq.ynlib.cn/medias2022/1001/tiles/infos.json"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewDziCnLib(ctx, opts) },
+	})
 }
 
-func NewDziCnLib() *DziCnLib {
+func NewDziCnLib(ctx context.Context, opts *config.Input) *DziCnLib {
 	return &DziCnLib{
 		// 初始化字段
-		dt:  new(DownloadTask),
-		ctx: context.Background(),
+		opts: opts,
+		dt:   new(DownloadTask),
+		ctx:  ctx,
 	}
 }
 
-func (d *DziCnLib) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (d *DziCnLib) GetRouterInit(sUrl string) (*Result, error) {
+	d.res = NewResult(d.ctx, d.opts, sUrl)
 	msg, err := d.Run(sUrl)
-	return map[string]interface{}{
-		"type": "iiif",
-		"url":  sUrl,
-		"msg":  msg,
-	}, err
+	d.res.SetBook(d.dt.BookId, d.dt.Title)
+	return d.res.Finish(msg, err)
 }
 
 // 自定义一个排序类型
@@ -56,6 +71,7 @@ func (r DziCnLib) Run(sUrl string) (msg string, err error) {
 	r.dt.UrlParsed, err = url.Parse(sUrl)
 	r.dt.Url = sUrl
 	r.dt.BookId = getBookId(r.dt.Url)
+	r.res.SetBook(r.dt.BookId, "")
 	if r.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -64,16 +80,16 @@ func (r DziCnLib) Run(sUrl string) (msg string, err error) {
 }
 
 func (r DziCnLib) download() (msg string, err error) {
-	log.Printf("Get %s\n", r.dt.Url)
+	r.res.Log().Info("获取图书信息", logger.KeyUrl, r.dt.Url)
 
 	r.ServerUrl = r.getServerUri()
 	if r.ServerUrl == "" {
 		return "requested URL was not found.", err
 	}
-	r.dt.SavePath = config.Conf.Directory
+	r.dt.SavePath = r.opts.Directory
 	r.Canvases, err = r.getCanvases(r.dt.Url, r.dt.Jar)
 	if err != nil {
-		fmt.Println(err.Error())
+		r.res.Log().Error("获取页面列表失败", "error", err)
 		return
 	}
 	return r.dezoomify()
@@ -89,13 +105,13 @@ func (r DziCnLib) dezoomify() (msg string, err error) {
 		"-H", "Referer:" + referer,
 	}
 	size := len(r.Canvases)
-	iiifDownloader := downloader.NewIIIFDownloader(&config.Conf)
+	iiifDownloader := downloader.NewIIIFDownloader(r.opts)
 	err = iiifDownloader.SetDeepZoomTileFormat("{{.URL}}/{{.Level}}/{{.X}}/{{.Y}}.{{.Format}}")
 	if err != nil {
-		return "[err=SetDeepZoomTileFormat]", err
+		return "设置拼图 URL 模板失败", err
 	}
 	// 有些不规范的JPG/jpg扩展名服务器，直接用配置文件指定
-	ext := config.Conf.FileExt[1:]
+	ext := r.opts.FileExt[1:]
 	// 设置固定值
 	iiifDownloader.DeepzoomTileFormat.FixedValues = map[string]interface{}{
 		"Level":  0,
@@ -103,19 +119,20 @@ func (r DziCnLib) dezoomify() (msg string, err error) {
 	}
 
 	for i, xml := range r.Canvases {
-		if !config.PageRange(i, size) {
+		if !r.opts.PageRange(i, size) {
 			continue
 		}
-		target := path.Join(storePath, fmt.Sprintf("%04d", i+1)+config.Conf.FileExt)
-		if FileExist(target) {
+		target := path.Join(storePath, fmt.Sprintf("%04d", i+1)+r.opts.FileExt)
+		if !r.res.Pending(r.dt.Url, target) {
 			continue
 		}
 
 		err = iiifDownloader.DezoomifyWithContent(r.ctx, xml, target, args)
+		r.res.Record(path.Base(target), r.dt.Url, target, err)
 		if err != nil {
-			return "[err=iiifDownloader.Dezoomify]", err
+			return "拼图下载失败", err
 		}
-		util.PrintSleepTime(config.Conf.Sleep)
+		util.PrintSleepTime(r.opts.Sleep)
 	}
 	return "", err
 }
@@ -155,7 +172,7 @@ func (r DziCnLib) getCanvases(apiUrl string, jar *cookiejar.Jar) (map[int]string
 	</Image>
 	`
 	// 有些不规范的JPG/jpg扩展名服务器，直接用配置文件指定
-	ext := config.Conf.FileExt[1:]
+	ext := r.opts.FileExt[1:]
 	r.Canvases = make(map[int]string, len(result.Tiles))
 	for key, item := range result.Tiles {
 		id, err := strconv.Atoi(key)
@@ -185,12 +202,12 @@ func (r DziCnLib) getServerUri() string {
 
 func (r DziCnLib) getBody(apiUrl string, jar *cookiejar.Jar) ([]byte, error) {
 	referer := url.QueryEscape(apiUrl)
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": r.opts.UserAgent,
 			"Referer":    referer,
 		},
 	})
@@ -5,12 +5,12 @@ import (
 	"bookget/model/iiif"
 	"bookget/pkg/downloader"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
 	"bookget/pkg/util"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"path"
@@ -22,28 +22,44 @@ import (
 type Emuseum struct {
 	dt  *DownloadTask
 	ctx context.Context
+
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:           "emuseum",
+		Name:         "[日本]E国宝eMuseum",
+		Country:      "JP",
+		Hosts:        []string{"emuseum.nich.go.jp"},
+		Capabilities: CapIIIF,
+		Examples:     []string{"https://emuseum.nich.go.jp/detail?content_base_id={id}&content_part_id={part}"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewEmuseum(ctx, opts) },
+	})
 }
 
-func NewEmuseum() *Emuseum {
+func NewEmuseum(ctx context.Context, opts *config.Input) *Emuseum {
 	return &Emuseum{
 		// 初始化字段
-		dt:  new(DownloadTask),
-		ctx: context.Background(),
+		opts: opts,
+		dt:   new(DownloadTask),
+		ctx:  ctx,
 	}
 }
 
-func (d *Emuseum) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (d *Emuseum) GetRouterInit(sUrl string) (*Result, error) {
+	d.res = NewResult(d.ctx, d.opts, sUrl)
 	msg, err := d.Run(sUrl)
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	d.res.SetBook(d.dt.BookId, d.dt.Title)
+	return d.res.Finish(msg, err)
 }
 
 func (d *Emuseum) Run(sUrl string) (msg string, err error) {
 	d.dt.UrlParsed, err = url.Parse(sUrl)
 	d.dt.Url = sUrl
 	d.dt.BookId = d.getBookId(d.dt.Url)
+	d.res.SetBook(d.dt.BookId, "")
 	if d.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -63,38 +79,39 @@ func (d *Emuseum) getBookId(sUrl string) (bookId string) {
 }
 
 func (d *Emuseum) download() (msg string, err error) {
-	log.Printf("Get %s\n", d.dt.Url)
+	d.res.Log().Info("获取图书信息", logger.KeyUrl, d.dt.Url)
 
 	respVolume, err := d.getVolumes(d.dt.Url, d.dt.Jar)
+	d.res.SetVolumes(len(respVolume))
 	if err != nil {
-		fmt.Println(err)
+		d.res.Log().Error("获取册列表失败", "error", err)
 		return "getVolumes", err
 	}
 	sizeVol := len(respVolume)
 	for i, vol := range respVolume {
-		if !config.VolumeRange(i) {
+		if !d.opts.VolumeRange(i) {
 			continue
 		}
 		if sizeVol == 1 {
-			d.dt.SavePath = config.Conf.Directory
+			d.dt.SavePath = d.opts.Directory
 		} else {
 			vid := fmt.Sprintf("%04d", i+1)
-			d.dt.SavePath = CreateDirectory(vid)
+			d.dt.SavePath = CreateDirectory(d.opts, vid)
 		}
 
 		canvases, err := d.getCanvases(vol, d.dt.Jar)
 		if err != nil || canvases == nil {
-			fmt.Println(err)
+			d.res.Log().Error("获取页面列表失败", "error", err)
 			continue
 		}
-		log.Printf(" %d/%d volume, %d pages \n", i+1, sizeVol, len(canvases))
+		d.res.Log().Info("下载分册", logger.KeyVolume, i+1, "volumes", sizeVol, "pages", len(canvases))
 		d.do(canvases)
 	}
 	return "", nil
 }
 
 func (d *Emuseum) do(imgUrls []string) (msg string, err error) {
-	if config.Conf.UseDzi {
+	if d.opts.UseDzi {
 		d.doDezoomify(imgUrls)
 	} else {
 		d.doNormal(imgUrls)
@@ -123,7 +140,7 @@ func (d *Emuseum) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []strin
 	}
 	var manifest = new(iiif.ManifestResponse)
 	if err = json.Unmarshal(bs, manifest); err != nil {
-		log.Printf("json.Unmarshal failed: %s\n", err)
+		d.res.Log().Error("解析 JSON 失败", "error", err)
 		return
 	}
 	if len(manifest.Sequences) == 0 {
@@ -137,13 +154,13 @@ func (d *Emuseum) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []strin
 				image.Resource.Service.Id = strings.Replace(image.Resource.Service.Id, "/100001001002.tif", "/100001001001.tif", 1)
 				image.Resource.Id = strings.Replace(image.Resource.Id, "/100001001002.tif", "/100001001001.tif", 1)
 			}
-			if config.Conf.UseDzi {
+			if d.opts.UseDzi {
 				//dezoomify-rs URL
 				iiiInfo := fmt.Sprintf("%s/info.json", image.Resource.Service.Id)
 				canvases = append(canvases, iiiInfo)
 			} else {
 				//JPEG URL
-				imgUrl := image.Resource.Service.Id + "/" + config.Conf.Format
+				imgUrl := image.Resource.Service.Id + "/" + d.opts.Format
 				canvases = append(canvases, imgUrl)
 			}
 		}
@@ -154,12 +171,12 @@ func (d *Emuseum) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []strin
 
 func (d *Emuseum) getBody(sUrl string, jar *cookiejar.Jar) ([]byte, error) {
 	referer := url.QueryEscape(sUrl)
-	ctx := context.Background()
+	ctx := d.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: d.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": d.opts.UserAgent,
 			"Referer":    referer,
 		},
 	})
@@ -185,19 +202,20 @@ func (d *Emuseum) doDezoomify(iiifUrls []string) bool {
 		"-H", "Referer:" + referer,
 	}
 	size := len(iiifUrls)
-	iiifDownloader := downloader.NewIIIFDownloader(&config.Conf)
+	iiifDownloader := downloader.NewIIIFDownloader(d.opts)
 	for i, uri := range iiifUrls {
-		if uri == "" || !config.PageRange(i, size) {
+		if uri == "" || !d.opts.PageRange(i, size) {
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
-		filename := sortId + config.Conf.FileExt
+		filename := sortId + d.opts.FileExt
 		dest := path.Join(d.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !d.res.Pending(uri, dest) {
 			continue
 		}
-		log.Printf("Get %d/%d  %s\n", i+1, size, uri)
-		iiifDownloader.Dezoomify(d.ctx, uri, dest, args)
+		d.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", size, logger.KeyUrl, uri)
+		err := iiifDownloader.Dezoomify(d.ctx, uri, dest, args)
+		d.res.Record(sortId, uri, dest, err)
 	}
 	return true
 }
@@ -209,37 +227,38 @@ func (d *Emuseum) doNormal(imgUrls []string) bool {
 	size := len(imgUrls)
 	fmt.Println()
 	var wg sync.WaitGroup
-	q := QueueNew(int(config.Conf.Threads))
+	q := QueueNew(int(d.opts.Threads))
 	for i, uri := range imgUrls {
-		if uri == "" || !config.PageRange(i, size) {
+		if uri == "" || !d.opts.PageRange(i, size) {
 			continue
 		}
 		ext := util.FileExt(uri)
 		sortId := fmt.Sprintf("%04d", i+1)
 		filename := sortId + ext
 		dest := path.Join(d.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !d.res.Pending(uri, dest) {
 			continue
 		}
 		imgUrl := uri
 		fmt.Println()
-		log.Printf("Get %d/%d  %s\n", i+1, size, imgUrl)
+		d.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", size, logger.KeyUrl, imgUrl)
 		wg.Add(1)
 		q.Go(func() {
 			defer wg.Done()
-			ctx := context.Background()
+			ctx := d.ctx
 			opts := gohttp.Options{
 				DestFile:    dest,
 				Overwrite:   false,
 				Concurrency: 1,
-				CookieFile:  config.Conf.CookieFile,
-				HeaderFile:  config.Conf.HeaderFile,
+				CookieFile:  d.opts.CookieFile,
+				HeaderFile:  d.opts.HeaderFile,
 				CookieJar:   d.dt.Jar,
 				Headers: map[string]interface{}{
-					"User-Agent": config.Conf.UserAgent,
+					"User-Agent": d.opts.UserAgent,
 				},
 			}
-			gohttp.FastGet(ctx, imgUrl, opts)
+			_, err := gohttp.FastGet(ctx, imgUrl, opts)
+			d.res.Record(sortId, imgUrl, dest, err)
 			fmt.Println()
 		})
 	}
@@ -5,17 +5,16 @@ import (
 	"bookget/model/family"
 	"bookget/pkg/chttp"
 	"bookget/pkg/downloader"
+	"bookget/pkg/httpclient"
+	"bookget/pkg/logger"
 	"bookget/pkg/util"
 	"bytes"
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
-	"net/http/cookiejar"
 	"net/url"
 	"os"
 	"path"
@@ -47,39 +46,46 @@ type Familysearch struct {
 	baseUrl     string
 	sgBaseUrl   string
 	apiUrl      string
+
+	opts *config.Input
+	res  *Result
 }
 
-func NewFamilysearch() *Familysearch {
-	ctx, cancel := context.WithCancel(context.Background())
-	dm := downloader.NewDownloadManager(ctx, cancel, config.Conf.MaxConcurrent)
+func init() {
+	Register(Site{
+		ID:           "familysearch",
+		Name:         "[美国]犹他州家谱",
+		Country:      "US",
+		Hosts:        []string{"familysearch.org"},
+		Capabilities: CapDZI | CapCookie | CapGUI,
+		Examples:     []string{"https://www.familysearch.org/ark:/61903/3:1:{id}"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewFamilysearch(ctx, opts) },
+	})
+}
 
-	// 创建自定义 Transport 忽略 SSL 验证
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-	}
-	jar, _ := cookiejar.New(nil)
+func NewFamilysearch(ctx context.Context, opts *config.Input) *Familysearch {
+	ctx, cancel := context.WithCancel(ctx)
+	dm := downloader.NewDownloadManager(ctx, cancel, opts.MaxConcurrent)
+	dm.SetRetries(opts.Retries)
 
 	return &Familysearch{
 		// 初始化字段
+		opts:   opts,
 		dm:     dm,
-		client: &http.Client{Timeout: config.Conf.Timeout * time.Second, Jar: jar, Transport: tr},
+		client: httpclient.ForSite(opts),
 		ctx:    ctx,
 		cancel: cancel,
 	}
 }
 
-func (r *Familysearch) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Familysearch) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	r.rawUrl = sUrl
 	r.parsedUrl, _ = url.Parse(r.rawUrl)
 	r.apiUrl = "https://" + r.parsedUrl.Host + "/search/filmdatainfo/image-data"
 	msg, err := r.Run()
-	return map[string]interface{}{
-		"type": "iiif",
-		"url":  sUrl,
-		"msg":  msg,
-	}, err
+	r.res.SetBook(r.bookId, "")
+	return r.res.Finish(msg, err)
 }
 
 func (r *Familysearch) getBookId(sUrl string) (bookId string) {
@@ -108,6 +114,7 @@ func (r *Familysearch) getBaseUrl(sUrl string) (string, string, error) {
 
 func (r *Familysearch) Run() (msg string, err error) {
 	r.bookId = r.getBookId(r.rawUrl)
+	r.res.SetBook(r.bookId, "")
 	if r.bookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -134,9 +141,9 @@ func (r *Familysearch) Run() (msg string, err error) {
 	if err != nil || r.canvases == nil {
 		return "", err
 	}
-	r.savePath = config.Conf.Directory
+	r.savePath = r.opts.Directory
 	r.urlsFile = path.Join(r.savePath, "urls.txt")
-	err = os.WriteFile(r.urlsFile, []byte(r.bufBuilder.String()), os.ModePerm)
+	err = writeFile(r.opts, r.urlsFile, []byte(r.bufBuilder.String()), os.ModePerm)
 	if err != nil {
 		return "", err
 	}
@@ -148,7 +155,7 @@ func (r *Familysearch) Run() (msg string, err error) {
 func (r *Familysearch) do(canvases []string) (err error) {
 	sizeVol := len(canvases)
 	if sizeVol <= 0 {
-		return errors.New("[err=do]")
+		return errors.New("没有可下载的页面")
 	}
 	referer := url.QueryEscape(r.rawUrl)
 	sid := r.getSessionId()
@@ -158,7 +165,7 @@ func (r *Familysearch) do(canvases []string) (err error) {
 		"-H", "referer:" + referer,
 	}
 	// 创建下载器实例
-	iiifDownloader := downloader.NewIIIFDownloader(&config.Conf)
+	iiifDownloader := downloader.NewIIIFDownloader(r.opts)
 	iiifDownloader.SetDeepZoomTileFormat("{{.ServerBaseURL}}/{{.URL}}_files/{{.Level}}/{{.X}}_{{.Y}}.{{.Format}}")
 	// 设置固定值
 	iiifDownloader.DeepzoomTileFormat.FixedValues = map[string]interface{}{
@@ -167,17 +174,18 @@ func (r *Familysearch) do(canvases []string) (err error) {
 		"ServerBaseURL": r.sgBaseUrl,
 	}
 	for i, uri := range canvases {
-		if uri == "" || !config.PageRange(i, sizeVol) {
+		if uri == "" || !r.opts.PageRange(i, sizeVol) {
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
-		dest := filepath.Join(r.savePath, sortId+config.Conf.FileExt)
-		if FileExist(dest) {
+		dest := filepath.Join(r.savePath, sortId+r.opts.FileExt)
+		if !r.res.Pending(uri, dest) {
 			continue
 		}
-		log.Printf("Get %d/%d  %s\n", i+1, sizeVol, uri)
-		iiifDownloader.Dezoomify(r.ctx, uri, dest, args)
-		util.PrintSleepTime(config.Conf.Sleep)
+		r.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", sizeVol, logger.KeyUrl, uri)
+		err := iiifDownloader.Dezoomify(r.ctx, uri, dest, args)
+		r.res.Record(sortId, uri, dest, err)
+		util.PrintSleepTime(r.opts.Sleep)
 	}
 	return err
 }
@@ -194,7 +202,7 @@ func (r *Familysearch) getImageData(sUrl string) (imageData family.ImageData, er
 
 	bs, err := r.postBody(sUrl, data)
 	if err != nil {
-		fmt.Println("请求失败，cookie 可能已失效。")
+		r.res.Log().Error("请求失败，cookie 可能已失效", logger.KeyUrl, sUrl, "error", err)
 		return
 	}
 	var resultError family.ResultError
@@ -271,7 +279,7 @@ func (r *Familysearch) getCanvases(sUrl string, imageData family.ImageData) (can
 }
 
 func (r *Familysearch) getSessionId() string {
-	cookies, err := chttp.ReadCookiesFromFile(config.Conf.CookieFile)
+	cookies, err := chttp.ReadCookiesFromFile(r.opts.CookieFile)
 	if err != nil {
 		return ""
 	}
@@ -288,13 +296,13 @@ func (r *Familysearch) getBody(sUrl string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", config.Conf.UserAgent)
+	req.Header.Set("User-Agent", r.opts.UserAgent)
 	req.Header.Set("accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7")
 	req.Header.Set("authority", "www.familysearch.org")
 	req.Header.Set("origin", r.baseUrl)
 	req.Header.Set("referer", r.rawUrl)
 
-	cookies, _ := chttp.ReadCookiesFromFile(config.Conf.CookieFile)
+	cookies, _ := chttp.ReadCookiesFromFile(r.opts.CookieFile)
 	if cookies != "" {
 		req.Header.Set("Cookie", cookies)
 		//sid := r.getSessionId(cookies)
@@ -307,7 +315,7 @@ func (r *Familysearch) getBody(sUrl string) ([]byte, error) {
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			log.Printf("close body err=%v", err)
+			r.res.Log().Warn("关闭响应失败", "error", err)
 		}
 	}()
 
@@ -346,7 +354,7 @@ func (r *Familysearch) postBody(sUrl string, postData interface{}) ([]byte, erro
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 	// 设置请求头
-	req.Header.Set("User-Agent", config.Conf.UserAgent)
+	req.Header.Set("User-Agent", r.opts.UserAgent)
 	if isJSON {
 		req.Header.Set("accept", "application/json, text/plain, */*")
 		req.Header.Set("Content-Type", "application/json")
@@ -358,7 +366,7 @@ func (r *Familysearch) postBody(sUrl string, postData interface{}) ([]byte, erro
 	req.Header.Set("referer", r.rawUrl)
 
 	// 添加cookie
-	cookies, err := chttp.ReadCookiesFromFile(config.Conf.CookieFile)
+	cookies, err := chttp.ReadCookiesFromFile(r.opts.CookieFile)
 	if err == nil && cookies != "" {
 		req.Header.Set("Cookie", cookies)
 		sid := r.getSessionId()
@@ -372,7 +380,7 @@ func (r *Familysearch) postBody(sUrl string, postData interface{}) ([]byte, erro
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			log.Printf("warning: failed to close response body: %v", err)
+			r.res.Log().Warn("关闭响应失败", "error", err)
 		}
 	}()
 
@@ -4,16 +4,14 @@ import (
 	"bookget/config"
 	"bookget/pkg/chttp"
 	"bookget/pkg/downloader"
+	"bookget/pkg/httpclient"
 	"context"
-	"crypto/tls"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
-	"net/http/cookiejar"
 	"net/url"
+	"path"
 	"regexp"
-	"time"
 )
 
 type Gzlib struct {
@@ -26,36 +24,46 @@ type Gzlib struct {
 	parsedUrl *url.URL
 	savePath  string
 	bookId    string
+
+	opts *config.Input
+	res  *Result
 }
 
-func NewGzlib() *Gzlib {
-	ctx, cancel := context.WithCancel(context.Background())
-	dm := downloader.NewDownloadManager(ctx, cancel, config.Conf.MaxConcurrent)
+func init() {
+	Register(Site{
+		ID:           "gzlib",
+		Name:         "[中国]广州大典",
+		Country:      "CN",
+		Hosts:        []string{"gzdd.gzlib.gov.cn", "gzdd.gzlib.org.cn"},
+		Capabilities: CapPDF,
+		Examples:     []string{"https://gzdd.gzlib.gov.cn/Hrcanton/Search/ResultDetail?BookId={id}"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewGzlib(ctx, opts) },
+	})
+}
+
+func NewGzlib(ctx context.Context, opts *config.Input) *Gzlib {
+	ctx, cancel := context.WithCancel(ctx)
+	dm := downloader.NewDownloadManager(ctx, cancel, opts.MaxConcurrent)
+	dm.SetRetries(opts.Retries)
 
-	// 创建自定义 Transport 忽略 SSL 验证
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-	}
-	jar, _ := cookiejar.New(nil)
 	return &Gzlib{
 		// 初始化字段
+		opts:   opts,
 		dm:     dm,
-		client: &http.Client{Timeout: config.Conf.Timeout * time.Second, Jar: jar, Transport: tr},
+		client: httpclient.ForSite(opts),
 		ctx:    ctx,
 		cancel: cancel,
 	}
 }
 
-func (r *Gzlib) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Gzlib) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	r.rawUrl = sUrl
 	r.parsedUrl, _ = url.Parse(sUrl)
 	msg, err := r.Run()
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.RecordTasks(r.dm.Tasks())
+	r.res.SetBook(r.bookId, "")
+	return r.res.Finish(msg, err)
 }
 
 func (r *Gzlib) getBookId() (bookId string) {
@@ -68,15 +76,19 @@ func (r *Gzlib) getBookId() (bookId string) {
 
 func (r *Gzlib) Run() (msg string, err error) {
 	r.bookId = r.getBookId()
+	r.res.SetBook(r.bookId, "")
 	if r.bookId == "" {
-		return "[err=getBookId]", err
+		return "requested URL was not found.", err
 	}
-	r.savePath = config.Conf.Directory
+	r.savePath = r.opts.Directory
 
 	apiUrl := fmt.Sprintf("https://%s/attach/GZDD/Attach/%s.pdf", r.parsedUrl.Hostname(), r.bookId)
 	fileName := fmt.Sprintf("%s.pdf", r.bookId)
+	if !r.res.Pending(apiUrl, path.Join(r.savePath, fileName)) {
+		return "", nil
+	}
 
-	headers := BuildRequestHeader()
+	headers := BuildRequestHeader(r.opts)
 	r.dm.UseSizeBar = true
 	// 添加GET下载任务
 	r.dm.AddTask(
@@ -86,7 +98,7 @@ func (r *Gzlib) Run() (msg string, err error) {
 		nil,
 		r.savePath,
 		fileName,
-		config.Conf.Threads,
+		r.opts.Threads,
 	)
 	r.dm.Start()
 
@@ -98,16 +110,16 @@ func (r *Gzlib) getBody(sUrl string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", config.Conf.UserAgent)
+	req.Header.Set("User-Agent", r.opts.UserAgent)
 	req.Header.Set("Origin", "https://"+r.parsedUrl.Host)
 	req.Header.Set("Referer", r.rawUrl)
 
-	cookies, _ := chttp.ReadCookiesFromFile(config.Conf.CookieFile)
+	cookies, _ := chttp.ReadCookiesFromFile(r.opts.CookieFile)
 	if cookies != "" {
 		req.Header.Set("Cookie", cookies)
 	}
 
-	headers, err := chttp.ReadHeadersFromFile(config.Conf.HeaderFile)
+	headers, err := chttp.ReadHeadersFromFile(r.opts.HeaderFile)
 	if err == nil {
 		for key, value := range headers {
 			req.Header.Set(key, value)
@@ -120,7 +132,7 @@ func (r *Gzlib) getBody(sUrl string) ([]byte, error) {
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			log.Printf("close body err=%v", err)
+			r.res.Log().Warn("关闭响应失败", "error", err)
 		}
 	}()
 
@@ -3,10 +3,10 @@ package app
 import (
 	"bookget/config"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"path"
@@ -17,21 +17,37 @@ import (
 type HannomNlv struct {
 	dt   *DownloadTask
 	body []byte
+
+	ctx  context.Context
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:       "hannomnlv",
+		Name:     "[越南]国家图书馆汉农图书馆",
+		Country:  "VN",
+		Hosts:    []string{"hannom.nlv.gov.vn"},
+		Examples: []string{"https://hannom.nlv.gov.vn/vi/viewer/{id}"},
+		New:      func(ctx context.Context, opts *config.Input) RouterInit { return NewHannomNlv(ctx, opts) },
+	})
 }
 
-func NewHannomNlv() *HannomNlv {
+func NewHannomNlv(ctx context.Context, opts *config.Input) *HannomNlv {
 	return &HannomNlv{
 		// 初始化字段
-		dt: new(DownloadTask),
+		ctx:  ctx,
+		opts: opts,
+		dt:   new(DownloadTask),
 	}
 }
 
-func (r *HannomNlv) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *HannomNlv) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (r *HannomNlv) Run(sUrl string) (msg string, err error) {
@@ -39,6 +55,7 @@ func (r *HannomNlv) Run(sUrl string) (msg string, err error) {
 	r.dt.Url = sUrl
 	r.dt.Jar, _ = cookiejar.New(nil)
 	r.dt.BookId = r.getBookId(r.dt.Url)
+	r.res.SetBook(r.dt.BookId, "")
 	if r.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -47,7 +64,7 @@ func (r *HannomNlv) Run(sUrl string) (msg string, err error) {
 
 func (r *HannomNlv) getBookId(sUrl string) (bookId string) {
 	var err error
-	r.body, err = getBody(sUrl, r.dt.Jar)
+	r.body, err = getBody(r.ctx, r.opts, sUrl, r.dt.Jar)
 	if err != nil {
 		return ""
 	}
@@ -59,13 +76,13 @@ func (r *HannomNlv) getBookId(sUrl string) (bookId string) {
 }
 
 func (r *HannomNlv) download() (msg string, err error) {
-	log.Printf("Get %s\n", r.dt.Url)
-	r.dt.SavePath = config.Conf.Directory
+	r.res.Log().Info("获取图书信息", logger.KeyUrl, r.dt.Url)
+	r.dt.SavePath = r.opts.Directory
 	canvases, err := r.getCanvases(r.dt.Url, r.dt.Jar)
 	if err != nil || canvases == nil {
-		fmt.Println(err)
+		r.res.Log().Error("获取页面列表失败", "error", err)
 	}
-	log.Printf(" %d pages \n", len(canvases))
+	r.res.Log().Info("解析完成", "pages", len(canvases))
 	r.do(canvases)
 	return "", nil
 }
@@ -77,36 +94,37 @@ func (r *HannomNlv) do(imgUrls []string) (msg string, err error) {
 	size := len(imgUrls)
 	fmt.Println()
 	var wg sync.WaitGroup
-	q := QueueNew(int(config.Conf.Threads))
+	q := QueueNew(int(r.opts.Threads))
 	for i, uri := range imgUrls {
-		if uri == "" || !config.PageRange(i, size) {
+		if uri == "" || !r.opts.PageRange(i, size) {
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
-		filename := sortId + config.Conf.FileExt
+		filename := sortId + r.opts.FileExt
 		dest := path.Join(r.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !r.res.Pending(uri, dest) {
 			continue
 		}
 		imgUrl := uri
 		fmt.Println()
-		log.Printf("Get %d/%d  %s\n", i+1, size, imgUrl)
+		r.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", size, logger.KeyUrl, imgUrl)
 		wg.Add(1)
 		q.Go(func() {
 			defer wg.Done()
-			ctx := context.Background()
+			ctx := r.ctx
 			opts := gohttp.Options{
 				DestFile:    dest,
 				Overwrite:   false,
 				Concurrency: 1,
-				CookieFile:  config.Conf.CookieFile,
-				HeaderFile:  config.Conf.HeaderFile,
+				CookieFile:  r.opts.CookieFile,
+				HeaderFile:  r.opts.HeaderFile,
 				CookieJar:   r.dt.Jar,
 				Headers: map[string]interface{}{
-					"User-Agent": config.Conf.UserAgent,
+					"User-Agent": r.opts.UserAgent,
 				},
 			}
-			gohttp.FastGet(ctx, imgUrl, opts)
+			_, err := gohttp.FastGet(ctx, imgUrl, opts)
+			r.res.Record(sortId, imgUrl, dest, err)
 			fmt.Println()
 		})
 	}
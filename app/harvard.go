@@ -5,19 +5,18 @@ import (
 	"bookget/model/iiif"
 	"bookget/pkg/chttp"
 	"bookget/pkg/downloader"
+	"bookget/pkg/httpclient"
+	"bookget/pkg/logger"
 	"bookget/pkg/progressbar"
 	"bookget/pkg/sharedmemory"
 	"bookget/pkg/util"
 	"bytes"
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
-	"net/http/cookiejar"
 	"net/url"
 	"os"
 	"path"
@@ -43,37 +42,46 @@ type Harvard struct {
 	parsedUrl *url.URL
 	savePath  string
 	bookId    string
+
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:           "harvard",
+		Name:         "[美国]哈佛大学图书馆",
+		Country:      "US",
+		Hosts:        []string{"lib.harvard.edu"},
+		Capabilities: CapIIIF | CapGUI,
+		Examples:     []string{"https://iiif.lib.harvard.edu/manifests/view/drs:{id}", "https://curiosity.lib.harvard.edu/chinese-rare-books/catalog/{id}"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewHarvard(ctx, opts) },
+	})
 }
 
-func NewHarvard() *Harvard {
-	ctx, cancel := context.WithCancel(context.Background())
-	dm := downloader.NewDownloadManager(ctx, cancel, config.Conf.MaxConcurrent)
+func NewHarvard(ctx context.Context, opts *config.Input) *Harvard {
+	ctx, cancel := context.WithCancel(ctx)
+	dm := downloader.NewDownloadManager(ctx, cancel, opts.MaxConcurrent)
+	dm.SetRetries(opts.Retries)
 
-	// 创建自定义 Transport 忽略 SSL 验证
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-	}
-	jar, _ := cookiejar.New(nil)
 	return &Harvard{
 		// 初始化字段
+		opts:   opts,
 		dm:     dm,
-		client: &http.Client{Timeout: config.Conf.Timeout * time.Second, Jar: jar, Transport: tr},
+		client: httpclient.ForSite(opts),
 		ctx:    ctx,
 		cancel: cancel,
 	}
 }
 
-func (r *Harvard) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Harvard) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	r.rawUrl = sUrl
 	r.parsedUrl, _ = url.Parse(r.rawUrl)
 	msg, err := r.Run()
-	return map[string]interface{}{
-		"type": "iiif",
-		"url":  sUrl,
-		"msg":  msg,
-	}, err
+	r.res.RecordTasks(r.dm.Tasks())
+	r.res.SetBook(r.bookId, "")
+	return r.res.Finish(msg, err)
 }
 
 func (r *Harvard) getBookId(sUrl string) (bookId string) {
@@ -90,6 +98,7 @@ func (r *Harvard) getBookId(sUrl string) (bookId string) {
 
 func (r *Harvard) Run() (msg string, err error) {
 	r.bookId = r.getBookId(r.rawUrl)
+	r.res.SetBook(r.bookId, "")
 	if r.bookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -109,13 +118,13 @@ func (r *Harvard) Run() (msg string, err error) {
 	if err != nil || r.canvases == nil {
 		return "", err
 	}
-	r.savePath = config.Conf.Directory
+	r.savePath = r.opts.Directory
 	r.urlsFile = path.Join(r.savePath, "urls.txt")
-	err = os.WriteFile(r.urlsFile, []byte(r.bufBuilder.String()), os.ModePerm)
+	err = writeFile(r.opts, r.urlsFile, []byte(r.bufBuilder.String()), os.ModePerm)
 	if err != nil {
 		return "", err
 	}
-	fmt.Printf("\n已生成图片URLs文件[%s]\n 可复制到 bookget-gui.exe 目录下，或使用其它软件下载。\n", r.urlsFile)
+	r.res.Log().Info("已生成图片 URL 列表，可复制到 bookget-gui.exe 目录下，或使用其它软件下载", "file", r.urlsFile)
 
 	r.do(r.canvases)
 	return "", nil
@@ -125,7 +134,7 @@ func (r *Harvard) do(imgUrls []string) (err error) {
 	if os.PathSeparator == '\\' {
 		return r.doByGUI(imgUrls)
 	}
-	if config.Conf.UseDzi {
+	if r.opts.UseDzi {
 		return r.doDezoomify(imgUrls)
 	}
 	return r.doNormal(imgUrls)
@@ -147,7 +156,7 @@ func (r *Harvard) getCanvases() (canvases []string, err error) {
 
 	var manifest = new(iiif.ManifestResponse)
 	if err = json.Unmarshal(r.bufBody, manifest); err != nil {
-		log.Printf("json.Unmarshal failed: %s\n", err)
+		r.res.Log().Error("解析 JSON 失败", "error", err)
 		return
 	}
 	if len(manifest.Sequences) == 0 {
@@ -158,10 +167,10 @@ func (r *Harvard) getCanvases() (canvases []string, err error) {
 	for _, canvase := range manifest.Sequences[0].Canvases {
 		for _, image := range canvase.Images {
 			//JPEG URL
-			imgUrl := image.Resource.Service.Id + "/" + config.Conf.Format
+			imgUrl := image.Resource.Service.Id + "/" + r.opts.Format
 			//dezoomify-rs URL
 			iiiInfo := fmt.Sprintf("%s/info.json", image.Resource.Service.Id)
-			if config.Conf.UseDzi && os.PathSeparator != '\\' {
+			if r.opts.UseDzi && os.PathSeparator != '\\' {
 				canvases = append(canvases, iiiInfo)
 			} else {
 				canvases = append(canvases, imgUrl)
@@ -177,27 +186,28 @@ func (r *Harvard) getCanvases() (canvases []string, err error) {
 func (r *Harvard) doDezoomify(canvases []string) (err error) {
 	sizeVol := len(canvases)
 	if sizeVol <= 0 {
-		return errors.New("[err=doByGUI]")
+		return errors.New("没有可下载的页面")
 	}
 	referer := url.QueryEscape(r.rawUrl)
-	iiifDownloader := downloader.NewIIIFDownloader(&config.Conf)
+	iiifDownloader := downloader.NewIIIFDownloader(r.opts)
 	for i, uri := range canvases {
-		if uri == "" || !config.PageRange(i, sizeVol) {
+		if uri == "" || !r.opts.PageRange(i, sizeVol) {
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
-		filename := sortId + config.Conf.FileExt
+		filename := sortId + r.opts.FileExt
 		dest := path.Join(r.savePath, filename)
-		if FileExist(dest) {
+		if !r.res.Pending(uri, dest) {
 			continue
 		}
-		log.Printf("Get %d/%d  %s\n", i+1, sizeVol, uri)
+		r.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", sizeVol, logger.KeyUrl, uri)
 
 		args := []string{
 			"-H", "Origin:" + referer,
 			"-H", "Referer:" + referer,
 		}
-		iiifDownloader.Dezoomify(r.ctx, uri, dest, args)
+		err := iiifDownloader.Dezoomify(r.ctx, uri, dest, args)
+		r.res.Record(sortId, uri, dest, err)
 	}
 	return nil
 }
@@ -205,30 +215,30 @@ func (r *Harvard) doDezoomify(canvases []string) (err error) {
 func (r *Harvard) doNormal(canvases []string) (err error) {
 	sizeVol := len(canvases)
 	if sizeVol <= 0 {
-		return errors.New("[err=doNormal]")
+		return errors.New("没有可下载的页面")
 	}
 	fmt.Println()
 	counter := 0
 	for i, imgUrl := range canvases {
-		if imgUrl == "" || !config.PageRange(i, sizeVol) {
+		if imgUrl == "" || !r.opts.PageRange(i, sizeVol) {
 			continue
 		}
 		ext := util.FileExt(imgUrl)
 		sortId := fmt.Sprintf("%04d", i+1)
 		fileName := sortId + ext
 		dest := path.Join(r.savePath, fileName)
-		if FileExist(dest) {
+		if !r.res.Pending(imgUrl, dest) {
 			continue
 		}
 		// 添加GET下载任务
 		r.dm.AddTask(
 			imgUrl,
 			"GET",
-			map[string]string{"User-Agent": config.Conf.UserAgent},
+			map[string]string{"User-Agent": r.opts.UserAgent},
 			nil,
 			r.savePath,
 			fileName,
-			config.Conf.Threads,
+			r.opts.Threads,
 		)
 		counter++
 
@@ -242,27 +252,28 @@ func (r *Harvard) doNormal(canvases []string) (err error) {
 func (r *Harvard) doByGUI(canvases []string) (err error) {
 	sizeVol := len(canvases)
 	if sizeVol <= 0 {
-		return errors.New("[err=doByGUI]")
+		return errors.New("没有可下载的页面")
 	}
 	fmt.Println()
 	bar := progressbar.Default(int64(sizeVol), "downloading")
 	for i, imgUrl := range canvases {
 		i++
 		sortId := fmt.Sprintf("%04d", i)
-		fileName := sortId + config.Conf.FileExt
+		fileName := sortId + r.opts.FileExt
 
-		if imgUrl == "" || !config.PageRange(i, sizeVol) {
+		if imgUrl == "" || !r.opts.PageRange(i, sizeVol) {
 			bar.Add(1)
 			continue
 		}
 		//跳过存在的文件
 		targetFilePath := path.Join(r.savePath, fileName)
-		if FileExist(targetFilePath) {
+		if !r.res.Pending(imgUrl, targetFilePath) {
 			bar.Add(1)
 			continue
 		}
 
 		ok, err := r.imageDownloader(imgUrl, targetFilePath)
+		r.res.Record(sortId, imgUrl, targetFilePath, err)
 		if err == nil && ok {
 			bar.Add(1)
 		}
@@ -281,7 +292,7 @@ func (r *Harvard) tryGetBody(sUrl string) (bs []byte, err error) {
 func (r *Harvard) getBodyByGui(apiUrl string) (bs []byte, err error) {
 	err = sharedmemory.WriteURLToSharedMemory(apiUrl)
 	if err != nil {
-		fmt.Println("Failed to write to shared memory:", err)
+		r.res.Log().Error("写入共享内存失败", "error", err)
 		return
 	}
 	for i := 0; i < 300; i++ {
@@ -298,7 +309,7 @@ func (r *Harvard) getBodyByGui(apiUrl string) (bs []byte, err error) {
 func (r *Harvard) imageDownloader(imgUrl, targetFilePath string) (ok bool, err error) {
 	err = sharedmemory.WriteURLImagePathToSharedMemory(imgUrl, targetFilePath)
 	if err != nil {
-		fmt.Println("Failed to write to shared memory:", err)
+		r.res.Log().Error("写入共享内存失败", "error", err)
 		return
 	}
 	for i := 0; i < 300; i++ {
@@ -317,8 +328,8 @@ func (r *Harvard) getBody(sUrl string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", config.Conf.UserAgent)
-	cookies, _ := chttp.ReadCookiesFromFile(config.Conf.CookieFile)
+	req.Header.Set("User-Agent", r.opts.UserAgent)
+	cookies, _ := chttp.ReadCookiesFromFile(r.opts.CookieFile)
 	if cookies != "" {
 		req.Header.Set("Cookie", cookies)
 	}
@@ -329,7 +340,7 @@ func (r *Harvard) getBody(sUrl string) ([]byte, error) {
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			log.Printf("close body err=%v", err)
+			r.res.Log().Warn("关闭响应失败", "error", err)
 		}
 	}()
 
@@ -368,14 +379,14 @@ func (r *Harvard) postBody(sUrl string, postData interface{}) ([]byte, error) {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 	// 设置请求头
-	req.Header.Set("User-Agent", config.Conf.UserAgent)
+	req.Header.Set("User-Agent", r.opts.UserAgent)
 	if isJSON {
 		req.Header.Set("Content-Type", "application/json")
 	} else {
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	}
 	// 添加cookie
-	cookies, _ := chttp.ReadCookiesFromFile(config.Conf.CookieFile)
+	cookies, _ := chttp.ReadCookiesFromFile(r.opts.CookieFile)
 	if cookies != "" {
 		req.Header.Set("Cookie", cookies)
 	}
@@ -387,7 +398,7 @@ func (r *Harvard) postBody(sUrl string, postData interface{}) ([]byte, error) {
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			log.Printf("warning: failed to close response body: %v", err)
+			r.res.Log().Warn("关闭响应失败", "error", err)
 		}
 	}()
 
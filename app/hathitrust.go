@@ -3,42 +3,61 @@ package app
 import (
 	"bookget/config"
 	"bookget/pkg/gohttp"
-	"bookget/pkg/util"
+	"bookget/pkg/logger"
+	"bookget/pkg/ratelimit"
+	"bookget/pkg/retry"
 	"bytes"
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"path"
 	"regexp"
 	"strconv"
+	"time"
 )
 
 type Hathitrust struct {
 	dt *DownloadTask
+
+	ctx  context.Context
+	opts *config.Input
+	res  *Result
 }
 
-func NewHathitrust() *Hathitrust {
+func init() {
+	Register(Site{
+		ID:       "hathitrust",
+		Name:     "[美国]hathitrust 数字图书馆",
+		Country:  "US",
+		Hosts:    []string{"babel.hathitrust.org"},
+		Examples: []string{"https://babel.hathitrust.org/cgi/pt?id={id}"},
+		New:      func(ctx context.Context, opts *config.Input) RouterInit { return NewHathitrust(ctx, opts) },
+	})
+}
+
+func NewHathitrust(ctx context.Context, opts *config.Input) *Hathitrust {
 	return &Hathitrust{
 		// 初始化字段
-		dt: new(DownloadTask),
+		ctx:  ctx,
+		opts: opts,
+		dt:   new(DownloadTask),
 	}
 }
 
-func (r *Hathitrust) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Hathitrust) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (r Hathitrust) Run(sUrl string) (msg string, err error) {
 	r.dt.UrlParsed, err = url.Parse(sUrl)
 	r.dt.Url = sUrl
 	r.dt.BookId = r.getBookId(r.dt.Url)
+	r.res.SetBook(r.dt.BookId, "")
 	if r.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -55,13 +74,13 @@ func (r Hathitrust) getBookId(sUrl string) (bookId string) {
 }
 
 func (r Hathitrust) download() (msg string, err error) {
-	log.Printf("Get %s\n", r.dt.Url)
+	r.res.Log().Info("获取图书信息", logger.KeyUrl, r.dt.Url)
 	canvases, err := r.getCanvases(r.dt.Url, r.dt.Jar)
 	if err != nil {
-		fmt.Println(err.Error())
+		r.res.Log().Error("获取页面列表失败", "error", err)
 		return "requested URL was not found.", err
 	}
-	r.dt.SavePath = config.Conf.Directory
+	r.dt.SavePath = r.opts.Directory
 	msg, err = r.do(canvases)
 	return msg, err
 }
@@ -74,42 +93,45 @@ func (r Hathitrust) do(imgUrls []string) (msg string, err error) {
 	referer := url.QueryEscape(r.dt.Url)
 	size := len(imgUrls)
 	for i, uri := range imgUrls {
-		if !config.PageRange(i, size) {
+		if !r.opts.PageRange(i, size) {
 			continue
 		}
 		if uri == "" {
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
-		filename := sortId + config.Conf.FileExt
+		filename := sortId + r.opts.FileExt
 		dest := path.Join(r.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !r.res.Pending(uri, dest) {
 			continue
 		}
-		log.Printf("Get %d/%d, URL: %s\n", i+1, size, uri)
+		r.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", size, logger.KeyUrl, uri)
 		opts := gohttp.Options{
 			DestFile:    dest,
 			Overwrite:   false,
 			Concurrency: 1,
-			CookieFile:  config.Conf.CookieFile,
-			HeaderFile:  config.Conf.HeaderFile,
+			CookieFile:  r.opts.CookieFile,
+			HeaderFile:  r.opts.HeaderFile,
 			CookieJar:   r.dt.Jar,
 			Headers: map[string]interface{}{
-				"User-Agent": config.Conf.UserAgent,
+				"User-Agent": r.opts.UserAgent,
 				"Referer":    referer,
 			},
 		}
-		ctx := context.Background()
-		for {
+		ctx := r.ctx
+		err := retry.New(r.opts.Retries).Do(ctx, func(int) error {
 			_, err := gohttp.FastGet(ctx, uri, opts)
-			if err != nil {
-				fmt.Println(err)
+			if err != nil && retry.Classify(err) != retry.Permanent {
+				r.res.Log().Error("请求失败", "error", err)
 				//log.Println("images (1 file per page, watermarked,  max. 20 MB / 1 min), image quality:Full")
-				util.PrintSleepTime(60)
-				continue
+				// 暂停访问该站点一分钟，并发的其它任务同样等待
+				if u, perr := url.Parse(uri); perr == nil {
+					ratelimit.Block(u.Hostname(), time.Minute)
+				}
 			}
-			break
-		}
+			return err
+		})
+		r.res.Record(sortId, uri, dest, err)
 	}
 	fmt.Println()
 	return "", err
@@ -137,7 +159,7 @@ func (r Hathitrust) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []str
 	size, _ := strconv.Atoi(matches[1])
 
 	canvases = make([]string, 0, size)
-	ext := config.Conf.FileExt
+	ext := r.opts.FileExt
 	format := "jpeg"
 	if ext == ".png" {
 		format = "png"
@@ -152,12 +174,12 @@ func (r Hathitrust) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []str
 }
 
 func (r Hathitrust) getBody(apiUrl string, jar *cookiejar.Jar) ([]byte, error) {
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": r.opts.UserAgent,
 		},
 	})
 	resp, err := cli.Get(apiUrl)
@@ -4,38 +4,55 @@ import (
 	"bookget/config"
 	"bookget/model/iiif"
 	"bookget/pkg/gohttp"
-	"bookget/pkg/util"
+	"bookget/pkg/logger"
+	"bookget/pkg/ratelimit"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"path"
 	"regexp"
 	"strings"
+	"time"
 )
 
 type Hkulib struct {
 	dt     *DownloadTask
 	apiUrl string
+
+	ctx  context.Context
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:           "hkulib",
+		Name:         "[中国]香港大学数字图书",
+		Country:      "CN",
+		Hosts:        []string{"digitalrepository.lib.hku.hk"},
+		Capabilities: CapIIIF,
+		Examples:     []string{"https://digitalrepository.lib.hku.hk/catalog/{id}"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewHkulib(ctx, opts) },
+	})
 }
 
-func NewHkulib() *Hkulib {
+func NewHkulib(ctx context.Context, opts *config.Input) *Hkulib {
 	return &Hkulib{
 		// 初始化字段
-		dt: new(DownloadTask),
+		ctx:  ctx,
+		opts: opts,
+		dt:   new(DownloadTask),
 	}
 }
 
-func (r *Hkulib) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Hkulib) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"type": "iiif",
-		"url":  sUrl,
-		"msg":  msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (r *Hkulib) Run(sUrl string) (msg string, err error) {
@@ -44,6 +61,7 @@ func (r *Hkulib) Run(sUrl string) (msg string, err error) {
 	r.dt.Url = sUrl
 
 	r.dt.BookId = r.getBookId(r.dt.Url)
+	r.res.SetBook(r.dt.BookId, "")
 	if r.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -61,25 +79,26 @@ func (r *Hkulib) getBookId(sUrl string) (bookId string) {
 }
 
 func (r *Hkulib) download() (msg string, err error) {
-	log.Printf("Get %s\n", r.dt.Url)
+	r.res.Log().Info("获取图书信息", logger.KeyUrl, r.dt.Url)
 
 	respVolume, err := r.getVolumes(r.dt.Url, r.dt.Jar)
+	r.res.SetVolumes(len(respVolume))
 	if err != nil {
-		fmt.Println(err)
+		r.res.Log().Error("获取册列表失败", "error", err)
 		return "getVolumes", err
 	}
 	for i, vol := range respVolume {
-		if !config.VolumeRange(i) {
+		if !r.opts.VolumeRange(i) {
 			continue
 		}
 		vid := fmt.Sprintf("%04d", i+1)
-		r.dt.SavePath = CreateDirectory(vid)
+		r.dt.SavePath = CreateDirectory(r.opts, vid)
 		canvases, err := r.getCanvases(vol, r.dt.Jar)
 		if err != nil || canvases == nil {
-			fmt.Println(err)
+			r.res.Log().Error("获取页面列表失败", "error", err)
 			continue
 		}
-		log.Printf(" %d/%d volume, %d pages \n", i+1, len(respVolume), len(canvases))
+		r.res.Log().Info("下载分册", logger.KeyVolume, i+1, "volumes", len(respVolume), "pages", len(canvases))
 		r.do(canvases)
 	}
 	return "", nil
@@ -92,38 +111,41 @@ func (r *Hkulib) do(imgUrls []string) (msg string, err error) {
 	fmt.Println()
 	referer := url.QueryEscape(r.dt.Url)
 	size := len(imgUrls)
-	ctx := context.Background()
+	ctx := r.ctx
 	for i, uri := range imgUrls {
-		if uri == "" || !config.PageRange(i, size) {
+		if uri == "" || !r.opts.PageRange(i, size) {
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
-		filename := sortId + config.Conf.FileExt
+		filename := sortId + r.opts.FileExt
 		dest := path.Join(r.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !r.res.Pending(uri, dest) {
 			continue
 		}
-		log.Printf("Get %d/%d page, URL: %s\n", i+1, size, uri)
+		r.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", size, logger.KeyUrl, uri)
 		opts := gohttp.Options{
 			DestFile:    dest,
 			Overwrite:   false,
 			Concurrency: 1,
-			CookieFile:  config.Conf.CookieFile,
-			HeaderFile:  config.Conf.HeaderFile,
+			CookieFile:  r.opts.CookieFile,
+			HeaderFile:  r.opts.HeaderFile,
 			CookieJar:   r.dt.Jar,
 			Headers: map[string]interface{}{
-				"User-Agent": config.Conf.UserAgent,
+				"User-Agent": r.opts.UserAgent,
 				"Referer":    referer,
 			},
 		}
 		_, err := gohttp.FastGet(ctx, uri, opts)
+		r.res.Record(sortId, uri, dest, err)
 		if err != nil {
-			fmt.Println(err)
-			util.PrintSleepTime(60)
+			// 暂停访问该站点一分钟，并发的其它任务同样等待
+			if u, perr := url.Parse(uri); perr == nil {
+				ratelimit.Block(u.Hostname(), time.Minute)
+			}
 			continue
 		}
 		fmt.Println()
-		//util.PrintSleepTime(config.Conf.Sleep)
+		//util.PrintSleepTime(r.opts.Sleep)
 	}
 	fmt.Println()
 	return "", err
@@ -153,7 +175,7 @@ func (r *Hkulib) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []string
 		return nil, err
 	}
 	if err = json.Unmarshal(bs, manifest); err != nil {
-		log.Printf("json.Unmarshal failed: %s\n", err)
+		r.res.Log().Error("解析 JSON 失败", "error", err)
 		return
 	}
 	if len(manifest.Sequences) == 0 {
@@ -174,12 +196,12 @@ func (r *Hkulib) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []string
 
 func (r *Hkulib) getBody(apiUrl string, jar *cookiejar.Jar) ([]byte, error) {
 	referer := url.QueryEscape(apiUrl)
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": r.opts.UserAgent,
 			"Referer":    referer,
 		},
 	})
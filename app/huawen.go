@@ -3,11 +3,11 @@ package app
 import (
 	"bookget/config"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
 	"bookget/pkg/util"
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"path"
@@ -17,21 +17,37 @@ import (
 
 type Huawen struct {
 	dt *DownloadTask
+
+	ctx  context.Context
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:       "huawen",
+		Name:     "[中国]臺灣華文電子書庫",
+		Country:  "CN",
+		Hosts:    []string{"taiwanebook.ncl.edu.tw"},
+		Examples: []string{"https://taiwanebook.ncl.edu.tw/zh-tw/book/{id}/reader"},
+		New:      func(ctx context.Context, opts *config.Input) RouterInit { return NewHuawen(ctx, opts) },
+	})
 }
 
-func NewHuawen() *Huawen {
+func NewHuawen(ctx context.Context, opts *config.Input) *Huawen {
 	return &Huawen{
 		// 初始化字段
-		dt: new(DownloadTask),
+		ctx:  ctx,
+		opts: opts,
+		dt:   new(DownloadTask),
 	}
 }
 
-func (r *Huawen) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Huawen) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (r *Huawen) Run(sUrl string) (msg string, err error) {
@@ -43,6 +59,7 @@ func (r *Huawen) Run(sUrl string) (msg string, err error) {
 	r.dt.Url = sUrl
 
 	r.dt.BookId = getBookId(r.dt.Url)
+	r.res.SetBook(r.dt.BookId, "")
 	if r.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -56,19 +73,20 @@ func (r *Huawen) getBookId(sUrl string) (bookId string) {
 }
 
 func (r *Huawen) download() (msg string, err error) {
-	log.Printf("Get %s\n", r.dt.Url)
+	r.res.Log().Info("获取图书信息", logger.KeyUrl, r.dt.Url)
 
 	respVolume, err := r.getVolumes(r.dt.Url, r.dt.Jar)
+	r.res.SetVolumes(len(respVolume))
 	if err != nil {
-		fmt.Println(err)
+		r.res.Log().Error("获取册列表失败", "error", err)
 		return "getVolumes", err
 	}
 	for i, vol := range respVolume {
-		if !config.VolumeRange(i) {
+		if !r.opts.VolumeRange(i) {
 			continue
 		}
-		r.dt.SavePath = config.Conf.Directory
-		log.Printf(" %d/%d PDFs \n", i+1, len(respVolume))
+		r.dt.SavePath = r.opts.Directory
+		r.res.Log().Info("下载 PDF", logger.KeyVolume, i+1, "volumes", len(respVolume))
 		r.do(vol)
 	}
 	return "", nil
@@ -77,28 +95,26 @@ func (r *Huawen) download() (msg string, err error) {
 func (r *Huawen) do(pdfUrl string) (msg string, err error) {
 	filename := util.FileName(pdfUrl)
 	dest := path.Join(r.dt.SavePath, filename)
-	if FileExist(dest) {
+	if !r.res.Pending(pdfUrl, dest) {
 		return "", nil
 	}
 	u, err := url.Parse(pdfUrl)
-	ctx := context.Background()
+	ctx := r.ctx
 	opts := gohttp.Options{
 		DestFile:    dest,
 		Overwrite:   false,
 		Concurrency: 1,
-		CookieFile:  config.Conf.CookieFile,
-		HeaderFile:  config.Conf.HeaderFile,
+		CookieFile:  r.opts.CookieFile,
+		HeaderFile:  r.opts.HeaderFile,
 		CookieJar:   r.dt.Jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": r.opts.UserAgent,
 			"Referer":    "https://" + r.dt.UrlParsed.Host + "/pdfjs/web/viewer.html?file=" + u.Path,
 		},
 	}
 	_, err = gohttp.FastGet(ctx, pdfUrl, opts)
-	if err != nil {
-		fmt.Println(err)
-	}
-	util.PrintSleepTime(config.Conf.Sleep)
+	r.res.Record(filename, pdfUrl, dest, err)
+	util.PrintSleepTime(r.opts.Sleep)
 	fmt.Println()
 	return "", nil
 }
@@ -130,12 +146,12 @@ func (r *Huawen) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []string
 
 func (r *Huawen) getBody(apiUrl string, jar *cookiejar.Jar) ([]byte, error) {
 	referer := url.QueryEscape(apiUrl)
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": r.opts.UserAgent,
 			"Referer":    referer,
 		},
 	})
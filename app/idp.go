@@ -3,11 +3,11 @@ package app
 import (
 	"bookget/config"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
 	"bookget/pkg/progressbar"
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"path/filepath"
@@ -17,27 +17,44 @@ import (
 type Idp struct {
 	dt  *DownloadTask
 	bar *progressbar.ProgressBar
+
+	ctx  context.Context
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:           "idp",
+		Name:         "國際敦煌項目",
+		Country:      "",
+		HostPatterns: []string{`^idp\.`},
+		Examples:     []string{"http://idp.nlc.cn/database/oo_scroll_h.a4d?uid={id}", "http://idp.bl.uk/database/oo_scroll_h.a4d?uid={id}"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewIdp(ctx, opts) },
+	})
 }
 
-func NewIdp() *Idp {
+func NewIdp(ctx context.Context, opts *config.Input) *Idp {
 	return &Idp{
 		// 初始化字段
-		dt: new(DownloadTask),
+		ctx:  ctx,
+		opts: opts,
+		dt:   new(DownloadTask),
 	}
 }
 
-func (r *Idp) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Idp) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (r *Idp) Run(sUrl string) (msg string, err error) {
 	r.dt.UrlParsed, err = url.Parse(sUrl)
 	r.dt.Url = sUrl
 	r.dt.BookId = r.getBookId(r.dt.Url)
+	r.res.SetBook(r.dt.BookId, "")
 	if r.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -54,37 +71,41 @@ func (r *Idp) getBookId(sUrl string) (bookId string) {
 }
 
 func (r *Idp) download() (msg string, err error) {
-	log.Printf("Get %s\n", r.dt.Url)
+	r.res.Log().Info("获取图书信息", logger.KeyUrl, r.dt.Url)
 
 	canvases, err := r.getCanvases(r.dt.BookId, r.dt.Jar)
 	if err != nil || canvases == nil {
-		fmt.Println(err)
+		r.res.Log().Error("获取页面列表失败", "error", err)
 		return "requested URL was not found.", err
 	}
 	//不按卷下载，所有图片存一个目录
-	r.dt.SavePath = config.Conf.Directory
+	r.dt.SavePath = r.opts.Directory
 	sizeCanvases := len(canvases)
 	fmt.Println()
 	ext := ".jpg"
 	r.bar = progressbar.Default(int64(sizeCanvases), "downloading")
-	ctx := context.Background()
+	ctx := r.ctx
 	for i, imgUrl := range canvases {
-		if !config.PageRange(i, sizeCanvases) || imgUrl == "" {
+		if !r.opts.PageRange(i, sizeCanvases) || imgUrl == "" {
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
 		dest := filepath.Join(r.dt.SavePath, sortId+ext)
+		if !r.res.Pending(imgUrl, dest) {
+			r.bar.Add(1)
+			continue
+		}
 		cli := gohttp.NewClient(ctx, gohttp.Options{
 			DestFile:   dest,
 			CookieJar:  r.dt.Jar,
-			CookieFile: config.Conf.CookieFile,
+			CookieFile: r.opts.CookieFile,
 			Headers: map[string]interface{}{
-				"User-Agent": config.Conf.UserAgent,
+				"User-Agent": r.opts.UserAgent,
 			},
 		})
 		_, err = cli.Get(imgUrl)
+		r.res.Record(sortId, imgUrl, dest, err)
 		if err != nil {
-			log.Println(err)
 			break
 		}
 		r.bar.Add(1)
@@ -124,12 +145,12 @@ func (r *Idp) getCanvases(sUrl string, jar *cookiejar.Jar) ([]string, error) {
 }
 
 func (r *Idp) getBody(sUrl string, jar *cookiejar.Jar) ([]byte, error) {
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": r.opts.UserAgent,
 		},
 	})
 	resp, err := cli.Get(sUrl)
@@ -5,17 +5,18 @@ import (
 	"bookget/model/iiif"
 	"bookget/pkg/downloader"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
 	"bookget/pkg/util"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"path"
 	"regexp"
 	"strings"
+	"sync"
 )
 
 type IIIF struct {
@@ -23,23 +24,79 @@ type IIIF struct {
 	xmlContent []byte
 	ctx        context.Context
 	bookId     string
+
+	opts *config.Input
+	res  *Result
 }
 
-func NewIiifRouter() *IIIF {
+func init() {
+	//[日本]駒澤大学 电子贵重书库
+	Register(Site{
+		ID:           "komazawa",
+		Name:         "[日本]駒澤大学 电子贵重书库",
+		Country:      "JP",
+		Hosts:        []string{"repo.komazawa-u.ac.jp"},
+		Capabilities: CapIIIF,
+		Examples:     []string{"https://repo.komazawa-u.ac.jp/{path}/manifest.json"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewIiifRouter(ctx, opts) },
+	})
+	//[日本]关西大学图书馆
+	Register(Site{
+		ID:           "kansai",
+		Name:         "[日本]关西大学图书馆",
+		Country:      "JP",
+		Hosts:        []string{"iiif.ku-orcas.kansai-u.ac.jp"},
+		Capabilities: CapIIIF,
+		Examples:     []string{"https://www.iiif.ku-orcas.kansai-u.ac.jp/{path}/manifest.json"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewIiifRouter(ctx, opts) },
+	})
+	//[日本]庆应义塾大学图书馆
+	Register(Site{
+		ID:           "keiolib",
+		Name:         "[日本]庆应义塾大学图书馆",
+		Country:      "JP",
+		Hosts:        []string{"dcollections.lib.keio.ac.jp"},
+		Capabilities: CapIIIF,
+		Examples:     []string{"https://dcollections.lib.keio.ac.jp/sites/default/files/iiif/KAN/110X-24-1/manifest.json"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewIiifRouter(ctx, opts) },
+	})
+	//[德国]巴伐利亞州立圖書館 IIIF manifest
+	Register(Site{
+		ID:           "sammlungen-iiif",
+		Name:         "[德国]巴伐利亞州立圖書館 IIIF",
+		Country:      "DE",
+		Hosts:        []string{"digitale-sammlungen.de"},
+		Paths:        []string{`^/iiif/`},
+		Capabilities: CapIIIF,
+		Examples:     []string{"https://api.digitale-sammlungen.de/iiif/presentation/v2/{id}/manifest"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewIiifRouter(ctx, opts) },
+	})
+	//任意站点的 IIIF manifest.json
+	Register(Site{
+		ID:           "iiif",
+		Name:         "IIIF manifest（通用）",
+		Paths:        []string{`\.json`},
+		Priority:     10,
+		Capabilities: CapIIIF,
+		Examples:     []string{"https://catalog.lib.kyushu-u.ac.jp/image/manifest/1/820/1446033.json"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewIiifRouter(ctx, opts) },
+	})
+}
+
+func NewIiifRouter(ctx context.Context, opts *config.Input) *IIIF {
 	return &IIIF{
 		// 初始化字段
-		dt:  new(DownloadTask),
-		ctx: context.Background(),
+		opts: opts,
+		dt:   new(DownloadTask),
+		ctx:  ctx,
 	}
 }
 
-func (i *IIIF) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (i *IIIF) GetRouterInit(sUrl string) (*Result, error) {
+	i.res = NewResult(i.ctx, i.opts, sUrl)
 	msg, err := i.Run(sUrl)
-	return map[string]interface{}{
-		"type": "iiif",
-		"url":  sUrl,
-		"msg":  msg,
-	}, err
+	i.res.SetBook(i.dt.BookId, i.dt.Title)
+	return i.res.Finish(msg, err)
 }
 
 func (i *IIIF) Run(sUrl string) (msg string, err error) {
@@ -47,6 +104,7 @@ func (i *IIIF) Run(sUrl string) (msg string, err error) {
 	i.dt.Url = sUrl
 	i.dt.Jar, _ = cookiejar.New(nil)
 	i.dt.BookId = i.getBookId(i.dt.Url)
+	i.res.SetBook(i.dt.BookId, "")
 	if i.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -60,6 +118,7 @@ func (i *IIIF) InitWithId(iTask int, sUrl string, id string) (msg string, err er
 	i.dt.Index = iTask
 	i.dt.Jar, _ = cookiejar.New(nil)
 	i.dt.BookId = id
+	i.res.SetBook(i.dt.BookId, "")
 	return i.download()
 }
 
@@ -90,12 +149,12 @@ func (i *IIIF) download() (msg string, err error) {
 	if err != nil || canvases == nil {
 		return
 	}
-	i.dt.SavePath = config.Conf.Directory
+	i.dt.SavePath = i.opts.Directory
 	return i.do(canvases)
 }
 
 func (i *IIIF) do(imgUrls []string) (msg string, err error) {
-	if config.Conf.UseDzi {
+	if i.opts.UseDzi {
 		i.doDezoomify(imgUrls)
 	} else {
 		i.doNormal(imgUrls)
@@ -106,7 +165,7 @@ func (i *IIIF) do(imgUrls []string) (msg string, err error) {
 func (i *IIIF) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []string, err error) {
 	var manifest = new(iiif.ManifestResponse)
 	if err = json.Unmarshal(i.xmlContent, manifest); err != nil {
-		log.Printf("json.Unmarshal failed: %s\n", err)
+		i.res.Log().Error("解析 JSON 失败", "error", err)
 		return
 	}
 	if len(manifest.Sequences) == 0 {
@@ -116,15 +175,9 @@ func (i *IIIF) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []string,
 	canvases = make([]string, 0, size)
 	for _, canvase := range manifest.Sequences[0].Canvases {
 		for _, image := range canvase.Images {
-			if config.Conf.UseDzi {
-				//dezoomify-rs URL
-				iiiInfo := fmt.Sprintf("%s/info.json", image.Resource.Service.Id)
-				canvases = append(canvases, iiiInfo)
-			} else {
-				//JPEG URL
-				imgUrl := image.Resource.Service.Id + "/" + config.Conf.Format
-				canvases = append(canvases, imgUrl)
-			}
+			//info.json，整图下载时也先读取，按服务器的限制协商尺寸
+			iiiInfo := fmt.Sprintf("%s/info.json", image.Resource.Service.Id)
+			canvases = append(canvases, iiiInfo)
 		}
 	}
 	return canvases, nil
@@ -133,7 +186,7 @@ func (i *IIIF) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []string,
 func (i *IIIF) getCanvasesV3(sUrl string, jar *cookiejar.Jar) (canvases []string, err error) {
 	var manifest = new(iiif.ManifestV3Response)
 	if err = json.Unmarshal(i.xmlContent, manifest); err != nil {
-		log.Printf("json.Unmarshal failed: %s\n", err)
+		i.res.Log().Error("解析 JSON 失败", "error", err)
 		return
 	}
 	if len(manifest.Canvases) == 0 {
@@ -141,33 +194,26 @@ func (i *IIIF) getCanvasesV3(sUrl string, jar *cookiejar.Jar) (canvases []string
 	}
 	size := len(manifest.Canvases)
 	canvases = make([]string, 0, size)
-	//config.Conf.Format = strings.ReplaceAll(config.Conf.Format, "full/full", "full/max")
 	for _, canvase := range manifest.Canvases {
 		image := canvase.Items[0].Items[0]
 		id := image.Body.Service[0].Id
 		if id == "" && image.Body.Service[0].Id_ != "" {
 			id = image.Body.Service[0].Id_
 		}
-		if config.Conf.UseDzi {
-			//dezoomify-rs URL
-			iiiInfo := fmt.Sprintf("%s/info.json", id)
-			canvases = append(canvases, iiiInfo)
-		} else {
-			//JPEG URL
-			imgUrl := id + "/" + config.Conf.Format
-			canvases = append(canvases, imgUrl)
-		}
+		//info.json，整图下载时也先读取，按服务器的限制协商尺寸
+		iiiInfo := fmt.Sprintf("%s/info.json", id)
+		canvases = append(canvases, iiiInfo)
 	}
 	return canvases, nil
 }
 
 func (i *IIIF) getBody(sUrl string, jar *cookiejar.Jar) ([]byte, error) {
-	ctx := context.Background()
+	ctx := i.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: i.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": i.opts.UserAgent,
 		},
 	})
 	resp, err := cli.Get(sUrl)
@@ -203,66 +249,104 @@ func (i *IIIF) doDezoomify(iiifUrls []string) bool {
 		"-H", "Referer:" + referer,
 	}
 	size := len(iiifUrls)
-	iiifDownloader := downloader.NewIIIFDownloader(&config.Conf)
-	for k, uri := range iiifUrls {
-		if uri == "" || !config.PageRange(k, size) {
-			continue
+	iiifDownloader := i.newDownloader()
+	i.eachPage(iiifUrls, func(string) string { return i.opts.FileExt }, func(k int, sortId, uri, dest string) {
+		i.res.Log().Info("下载页面", logger.KeyPage, k+1, "total", size, logger.KeyUrl, uri)
+
+		err := iiifDownloader.Dezoomify(i.ctx, uri, dest, args)
+		i.res.Record(sortId, uri, dest, err)
+	})
+	return true
+}
+
+func (i *IIIF) doNormal(iiifUrls []string) bool {
+	if iiifUrls == nil {
+		return false
+	}
+	referer := url.QueryEscape(i.dt.Url)
+	args := []string{
+		"-H", "Origin:" + referer,
+		"-H", "Referer:" + referer,
+	}
+	size := len(iiifUrls)
+	fmt.Println()
+	//--format 中的尺寸只是偏好，服务器不接受时依次降级，最后改为拼图下载
+	iiifDownloader := i.newDownloader()
+	ext := util.FileExt(i.opts.Format)
+	if ext == "" {
+		ext = i.opts.FileExt
+	}
+	if i.opts.DryRun {
+		//下载计划中显示协商出的图像 URL
+		iiifUrls = i.imageUrls(iiifDownloader, iiifUrls, args)
+	}
+	i.eachPage(iiifUrls, func(string) string { return ext }, func(k int, sortId, uri, dest string) {
+		i.res.Log().Info("下载页面", logger.KeyPage, k+1, "total", size, logger.KeyUrl, uri)
+		imgUrl, err := iiifDownloader.DownloadImage(i.ctx, uri, dest, i.opts.Format, args)
+		if imgUrl == "" {
+			imgUrl = uri
 		}
-		sortId := fmt.Sprintf("%04d", k+1)
+		i.res.Record(sortId, imgUrl, dest, err)
+		fmt.Println()
+	})
+	return true
+}
 
-		filename := sortId + config.Conf.FileExt
-		dest := path.Join(i.dt.SavePath, filename)
-		if FileExist(dest) {
+// imageUrls 页面范围内各页按 info.json 协商出的图像 URL，读取失败的保留 info.json
+func (i *IIIF) imageUrls(d *downloader.IIIFDownloader, infoUrls []string, args []string) []string {
+	urls := make([]string, len(infoUrls))
+	for k, uri := range infoUrls {
+		urls[k] = uri
+		if uri == "" || !i.opts.PageRange(k, len(infoUrls)) {
 			continue
 		}
-		log.Printf("Get %d/%d  %s\n", k+1, size, uri)
-
-		err := iiifDownloader.Dezoomify(i.ctx, uri, dest, args)
-		if err != nil {
-			log.Printf("\nDezoomify failed: %s\n", err)
+		if imgUrl, err := d.ImageURL(i.ctx, uri, i.opts.Format, args); err == nil {
+			urls[k] = imgUrl
 		}
-
 	}
-	return true
+	return urls
 }
 
-func (i *IIIF) doNormal(imgUrls []string) bool {
-	if imgUrls == nil {
-		return false
+// newDownloader 各页平分拼图的并发数，同时进行的请求总数不变；与读取 manifest 共用 cookie
+func (i *IIIF) newDownloader() *downloader.IIIFDownloader {
+	tileOpts := *i.opts
+	tileOpts.MaxConcurrent = max(1, i.opts.MaxConcurrent/i.pageWorkers())
+	d := downloader.NewIIIFDownloader(&tileOpts)
+	if i.dt.Jar != nil {
+		d.SetCookieJar(i.dt.Jar)
 	}
-	size := len(imgUrls)
-	fmt.Println()
-	ctx := context.Background()
-	for k, uri := range imgUrls {
-		if uri == "" || !config.PageRange(k, size) {
+	return d
+}
+
+// pageWorkers 同时下载的页数
+func (i *IIIF) pageWorkers() int {
+	return max(1, i.opts.PageConcurrency)
+}
+
+// eachPage 按页码顺序筛选页面范围内、尚未下载的页，交给 pageWorkers 个协程执行 download。
+// 文件名为 4 位页码加 ext 返回的扩展名；请求经过共享的 HTTP 客户端，与拼图下载共用每个站点的访问限制
+func (i *IIIF) eachPage(urls []string, ext func(uri string) string, download func(k int, sortId, uri, dest string)) {
+	size := len(urls)
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, i.pageWorkers())
+	for k, uri := range urls {
+		if uri == "" || !i.opts.PageRange(k, size) {
 			continue
 		}
-		ext := util.FileExt(uri)
 		sortId := fmt.Sprintf("%04d", k+1)
-		filename := sortId + ext
-		dest := path.Join(i.dt.SavePath, filename)
-		if FileExist(dest) {
+		dest := path.Join(i.dt.SavePath, sortId+ext(uri))
+		if !i.res.Pending(uri, dest) {
 			continue
 		}
-		log.Printf("Get %d/%d  %s\n", k+1, size, uri)
-		opts := gohttp.Options{
-			DestFile:    dest,
-			Overwrite:   false,
-			Concurrency: 1,
-			CookieFile:  config.Conf.CookieFile,
-			HeaderFile:  config.Conf.HeaderFile,
-			CookieJar:   i.dt.Jar,
-			Headers: map[string]interface{}{
-				"User-Agent": config.Conf.UserAgent,
-			},
-		}
-		_, err := gohttp.FastGet(ctx, uri, opts)
-		if err != nil {
-			fmt.Println(err)
-		}
-		fmt.Println()
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(k int, sortId, uri, dest string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			download(k, sortId, uri, dest)
+		}(k, sortId, uri, dest)
 	}
-	return true
+	wg.Wait()
 }
 
 func (i *IIIF) checkVersion(bs []byte) (int, error) {
@@ -0,0 +1,50 @@
+package app
+
+import (
+	"bookget/config"
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIIIFEachPage(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "0003.jpg"), []byte("jpeg"), 0644))
+	opts := &config.Input{PageConcurrency: 3, SeqStart: 2, SeqEnd: 7}
+	r := NewIiifRouter(context.Background(), opts)
+	r.res = NewResult(context.Background(), opts, "https://example.org/manifest.json")
+	r.dt.SavePath = dir
+
+	urls := []string{"u1", "u2", "u3", "", "u5", "u6", "u7", "u8"}
+	var (
+		mu       sync.Mutex
+		got      []string
+		inflight atomic.Int32
+		peak     atomic.Int32
+	)
+	r.eachPage(urls, func(string) string { return ".jpg" }, func(k int, sortId, uri, dest string) {
+		n := inflight.Add(1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(20 * time.Millisecond)
+		inflight.Add(-1)
+		mu.Lock()
+		got = append(got, filepath.Base(dest))
+		mu.Unlock()
+	})
+
+	sort.Strings(got)
+	assert.Equal(t, []string{"0002.jpg", "0005.jpg", "0006.jpg", "0007.jpg"}, got)
+	assert.GreaterOrEqual(t, peak.Load(), int32(2))
+	assert.LessOrEqual(t, peak.Load(), int32(3))
+	assert.Equal(t, 5, r.res.Planned)
+	assert.Equal(t, 1, r.res.Skipped)
+}
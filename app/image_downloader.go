@@ -3,15 +3,20 @@ package app
 import (
 	"bookget/config"
 	"bookget/pkg/chttp"
+	"bookget/pkg/downloader"
+	"bookget/pkg/event"
+	"bookget/pkg/httpclient"
+	"bookget/pkg/logger"
+	"bookget/pkg/retry"
+	"bookget/pkg/util"
+	"bookget/pkg/validate"
 	"bufio"
 	"bytes"
 	"context"
-	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"net/http/cookiejar"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -19,14 +24,15 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
-
-	"bookget/pkg/progressbar"
 )
 
 const (
 	minFileSize = 1024 // 最小文件大小(1KB)
 )
 
+// imagesJob 进度条说明
+const imagesJob = "总下载进度"
+
 type ImageDownloader struct {
 	client            *http.Client
 	reader            *bufio.Reader
@@ -36,36 +42,49 @@ type ImageDownloader struct {
 	abIsLowercase     bool   // 占位符是否为小写
 	maxConcurrent     int
 
+	events    event.Bus    // 下载进度，终端进度条是其中一个订阅者
+	succeeded atomic.Int64 // 本次成功下载的文件数
+	failed    atomic.Int64 // 本次失败的页数
+
 	ctx context.Context
+
+	opts *config.Input
+	res  *Result
 }
 
-func NewImageDownloader() *ImageDownloader {
-	// 创建自定义 Transport 忽略 SSL 验证
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-	}
-	jar, _ := cookiejar.New(nil)
+func init() {
+	Register(Site{
+		ID:      "bookget",
+		Name:    "通用批量下载",
+		Country: "",
+		New:     func(ctx context.Context, opts *config.Input) RouterInit { return NewImageDownloader(ctx, opts) },
+	})
+}
 
-	return &ImageDownloader{
+func NewImageDownloader(ctx context.Context, opts *config.Input) *ImageDownloader {
+	i := &ImageDownloader{
 		// 初始化字段
-		client:            &http.Client{Timeout: config.Conf.Timeout * time.Second, Jar: jar, Transport: tr},
+		opts:              opts,
+		client:            httpclient.ForSite(opts),
 		reader:            bufio.NewReader(os.Stdin),
 		hasVolPlaceholder: false,
-		maxConcurrent:     config.Conf.MaxConcurrent,
-		ctx:               context.Background(),
+		maxConcurrent:     opts.MaxConcurrent,
+		ctx:               ctx,
 	}
+	i.events.Subscribe(&downloader.Terminal{Summary: true})
+	return i
+}
+
+// Events 返回事件总线，可订阅下载进度
+func (i *ImageDownloader) Events() *event.Bus {
+	return &i.events
 }
 
-func (i *ImageDownloader) GetRouterInit(rawUrl string) (map[string]interface{}, error) {
+func (i *ImageDownloader) GetRouterInit(rawUrl string) (*Result, error) {
+	i.res = NewResult(i.ctx, i.opts, rawUrl)
 	// 实现具体逻辑
 	i.Run(rawUrl)
-
-	return map[string]interface{}{
-		"type": "bookget",
-		"url":  rawUrl,
-	}, nil
+	return i.res.Finish("", nil)
 }
 
 func (i *ImageDownloader) Run(rawUrl string) {
@@ -202,23 +221,10 @@ func (i *ImageDownloader) downloadAll(urlTemplate string, startVol, endVol, tota
 	totalVolumes := endVol - startVol + 1
 	//totalExpected := totalPages * 2 // 假设每页都有A/B两面
 
-	var totalDownloaded int64
-	globalBar := progressbar.NewOptions64(
-		int64(totalPages),
-		progressbar.OptionSetDescription("总下载进度"),
-		progressbar.OptionSetTheme(progressbar.Theme{
-			Saucer:        "=",
-			SaucerHead:    ">",
-			SaucerPadding: " ",
-			BarStart:      "[",
-			BarEnd:        "]",
-		}),
-		progressbar.OptionShowCount(),
-		progressbar.OptionSetWidth(50),
-		progressbar.OptionOnCompletion(func() {
-			fmt.Printf("\n下载完成！共成功下载 %d 个文件\n", atomic.LoadInt64(&totalDownloaded))
-		}),
-	)
+	start := time.Now()
+	i.succeeded.Store(0)
+	i.failed.Store(0)
+	i.events.Emit(event.Event{Kind: event.JobStarted, Job: imagesJob, Total: int64(totalPages), Workers: i.maxConcurrent})
 
 	var wg sync.WaitGroup
 	semaphore := make(chan struct{}, i.maxConcurrent)
@@ -244,27 +250,31 @@ func (i *ImageDownloader) downloadAll(urlTemplate string, startVol, endVol, tota
 			volStr := fmt.Sprintf("%04d", volume)
 			var dirPath string
 			if i.hasVolPlaceholder {
-				dirPath = filepath.Join(config.Conf.Directory, volStr)
+				dirPath = filepath.Join(i.opts.Directory, volStr)
 			} else {
-				dirPath = config.Conf.Directory
+				dirPath = i.opts.Directory
 			}
 
-			if err := os.MkdirAll(dirPath, 0755); err != nil {
-				fmt.Printf("\n创建目录 %s 失败: %v\n", dirPath, err)
-				return
+			//dry-run 时不创建目录，各页只记入下载计划
+			if !i.opts.DryRun {
+				if err := os.MkdirAll(dirPath, 0755); err != nil {
+					i.res.Log().Error("创建目录失败", "dir", dirPath, logger.KeyVolume, volStr, "error", err)
+					return
+				}
 			}
 
 			for page := 1; page <= pagesThisVol; page++ {
-				i.downloadPageSmart(urlTemplate, volStr, page, dirPath, pageFormat, ext, globalBar, &totalDownloaded)
+				i.downloadPageSmart(urlTemplate, volStr, page, dirPath, pageFormat, ext)
 			}
 		}(vol, currentPages)
 	}
 
 	wg.Wait()
-	globalBar.Finish()
+	i.events.Emit(event.Event{Kind: event.JobFinished, Job: imagesJob, Succeeded: int(i.succeeded.Load()),
+		Failed: int(i.failed.Load()), Elapsed: time.Since(start)})
 }
 
-func (i *ImageDownloader) downloadPageSmart(urlTemplate, volStr string, page int, dirPath, pageFormat, ext string, globalBar *progressbar.ProgressBar, totalDownloaded *int64) {
+func (i *ImageDownloader) downloadPageSmart(urlTemplate, volStr string, page int, dirPath, pageFormat, ext string) {
 	// 构建页码格式
 	pageNum := fmt.Sprintf("%0"+pageFormat+"d", page)
 
@@ -284,7 +294,7 @@ func (i *ImageDownloader) downloadPageSmart(urlTemplate, volStr string, page int
 		// 构建A面URL
 		urlA := strings.Replace(url, "[PAGE]", pageNum, 1)
 		urlA = strings.Replace(urlA, i.abPlaceholder, abSuffix, 1)
-		err := i.downloadAndValidate(urlA, filepath.Join(dirPath, fmt.Sprintf("%s%s%s", pageNum, abSuffix, ext)), globalBar, totalDownloaded)
+		err := i.downloadAndValidate(urlA, filepath.Join(dirPath, fmt.Sprintf("%s%s%s", pageNum, abSuffix, ext)))
 
 		if err == nil {
 			// 如果A面存在，下载B面
@@ -295,43 +305,80 @@ func (i *ImageDownloader) downloadPageSmart(urlTemplate, volStr string, page int
 
 			urlB := strings.Replace(url, "[PAGE]", pageNum, 1)
 			urlB = strings.Replace(urlB, i.abPlaceholder, abSuffix, 1)
-			err = i.downloadAndValidate(urlB, filepath.Join(dirPath, fmt.Sprintf("%s%s%s", pageNum, abSuffix, ext)), globalBar, totalDownloaded)
+			err = i.downloadAndValidate(urlB, filepath.Join(dirPath, fmt.Sprintf("%s%s%s", pageNum, abSuffix, ext)))
 			if err != nil {
-				fmt.Printf("[err=downloadAndValidate]+%v\n", err)
+				i.pageFailed(pageNum, urlB, err)
 				return
 			}
 		} else {
 			urlPlain := strings.Replace(url, "[PAGE]", pageNum, 1)
 			urlPlain = strings.Replace(urlPlain, i.abPlaceholder, "", 1)
 			s := filepath.Join(dirPath, fmt.Sprintf("%s%s", pageNum, ext))
-			err = i.downloadAndValidate(urlPlain, s, globalBar, totalDownloaded)
+			err = i.downloadAndValidate(urlPlain, s)
 			if err != nil {
-				fmt.Printf("[err=downloadAndValidate]+%v\n", err)
+				i.pageFailed(pageNum, urlPlain, err)
 				return
 			}
 		}
 
 	} else {
 		urlPlain := strings.Replace(url, "[PAGE]", pageNum, 1)
-		err := i.downloadAndValidate(urlPlain, filepath.Join(dirPath, fmt.Sprintf("%s%s", pageNum, ext)), globalBar, totalDownloaded)
+		err := i.downloadAndValidate(urlPlain, filepath.Join(dirPath, fmt.Sprintf("%s%s", pageNum, ext)))
 		if err != nil {
-			fmt.Printf("[err=downloadAndValidate]+%v\n", err)
+			i.pageFailed(pageNum, urlPlain, err)
 			return
 		}
 	}
 }
 
-func (i *ImageDownloader) downloadAndValidate(url, filePath string, globalBar *progressbar.ProgressBar, totalDownloaded *int64) error {
-	req, err := http.NewRequest("GET", url, nil)
+// pageFailed 记录下载失败的页面
+func (i *ImageDownloader) pageFailed(pageNum, url string, err error) {
+	i.res.Record(pageNum, url, "", err)
+	i.failed.Add(1)
+	i.events.Emit(event.Event{Kind: event.TaskFailed, Job: imagesJob, Task: url, Err: err})
+}
+
+// downloadAndValidate 下载一页并检查内容（错误页、截断的图片等），无效时按重试策略重新下载，不写入文件
+// 目标文件已存在时跳过；dry-run 时只记入下载计划，视为成功，有 [AB] 时 B 面同样列出
+func (i *ImageDownloader) downloadAndValidate(url, filePath string) error {
+	if !i.res.Pending(url, filePath) {
+		return nil
+	}
+	i.events.Emit(event.Event{Kind: event.TaskStarted, Job: imagesJob, Task: url, File: filePath})
+	var data []byte
+	err := retry.New(i.opts.Retries).Do(i.ctx, func(attempt int) (err error) {
+		if attempt > 1 {
+			i.events.Emit(event.Event{Kind: event.TaskRetried, Job: imagesJob, Task: url, File: filePath, Attempt: attempt})
+		}
+		data, err = i.fetch(url, filepath.Ext(filePath))
+		return err
+	})
 	if err != nil {
 		return err
 	}
-	req.Header.Set("User-Agent", config.Conf.UserAgent)
-	cookies, _ := chttp.ReadCookiesFromFile(config.Conf.CookieFile)
+	if err := util.WriteFileAtomic(filePath, data, 0644); err != nil {
+		return fmt.Errorf("写入文件失败: %v", err)
+	}
+
+	i.res.Record(filepath.Base(filePath), url, filePath, nil)
+	i.succeeded.Add(1)
+	i.events.Emit(event.Event{Kind: event.TaskCompleted, Job: imagesJob, Task: url, File: filePath, Bytes: int64(len(data))})
+
+	return nil
+}
+
+// fetch 请求一页，返回通过内容检查的数据
+func (i *ImageDownloader) fetch(url, ext string) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", i.opts.UserAgent)
+	cookies, _ := chttp.ReadCookiesFromFile(i.opts.CookieFile)
 	if cookies != "" {
 		req.Header.Set("Cookie", cookies)
 	}
-	headers, err := chttp.ReadHeadersFromFile(config.Conf.HeaderFile)
+	headers, err := chttp.ReadHeadersFromFile(i.opts.HeaderFile)
 	if err == nil {
 		for key, value := range headers {
 			req.Header.Set(key, value)
@@ -340,40 +387,23 @@ func (i *ImageDownloader) downloadAndValidate(url, filePath string, globalBar *p
 
 	resp, err := i.client.Do(req.WithContext(i.ctx))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return err
+		return nil, retry.Status(resp.StatusCode)
 	}
 
 	buf := bytes.NewBuffer(make([]byte, 0, 10*1024*1024))
 	if _, err := io.CopyBuffer(buf, resp.Body, make([]byte, 32*1024)); err != nil { // 32KB缓冲区
-		return err
+		return nil, err
 	}
 	if buf.Len() < minFileSize {
-		return errors.New("发现0字节文件")
+		return nil, errors.New("发现0字节文件")
 	}
-
-	//file, err := os.Create(filePath)
-	//if err != nil {
-	//	return err
-	//}
-	//defer file.Close()
-	//
-	//
-	//if _, err := buf.WriteTo(file); err != nil {
-	//	os.Remove(filePath)
-	//	return err
-	//}
-
-	if err := os.WriteFile(filePath, buf.Bytes(), 0644); err != nil {
-		return fmt.Errorf("写入文件失败: %v", err)
+	if err := validate.Bytes(buf.Bytes(), ext, resp.Header.Get("Content-Type")); err != nil {
+		return nil, err
 	}
-
-	atomic.AddInt64(totalDownloaded, 1)
-	globalBar.Add(1)
-
-	return nil
+	return buf.Bytes(), nil
 }
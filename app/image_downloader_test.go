@@ -0,0 +1,66 @@
+package app
+
+import (
+	"bookget/config"
+	"bookget/pkg/event"
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageDownloaderDryRun(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	opts := &config.Input{Directory: dir, DryRun: true, MaxConcurrent: 2, Retries: 1}
+	i := NewImageDownloader(context.Background(), opts)
+	i.res = NewResult(context.Background(), opts, "")
+	i.hasVolPlaceholder = true
+
+	i.downloadAll("http://127.0.0.1:1/[VOL]/[PAGE].jpg", 1, 2, 4, "03", ".jpg")
+
+	assert.NoDirExists(t, dir, "dry-run 不创建目录")
+	assert.Equal(t, 4, i.res.Planned)
+	assert.Len(t, i.res.Items, 4)
+	assert.Zero(t, i.res.Failed)
+	var plan []string
+	for _, item := range i.res.Items {
+		plan = append(plan, item.Url+" "+item.Dest)
+	}
+	assert.Contains(t, plan, "http://127.0.0.1:1/0002/002.jpg "+filepath.Join(dir, "0002", "002.jpg"))
+}
+
+func TestImageDownloaderEvents(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 64, 64))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(buf.Bytes())
+	}))
+	defer srv.Close()
+
+	opts := &config.Input{Directory: t.TempDir(), MaxConcurrent: 1, Retries: 2}
+	i := &ImageDownloader{opts: opts, client: http.DefaultClient, ctx: context.Background()}
+	i.res = NewResult(context.Background(), opts, "")
+	var kinds []event.Kind
+	i.Events().Subscribe(event.Func(func(e event.Event) { kinds = append(kinds, e.Kind) }))
+
+	require.NoError(t, i.downloadAndValidate(srv.URL+"/001.png", filepath.Join(opts.Directory, "001.png")))
+	// 第一次尝试前发出 TaskStarted，重试时不再重复
+	assert.Equal(t, []event.Kind{event.TaskStarted, event.TaskRetried, event.TaskCompleted}, kinds)
+}
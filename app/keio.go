@@ -5,12 +5,12 @@ import (
 	"bookget/model/iiif"
 	"bookget/pkg/downloader"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
 	"bookget/pkg/util"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"path"
@@ -22,23 +22,37 @@ import (
 type Keio struct {
 	dt  *DownloadTask
 	ctx context.Context
+
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:           "keio",
+		Name:         "[日本]宫内厅书陵部（汉籍集览）",
+		Country:      "JP",
+		Hosts:        []string{"db2.sido.keio.ac.jp"},
+		Capabilities: CapIIIF,
+		Examples:     []string{"https://db2.sido.keio.ac.jp/kanseki/bib_frame?id={id}"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewKeio(ctx, opts) },
+	})
 }
 
-func NewKeio() *Keio {
+func NewKeio(ctx context.Context, opts *config.Input) *Keio {
 	return &Keio{
 		// 初始化字段
-		dt:  new(DownloadTask),
-		ctx: context.Background(),
+		opts: opts,
+		dt:   new(DownloadTask),
+		ctx:  ctx,
 	}
 }
 
-func (r *Keio) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Keio) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"type": "iiif",
-		"url":  sUrl,
-		"msg":  msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (r *Keio) Run(sUrl string) (msg string, err error) {
@@ -84,32 +98,33 @@ func (r *Keio) getManifestUrl(sUrl string) (uri string, err error) {
 }
 
 func (r *Keio) download() (msg string, err error) {
-	log.Printf("Get %s\n", r.dt.Url)
+	r.res.Log().Info("获取图书信息", logger.KeyUrl, r.dt.Url)
 
 	respVolume, err := r.getVolumes(r.dt.Url, r.dt.Jar)
+	r.res.SetVolumes(len(respVolume))
 	if err != nil {
-		fmt.Println(err)
+		r.res.Log().Error("获取册列表失败", "error", err)
 		return "getVolumes", err
 	}
 	for i, vol := range respVolume {
-		if !config.VolumeRange(i) {
+		if !r.opts.VolumeRange(i) {
 			continue
 		}
 		vid := fmt.Sprintf("%04d", i+1)
-		r.dt.SavePath = CreateDirectory(vid)
+		r.dt.SavePath = CreateDirectory(r.opts, vid)
 		canvases, err := r.getCanvases(vol, r.dt.Jar)
 		if err != nil || canvases == nil {
-			fmt.Println(err)
+			r.res.Log().Error("获取页面列表失败", "error", err)
 			continue
 		}
-		log.Printf(" %d/%d volume, %d pages \n", i+1, len(respVolume), len(canvases))
+		r.res.Log().Info("下载分册", logger.KeyVolume, i+1, "volumes", len(respVolume), "pages", len(canvases))
 		r.do(canvases)
 	}
 	return "", nil
 }
 
 func (r *Keio) do(imgUrls []string) (msg string, err error) {
-	if config.Conf.UseDzi {
+	if r.opts.UseDzi {
 		r.doDezoomify(imgUrls)
 	} else {
 		r.doNormal(imgUrls)
@@ -144,7 +159,7 @@ func (r *Keio) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []string,
 	}
 	var manifest = new(iiif.ManifestResponse)
 	if err = json.Unmarshal(bs, manifest); err != nil {
-		log.Printf("json.Unmarshal failed: %s\n", err)
+		r.res.Log().Error("解析 JSON 失败", "error", err)
 		return
 	}
 	if len(manifest.Sequences) == 0 {
@@ -154,13 +169,13 @@ func (r *Keio) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []string,
 	canvases = make([]string, 0, size)
 	for _, canvase := range manifest.Sequences[0].Canvases {
 		for _, image := range canvase.Images {
-			if config.Conf.UseDzi {
+			if r.opts.UseDzi {
 				//dezoomify-rs URL
 				iiiInfo := fmt.Sprintf("%s/info.json", image.Resource.Service.Id)
 				canvases = append(canvases, iiiInfo)
 			} else {
 				//JPEG URL
-				imgUrl := image.Resource.Service.Id + "/" + config.Conf.Format
+				imgUrl := image.Resource.Service.Id + "/" + r.opts.Format
 				canvases = append(canvases, imgUrl)
 			}
 		}
@@ -170,12 +185,12 @@ func (r *Keio) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []string,
 
 func (r *Keio) getBody(apiUrl string, jar *cookiejar.Jar) ([]byte, error) {
 	referer := url.QueryEscape(apiUrl)
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": r.opts.UserAgent,
 			"Referer":    referer,
 		},
 	})
@@ -210,36 +225,37 @@ func (r *Keio) doNormal(imgUrls []string) bool {
 	fmt.Println()
 	size := len(imgUrls)
 	var wg sync.WaitGroup
-	q := QueueNew(int(config.Conf.Threads))
+	q := QueueNew(int(r.opts.Threads))
 	for i, dUrl := range imgUrls {
-		if dUrl == "" || !config.PageRange(i, size) {
+		if dUrl == "" || !r.opts.PageRange(i, size) {
 			continue
 		}
 		ext := util.FileExt(dUrl)
 		sortId := fmt.Sprintf("%04d", i+1)
 		filename := sortId + ext
 		dest := path.Join(r.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !r.res.Pending(dUrl, dest) {
 			continue
 		}
 		imgUrl := dUrl
-		log.Printf("Get %d/%d  %s\n", i+1, size, imgUrl)
+		r.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", size, logger.KeyUrl, imgUrl)
 		wg.Add(1)
 		q.Go(func() {
 			defer wg.Done()
-			ctx := context.Background()
+			ctx := r.ctx
 			opts := gohttp.Options{
 				DestFile:    dest,
 				Overwrite:   false,
 				Concurrency: 1,
-				CookieFile:  config.Conf.CookieFile,
-				HeaderFile:  config.Conf.HeaderFile,
+				CookieFile:  r.opts.CookieFile,
+				HeaderFile:  r.opts.HeaderFile,
 				CookieJar:   r.dt.Jar,
 				Headers: map[string]interface{}{
-					"User-Agent": config.Conf.UserAgent,
+					"User-Agent": r.opts.UserAgent,
 				},
 			}
-			gohttp.FastGet(ctx, imgUrl, opts)
+			_, err := gohttp.FastGet(ctx, imgUrl, opts)
+			r.res.Record(sortId, imgUrl, dest, err)
 		})
 	}
 	wg.Wait()
@@ -257,19 +273,20 @@ func (r *Keio) doDezoomify(iiifUrls []string) bool {
 		"-H", "Referer:" + referer,
 	}
 	size := len(iiifUrls)
-	iiifDownloader := downloader.NewIIIFDownloader(&config.Conf)
+	iiifDownloader := downloader.NewIIIFDownloader(r.opts)
 	for i, uri := range iiifUrls {
-		if uri == "" || !config.PageRange(i, size) {
+		if uri == "" || !r.opts.PageRange(i, size) {
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
-		filename := sortId + config.Conf.FileExt
+		filename := sortId + r.opts.FileExt
 		dest := path.Join(r.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !r.res.Pending(uri, dest) {
 			continue
 		}
-		log.Printf("Get %d/%d  %s\n", i+1, size, uri)
-		iiifDownloader.Dezoomify(r.ctx, uri, dest, args)
+		r.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", size, logger.KeyUrl, uri)
+		err := iiifDownloader.Dezoomify(r.ctx, uri, dest, args)
+		r.res.Record(sortId, uri, dest, err)
 	}
 	return true
 }
@@ -5,11 +5,11 @@ import (
 	"bookget/model/iiif"
 	"bookget/pkg/downloader"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"os"
@@ -23,23 +23,37 @@ type Khirin struct {
 	dt     *DownloadTask
 	apiUrl string
 	ctx    context.Context
+
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:           "khirin",
+		Name:         "[日本]国立历史民俗博物馆",
+		Country:      "JP",
+		Hosts:        []string{"khirin-a.rekihaku.ac.jp"},
+		Capabilities: CapIIIF,
+		Examples:     []string{"https://khirin-a.rekihaku.ac.jp/{collection}/{id}"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewKhirin(ctx, opts) },
+	})
 }
 
-func NewKhirin() *Khirin {
+func NewKhirin(ctx context.Context, opts *config.Input) *Khirin {
 	return &Khirin{
 		// 初始化字段
-		dt:  new(DownloadTask),
-		ctx: context.Background(),
+		opts: opts,
+		dt:   new(DownloadTask),
+		ctx:  ctx,
 	}
 }
 
-func (r *Khirin) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Khirin) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"type": "iiif",
-		"url":  sUrl,
-		"msg":  msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (r *Khirin) Run(sUrl string) (msg string, err error) {
@@ -48,6 +62,7 @@ func (r *Khirin) Run(sUrl string) (msg string, err error) {
 	r.dt.Url = sUrl
 
 	r.dt.BookId = r.getBookId(r.dt.Url)
+	r.res.SetBook(r.dt.BookId, "")
 	if r.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -65,8 +80,8 @@ func (r *Khirin) getBookId(sUrl string) (bookId string) {
 }
 
 func (r *Khirin) download() (msg string, err error) {
-	log.Printf("Get %s  %s\n", r.dt.Url)
-	r.dt.SavePath = config.Conf.Directory
+	r.res.Log().Info("获取图书信息", logger.KeyUrl, r.dt.Url)
+	r.dt.SavePath = r.opts.Directory
 	manifestUrl, err := r.getManifestUrl(r.dt.Url)
 	if err != nil {
 		return "requested URL was not found.", err
@@ -75,12 +90,12 @@ func (r *Khirin) download() (msg string, err error) {
 	if err != nil || canvases == nil {
 		return "requested URL was not found.", err
 	}
-	log.Printf(" %d pages \n", len(canvases))
+	r.res.Log().Info("解析完成", "pages", len(canvases))
 	return r.do(canvases)
 }
 
 func (r *Khirin) do(canvases []string) (msg string, err error) {
-	if config.Conf.UseDzi {
+	if r.opts.UseDzi {
 		r.doDezoomify(canvases)
 	} else {
 		r.doNormal(canvases)
@@ -99,27 +114,29 @@ func (r *Khirin) doDezoomify(canvases []string) bool {
 		"-H", "Referer:" + referer,
 	}
 	size := len(canvases)
-	iiifDownloader := downloader.NewIIIFDownloader(&config.Conf)
+	iiifDownloader := downloader.NewIIIFDownloader(r.opts)
 	for i, uri := range canvases {
-		if uri == "" || !config.PageRange(i, size) {
+		if uri == "" || !r.opts.PageRange(i, size) {
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
-		filename := sortId + config.Conf.FileExt
+		filename := sortId + r.opts.FileExt
 		inputUri := filepath.Join(r.dt.SavePath, sortId+"_info.json")
 		bs, err := r.getBody(uri, r.dt.Jar)
 		if err != nil {
 			continue
 		}
 		bsNew := regexp.MustCompile(`profile":\[([^{]+)\{"formats":([^\]]+)\],`).ReplaceAll(bs, []byte(`profile":[{"formats":["jpg"],`))
-		os.WriteFile(inputUri, bsNew, os.ModePerm)
+		writeFile(r.opts, inputUri, bsNew, os.ModePerm)
 		dest := path.Join(r.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !r.res.Pending(uri, dest) {
 			continue
 		}
-		log.Printf("Get %s  %s\n", sortId, uri)
+		r.res.Log().Info("下载页面", logger.KeyPage, sortId, logger.KeyUrl, uri)
 
-		if err := iiifDownloader.Dezoomify(r.ctx, inputUri, dest, args); err == nil {
+		err = iiifDownloader.Dezoomify(r.ctx, inputUri, dest, args)
+		r.res.Record(sortId, uri, dest, err)
+		if err == nil {
 			os.Remove(inputUri)
 		}
 	}
@@ -132,32 +149,33 @@ func (r *Khirin) doNormal(canvases []string) bool {
 	}
 	fmt.Println()
 	size := len(canvases)
-	ctx := context.Background()
+	ctx := r.ctx
 	for i, uri := range canvases {
-		if uri == "" || !config.PageRange(i, size) {
+		if uri == "" || !r.opts.PageRange(i, size) {
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
-		filename := sortId + config.Conf.FileExt
+		filename := sortId + r.opts.FileExt
 		dest := path.Join(r.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !r.res.Pending(uri, dest) {
 			continue
 		}
-		log.Printf("Get %d/%d, URL: %s\n", i+1, size, uri)
+		r.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", size, logger.KeyUrl, uri)
 		opts := gohttp.Options{
 			DestFile:    dest,
 			Overwrite:   false,
 			Concurrency: 1,
-			CookieFile:  config.Conf.CookieFile,
-			HeaderFile:  config.Conf.HeaderFile,
+			CookieFile:  r.opts.CookieFile,
+			HeaderFile:  r.opts.HeaderFile,
 			CookieJar:   r.dt.Jar,
 			Headers: map[string]interface{}{
-				"User-Agent": config.Conf.UserAgent,
+				"User-Agent": r.opts.UserAgent,
 			},
 		}
-		gohttp.FastGet(ctx, uri, opts)
+		_, err := gohttp.FastGet(ctx, uri, opts)
+		r.res.Record(sortId, uri, dest, err)
 		fmt.Println()
-		//util.PrintSleepTime(config.Conf.Sleep)
+		//util.PrintSleepTime(r.opts.Sleep)
 	}
 	fmt.Println()
 	return true
@@ -175,7 +193,7 @@ func (r *Khirin) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []string
 		return nil, err
 	}
 	if err = json.Unmarshal(bs, manifest); err != nil {
-		log.Printf("json.Unmarshal failed: %s\n", err)
+		r.res.Log().Error("解析 JSON 失败", "error", err)
 		return
 	}
 	if len(manifest.Sequences) == 0 {
@@ -185,12 +203,12 @@ func (r *Khirin) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []string
 	canvases = make([]string, 0, size)
 	for _, canvase := range manifest.Sequences[0].Canvases {
 		for _, image := range canvase.Images {
-			if config.Conf.UseDzi {
+			if r.opts.UseDzi {
 				//dezoomify-rs URL
 				iiiInfo := fmt.Sprintf("%s/info.json", image.Resource.Service.Id)
 				canvases = append(canvases, iiiInfo)
 			} else {
-				imgUrl := image.Resource.Service.Id + "/" + config.Conf.Format
+				imgUrl := image.Resource.Service.Id + "/" + r.opts.Format
 				canvases = append(canvases, imgUrl)
 			}
 		}
@@ -218,12 +236,12 @@ func (r *Khirin) getManifestUrl(sUrl string) (uri string, err error) {
 
 func (r *Khirin) getBody(apiUrl string, jar *cookiejar.Jar) ([]byte, error) {
 	referer := url.QueryEscape(apiUrl)
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": r.opts.UserAgent,
 			"Referer":    referer,
 		},
 	})
@@ -5,12 +5,12 @@ import (
 	"bookget/model/iiif"
 	"bookget/pkg/downloader"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
 	"bookget/pkg/util"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"path"
@@ -22,23 +22,37 @@ import (
 type Kokusho struct {
 	dt  *DownloadTask
 	ctx context.Context
+
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:           "kokusho",
+		Name:         "[日本]国書数据库（古典籍）",
+		Country:      "JP",
+		Hosts:        []string{"kokusho.nijl.ac.jp"},
+		Capabilities: CapIIIF,
+		Examples:     []string{"https://kokusho.nijl.ac.jp/biblio/{id}"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewKokusho(ctx, opts) },
+	})
 }
 
-func NewKokusho() *Kokusho {
+func NewKokusho(ctx context.Context, opts *config.Input) *Kokusho {
 	return &Kokusho{
 		// 初始化字段
-		dt:  new(DownloadTask),
-		ctx: context.Background(),
+		opts: opts,
+		dt:   new(DownloadTask),
+		ctx:  ctx,
 	}
 }
 
-func (r *Kokusho) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Kokusho) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"type": "iiif",
-		"url":  sUrl,
-		"msg":  msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (p *Kokusho) Run(sUrl string) (msg string, err error) {
@@ -46,6 +60,7 @@ func (p *Kokusho) Run(sUrl string) (msg string, err error) {
 	p.dt.UrlParsed, err = url.Parse(sUrl)
 	p.dt.Url = sUrl
 	p.dt.BookId = p.getBookId(p.dt.Url)
+	p.res.SetBook(p.dt.BookId, "")
 	if p.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -62,38 +77,39 @@ func (p *Kokusho) getBookId(sUrl string) (bookId string) {
 }
 
 func (p *Kokusho) download() (msg string, err error) {
-	log.Printf("Get %s\n", p.dt.Url)
+	p.res.Log().Info("获取图书信息", logger.KeyUrl, p.dt.Url)
 
 	respVolume, err := p.getVolumes(p.dt.Url, p.dt.Jar)
+	p.res.SetVolumes(len(respVolume))
 	if err != nil {
-		fmt.Println(err)
+		p.res.Log().Error("获取册列表失败", "error", err)
 		return "getVolumes", err
 	}
 	sizeVol := len(respVolume)
 	for i, vol := range respVolume {
-		if !config.VolumeRange(i) {
+		if !p.opts.VolumeRange(i) {
 			continue
 		}
 		if sizeVol == 1 {
-			p.dt.SavePath = config.Conf.Directory
+			p.dt.SavePath = p.opts.Directory
 		} else {
 			vid := fmt.Sprintf("%04d", i+1)
-			p.dt.SavePath = CreateDirectory(vid)
+			p.dt.SavePath = CreateDirectory(p.opts, vid)
 		}
 
 		canvases, err := p.getCanvases(vol, p.dt.Jar)
 		if err != nil || canvases == nil {
-			fmt.Println(err)
+			p.res.Log().Error("获取页面列表失败", "error", err)
 			continue
 		}
-		log.Printf(" %d/%d volume, %d pages \n", i+1, sizeVol, len(canvases))
+		p.res.Log().Info("下载分册", logger.KeyVolume, i+1, "volumes", sizeVol, "pages", len(canvases))
 		p.do(canvases)
 	}
 	return "", nil
 }
 
 func (p *Kokusho) do(imgUrls []string) (msg string, err error) {
-	if config.Conf.UseDzi {
+	if p.opts.UseDzi {
 		p.doDezoomify(imgUrls)
 	} else {
 		p.doNormal(imgUrls)
@@ -103,7 +119,7 @@ func (p *Kokusho) do(imgUrls []string) (msg string, err error) {
 
 func (p *Kokusho) getVolumes(sUrl string, jar *cookiejar.Jar) (volumes []string, err error) {
 	apiUrl := fmt.Sprintf("https://"+p.dt.UrlParsed.Host+"/api/biblioDetail/%s?t=%d", p.dt.BookId, time.Now().UnixMilli())
-	bs, err := getBody(apiUrl, jar)
+	bs, err := getBody(p.ctx, p.opts, apiUrl, jar)
 	if err != nil {
 		return
 	}
@@ -126,7 +142,7 @@ func (p *Kokusho) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []strin
 	}
 	var manifest = new(iiif.ManifestResponse)
 	if err = json.Unmarshal(bs, manifest); err != nil {
-		log.Printf("json.Unmarshal failed: %s\n", err)
+		p.res.Log().Error("解析 JSON 失败", "error", err)
 		return
 	}
 	if len(manifest.Sequences) == 0 {
@@ -136,13 +152,13 @@ func (p *Kokusho) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []strin
 	canvases = make([]string, 0, size)
 	for _, canvase := range manifest.Sequences[0].Canvases {
 		for _, image := range canvase.Images {
-			if config.Conf.UseDzi {
+			if p.opts.UseDzi {
 				//dezoomify-rs URL
 				iiiInfo := fmt.Sprintf("%s/info.json", image.Resource.Service.Id)
 				canvases = append(canvases, iiiInfo)
 			} else {
 				//JPEG URL
-				imgUrl := image.Resource.Service.Id + "/" + config.Conf.Format
+				imgUrl := image.Resource.Service.Id + "/" + p.opts.Format
 				canvases = append(canvases, imgUrl)
 			}
 		}
@@ -153,12 +169,12 @@ func (p *Kokusho) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []strin
 
 func (p *Kokusho) getBody(sUrl string, jar *cookiejar.Jar) ([]byte, error) {
 	referer := url.QueryEscape(sUrl)
-	ctx := context.Background()
+	ctx := p.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: p.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": p.opts.UserAgent,
 			"Referer":    referer,
 		},
 	})
@@ -188,19 +204,20 @@ func (p *Kokusho) doDezoomify(iiifUrls []string) bool {
 		"-H", "Referer:" + referer,
 	}
 	size := len(iiifUrls)
-	iiifDownloader := downloader.NewIIIFDownloader(&config.Conf)
+	iiifDownloader := downloader.NewIIIFDownloader(p.opts)
 	for i, uri := range iiifUrls {
-		if uri == "" || !config.PageRange(i, size) {
+		if uri == "" || !p.opts.PageRange(i, size) {
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
-		filename := sortId + config.Conf.FileExt
+		filename := sortId + p.opts.FileExt
 		dest := path.Join(p.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !p.res.Pending(uri, dest) {
 			continue
 		}
-		log.Printf("Get %d/%d  %s\n", i+1, size, uri)
-		iiifDownloader.Dezoomify(p.ctx, uri, dest, args)
+		p.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", size, logger.KeyUrl, uri)
+		err := iiifDownloader.Dezoomify(p.ctx, uri, dest, args)
+		p.res.Record(sortId, uri, dest, err)
 	}
 	return true
 }
@@ -212,37 +229,38 @@ func (p *Kokusho) doNormal(imgUrls []string) bool {
 	size := len(imgUrls)
 	fmt.Println()
 	var wg sync.WaitGroup
-	q := QueueNew(int(config.Conf.Threads))
+	q := QueueNew(int(p.opts.Threads))
 	for i, uri := range imgUrls {
-		if uri == "" || !config.PageRange(i, size) {
+		if uri == "" || !p.opts.PageRange(i, size) {
 			continue
 		}
 		ext := util.FileExt(uri)
 		sortId := fmt.Sprintf("%04d", i+1)
 		filename := sortId + ext
 		dest := path.Join(p.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !p.res.Pending(uri, dest) {
 			continue
 		}
 		imgUrl := uri
 		fmt.Println()
-		log.Printf("Get %d/%d  %s\n", i+1, size, imgUrl)
+		p.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", size, logger.KeyUrl, imgUrl)
 		wg.Add(1)
 		q.Go(func() {
 			defer wg.Done()
-			ctx := context.Background()
+			ctx := p.ctx
 			opts := gohttp.Options{
 				DestFile:    dest,
 				Overwrite:   false,
 				Concurrency: 1,
-				CookieFile:  config.Conf.CookieFile,
-				HeaderFile:  config.Conf.HeaderFile,
+				CookieFile:  p.opts.CookieFile,
+				HeaderFile:  p.opts.HeaderFile,
 				CookieJar:   p.dt.Jar,
 				Headers: map[string]interface{}{
-					"User-Agent": config.Conf.UserAgent,
+					"User-Agent": p.opts.UserAgent,
 				},
 			}
-			gohttp.FastGet(ctx, imgUrl, opts)
+			_, err := gohttp.FastGet(ctx, imgUrl, opts)
+			p.res.Record(sortId, imgUrl, dest, err)
 			fmt.Println()
 		})
 	}
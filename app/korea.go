@@ -4,11 +4,11 @@ import (
 	"bookget/config"
 	"bookget/model/korea"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
 	"bookget/pkg/util"
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"path"
@@ -19,21 +19,37 @@ import (
 type Korea struct {
 	dt   *DownloadTask
 	body []byte
+
+	ctx  context.Context
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:       "korea",
+		Name:     "[韩国]高丽大学",
+		Country:  "KR",
+		Hosts:    []string{"kostma.korea.ac.kr"},
+		Examples: []string{"https://kostma.korea.ac.kr/viewer/viewerDes?uci={id}"},
+		New:      func(ctx context.Context, opts *config.Input) RouterInit { return NewKorea(ctx, opts) },
+	})
 }
 
-func NewKorea() *Korea {
+func NewKorea(ctx context.Context, opts *config.Input) *Korea {
 	return &Korea{
 		// 初始化字段
-		dt: new(DownloadTask),
+		ctx:  ctx,
+		opts: opts,
+		dt:   new(DownloadTask),
 	}
 }
 
-func (r *Korea) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Korea) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (r *Korea) Run(sUrl string) (msg string, err error) {
@@ -41,6 +57,7 @@ func (r *Korea) Run(sUrl string) (msg string, err error) {
 	r.dt.UrlParsed, err = url.Parse(sUrl)
 	r.dt.Url = sUrl
 	r.dt.BookId = r.getBookId(r.dt.Url)
+	r.res.SetBook(r.dt.BookId, "")
 	if r.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -57,28 +74,29 @@ func (r *Korea) getBookId(sUrl string) (bookId string) {
 }
 
 func (r *Korea) download() (msg string, err error) {
-	log.Printf("Get %s\n", r.dt.Url)
+	r.res.Log().Info("获取图书信息", logger.KeyUrl, r.dt.Url)
 
 	respVolume, err := r.getVolumes(r.dt.Url, r.dt.Jar)
+	r.res.SetVolumes(len(respVolume))
 	if err != nil {
-		fmt.Println(err)
+		r.res.Log().Error("获取册列表失败", "error", err)
 		return "getVolumes", err
 	}
 	sizeVol := len(respVolume)
 	for i, vol := range respVolume {
-		if !config.VolumeRange(i) {
+		if !r.opts.VolumeRange(i) {
 			continue
 		}
 		if sizeVol == 1 {
-			r.dt.SavePath = config.Conf.Directory
+			r.dt.SavePath = r.opts.Directory
 		} else {
 			vid := fmt.Sprintf("%04d", i+1)
-			r.dt.SavePath = CreateDirectory(vid)
+			r.dt.SavePath = CreateDirectory(r.opts, vid)
 		}
 		if err != nil || vol.Canvases == nil {
 			continue
 		}
-		log.Printf(" %d/%d volume, %d pages \n", i+1, sizeVol, len(vol.Canvases))
+		r.res.Log().Info("下载分册", logger.KeyVolume, i+1, "volumes", sizeVol, "pages", len(vol.Canvases))
 		r.do(vol.Canvases)
 	}
 	return "", nil
@@ -92,36 +110,37 @@ func (r *Korea) do(imgUrls []string) (msg string, err error) {
 	size := len(imgUrls)
 
 	var wg sync.WaitGroup
-	q := QueueNew(int(config.Conf.Threads))
+	q := QueueNew(int(r.opts.Threads))
 	for i, uri := range imgUrls {
-		if uri == "" || !config.PageRange(i, size) {
+		if uri == "" || !r.opts.PageRange(i, size) {
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
-		filename := sortId + config.Conf.FileExt
+		filename := sortId + r.opts.FileExt
 		dest := path.Join(r.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !r.res.Pending(uri, dest) {
 			continue
 		}
 		imgUrl := uri
-		log.Printf("Get %d/%d, %s\n", i+1, size, imgUrl)
+		r.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", size, logger.KeyUrl, imgUrl)
 		wg.Add(1)
 		q.Go(func() {
 			defer wg.Done()
-			ctx := context.Background()
+			ctx := r.ctx
 			opts := gohttp.Options{
 				DestFile:    dest,
 				Overwrite:   false,
 				Concurrency: 1,
-				CookieFile:  config.Conf.CookieFile,
-				HeaderFile:  config.Conf.HeaderFile,
+				CookieFile:  r.opts.CookieFile,
+				HeaderFile:  r.opts.HeaderFile,
 				CookieJar:   r.dt.Jar,
 				Headers: map[string]interface{}{
-					"User-Agent": config.Conf.UserAgent,
+					"User-Agent": r.opts.UserAgent,
 				},
 			}
-			gohttp.FastGet(ctx, imgUrl, opts)
-			util.PrintSleepTime(config.Conf.Sleep)
+			_, err := gohttp.FastGet(ctx, imgUrl, opts)
+			r.res.Record(sortId, imgUrl, dest, err)
+			util.PrintSleepTime(r.opts.Sleep)
 			fmt.Println()
 		})
 	}
@@ -131,7 +150,7 @@ func (r *Korea) do(imgUrls []string) (msg string, err error) {
 }
 
 func (r *Korea) getVolumes(sUrl string, jar *cookiejar.Jar) (volumes []korea.PartialCanvases, err error) {
-	bs, err := getBody(sUrl, jar)
+	bs, err := getBody(r.ctx, r.opts, sUrl, jar)
 	if err != nil {
 		return nil, err
 	}
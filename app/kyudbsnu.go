@@ -3,13 +3,13 @@ package app
 import (
 	"bookget/config"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
 	"bookget/pkg/util"
 	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"path"
@@ -23,21 +23,38 @@ type KyudbSnu struct {
 	dt     *DownloadTask
 	itemId string
 	entry  string
+
+	ctx  context.Context
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:           "kyudbsnu",
+		Name:         "[韩国]首尔大学奎章阁",
+		Country:      "KR",
+		Hosts:        []string{"kyudb.snu.ac.kr"},
+		Capabilities: CapPDF,
+		Examples:     []string{"https://kyudb.snu.ac.kr/book/view.do?book_cd={id}"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewKyudbSnu(ctx, opts) },
+	})
 }
 
-func NewKyudbSnu() *KyudbSnu {
+func NewKyudbSnu(ctx context.Context, opts *config.Input) *KyudbSnu {
 	return &KyudbSnu{
 		// 初始化字段
-		dt: new(DownloadTask),
+		ctx:  ctx,
+		opts: opts,
+		dt:   new(DownloadTask),
 	}
 }
 
-func (r *KyudbSnu) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *KyudbSnu) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (r *KyudbSnu) Run(sUrl string) (msg string, err error) {
@@ -46,6 +63,7 @@ func (r *KyudbSnu) Run(sUrl string) (msg string, err error) {
 	r.dt.Url = sUrl
 
 	r.dt.BookId = r.getBookId(r.dt.Url)
+	r.res.SetBook(r.dt.BookId, "")
 	if r.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -81,19 +99,19 @@ func (r *KyudbSnu) getBookId(sUrl string) (bookId string) {
 }
 
 func (r *KyudbSnu) download() (msg string, err error) {
-	log.Printf("Get %s\n", r.dt.Url)
+	r.res.Log().Info("获取图书信息", logger.KeyUrl, r.dt.Url)
 	bs, err := r.getBody(r.dt.Url, r.dt.Jar)
 	if err != nil || bs == nil {
 		return "requested URL was not found.", err
 	}
 	//PDF
 	if bytes.Contains(bs, []byte("name=\"mfpdf_link\"")) {
-		r.dt.SavePath = config.Conf.Directory
+		r.dt.SavePath = r.opts.Directory
 		canvases, err := r.getPdfUrls(r.dt.Url)
 		if err != nil || canvases == nil {
 			return "requested URL was not found.", err
 		}
-		log.Printf(" %d volumes \n", len(canvases))
+		r.res.Log().Info("解析完成", "volumes", len(canvases))
 		r.doPdf(canvases)
 		return "", nil
 	}
@@ -106,19 +124,20 @@ func (r *KyudbSnu) download() (msg string, err error) {
 		r.itemId = string(match[1])
 	}
 	respVolume, err := r.getVolumes(r.dt.Url, r.dt.Jar)
+	r.res.SetVolumes(len(respVolume))
 	if err != nil {
 		return "getVolumes", err
 	}
 	for i, vol := range respVolume {
-		if !config.VolumeRange(i) {
+		if !r.opts.VolumeRange(i) {
 			continue
 		}
-		r.dt.SavePath = CreateDirectory(vol)
+		r.dt.SavePath = CreateDirectory(r.opts, vol)
 		canvases, err := r.getCanvases(vol, r.dt.Jar)
 		if err != nil || canvases == nil {
 			continue
 		}
-		log.Printf(" %d/%d volume, %d pages \n", i+1, len(respVolume), len(canvases))
+		r.res.Log().Info("下载分册", logger.KeyVolume, i+1, "volumes", len(respVolume), "pages", len(canvases))
 		r.do(canvases)
 	}
 	return "", nil
@@ -131,9 +150,9 @@ func (r *KyudbSnu) do(imgUrls []string) (msg string, err error) {
 	fmt.Println()
 	referer := fmt.Sprintf("%s://%s/pf01/rendererImg.do", r.dt.UrlParsed.Scheme, r.dt.UrlParsed.Host)
 	size := len(imgUrls)
-	ctx := context.Background()
+	ctx := r.ctx
 	for i, uri := range imgUrls {
-		if !config.PageRange(i, size) {
+		if !r.opts.PageRange(i, size) {
 			continue
 		}
 		if uri == "" {
@@ -141,24 +160,27 @@ func (r *KyudbSnu) do(imgUrls []string) (msg string, err error) {
 		}
 		ext := util.FileExt(uri)
 		sortId := fmt.Sprintf("%04d", i+1)
-		log.Printf("Get %d/%d page, URL: %s\n", i+1, len(imgUrls), uri)
+		r.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", len(imgUrls), logger.KeyUrl, uri)
 		filename := sortId + ext
 		dest := path.Join(r.dt.SavePath, filename)
+		if !r.res.Pending(uri, dest) {
+			continue
+		}
 		opts := gohttp.Options{
 			DestFile:    dest,
 			Overwrite:   false,
 			Concurrency: 1,
-			CookieFile:  config.Conf.CookieFile,
-			HeaderFile:  config.Conf.HeaderFile,
+			CookieFile:  r.opts.CookieFile,
+			HeaderFile:  r.opts.HeaderFile,
 			CookieJar:   r.dt.Jar,
 			Headers: map[string]interface{}{
-				"User-Agent": config.Conf.UserAgent,
+				"User-Agent": r.opts.UserAgent,
 				"Referer":    referer,
 			},
 		}
 		_, err = gohttp.FastGet(ctx, uri, opts)
+		r.res.Record(sortId, uri, dest, err)
 		if err != nil {
-			fmt.Println(err)
 			break
 		}
 	}
@@ -174,37 +196,38 @@ func (r *KyudbSnu) doPdf(imgUrls []string) (msg string, err error) {
 	referer := url.QueryEscape(r.dt.Url)
 	size := len(imgUrls)
 	var wg sync.WaitGroup
-	q := QueueNew(int(config.Conf.Threads))
+	q := QueueNew(int(r.opts.Threads))
 	for i, uri := range imgUrls {
-		if uri == "" || !config.PageRange(i, size) {
+		if uri == "" || !r.opts.PageRange(i, size) {
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
 		filename := sortId + ".pdf"
 		dest := path.Join(r.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !r.res.Pending(uri, dest) {
 			continue
 		}
 		imgUrl := uri
-		log.Printf("Get %d/%d, URL: %s\n", i+1, size, imgUrl)
+		r.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", size, logger.KeyUrl, imgUrl)
 		wg.Add(1)
 		q.Go(func() {
 			defer wg.Done()
-			ctx := context.Background()
+			ctx := r.ctx
 			opts := gohttp.Options{
 				DestFile:    dest,
 				Overwrite:   false,
 				Concurrency: 1,
-				CookieFile:  config.Conf.CookieFile,
-				HeaderFile:  config.Conf.HeaderFile,
+				CookieFile:  r.opts.CookieFile,
+				HeaderFile:  r.opts.HeaderFile,
 				CookieJar:   r.dt.Jar,
 				Headers: map[string]interface{}{
-					"User-Agent": config.Conf.UserAgent,
+					"User-Agent": r.opts.UserAgent,
 					"Referer":    referer,
 				},
 			}
-			gohttp.FastGet(ctx, imgUrl, opts)
-			util.PrintSleepTime(config.Conf.Sleep)
+			_, err := gohttp.FastGet(ctx, imgUrl, opts)
+			r.res.Record(sortId, imgUrl, dest, err)
+			util.PrintSleepTime(r.opts.Sleep)
 			fmt.Println()
 		})
 	}
@@ -224,12 +247,12 @@ func (r *KyudbSnu) getVolumes(sUrl string, jar *cookiejar.Jar) (volumes []string
 		"mokNm":         "",
 		"add_page_no":   "",
 	}
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent":   config.Conf.UserAgent,
+			"User-Agent":   r.opts.UserAgent,
 			"Referer":      url.PathEscape(sUrl),
 			"Content-Type": "application/x-www-form-urlencoded",
 		},
@@ -260,12 +283,12 @@ func (r *KyudbSnu) getCanvases(vol string, jar *cookiejar.Jar) (canvases []strin
 		"page_no": "",
 		"tool":    "1",
 	}
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent":   config.Conf.UserAgent,
+			"User-Agent":   r.opts.UserAgent,
 			"Referer":      url.PathEscape(sUrl),
 			"Content-Type": "application/x-www-form-urlencoded",
 		},
@@ -323,12 +346,12 @@ func (r *KyudbSnu) getPdfUrls(sUrl string) (canvases []string, err error) {
 	}
 
 	d := []byte("book_cd=" + r.dt.BookId)
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  r.dt.Jar,
 		Headers: map[string]interface{}{
-			"User-Agent":   config.Conf.UserAgent,
+			"User-Agent":   r.opts.UserAgent,
 			"Content-Type": "application/x-www-form-urlencoded",
 			"Referer":      sUrl,
 		},
@@ -354,12 +377,12 @@ func (r *KyudbSnu) getPdfUrls(sUrl string) (canvases []string, err error) {
 }
 
 func (r *KyudbSnu) getBody(apiUrl string, jar *cookiejar.Jar) ([]byte, error) {
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": r.opts.UserAgent,
 		},
 	})
 	resp, err := cli.Get(apiUrl)
@@ -4,17 +4,15 @@ import (
 	"bookget/config"
 	"bookget/model/loc"
 	"bookget/pkg/downloader"
+	"bookget/pkg/httpclient"
 	"bookget/pkg/progressbar"
 	"bookget/pkg/sharedmemory"
 	"bookget/pkg/util"
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
-	"net/http/cookiejar"
 	"net/url"
 	"os"
 	"path"
@@ -40,36 +38,46 @@ type Loc struct {
 	parsedUrl *url.URL
 	savePath  string
 	bookId    string
+
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:           "loc",
+		Name:         "[美国]国会图书馆",
+		Country:      "US",
+		Hosts:        []string{"loc.gov"},
+		Capabilities: CapIIIF | CapGUI,
+		Examples:     []string{"https://www.loc.gov/item/{id}/"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewLoc(ctx, opts) },
+	})
 }
 
-func NewLoc() *Loc {
-	ctx, cancel := context.WithCancel(context.Background())
-	dm := downloader.NewDownloadManager(ctx, cancel, config.Conf.MaxConcurrent)
+func NewLoc(ctx context.Context, opts *config.Input) *Loc {
+	ctx, cancel := context.WithCancel(ctx)
+	dm := downloader.NewDownloadManager(ctx, cancel, opts.MaxConcurrent)
+	dm.SetRetries(opts.Retries)
 
-	// 创建自定义 Transport 忽略 SSL 验证
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-	}
-	jar, _ := cookiejar.New(nil)
 	return &Loc{
 		// 初始化字段
+		opts:   opts,
 		dm:     dm,
-		client: &http.Client{Timeout: config.Conf.Timeout * time.Second, Jar: jar, Transport: tr},
+		client: httpclient.ForSite(opts),
 		ctx:    ctx,
 		cancel: cancel,
 	}
 }
 
-func (r *Loc) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Loc) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	r.rawUrl = sUrl
 	r.parsedUrl, _ = url.Parse(sUrl)
 	msg, err := r.Run()
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.RecordTasks(r.dm.Tasks())
+	r.res.SetBook(r.bookId, "")
+	return r.res.Finish(msg, err)
 }
 
 func (r *Loc) getBookId() (bookId string) {
@@ -83,10 +91,11 @@ func (r *Loc) getBookId() (bookId string) {
 func (r *Loc) Run() (msg string, err error) {
 
 	r.bookId = r.getBookId()
+	r.res.SetBook(r.bookId, "")
 	if r.bookId == "" {
-		return "[err=getBookId]", err
+		return "requested URL was not found.", err
 	}
-	r.savePath = config.Conf.Directory
+	r.savePath = r.opts.Directory
 
 	apiUrl := fmt.Sprintf("https://www.loc.gov/item/%s/?fo=json", r.bookId)
 
@@ -106,7 +115,7 @@ func (r *Loc) Run() (msg string, err error) {
 		start := strings.Index(r.bufBody, "<pre>") + 5
 		end := strings.Index(r.bufBody, "</pre>")
 		if start <= 0 || end <= 0 || err != nil {
-			return "[err=getBodyByGui]", err
+			return "通过 bookget-gui 获取网页失败", err
 		}
 		r.bufBody = r.bufBody[start:end]
 		r.responseBody = []byte(r.bufBody)
@@ -119,14 +128,14 @@ func (r *Loc) Run() (msg string, err error) {
 	if err != nil || r.canvases == nil {
 		return "", err
 	}
-	r.savePath = config.Conf.Directory
+	r.savePath = r.opts.Directory
 	if os.PathSeparator == '\\' {
 		r.urlsFile = path.Join(r.savePath, "urls.txt")
-		err = os.WriteFile(r.urlsFile, []byte(r.bufBuilder.String()), os.ModePerm)
+		err = writeFile(r.opts, r.urlsFile, []byte(r.bufBuilder.String()), os.ModePerm)
 		if err != nil {
 			return "", err
 		}
-		fmt.Printf("\n已生成图片URLs文件[%s]\n 可复制到 bookget-gui.exe 目录下，或使用其它软件下载。\n", r.urlsFile)
+		r.res.Log().Info("已生成图片 URL 列表，可复制到 bookget-gui.exe 目录下，或使用其它软件下载", "file", r.urlsFile)
 		r.do(r.canvases)
 	} else {
 		r.letsGo(r.canvases)
@@ -137,27 +146,28 @@ func (r *Loc) Run() (msg string, err error) {
 func (r *Loc) do(canvases []string) (msg string, err error) {
 	sizeVol := len(canvases)
 	if sizeVol <= 0 {
-		return "[err=letsGo]", err
+		return "没有可下载的页面", err
 	}
 
 	bar := progressbar.Default(int64(sizeVol), "downloading")
 	for i, imgUrl := range canvases {
 		i++
 		sortId := fmt.Sprintf("%04d", i)
-		fileName := sortId + config.Conf.FileExt
+		fileName := sortId + r.opts.FileExt
 
-		if imgUrl == "" || !config.PageRange(i, sizeVol) {
+		if imgUrl == "" || !r.opts.PageRange(i, sizeVol) {
 			bar.Add(1)
 			continue
 		}
 		//跳过存在的文件
 		targetFilePath := path.Join(r.savePath, fileName)
-		if FileExist(targetFilePath) {
+		if !r.res.Pending(imgUrl, targetFilePath) {
 			bar.Add(1)
 			continue
 		}
 
 		ok, err := r.imageDownloader(imgUrl, targetFilePath)
+		r.res.Record(sortId, imgUrl, targetFilePath, err)
 		if err == nil && ok {
 			bar.Add(1)
 		}
@@ -169,31 +179,31 @@ func (r *Loc) do(canvases []string) (msg string, err error) {
 func (r *Loc) letsGo(canvases []string) (msg string, err error) {
 	sizeVol := len(canvases)
 	if sizeVol <= 0 {
-		return "[err=letsGo]", err
+		return "没有可下载的页面", err
 	}
 
 	counter := 0
 	for i, imgUrl := range canvases {
 		i++
 		sortId := fmt.Sprintf("%04d", i)
-		fileName := sortId + config.Conf.FileExt
+		fileName := sortId + r.opts.FileExt
 
-		if imgUrl == "" || !config.PageRange(i, sizeVol) {
+		if imgUrl == "" || !r.opts.PageRange(i, sizeVol) {
 			continue
 		}
 		//跳过存在的文件
-		if FileExist(path.Join(r.savePath, fileName)) {
+		if !r.res.Pending(imgUrl, path.Join(r.savePath, fileName)) {
 			continue
 		}
 		// 添加GET下载任务
 		r.dm.AddTask(
 			imgUrl,
 			"GET",
-			map[string]string{"User-Agent": config.Conf.UserAgent},
+			map[string]string{"User-Agent": r.opts.UserAgent},
 			nil,
 			r.savePath,
 			fileName,
-			config.Conf.Threads,
+			r.opts.Threads,
 		)
 		counter++
 	}
@@ -226,7 +236,7 @@ func (r *Loc) getCanvases() (canvases []string, err error) {
 //func (r *Loc) getVolumes() (volumes []string, err error) {
 //	var manifests = new(loc.ManifestsJson)
 //	if err = json.Unmarshal(r.responseBody, manifests); err != nil {
-//		log.Printf("json.Unmarshal failed: %s\n", err)
+//		r.res.Log().Error("解析 JSON 失败", "error", err)
 //		return
 //	}
 //	//一本书有N卷
@@ -264,14 +274,14 @@ func (r *Loc) getBody(sUrl string) (bs []byte, err error) {
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", config.Conf.UserAgent)
+	req.Header.Set("User-Agent", r.opts.UserAgent)
 	resp, err := r.client.Do(req.WithContext(r.ctx))
 	if err != nil {
 		return nil, err
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			log.Printf("close body err=%v", err)
+			r.res.Log().Warn("关闭响应失败", "error", err)
 		}
 	}()
 
@@ -288,10 +298,10 @@ func (r *Loc) getBody(sUrl string) (bs []byte, err error) {
 
 func (r *Loc) getImagePage(fileUrls []loc.ImageFile) (downloadUrl string, ok bool) {
 	for _, f := range fileUrls {
-		if config.Conf.FileExt == ".jpg" && f.Mimetype == "image/jpeg" {
+		if r.opts.FileExt == ".jpg" && f.Mimetype == "image/jpeg" {
 			if strings.Contains(f.Url, "full/pct:100/") {
-				if config.Conf.Format != "" {
-					downloadUrl = regexp.MustCompile(`full/pct:(.+)`).ReplaceAllString(f.Url, config.Conf.Format)
+				if r.opts.Format != "" {
+					downloadUrl = regexp.MustCompile(`full/pct:(.+)`).ReplaceAllString(f.Url, r.opts.Format)
 				} else {
 					downloadUrl = f.Url
 				}
@@ -310,7 +320,7 @@ func (r *Loc) getImagePage(fileUrls []loc.ImageFile) (downloadUrl string, ok boo
 func (r *Loc) getBodyByGui(apiUrl string) (buf string, err error) {
 	err = sharedmemory.WriteURLToSharedMemory(apiUrl)
 	if err != nil {
-		fmt.Println("Failed to write to shared memory:", err)
+		r.res.Log().Error("写入共享内存失败", "error", err)
 		return
 	}
 	for i := 0; i < 300; i++ {
@@ -326,7 +336,7 @@ func (r *Loc) getBodyByGui(apiUrl string) (buf string, err error) {
 func (r *Loc) imageDownloader(imgUrl, targetFilePath string) (ok bool, err error) {
 	err = sharedmemory.WriteURLImagePathToSharedMemory(imgUrl, targetFilePath)
 	if err != nil {
-		fmt.Println("Failed to write to shared memory:", err)
+		r.res.Log().Error("写入共享内存失败", "error", err)
 		return
 	}
 	for i := 0; i < 300; i++ {
@@ -3,17 +3,15 @@ package app
 import (
 	"bookget/config"
 	"bookget/pkg/downloader"
+	"bookget/pkg/httpclient"
 	"bookget/pkg/sharedmemory"
 	"bookget/pkg/util"
 	"bytes"
 	"context"
-	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
-	"net/http/cookiejar"
 	"net/url"
 	"path"
 	"regexp"
@@ -39,24 +37,33 @@ type LodNLGoKr struct {
 	bookId    string
 	ServerUrl string
 	fileExt   string
+
+	opts *config.Input
+	res  *Result
 }
 
-func NewLodNLGoKr() *LodNLGoKr {
-	ctx, cancel := context.WithCancel(context.Background())
-	dm := downloader.NewDownloadManager(ctx, cancel, config.Conf.MaxConcurrent)
+func init() {
+	Register(Site{
+		ID:           "lodnlgokr",
+		Name:         "[韩国]国立中央图书馆",
+		Country:      "KR",
+		Hosts:        []string{"lod.nl.go.kr"},
+		Capabilities: CapPDF | CapGUI,
+		Examples:     []string{"https://lod.nl.go.kr/page/{id}"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewLodNLGoKr(ctx, opts) },
+	})
+}
 
-	// 创建自定义 Transport 忽略 SSL 验证
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-	}
-	jar, _ := cookiejar.New(nil)
+func NewLodNLGoKr(ctx context.Context, opts *config.Input) *LodNLGoKr {
+	ctx, cancel := context.WithCancel(ctx)
+	dm := downloader.NewDownloadManager(ctx, cancel, opts.MaxConcurrent)
+	dm.SetRetries(opts.Retries)
 
 	return &LodNLGoKr{
 		// 初始化字段
+		opts:      opts,
 		dm:        dm,
-		client:    &http.Client{Timeout: config.Conf.Timeout * time.Second, Jar: jar, Transport: tr},
+		client:    httpclient.ForSite(opts),
 		ctx:       ctx,
 		cancel:    cancel,
 		ServerUrl: "http://viewer.nl.go.kr:8080", //"https://viewer.nl.go.kr"
@@ -64,14 +71,14 @@ func NewLodNLGoKr() *LodNLGoKr {
 	}
 }
 
-func (r *LodNLGoKr) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *LodNLGoKr) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	r.rawUrl = sUrl
 	r.parsedUrl, _ = url.Parse(sUrl)
 	msg, err := r.Run()
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.RecordTasks(r.dm.Tasks())
+	r.res.SetBook(r.bookId, "")
+	return r.res.Finish(msg, err)
 }
 
 func (r *LodNLGoKr) getBookId(sUrl string) (bookId string) {
@@ -89,10 +96,11 @@ func (r *LodNLGoKr) getBookId(sUrl string) (bookId string) {
 
 func (r *LodNLGoKr) Run() (msg string, err error) {
 	r.bookId = r.getBookId(r.rawUrl)
+	r.res.SetBook(r.bookId, "")
 	if r.bookId == "" {
-		return "[err=getBookId]", err
+		return "requested URL was not found.", err
 	}
-	r.savePath = config.Conf.Directory
+	r.savePath = r.opts.Directory
 
 	webPageUrl := r.ServerUrl + "/nlmivs/viewWonmun_js.jsp?card_class=L&cno=" + r.bookId
 	if util.OpenWebBrowser([]string{"-i", webPageUrl}) {
@@ -106,10 +114,10 @@ func (r *LodNLGoKr) Run() (msg string, err error) {
 
 	r.bufBody, err = r.getBodyByGui(webPageUrl)
 	if err != nil || r.bufBody == "" {
-		return "[err=getBodyByGui]", err
+		return "通过 bookget-gui 获取网页失败", err
 	}
 
-	r.savePath = config.Conf.Directory
+	r.savePath = r.opts.Directory
 
 	//PDF
 	if strings.Contains(r.bufBody, "extention = \"PDF\";") {
@@ -129,18 +137,18 @@ func (r *LodNLGoKr) Run() (msg string, err error) {
 
 	respVolume, err := r.getVolumeUrls()
 	if err != nil {
-		fmt.Println(err)
+		r.res.Log().Error("获取册列表失败", "error", err)
 		return "getVolumes", err
 	}
 	for i, vol := range respVolume {
-		if !config.VolumeRange(i) {
+		if !r.opts.VolumeRange(i) {
 			continue
 		}
 		vid := fmt.Sprintf("%04d", i+1)
-		r.savePath = CreateDirectory(vid)
+		r.savePath = CreateDirectory(r.opts, vid)
 		r.canvases, err = r.getCanvasesByUrl(i, vol.Url)
 		if err != nil || r.canvases == nil {
-			fmt.Println(err)
+			r.res.Log().Error("获取页面列表失败", "error", err)
 			continue
 		}
 		r.do(r.canvases)
@@ -151,31 +159,31 @@ func (r *LodNLGoKr) Run() (msg string, err error) {
 func (r *LodNLGoKr) do(canvases []string) (msg string, err error) {
 	sizeVol := len(canvases)
 	if sizeVol <= 0 {
-		return "[err=letsGo]", err
+		return "没有可下载的页面", err
 	}
 	fmt.Println()
 	if r.fileExt != ".pdf" {
-		config.Conf.Threads = 1
+		r.opts.Threads = 1
 	}
 	counter := 0
 	for i, imgUrl := range canvases {
-		if imgUrl == "" || !config.PageRange(i, sizeVol) {
+		if imgUrl == "" || !r.opts.PageRange(i, sizeVol) {
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
 		fileName := sortId + r.fileExt
-		if FileExist(path.Join(r.savePath, fileName)) {
+		if !r.res.Pending(imgUrl, path.Join(r.savePath, fileName)) {
 			continue
 		}
 		// 添加GET下载任务
 		r.dm.AddTask(
 			imgUrl,
 			"GET",
-			map[string]string{"User-Agent": config.Conf.UserAgent},
+			map[string]string{"User-Agent": r.opts.UserAgent},
 			nil,
 			r.savePath,
 			fileName,
-			config.Conf.Threads,
+			r.opts.Threads,
 		)
 		counter++
 	}
@@ -234,14 +242,14 @@ func (r *LodNLGoKr) getBody(sUrl string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", config.Conf.UserAgent)
+	req.Header.Set("User-Agent", r.opts.UserAgent)
 	resp, err := r.client.Do(req.WithContext(r.ctx))
 	if err != nil {
 		return nil, err
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			log.Printf("close body err=%v", err)
+			r.res.Log().Warn("关闭响应失败", "error", err)
 		}
 	}()
 
@@ -262,7 +270,7 @@ func (r *LodNLGoKr) postBody(sUrl string, postData []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", config.Conf.UserAgent)
+	req.Header.Set("User-Agent", r.opts.UserAgent)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Referer", r.ServerUrl+"/main.wviewer")
 
@@ -272,7 +280,7 @@ func (r *LodNLGoKr) postBody(sUrl string, postData []byte) ([]byte, error) {
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			log.Printf("close body err=%v", err)
+			r.res.Log().Warn("关闭响应失败", "error", err)
 		}
 	}()
 
@@ -290,7 +298,7 @@ func (r *LodNLGoKr) postBody(sUrl string, postData []byte) ([]byte, error) {
 func (r *LodNLGoKr) getBodyByGui(apiUrl string) (buf string, err error) {
 	err = sharedmemory.WriteURLToSharedMemory(apiUrl)
 	if err != nil {
-		fmt.Println("Failed to write to shared memory:", err)
+		r.res.Log().Error("写入共享内存失败", "error", err)
 		return
 	}
 	for i := 0; i < 300; i++ {
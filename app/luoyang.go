@@ -3,11 +3,11 @@ package app
 import (
 	"bookget/config"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
 	"bookget/pkg/util"
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"path/filepath"
@@ -16,27 +16,45 @@ import (
 
 type Luoyang struct {
 	dt *DownloadTask
+
+	ctx  context.Context
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:           "luoyang",
+		Name:         "[中国]洛阳市图书馆",
+		Country:      "CN",
+		Hosts:        []string{"111.7.82.29:8090"},
+		Capabilities: CapPDF,
+		Examples:     []string{"http://111.7.82.29:8090/reader?type=1&id={id}"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewLuoyang(ctx, opts) },
+	})
 }
 
-func NewLuoyang() *Luoyang {
+func NewLuoyang(ctx context.Context, opts *config.Input) *Luoyang {
 	return &Luoyang{
 		// 初始化字段
-		dt: new(DownloadTask),
+		ctx:  ctx,
+		opts: opts,
+		dt:   new(DownloadTask),
 	}
 }
 
-func (r *Luoyang) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Luoyang) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (p *Luoyang) Run(sUrl string) (msg string, err error) {
 	p.dt.UrlParsed, err = url.Parse(sUrl)
 	p.dt.Url = sUrl
 	p.dt.BookId = p.getBookId(p.dt.Url)
+	p.res.SetBook(p.dt.BookId, "")
 	if p.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -52,44 +70,46 @@ func (p *Luoyang) getBookId(sUrl string) (bookId string) {
 }
 
 func (p *Luoyang) download() (msg string, err error) {
-	log.Printf("Get %s\n", p.dt.Url)
+	p.res.Log().Info("获取图书信息", logger.KeyUrl, p.dt.Url)
 	respVolume, err := p.getVolumes(p.dt.Url, p.dt.Jar)
+	p.res.SetVolumes(len(respVolume))
 	if err != nil {
-		fmt.Println(err)
+		p.res.Log().Error("获取册列表失败", "error", err)
 		return "getVolumes", err
 	}
-	p.dt.SavePath = config.Conf.Directory
+	p.dt.SavePath = p.opts.Directory
 	for i, vol := range respVolume {
-		if !config.VolumeRange(i) {
+		if !p.opts.VolumeRange(i) {
 			continue
 		}
-		log.Printf(" %d/%d volume, %s \n", i+1, len(respVolume), vol)
+		p.res.Log().Info("下载分册", logger.KeyVolume, i+1, "volumes", len(respVolume), logger.KeyUrl, vol)
 		fName := util.FileName(vol)
 		sortId := fmt.Sprintf("%04d", i+1)
 		dest := filepath.Join(p.dt.SavePath, sortId+"."+fName)
 		p.do(dest, vol)
-		util.PrintSleepTime(config.Conf.Sleep)
+		util.PrintSleepTime(p.opts.Sleep)
 	}
 	return msg, err
 }
 
 func (p *Luoyang) do(dest, pdfUrl string) (msg string, err error) {
-	ctx := context.Background()
+	if !p.res.Pending(pdfUrl, dest) {
+		return "", nil
+	}
+	ctx := p.ctx
 	opts := gohttp.Options{
 		DestFile:    dest,
 		Overwrite:   false,
-		Concurrency: config.Conf.Threads,
-		CookieFile:  config.Conf.CookieFile,
-		HeaderFile:  config.Conf.HeaderFile,
+		Concurrency: p.opts.Threads,
+		CookieFile:  p.opts.CookieFile,
+		HeaderFile:  p.opts.HeaderFile,
 		CookieJar:   p.dt.Jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": p.opts.UserAgent,
 		},
 	}
 	_, err = gohttp.FastGet(ctx, pdfUrl, opts)
-	if err != nil {
-		fmt.Println(err)
-	}
+	p.res.Record(filepath.Base(dest), pdfUrl, dest, err)
 	return "", err
 }
 
@@ -123,12 +143,12 @@ func (p *Luoyang) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []strin
 
 func (p *Luoyang) getBody(sUrl string, jar *cookiejar.Jar) ([]byte, error) {
 	referer := url.QueryEscape(sUrl)
-	ctx := context.Background()
+	ctx := p.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: p.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": p.opts.UserAgent,
 			"Referer":    referer,
 		},
 	})
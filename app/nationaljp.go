@@ -3,9 +3,9 @@ package app
 import (
 	"bookget/config"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
 	"context"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"path"
@@ -15,27 +15,44 @@ import (
 type Nationaljp struct {
 	dt    *DownloadTask
 	extId string
+
+	ctx  context.Context
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:       "nationaljp",
+		Name:     "[日本]国立公文书馆（内阁文库）",
+		Country:  "JP",
+		Hosts:    []string{"digital.archives.go.jp"},
+		Examples: []string{"https://www.digital.archives.go.jp/img.pdf/{id}?BID={id}"},
+		New:      func(ctx context.Context, opts *config.Input) RouterInit { return NewNationaljp(ctx, opts) },
+	})
 }
 
-func NewNationaljp() *Nationaljp {
+func NewNationaljp(ctx context.Context, opts *config.Input) *Nationaljp {
 	return &Nationaljp{
 		// 初始化字段
-		dt: new(DownloadTask),
+		ctx:  ctx,
+		opts: opts,
+		dt:   new(DownloadTask),
 	}
 }
 
-func (r *Nationaljp) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Nationaljp) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (r *Nationaljp) Run(sUrl string) (msg string, err error) {
 	r.dt.UrlParsed, err = url.Parse(sUrl)
 	r.dt.Url = sUrl
 	r.dt.BookId = r.getBookId(r.dt.Url)
+	r.res.SetBook(r.dt.BookId, "")
 	if r.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -53,25 +70,26 @@ func (r *Nationaljp) getBookId(sUrl string) (bookId string) {
 }
 
 func (r *Nationaljp) download() (msg string, err error) {
-	log.Printf("Get %s\n", r.dt.Url)
+	r.res.Log().Info("获取图书信息", logger.KeyUrl, r.dt.Url)
 
 	respVolume, err := r.getVolumes()
+	r.res.SetVolumes(len(respVolume))
 	if err != nil {
-		fmt.Println(err)
+		r.res.Log().Error("获取册列表失败", "error", err)
 		return "getVolumes", err
 	}
-	r.dt.SavePath = config.Conf.Directory
+	r.dt.SavePath = r.opts.Directory
 	for i, vol := range respVolume {
-		if !config.VolumeRange(i) {
+		if !r.opts.VolumeRange(i) {
 			continue
 		}
 		vid := fmt.Sprintf("%04d", i+1)
 		fileName := vid + ".zip"
 		dest := path.Join(r.dt.SavePath, fileName)
-		if FileExist(dest) {
+		if !r.res.Pending("", dest) {
 			continue
 		}
-		log.Printf(" %d/%d volume, %s\n", i+1, len(respVolume), r.extId)
+		r.res.Log().Info("下载分册", logger.KeyVolume, i+1, "volumes", len(respVolume), logger.KeyUrl, r.extId)
 		r.do(i+1, vol, dest)
 		fmt.Println()
 	}
@@ -81,27 +99,28 @@ func (r *Nationaljp) download() (msg string, err error) {
 func (r *Nationaljp) do(index int, id, dest string) (msg string, err error) {
 	apiUrl := "https://" + r.dt.UrlParsed.Host + "/acv/auto_conversion/download"
 	data := fmt.Sprintf("DL_TYPE=%s&id_%d=%s", r.extId, index, id)
-	ctx := context.Background()
+	ctx := r.ctx
 	opts := gohttp.Options{
 		DestFile:    dest,
 		Overwrite:   false,
 		Concurrency: 1,
-		CookieFile:  config.Conf.CookieFile,
-		HeaderFile:  config.Conf.HeaderFile,
+		CookieFile:  r.opts.CookieFile,
+		HeaderFile:  r.opts.HeaderFile,
 		CookieJar:   r.dt.Jar,
 		Headers: map[string]interface{}{
-			"User-Agent":   config.Conf.UserAgent,
+			"User-Agent":   r.opts.UserAgent,
 			"Content-Type": "application/x-www-form-urlencoded",
 		},
 		Body: []byte(data),
 	}
 	_, err = gohttp.Post(ctx, apiUrl, opts)
+	r.res.Record(path.Base(dest), apiUrl, dest, err)
 	return "", err
 }
 
 func (r *Nationaljp) getVolumes() (volumes []string, err error) {
 	apiUrl := fmt.Sprintf("https://%s/DAS/meta/listPhoto?LANG=default&BID=%s&ID=&NO=&TYPE=dljpeg&DL_TYPE=jpeg", r.dt.UrlParsed.Host, r.dt.BookId)
-	bs, err := getBody(apiUrl, nil)
+	bs, err := getBody(r.ctx, r.opts, apiUrl, nil)
 	if err != nil {
 		return
 	}
@@ -4,18 +4,16 @@ import (
 	"bookget/config"
 	"bookget/pkg/chttp"
 	xhash "bookget/pkg/hash"
+	"bookget/pkg/httpclient"
 	"bookget/pkg/sharedmemory"
 	"bookget/pkg/util"
 	"bytes"
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
-	"net/http/cookiejar"
 	"net/url"
 	"os"
 	"path"
@@ -39,36 +37,29 @@ type NlcTw struct {
 	serverURL string
 	savePath  string
 	bookId    string
+
+	opts *config.Input
+	res  *Result
 }
 
-func (r *NlcTw) NewNlcTw() *NlcTw {
-	ctx, cancel := context.WithCancel(context.Background())
+func (r *NlcTw) NewNlcTw(ctx context.Context, opts *config.Input) *NlcTw {
+	ctx, cancel := context.WithCancel(ctx)
 
-	// 创建自定义 Transport 忽略 SSL 验证
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-	}
-	jar, _ := cookiejar.New(nil)
 	return &NlcTw{
 		// 初始化字段
-		client: &http.Client{Timeout: config.Conf.Timeout * time.Second, Jar: jar, Transport: tr},
+		opts:   opts,
+		client: httpclient.ForSite(opts),
 		ctx:    ctx,
 		cancel: cancel,
 	}
 }
-func (d *NlcTw) GetRouterInit(rawUrl string) (map[string]interface{}, error) {
+func (d *NlcTw) GetRouterInit(rawUrl string) (*Result, error) {
+	d.res = NewResult(d.ctx, d.opts, rawUrl)
 	d.rawUrl = rawUrl
 	d.parsedUrl, _ = url.Parse(rawUrl)
 	err := d.Run()
-	if err != nil {
-		return nil, err
-	}
-	return map[string]interface{}{
-		"type": "",
-		"url":  rawUrl,
-	}, nil
+	d.res.SetBook(d.bookId, "")
+	return d.res.Finish("", err)
 }
 
 func (r *NlcTw) getBookId(rawUrl string) (bookId string) {
@@ -80,10 +71,11 @@ func (r *NlcTw) getBookId(rawUrl string) (bookId string) {
 
 func (r *NlcTw) Run() (err error) {
 	r.bookId = r.getBookId(r.rawUrl)
+	r.res.SetBook(r.bookId, "")
 	if r.bookId == "" {
 		return err
 	}
-	r.savePath = config.Conf.Directory
+	r.savePath = r.opts.Directory
 	r.urlsFile = path.Join(r.savePath, "urls.txt")
 	//開始工作了
 	if os.PathSeparator != '\\' {
@@ -103,11 +95,11 @@ func (r *NlcTw) Run() (err error) {
 	r.bufBody, err = r.getBodyByGui(r.rawUrl)
 
 	//保存URLs
-	err = os.WriteFile(r.urlsFile, []byte(r.bufBuilder.String()), os.ModePerm)
+	err = writeFile(r.opts, r.urlsFile, []byte(r.bufBuilder.String()), os.ModePerm)
 	if err != nil {
 		return err
 	}
-	fmt.Printf("\n已生成图片URLs文件[%s]\n 可复制到 bookget-gui.exe 目录下，或使用其它软件下载。\n", r.urlsFile)
+	r.res.Log().Info("已生成图片 URL 列表，可复制到 bookget-gui.exe 目录下，或使用其它软件下载", "file", r.urlsFile)
 
 	return err
 }
@@ -132,8 +124,8 @@ func (r *NlcTw) getBody(rawUrl string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", config.Conf.UserAgent)
-	cookies, _ := chttp.ReadCookiesFromFile(config.Conf.CookieFile)
+	req.Header.Set("User-Agent", r.opts.UserAgent)
+	cookies, _ := chttp.ReadCookiesFromFile(r.opts.CookieFile)
 	if cookies != "" {
 		req.Header.Set("Cookie", cookies)
 	}
@@ -143,7 +135,7 @@ func (r *NlcTw) getBody(rawUrl string) ([]byte, error) {
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			log.Printf("close body err=%v", err)
+			r.res.Log().Warn("关闭响应失败", "error", err)
 		}
 	}()
 
@@ -182,7 +174,7 @@ func (r *NlcTw) postBody(rawUrl string, postData interface{}) ([]byte, error) {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 	// 设置请求头
-	req.Header.Set("User-Agent", config.Conf.UserAgent)
+	req.Header.Set("User-Agent", r.opts.UserAgent)
 	if isJSON {
 		req.Header.Set("Content-Type", "application/json")
 	} else {
@@ -193,7 +185,7 @@ func (r *NlcTw) postBody(rawUrl string, postData interface{}) ([]byte, error) {
 	req.Header.Set("Origin", "https://"+r.parsedUrl.Host)
 	req.Header.Set("Referer", r.rawUrl)
 
-	cookies, _ := chttp.ReadCookiesFromFile(config.Conf.CookieFile)
+	cookies, _ := chttp.ReadCookiesFromFile(r.opts.CookieFile)
 	if cookies != "" {
 		req.Header.Set("Cookie", cookies)
 	}
@@ -203,7 +195,7 @@ func (r *NlcTw) postBody(rawUrl string, postData interface{}) ([]byte, error) {
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			log.Printf("close body err=%v", err)
+			r.res.Log().Warn("关闭响应失败", "error", err)
 		}
 	}()
 
@@ -221,7 +213,7 @@ func (r *NlcTw) postBody(rawUrl string, postData interface{}) ([]byte, error) {
 func (r *NlcTw) getBodyByGui(apiUrl string) (bs []byte, err error) {
 	err = sharedmemory.WriteURLToSharedMemory(apiUrl)
 	if err != nil {
-		fmt.Println("Failed to write to shared memory:", err)
+		r.res.Log().Error("写入共享内存失败", "error", err)
 		return
 	}
 	for i := 0; i < 300; i++ {
@@ -237,7 +229,7 @@ func (r *NlcTw) getBodyByGui(apiUrl string) (bs []byte, err error) {
 func (r *NlcTw) imageDownloader(imgUrl, targetFilePath string) (ok bool, err error) {
 	err = sharedmemory.WriteURLImagePathToSharedMemory(imgUrl, targetFilePath)
 	if err != nil {
-		fmt.Println("Failed to write to shared memory:", err)
+		r.res.Log().Error("写入共享内存失败", "error", err)
 		return
 	}
 	for i := 0; i < 300; i++ {
@@ -3,12 +3,12 @@ package app
 import (
 	"bookget/config"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
 	"bookget/pkg/util"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"path/filepath"
@@ -18,28 +18,45 @@ import (
 
 type Ncpssd struct {
 	dt *DownloadTask
+
+	ctx  context.Context
+	opts *config.Input
+	res  *Result
 }
 
-func NewNcpssd() *Ncpssd {
+func init() {
+	Register(Site{
+		ID:           "ncpssd",
+		Name:         "[中国]国家哲学社会科学文献中心",
+		Country:      "CN",
+		Hosts:        []string{"ncpssd.org", "ncpssd.cn"},
+		Capabilities: CapPDF | CapCookie,
+		Examples:     []string{"https://www.ncpssd.cn/Literature/articleinfo?barcodenum={id}"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewNcpssd(ctx, opts) },
+	})
+}
+
+func NewNcpssd(ctx context.Context, opts *config.Input) *Ncpssd {
 	return &Ncpssd{
 		// 初始化字段
-		dt: new(DownloadTask),
+		ctx:  ctx,
+		opts: opts,
+		dt:   new(DownloadTask),
 	}
 }
 
-func (r *Ncpssd) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Ncpssd) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (r *Ncpssd) Run(sUrl string) (msg string, err error) {
 	r.dt.UrlParsed, err = url.Parse(sUrl)
 	r.dt.Url = sUrl
 	r.dt.Jar, _ = cookiejar.New(nil)
-	WaitNewCookie()
+	WaitNewCookie(r.ctx, r.opts)
 	return r.download()
 }
 
@@ -57,23 +74,24 @@ func (r *Ncpssd) getBookId(sUrl string) (bookId string) {
 
 func (r *Ncpssd) download() (msg string, err error) {
 	respVolume, err := r.getVolumes(r.dt.Url, r.dt.Jar)
+	r.res.SetVolumes(len(respVolume))
 	if r.dt.BookId == "" || err != nil {
-		fmt.Println(err)
+		r.res.Log().Error("获取册列表失败", "error", err)
 		return "requested URL was not found.", err
 	}
-	log.Printf("Get %s\n", r.dt.Url)
+	r.res.Log().Info("获取图书信息", logger.KeyUrl, r.dt.Url)
 	bookId := r.dt.UrlParsed.Query().Get("type")
 	if bookId == "" {
 		bookId = "ncpssd"
 	}
-	r.dt.SavePath = config.Conf.Directory
+	r.dt.SavePath = r.opts.Directory
 	for i, vol := range respVolume {
-		if !config.VolumeRange(i) {
+		if !r.opts.VolumeRange(i) {
 			continue
 		}
-		log.Printf(" %d/%d volume, %s \n", i+1, len(respVolume), vol)
+		r.res.Log().Info("下载分册", logger.KeyVolume, i+1, "volumes", len(respVolume), logger.KeyUrl, vol)
 		r.do(vol)
-		util.PrintSleepTime(config.Conf.Sleep)
+		util.PrintSleepTime(r.opts.Sleep)
 		fmt.Println()
 	}
 	return msg, err
@@ -83,24 +101,28 @@ func (r *Ncpssd) do(pdfUrl string) (msg string, err error) {
 	token, _ := r.getToken()
 	ext := util.FileExt(pdfUrl)
 	dest := filepath.Join(r.dt.SavePath, r.dt.BookId+ext)
+	if !r.res.Pending(pdfUrl, dest) {
+		return "", nil
+	}
 	jar, _ := cookiejar.New(nil)
-	ctx := context.Background()
+	ctx := r.ctx
 	referer := "https://" + r.dt.UrlParsed.Host
-	gohttp.FastGet(ctx, pdfUrl, gohttp.Options{
+	_, err = gohttp.FastGet(ctx, pdfUrl, gohttp.Options{
 		DestFile:    dest,
 		Overwrite:   false,
 		Concurrency: 1,
 		CookieJar:   jar,
-		CookieFile:  config.Conf.CookieFile,
-		HeaderFile:  config.Conf.HeaderFile,
+		CookieFile:  r.opts.CookieFile,
+		HeaderFile:  r.opts.HeaderFile,
 		Headers: map[string]interface{}{
-			"user-agent": config.Conf.UserAgent,
+			"user-agent": r.opts.UserAgent,
 			"Referer":    referer,
 			"Origin":     referer,
 			"site":       "npssd",
 			"sign":       token,
 		},
 	})
+	r.res.Record(filepath.Base(dest), pdfUrl, dest, err)
 	return "", err
 }
 
@@ -108,11 +130,13 @@ func (r *Ncpssd) getVolumes(sUrl string, jar *cookiejar.Jar) (volumes []string,
 	if strings.Contains(sUrl, "fullTextRead?filePath=") {
 		dUrl := r.getPdfUrl(sUrl)
 		r.dt.BookId = r.getBookId(dUrl)
+		r.res.SetBook(r.dt.BookId, "")
 		volumes = append(volumes, dUrl)
 	} else {
 		r.dt.BookId = r.getBookId(sUrl)
+		r.res.SetBook(r.dt.BookId, "")
 		name := fmt.Sprintf("%04d", r.dt.Index)
-		log.Printf("Get %s  %s\n", name, sUrl)
+		r.res.Log().Info("下载页面", logger.KeyPage, name, logger.KeyUrl, sUrl)
 		dUrl, err := r.getReadUrl(r.dt.BookId)
 		if err != nil {
 			return nil, err
@@ -129,12 +153,12 @@ func (r *Ncpssd) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []string
 
 func (r *Ncpssd) getBody(sUrl string, jar *cookiejar.Jar) ([]byte, error) {
 	referer := url.QueryEscape(r.dt.Url)
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent":       config.Conf.UserAgent,
+			"User-Agent":       r.opts.UserAgent,
 			"Referer":          referer,
 			"X-Requested-With": "XMLHttpRequest",
 			"Content-Type":     "application/json; charset=utf-8",
@@ -152,12 +176,12 @@ func (r *Ncpssd) getBody(sUrl string, jar *cookiejar.Jar) ([]byte, error) {
 }
 
 func (r *Ncpssd) postBody(sUrl string, d []byte) ([]byte, error) {
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  r.dt.Jar,
 		Headers: map[string]interface{}{
-			"User-Agent":   config.Conf.UserAgent,
+			"User-Agent":   r.opts.UserAgent,
 			"Content-Type": "application/json; charset=utf-8",
 		},
 		Body: d,
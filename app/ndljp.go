@@ -4,11 +4,11 @@ import (
 	"bookget/config"
 	"bookget/model/iiif"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"path"
@@ -18,27 +18,45 @@ import (
 
 type NdlJP struct {
 	dt *DownloadTask
+
+	ctx  context.Context
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:           "ndljp",
+		Name:         "[日本]国立国会图书馆",
+		Country:      "JP",
+		Hosts:        []string{"dl.ndl.go.jp"},
+		Capabilities: CapIIIF,
+		Examples:     []string{"https://dl.ndl.go.jp/pid/{id}"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewNdlJP(ctx, opts) },
+	})
 }
 
-func NewNdlJP() *NdlJP {
+func NewNdlJP(ctx context.Context, opts *config.Input) *NdlJP {
 	return &NdlJP{
 		// 初始化字段
-		dt: new(DownloadTask),
+		ctx:  ctx,
+		opts: opts,
+		dt:   new(DownloadTask),
 	}
 }
 
-func (r *NdlJP) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *NdlJP) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (r *NdlJP) Run(sUrl string) (msg string, err error) {
 	r.dt.UrlParsed, err = url.Parse(sUrl)
 	r.dt.Url = sUrl
 	r.dt.BookId = r.getBookId(r.dt.Url)
+	r.res.SetBook(r.dt.BookId, "")
 	if r.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -55,12 +73,13 @@ func (r *NdlJP) getBookId(sUrl string) (bookId string) {
 
 func (r *NdlJP) download() (msg string, err error) {
 	respVolume, err := r.getVolumes(r.dt.Url, r.dt.Jar)
+	r.res.SetVolumes(len(respVolume))
 	if err != nil {
-		fmt.Println(err)
+		r.res.Log().Error("获取册列表失败", "error", err)
 		return "getVolumes", err
 	}
 	for i, vol := range respVolume {
-		if !config.VolumeRange(i) {
+		if !r.opts.VolumeRange(i) {
 			continue
 		}
 		iiifUrl, _ := r.getManifestUrl(vol)
@@ -69,13 +88,13 @@ func (r *NdlJP) download() (msg string, err error) {
 		}
 		canvases, err := r.getCanvases(iiifUrl, r.dt.Jar)
 		if err != nil || canvases == nil {
-			fmt.Println(err)
+			r.res.Log().Error("获取页面列表失败", "error", err)
 			continue
 		}
 		vid := fmt.Sprintf("%04d", i+1)
-		r.dt.SavePath = CreateDirectory(vid)
+		r.dt.SavePath = CreateDirectory(r.opts, vid)
 
-		log.Printf(" %d/%d volume, %d pages \n", i+1, len(respVolume), len(canvases))
+		r.res.Log().Info("下载分册", logger.KeyVolume, i+1, "volumes", len(respVolume), "pages", len(canvases))
 		r.do(canvases)
 	}
 	return msg, err
@@ -88,36 +107,37 @@ func (r *NdlJP) do(imgUrls []string) (msg string, err error) {
 	size := len(imgUrls)
 	fmt.Println()
 	var wg sync.WaitGroup
-	q := QueueNew(int(config.Conf.Threads))
+	q := QueueNew(int(r.opts.Threads))
 	for i, uri := range imgUrls {
-		if uri == "" || !config.PageRange(i, size) {
+		if uri == "" || !r.opts.PageRange(i, size) {
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
-		filename := sortId + config.Conf.FileExt
+		filename := sortId + r.opts.FileExt
 		dest := path.Join(r.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !r.res.Pending(uri, dest) {
 			continue
 		}
 		imgUrl := uri
 		fmt.Println()
-		log.Printf("Get %d/%d  %s\n", i+1, size, imgUrl)
+		r.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", size, logger.KeyUrl, imgUrl)
 		wg.Add(1)
 		q.Go(func() {
 			defer wg.Done()
-			ctx := context.Background()
+			ctx := r.ctx
 			opts := gohttp.Options{
 				DestFile:    dest,
 				Overwrite:   false,
 				Concurrency: 1,
-				CookieFile:  config.Conf.CookieFile,
-				HeaderFile:  config.Conf.HeaderFile,
+				CookieFile:  r.opts.CookieFile,
+				HeaderFile:  r.opts.HeaderFile,
 				CookieJar:   r.dt.Jar,
 				Headers: map[string]interface{}{
-					"User-Agent": config.Conf.UserAgent,
+					"User-Agent": r.opts.UserAgent,
 				},
 			}
-			gohttp.FastGet(ctx, imgUrl, opts)
+			_, err := gohttp.FastGet(ctx, imgUrl, opts)
+			r.res.Record(sortId, imgUrl, dest, err)
 			fmt.Println()
 		})
 	}
@@ -147,7 +167,7 @@ func (r *NdlJP) getVolumes(sUrl string, jar *cookiejar.Jar) (volumes []string, e
 	}
 	var result = new(ResponseBody)
 	if err = json.Unmarshal(bs, result); err != nil {
-		log.Printf("json.Unmarshal failed: %s\n", err)
+		r.res.Log().Error("解析 JSON 失败", "error", err)
 		return
 	}
 	if result.Children == nil {
@@ -168,7 +188,7 @@ func (r *NdlJP) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []string,
 	}
 	var manifest = new(iiif.ManifestResponse)
 	if err = json.Unmarshal(bs, manifest); err != nil {
-		log.Printf("json.Unmarshal failed: %s\n", err)
+		r.res.Log().Error("解析 JSON 失败", "error", err)
 		return
 	}
 	if len(manifest.Sequences) == 0 {
@@ -179,7 +199,7 @@ func (r *NdlJP) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []string,
 	for _, canvase := range manifest.Sequences[0].Canvases {
 		for _, image := range canvase.Images {
 			//JPEG URL
-			imgUrl := image.Resource.Service.Id + "/" + config.Conf.Format
+			imgUrl := image.Resource.Service.Id + "/" + r.opts.Format
 			canvases = append(canvases, imgUrl)
 		}
 	}
@@ -188,12 +208,12 @@ func (r *NdlJP) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []string,
 
 func (r *NdlJP) getBody(apiUrl string, jar *cookiejar.Jar) ([]byte, error) {
 	referer := url.QueryEscape(apiUrl)
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": r.opts.UserAgent,
 			"Referer":    referer,
 		},
 	})
@@ -221,7 +241,7 @@ func (r *NdlJP) getManifestUrl(id string) (iiifUrl string, err error) {
 	}
 	var result ResponseBody
 	if err = json.Unmarshal(bs, &result); err != nil {
-		log.Printf("json.Unmarshal failed: %s\n", err)
+		r.res.Log().Error("解析 JSON 失败", "error", err)
 		return
 	}
 	return result.Item.IiifManifestUrl, nil
@@ -4,11 +4,11 @@ import (
 	"bookget/config"
 	"bookget/model/njuedu"
 	"bookget/pkg/downloader"
+	"bookget/pkg/logger"
 	"bookget/pkg/util"
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"os"
@@ -21,28 +21,44 @@ type Njuedu struct {
 	dt     *DownloadTask
 	typeId int
 	ctx    context.Context
+
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:           "njuedu",
+		Name:         "[中国]江苏高校珍贵古籍数字图书馆",
+		Country:      "CN",
+		Hosts:        []string{"jsgxgj.nju.edu.cn"},
+		Capabilities: CapDZI,
+		Examples:     []string{"https://jsgxgj.nju.edu.cn/jsgxgj/ancient-book/read?bookId={id}"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewNjuedu(ctx, opts) },
+	})
 }
 
-func NewNjuedu() *Njuedu {
+func NewNjuedu(ctx context.Context, opts *config.Input) *Njuedu {
 	return &Njuedu{
 		// 初始化字段
-		dt:  new(DownloadTask),
-		ctx: context.Background(),
+		opts: opts,
+		dt:   new(DownloadTask),
+		ctx:  ctx,
 	}
 }
 
-func (r *Njuedu) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Njuedu) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (r *Njuedu) Run(sUrl string) (msg string, err error) {
 	r.dt.UrlParsed, err = url.Parse(sUrl)
 	r.dt.Url = sUrl
 	r.dt.BookId = r.getBookId(r.dt.Url)
+	r.res.SetBook(r.dt.BookId, "")
 	if r.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -58,29 +74,30 @@ func (r *Njuedu) getBookId(sUrl string) (bookId string) {
 }
 
 func (r *Njuedu) download() (msg string, err error) {
-	log.Printf("Get %s\n", r.dt.Url)
+	r.res.Log().Info("获取图书信息", logger.KeyUrl, r.dt.Url)
 	r.typeId, err = r.getDetail(r.dt.BookId, r.dt.Jar)
 	if err != nil {
-		fmt.Println(err)
+		r.res.Log().Error("请求失败", "error", err)
 		return "getDetail", err
 	}
 	respVolume, err := r.getVolumes(r.dt.BookId, r.dt.Jar)
+	r.res.SetVolumes(len(respVolume))
 	if err != nil {
-		fmt.Println(err)
+		r.res.Log().Error("获取册列表失败", "error", err)
 		return "getVolumes", err
 	}
 	for i, vol := range respVolume {
-		if !config.VolumeRange(i) {
+		if !r.opts.VolumeRange(i) {
 			continue
 		}
 		vid := fmt.Sprintf("%04d", i+1)
-		r.dt.SavePath = CreateDirectory(vid)
+		r.dt.SavePath = CreateDirectory(r.opts, vid)
 		canvases, err := r.getCanvases(vol, r.dt.Jar)
 		if err != nil || canvases == nil {
-			fmt.Println(err)
+			r.res.Log().Error("获取页面列表失败", "error", err)
 			continue
 		}
-		log.Printf(" %d/%d volume, %d pages \n", i+1, len(respVolume), len(canvases))
+		r.res.Log().Info("下载分册", logger.KeyVolume, i+1, "volumes", len(respVolume), "pages", len(canvases))
 		r.do(canvases)
 	}
 	return msg, err
@@ -97,35 +114,37 @@ func (r *Njuedu) do(dziUrls []string) (msg string, err error) {
 		"-H", "Referer:" + referer,
 	}
 	size := len(dziUrls)
-	iiifDownloader := downloader.NewIIIFDownloader(&config.Conf)
+	iiifDownloader := downloader.NewIIIFDownloader(r.opts)
 	for i, val := range dziUrls {
-		if !config.PageRange(i, size) {
+		if !r.opts.PageRange(i, size) {
 			continue
 		}
-		fileName := fmt.Sprintf("%04d", i+1) + config.Conf.FileExt
+		fileName := fmt.Sprintf("%04d", i+1) + r.opts.FileExt
 		inputUri := filepath.Join(r.dt.SavePath, val)
 		outfile := path.Join(r.dt.SavePath, fileName)
-		if FileExist(outfile) {
+		if !r.res.Pending(val, outfile) {
 			continue
 		}
 
-		if err := iiifDownloader.Dezoomify(r.ctx, inputUri, outfile, args); err == nil {
+		err := iiifDownloader.Dezoomify(r.ctx, inputUri, outfile, args)
+		r.res.Record(fileName, inputUri, outfile, err)
+		if err == nil {
 			os.Remove(inputUri)
 		}
-		util.PrintSleepTime(config.Conf.Sleep)
+		util.PrintSleepTime(r.opts.Sleep)
 	}
 	return "", err
 }
 
 func (r *Njuedu) getDetail(bookId string, jar *cookiejar.Jar) (typeId int, err error) {
 	apiUrl := "https://" + r.dt.UrlParsed.Host + "/portal/book/getBookById?bookId=" + bookId
-	bs, err := getBody(apiUrl, jar)
+	bs, err := getBody(r.ctx, r.opts, apiUrl, jar)
 	if err != nil {
 		return 0, err
 	}
 	var result njuedu.Detail
 	if err = json.Unmarshal(bs, &result); err != nil {
-		log.Printf("json.Unmarshal failed: %s\n", err)
+		r.res.Log().Error("解析 JSON 失败", "error", err)
 		return
 	}
 	for _, v := range result.Data {
@@ -139,13 +158,13 @@ func (r *Njuedu) getDetail(bookId string, jar *cookiejar.Jar) (typeId int, err e
 
 func (r *Njuedu) getVolumes(bookId string, jar *cookiejar.Jar) (volumes []string, err error) {
 	apiUrl := fmt.Sprintf("https://%s/portal/book/getMasterSlaveCatalogue?typeId=%d&bookId=%s", r.dt.UrlParsed.Host, r.typeId, bookId)
-	bs, err := getBody(apiUrl, jar)
+	bs, err := getBody(r.ctx, r.opts, apiUrl, jar)
 	if err != nil {
 		return nil, err
 	}
 	var result njuedu.Catalog
 	if err = json.Unmarshal(bs, &result); err != nil {
-		log.Printf("json.Unmarshal failed: %s\n", err)
+		r.res.Log().Error("解析 JSON 失败", "error", err)
 		return
 	}
 	for _, d := range result.Data {
@@ -157,13 +176,13 @@ func (r *Njuedu) getVolumes(bookId string, jar *cookiejar.Jar) (volumes []string
 }
 
 func (r *Njuedu) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []string, err error) {
-	bs, err := getBody(sUrl, jar)
+	bs, err := getBody(r.ctx, r.opts, sUrl, jar)
 	if err != nil {
 		return nil, err
 	}
 	var result njuedu.Response
 	if err = json.Unmarshal(bs, &result); err != nil {
-		log.Printf("json.Unmarshal failed: %s\n", err)
+		r.res.Log().Error("解析 JSON 失败", "error", err)
 		return
 	}
 	for _, id := range result.Data.Images {
@@ -189,7 +208,7 @@ func (r *Njuedu) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []string
     }
 }
 `
-	bs, err = getBody(jsonUrl, jar)
+	bs, err = getBody(r.ctx, r.opts, jsonUrl, jar)
 	if err != nil {
 		return nil, err
 	}
@@ -200,7 +219,7 @@ func (r *Njuedu) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []string
 	if resp.Tiles == nil {
 		return
 	}
-	ext := config.Conf.FileExt[1:]
+	ext := r.opts.FileExt[1:]
 	for key, item := range resp.Tiles {
 		sortId := fmt.Sprintf("%s.json", key)
 		dest := filepath.Join(r.dt.SavePath, sortId)
@@ -212,7 +231,7 @@ func (r *Njuedu) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []string
 		} else {
 			jsonText = fmt.Sprintf(text, serverUrl, ext, item.TileSize.W, item.Height, item.Width)
 		}
-		_ = os.WriteFile(dest, []byte(jsonText), os.ModePerm)
+		_ = writeFile(r.opts, dest, []byte(jsonText), os.ModePerm)
 	}
 	return canvases, nil
 }
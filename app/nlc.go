@@ -4,12 +4,12 @@ import (
 	"bookget/config"
 	"bookget/pkg/downloader"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/httpclient"
+	"bookget/pkg/logger"
 	"bookget/pkg/util"
 	"context"
-	"crypto/tls"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
@@ -37,47 +37,57 @@ type ChinaNlc struct {
 	dataType    int //0=pdf,1=pic
 	aid         string
 	vectorBooks []string
+
+	opts *config.Input
+	res  *Result
 }
 
-func NewChinaNlc() *ChinaNlc {
-	ctx, cancel := context.WithCancel(context.Background())
-	dm := downloader.NewDownloadManager(ctx, cancel, config.Conf.MaxConcurrent)
+func init() {
+	Register(Site{
+		ID:           "nlc",
+		Name:         "[中国]国家图书馆",
+		Country:      "CN",
+		Hosts:        []string{"read.nlc.cn", "mylib.nlc.cn"},
+		Capabilities: CapPDF | CapOCR,
+		Examples:     []string{"http://read.nlc.cn/allSearch/searchDetail?searchType=1002&showType=1&indexName=data_892&fid={id}"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewChinaNlc(ctx, opts) },
+	})
+}
 
-	// 创建自定义 Transport 忽略 SSL 验证
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-	}
-	jar, _ := cookiejar.New(nil)
+func NewChinaNlc(ctx context.Context, opts *config.Input) *ChinaNlc {
+	ctx, cancel := context.WithCancel(ctx)
+	dm := downloader.NewDownloadManager(ctx, cancel, opts.MaxConcurrent)
+	dm.SetRetries(opts.Retries)
 
+	jar, _ := cookiejar.New(nil)
 	return &ChinaNlc{
 		// 初始化字段
+		opts:   opts,
 		dm:     dm,
-		client: &http.Client{Timeout: config.Conf.Timeout * time.Second, Jar: jar, Transport: tr},
+		client: httpclient.New(httpclient.Options{Timeout: opts.Timeout * time.Second, Jar: jar, Header: httpclient.Headers(opts)}),
 		ctx:    ctx,
 		cancel: cancel,
 		jar:    jar,
 	}
 }
 
-func (r *ChinaNlc) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *ChinaNlc) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	r.rawUrl = sUrl
 	r.parsedUrl, _ = url.Parse(sUrl)
 	msg, err := r.Run()
-	return map[string]interface{}{
-		"type": "dzicnlib",
-		"url":  sUrl,
-		"msg":  msg,
-	}, err
+	r.res.SetBook(r.bookId, "")
+	return r.res.Finish(msg, err)
 }
 
 func (r *ChinaNlc) Run() (msg string, err error) {
 	if strings.Contains(r.rawUrl, "OutOpenBook/Open") {
 		r.body, _ = r.getBody(r.rawUrl)
 		r.bookId = r.getBookId(string(r.body))
+		r.res.SetBook(r.bookId, "")
 	} else {
 		r.bookId = r.getBookId(r.rawUrl)
+		r.res.SetBook(r.bookId, "")
 	}
 	if r.bookId == "" {
 		return "requested URL was not found.", err
@@ -110,7 +120,7 @@ func (r *ChinaNlc) download() (msg string, err error) {
 	//单册PDF
 	if strings.Contains(r.rawUrl, "OutOpenBook/OpenObjectBook") {
 		//PDF
-		r.savePath = config.Conf.Directory
+		r.savePath = r.opts.Directory
 		v, _ := r.identifier(r.rawUrl)
 		filename := v.Get("bid") + ".pdf"
 		err = r.doPdfUrl(r.rawUrl, filename)
@@ -118,18 +128,18 @@ func (r *ChinaNlc) download() (msg string, err error) {
 	}
 	//单张图
 	if strings.Contains(r.rawUrl, "OutOpenBook/OpenObjectPic") {
-		r.savePath = config.Conf.Directory
+		r.savePath = r.opts.Directory
 		canvases, err := r.getCanvases()
 		if err != nil || canvases == nil {
 			return "", err
 		}
-		log.Printf("  %d pages \n", len(canvases))
+		r.res.Log().Info("解析完成", "pages", len(canvases))
 		r.do(canvases)
 		return "", err
 	}
 	//对照阅读单册
 	if strings.Contains(r.rawUrl, "OpenTwoObjectBook") {
-		r.savePath = config.Conf.Directory
+		r.savePath = r.opts.Directory
 		v, _ := r.identifier(r.rawUrl)
 		filename := v.Get("bid") + ".pdf"
 		pageUrl := fmt.Sprintf("%s://%s/OutOpenBook/OpenObjectBook?aid=%s&bid=%s", r.parsedUrl.Scheme, r.parsedUrl.Host,
@@ -145,7 +155,7 @@ func (r *ChinaNlc) download() (msg string, err error) {
 	//多册/多图
 	err = r.downloadForPDFs()
 	if err != nil {
-		fmt.Println(err)
+		r.res.Log().Error("请求失败", "error", err)
 		return "getVolumes", err
 	}
 	//矢量多册PDF
@@ -161,19 +171,19 @@ func (r *ChinaNlc) do(imgUrls []string) (msg string, err error) {
 	referer := url.QueryEscape(r.rawUrl)
 	size := len(imgUrls)
 	var wg sync.WaitGroup
-	q := QueueNew(int(config.Conf.Threads))
+	q := QueueNew(int(r.opts.Threads))
 	for i, uri := range imgUrls {
-		if uri == "" || !config.PageRange(i, size) {
+		if uri == "" || !r.opts.PageRange(i, size) {
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
-		filename := sortId + config.Conf.FileExt
+		filename := sortId + r.opts.FileExt
 		dest := path.Join(r.savePath, filename)
-		if FileExist(dest) {
+		if !r.res.Pending(uri, dest) {
 			continue
 		}
 		imgUrl := uri
-		log.Printf("Get %d/%d, URL: %s\n", i+1, size, imgUrl)
+		r.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", size, logger.KeyUrl, imgUrl)
 		wg.Add(1)
 		q.Go(func() {
 			defer wg.Done()
@@ -181,16 +191,17 @@ func (r *ChinaNlc) do(imgUrls []string) (msg string, err error) {
 				DestFile:    dest,
 				Overwrite:   false,
 				Concurrency: 1,
-				CookieFile:  config.Conf.CookieFile,
-				HeaderFile:  config.Conf.HeaderFile,
+				CookieFile:  r.opts.CookieFile,
+				HeaderFile:  r.opts.HeaderFile,
 				CookieJar:   r.jar,
 				Headers: map[string]interface{}{
-					"User-Agent": config.Conf.UserAgent,
+					"User-Agent": r.opts.UserAgent,
 					"Referer":    referer,
 				},
 			}
-			gohttp.FastGet(r.ctx, imgUrl, opts)
-			util.PrintSleepTime(config.Conf.Sleep)
+			_, err := gohttp.FastGet(r.ctx, imgUrl, opts)
+			r.res.Record(sortId, imgUrl, dest, err)
+			util.PrintSleepTime(r.opts.Sleep)
 			fmt.Println()
 		})
 	}
@@ -201,30 +212,31 @@ func (r *ChinaNlc) do(imgUrls []string) (msg string, err error) {
 
 func (r *ChinaNlc) downloadForPDFs() error {
 	respVolume, err := r.getVolumes()
+	r.res.SetVolumes(len(respVolume))
 	if err != nil {
 		return err
 	}
 	size := len(respVolume)
 	for i, vol := range respVolume {
-		if !config.VolumeRange(i) {
+		if !r.opts.VolumeRange(i) {
 			continue
 		}
 		vid := fmt.Sprintf("%04d", i+1)
 		//图片
 		if strings.Contains(vol, "OpenObjectPic") {
 			r.dataType = 1
-			r.savePath = CreateDirectory(vid)
+			r.savePath = CreateDirectory(r.opts, vid)
 			canvases, err := r.getCanvases()
 			if err != nil || canvases == nil {
-				fmt.Println(err)
+				r.res.Log().Error("获取页面列表失败", "error", err)
 				continue
 			}
-			log.Printf(" %d/%d volume, %d pages \n", i+1, size, len(canvases))
+			r.res.Log().Info("下载分册", logger.KeyVolume, i+1, "volumes", size, "pages", len(canvases))
 			r.do(canvases)
 		} else {
 			//PDF
-			r.savePath = config.Conf.Directory
-			log.Printf("Get %d/%d volume, URL: %s\n", i+1, size, vol)
+			r.savePath = r.opts.Directory
+			r.res.Log().Info("下载分册", logger.KeyVolume, i+1, "volumes", size, logger.KeyUrl, vol)
 			filename := vid + ".pdf"
 			r.doPdfUrl(vol, filename)
 		}
@@ -237,12 +249,12 @@ func (r *ChinaNlc) downloadForOCR() {
 		return
 	}
 	for i, vol := range r.vectorBooks {
-		if !config.VolumeRange(i) {
+		if !r.opts.VolumeRange(i) {
 			continue
 		}
 		vid := fmt.Sprintf("%04d", i+1)
-		r.savePath = CreateDirectory("ocr")
-		log.Printf("Get %d/%d volume, URL: %s\n", i+1, len(r.vectorBooks), vol)
+		r.savePath = CreateDirectory(r.opts, "ocr")
+		r.res.Log().Info("下载分册", logger.KeyVolume, i+1, "volumes", len(r.vectorBooks), logger.KeyUrl, vol)
 		filename := vid + ".pdf"
 		r.doPdfUrl(vol, filename)
 	}
@@ -284,7 +296,7 @@ func (r *ChinaNlc) getVolumes() (volumes []string, err error) {
 
 func (r *ChinaNlc) doPdfUrl(sUrl, filename string) error {
 	dest := path.Join(r.savePath, filename)
-	if FileExist(dest) {
+	if !r.res.Pending(sUrl, dest) {
 		return nil
 	}
 	v, err := r.identifier(sUrl)
@@ -302,21 +314,19 @@ func (r *ChinaNlc) doPdfUrl(sUrl, filename string) error {
 		DestFile:    dest,
 		Overwrite:   false,
 		Concurrency: 1,
-		CookieFile:  config.Conf.CookieFile,
-		HeaderFile:  config.Conf.HeaderFile,
+		CookieFile:  r.opts.CookieFile,
+		HeaderFile:  r.opts.HeaderFile,
 		CookieJar:   r.jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": r.opts.UserAgent,
 			"Referer":    "http://read.nlc.cn/static/webpdf/lib/WebPDFJRWorker.js",
 			"Range":      "bytes=0-1",
 			"myreader":   tokenKey,
 		},
 	}
-	resp, err := gohttp.FastGet(r.ctx, pdfUrl, opts)
-	if err != nil || resp.GetStatusCode() != 200 {
-		fmt.Println(err)
-	}
-	util.PrintSleepTime(config.Conf.Sleep)
+	_, err = gohttp.FastGet(r.ctx, pdfUrl, opts)
+	r.res.Record(filename, pdfUrl, dest, err)
+	util.PrintSleepTime(r.opts.Sleep)
 	fmt.Println()
 	return err
 }
@@ -370,10 +380,10 @@ func (r *ChinaNlc) identifier(sUrl string) (v url.Values, err error) {
 func (r *ChinaNlc) getBody(apiUrl string) ([]byte, error) {
 	referer := url.QueryEscape(apiUrl)
 	cli := gohttp.NewClient(r.ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  r.jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": r.opts.UserAgent,
 			"Referer":    referer,
 		},
 	})
@@ -391,7 +401,7 @@ func (r *ChinaNlc) getBody(apiUrl string) ([]byte, error) {
 func (r *ChinaNlc) getToken(uri string) (tokenKey, timeKey, timeFlag string) {
 	body, err := r.getBody(uri)
 	if err != nil {
-		log.Printf("Server unavailable: %s", err.Error())
+		r.res.Log().Error("获取 token 失败", logger.KeyUrl, uri, "error", err)
 		return
 	}
 	//<iframe id="myframe" name="myframe" src="" width="100%" height="100%" scrolling="no" frameborder="0" tokenKey="4ADAD4B379874C10864990817734A2BA" timeKey="1648363906519" timeFlag="1648363906519" sflag=""></iframe>
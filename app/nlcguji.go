@@ -4,17 +4,17 @@ import (
 	"bookget/config"
 	"bookget/model/nlc"
 	"bookget/pkg/chttp"
+	"bookget/pkg/httpclient"
+	"bookget/pkg/logger"
 	"bookget/pkg/progressbar"
 	"bookget/pkg/util"
+	"bookget/pkg/validate"
 	"bytes"
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
-	"net/http/cookiejar"
 	"net/url"
 	"os"
 	"path"
@@ -39,35 +39,42 @@ type NlcGuji struct {
 	responseBody []byte
 	urlsFile     string
 	bufBuilder   strings.Builder
+
+	opts *config.Input
+	res  *Result
 }
 
-func NewNlcGuji() *NlcGuji {
-	ctx, cancel := context.WithCancel(context.Background())
+func init() {
+	Register(Site{
+		ID:           "nlcguji",
+		Name:         "[中国]国家图书馆-中华古籍资源库",
+		Country:      "CN",
+		Hosts:        []string{"guji.nlc.cn"},
+		Capabilities: CapCatalog,
+		Examples:     []string{"https://guji.nlc.cn/guji/pmgj/gjzypt?metadataId={id}"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewNlcGuji(ctx, opts) },
+	})
+}
 
-	// 创建自定义 Transport 忽略 SSL 验证
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-	}
-	jar, _ := cookiejar.New(nil)
+func NewNlcGuji(ctx context.Context, opts *config.Input) *NlcGuji {
+	ctx, cancel := context.WithCancel(ctx)
 
 	return &NlcGuji{
 		// 初始化字段
-		client: &http.Client{Timeout: config.Conf.Timeout * time.Second, Jar: jar, Transport: tr},
+		opts:   opts,
+		client: httpclient.ForSite(opts),
 		ctx:    ctx,
 		cancel: cancel,
 	}
 }
 
-func (s *NlcGuji) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (s *NlcGuji) GetRouterInit(sUrl string) (*Result, error) {
+	s.res = NewResult(s.ctx, s.opts, sUrl)
 	s.rawUrl = sUrl
 	s.parsedUrl, _ = url.Parse(sUrl)
-	s.Run()
-	return map[string]interface{}{
-		"type": "dzicnlib",
-		"url":  sUrl,
-	}, nil
+	msg, err := s.Run()
+	s.res.SetBook(s.bookId, "")
+	return s.res.Finish(msg, err)
 }
 
 func (s *NlcGuji) getBookId() (bookId string) {
@@ -97,42 +104,44 @@ func (s *NlcGuji) getBookId() (bookId string) {
 
 func (s *NlcGuji) Run() (msg string, err error) {
 	s.bookId = s.getBookId()
+	s.res.SetBook(s.bookId, "")
 	if s.bookId == "" {
-		return "[err=getBookId]", err
+		return "requested URL was not found.", err
 	}
-	s.savePath = config.Conf.Directory
+	s.savePath = s.opts.Directory
 	s.urlsFile = path.Join(s.savePath, "urls.txt")
 	//先生成书签目录
 	s.buildCatalog(path.Join(s.savePath, "catalog.txt"))
 
 	groupedVolumes, err := s.getVolumes()
+	s.res.SetVolumes(len(groupedVolumes))
 	if err != nil || groupedVolumes == nil {
-		return "[err=getVolumes]", err
+		return "获取册列表失败", err
 
 	}
 
 	if err != nil {
-		fmt.Println(err)
+		s.res.Log().Error("请求失败", "error", err)
 		return "getVolumes", err
 	}
 
 	var i = 0
 	for _, item := range groupedVolumes {
-		if !config.VolumeRange(i) {
+		if !s.opts.VolumeRange(i) {
 			continue
 		}
 		i++
 		vid := fmt.Sprintf("%04d", i)
-		s.savePath = CreateDirectory(vid)
-		log.Printf(" %d/%d volume, %d pages \n", i, len(groupedVolumes), len(item.Items))
+		s.savePath = CreateDirectory(s.opts, vid)
+		s.res.Log().Info("下载分册", logger.KeyVolume, i, "volumes", len(groupedVolumes), "pages", len(item.Items))
 		s.letsGo(item.Items)
 	}
 
-	err = os.WriteFile(s.urlsFile, []byte(s.bufBuilder.String()), os.ModePerm)
+	err = writeFile(s.opts, s.urlsFile, []byte(s.bufBuilder.String()), os.ModePerm)
 	if err != nil {
 		return "", err
 	}
-	fmt.Printf("\n已生成图片URLs文件[%s]\n 可复制到 bookget-gui.exe 目录下，或使用其它软件下载。\n", s.urlsFile)
+	s.res.Log().Info("已生成图片 URL 列表，可复制到 bookget-gui.exe 目录下，或使用其它软件下载", "file", s.urlsFile)
 
 	return "", nil
 }
@@ -140,7 +149,7 @@ func (s *NlcGuji) Run() (msg string, err error) {
 func (s *NlcGuji) letsGo(canvases []nlc.DataItem) (msg string, err error) {
 	sizeVol := len(canvases)
 	if sizeVol <= 0 {
-		return "[err=letsGo]", err
+		return "没有可下载的页面", err
 	}
 	imgServer := fmt.Sprintf("https://%s/api/common/jpgViewer?ftpId=1&filePathName=", s.parsedUrl.Host)
 
@@ -149,9 +158,9 @@ func (s *NlcGuji) letsGo(canvases []nlc.DataItem) (msg string, err error) {
 	for i, item := range canvases {
 		i++
 		sortId := fmt.Sprintf("%04d", i)
-		fileName := sortId + config.Conf.FileExt
+		fileName := sortId + s.opts.FileExt
 		//跳过存在的文件
-		if FileExist(path.Join(s.savePath, fileName)) {
+		if !s.res.Pending("", path.Join(s.savePath, fileName)) {
 			s.bar.Add(1)
 			continue
 		}
@@ -162,11 +171,11 @@ func (s *NlcGuji) letsGo(canvases []nlc.DataItem) (msg string, err error) {
 		rawData := []byte(fmt.Sprintf("metadataId=%s&structureId=%d&imageId=%s", s.bookId, item.StructureId, item.ImageId))
 		bs, err := s.postBody(apiUrl, rawData)
 		if err != nil {
-			return "[err=letsGo]", err
+			return "获取图片信息失败", err
 		}
 		var resp nlc.ImageData
 		if err = json.Unmarshal(bs, &resp); err != nil {
-			return "[err=letsGo::Unmarshal]", err
+			return "解析图片信息失败", err
 		}
 		encoded := url.QueryEscape(resp.Data.FilePath)
 		imgUrl := imgServer + encoded
@@ -174,14 +183,19 @@ func (s *NlcGuji) letsGo(canvases []nlc.DataItem) (msg string, err error) {
 		s.bufBuilder.WriteString(imgUrl)
 		s.bufBuilder.WriteString("\n")
 
+		dest := path.Join(s.savePath, fileName)
 		body, err := s.getBody(imgUrl)
 		if err != nil {
+			s.res.Record(sortId, imgUrl, dest, err)
 			return "", err
 		}
 		securedBody := s.removeMarkHeader(body, markHeader)
-		_ = os.WriteFile(path.Join(s.savePath, fileName), securedBody, os.ModePerm)
+		if err = validate.Bytes(securedBody, path.Ext(dest), ""); err == nil {
+			err = util.WriteFileAtomic(dest, securedBody, os.ModePerm)
+		}
+		s.res.Record(sortId, imgUrl, dest, err)
 		s.bar.Add(1)
-		time.Sleep(time.Duration(config.Conf.Sleep) * time.Second)
+		time.Sleep(time.Duration(s.opts.Sleep) * time.Second)
 	}
 	fmt.Println()
 	return "", nil
@@ -243,16 +257,16 @@ func (s *NlcGuji) getBody(sUrl string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", config.Conf.UserAgent)
+	req.Header.Set("User-Agent", s.opts.UserAgent)
 	req.Header.Set("Origin", "https://"+s.parsedUrl.Host)
 	req.Header.Set("Referer", s.rawUrl)
 
-	cookies, _ := chttp.ReadCookiesFromFile(config.Conf.CookieFile)
+	cookies, _ := chttp.ReadCookiesFromFile(s.opts.CookieFile)
 	if cookies != "" {
 		req.Header.Set("Cookie", cookies)
 	}
 
-	headers, err := chttp.ReadHeadersFromFile(config.Conf.HeaderFile)
+	headers, err := chttp.ReadHeadersFromFile(s.opts.HeaderFile)
 	if err == nil {
 		for key, value := range headers {
 			req.Header.Set(key, value)
@@ -265,7 +279,7 @@ func (s *NlcGuji) getBody(sUrl string) ([]byte, error) {
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			log.Printf("close body err=%v", err)
+			s.res.Log().Warn("关闭响应失败", "error", err)
 		}
 	}()
 
@@ -285,16 +299,16 @@ func (s *NlcGuji) postBody(sUrl string, postData []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", config.Conf.UserAgent)
+	req.Header.Set("User-Agent", s.opts.UserAgent)
 	req.Header.Set("Origin", "https://"+s.parsedUrl.Host)
 	req.Header.Set("Referer", s.rawUrl)
 	req.Header.Set("Content-Type", "application/json")
 
-	cookies, _ := chttp.ReadCookiesFromFile(config.Conf.CookieFile)
+	cookies, _ := chttp.ReadCookiesFromFile(s.opts.CookieFile)
 	if cookies != "" {
 		req.Header.Set("Cookie", cookies)
 	}
-	headers, err := chttp.ReadHeadersFromFile(config.Conf.HeaderFile)
+	headers, err := chttp.ReadHeadersFromFile(s.opts.HeaderFile)
 	if err == nil {
 		for key, value := range headers {
 			req.Header.Set(key, value)
@@ -307,7 +321,7 @@ func (s *NlcGuji) postBody(sUrl string, postData []byte) ([]byte, error) {
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			log.Printf("close body err=%v", err)
+			s.res.Log().Warn("关闭响应失败", "error", err)
 		}
 	}()
 
@@ -331,13 +345,13 @@ func (s *NlcGuji) buildCatalog(outputPath string) {
 
 	structureData, err := s.postBody(apiUrl, rawData)
 	if err != nil {
-		fmt.Printf("获取目录结构失败: %v\n", err)
+		s.res.Log().Error("获取目录结构失败", "error", err)
 		return
 	}
 
 	var structureResp nlc.StructureResponse
 	if err := json.Unmarshal(structureData, &structureResp); err != nil {
-		fmt.Printf("解析目录结构失败: %v\n", err)
+		s.res.Log().Error("解析目录结构失败", "error", err)
 		return
 	}
 
@@ -346,13 +360,13 @@ func (s *NlcGuji) buildCatalog(outputPath string) {
 	apiUrl = fmt.Sprintf("https://%s/api/anc/ancImageIdListWithPageNum?metadataId=%s", s.parsedUrl.Host, s.bookId)
 	s.responseBody, err = s.postBody(apiUrl, rawData)
 	if err != nil {
-		fmt.Printf("获取页码映射失败: %v\n", err)
+		s.res.Log().Error("获取页码映射失败", "error", err)
 		return
 	}
 
 	var pageResp nlc.PageResponse
 	if err := json.Unmarshal(s.responseBody, &pageResp); err != nil {
-		fmt.Printf("解析页码映射失败: %v\n", err)
+		s.res.Log().Error("解析页码映射失败", "error", err)
 		return
 	}
 
@@ -384,12 +398,12 @@ func (s *NlcGuji) buildCatalog(outputPath string) {
 
 	// 保存到文件
 	content := strings.Join(catalog, "\n")
-	if err := os.WriteFile(outputPath, []byte(content), 0644); err != nil {
-		fmt.Printf("保存文件失败: %v\n", err)
+	if err := writeFile(s.opts, outputPath, []byte(content), 0644); err != nil {
+		s.res.Log().Error("保存目录失败", "dest", outputPath, "error", err)
 		return
 	}
 
-	fmt.Printf("目录已成功保存到 %s\n", outputPath)
+	s.res.Log().Info("目录已保存", "dest", outputPath)
 	//fmt.Printf("共生成 %d 条目录项）\n", len(catalog)-1)
 }
 
@@ -489,7 +503,7 @@ func (s *NlcGuji) removeMarkHeader(e, t []byte) []byte {
 	}
 
 	if !match {
-		log.Println("头部字节不匹配，不移除")
+		s.res.Log().Debug("头部字节不匹配，不移除")
 		return e
 	}
 
@@ -3,11 +3,11 @@ package app
 import (
 	"bookget/config"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
 	"bookget/pkg/util"
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
@@ -20,21 +20,37 @@ import (
 
 type Nomfoundation struct {
 	dt *DownloadTask
+
+	ctx  context.Context
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:       "nomfoundation",
+		Name:     "[越南]汉喃古籍文献典藏数位计划",
+		Country:  "VN",
+		Hosts:    []string{"lib.nomfoundation.org"},
+		Examples: []string{"https://lib.nomfoundation.org/collection/{collection}/volume/{id}/"},
+		New:      func(ctx context.Context, opts *config.Input) RouterInit { return NewNomfoundation(ctx, opts) },
+	})
 }
 
-func NewNomfoundation() *Nomfoundation {
+func NewNomfoundation(ctx context.Context, opts *config.Input) *Nomfoundation {
 	return &Nomfoundation{
 		// 初始化字段
-		dt: new(DownloadTask),
+		ctx:  ctx,
+		opts: opts,
+		dt:   new(DownloadTask),
 	}
 }
 
-func (r *Nomfoundation) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Nomfoundation) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (r *Nomfoundation) Run(sUrl string) (msg string, err error) {
@@ -43,6 +59,7 @@ func (r *Nomfoundation) Run(sUrl string) (msg string, err error) {
 	r.dt.Url = sUrl
 
 	r.dt.BookId = r.getBookId(r.dt.Url)
+	r.res.SetBook(r.dt.BookId, "")
 	if r.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -62,13 +79,13 @@ func (r *Nomfoundation) getBookId(sUrl string) (bookId string) {
 }
 
 func (r *Nomfoundation) download() (msg string, err error) {
-	log.Printf("Get %s\n", r.dt.Url)
-	r.dt.SavePath = config.Conf.Directory
+	r.res.Log().Info("获取图书信息", logger.KeyUrl, r.dt.Url)
+	r.dt.SavePath = r.opts.Directory
 	canvases, err := r.getCanvases(r.dt.Url, r.dt.Jar)
 	if err != nil || canvases == nil {
 		return "requested URL was not found.", err
 	}
-	log.Printf(" %d pages \n", len(canvases))
+	r.res.Log().Info("解析完成", "pages", len(canvases))
 	return r.do(canvases)
 }
 
@@ -80,37 +97,38 @@ func (r *Nomfoundation) do(canvases []string) (msg string, err error) {
 	referer := r.dt.Url
 	size := len(canvases)
 	var wg sync.WaitGroup
-	q := QueueNew(int(config.Conf.Threads))
+	q := QueueNew(int(r.opts.Threads))
 	for i, uri := range canvases {
-		if uri == "" || !config.PageRange(i, size) {
+		if uri == "" || !r.opts.PageRange(i, size) {
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
-		filename := sortId + config.Conf.FileExt
+		filename := sortId + r.opts.FileExt
 		dest := path.Join(r.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !r.res.Pending(uri, dest) {
 			continue
 		}
 		imgUrl := uri
-		log.Printf("Get %d/%d, URL: %s\n", i+1, size, imgUrl)
+		r.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", size, logger.KeyUrl, imgUrl)
 		wg.Add(1)
 		q.Go(func() {
 			defer wg.Done()
-			ctx := context.Background()
+			ctx := r.ctx
 			opts := gohttp.Options{
 				DestFile:    dest,
 				Overwrite:   false,
 				Concurrency: 1,
-				CookieFile:  config.Conf.CookieFile,
-				HeaderFile:  config.Conf.HeaderFile,
+				CookieFile:  r.opts.CookieFile,
+				HeaderFile:  r.opts.HeaderFile,
 				CookieJar:   r.dt.Jar,
 				Headers: map[string]interface{}{
-					"User-Agent": config.Conf.UserAgent,
+					"User-Agent": r.opts.UserAgent,
 					"Referer":    referer,
 				},
 			}
-			gohttp.FastGet(ctx, imgUrl, opts)
-			util.PrintSleepTime(config.Conf.Sleep)
+			_, err := gohttp.FastGet(ctx, imgUrl, opts)
+			r.res.Record(sortId, imgUrl, dest, err)
+			util.PrintSleepTime(r.opts.Sleep)
 			fmt.Println()
 		})
 	}
@@ -156,12 +174,12 @@ func (r *Nomfoundation) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases [
 }
 
 func (r *Nomfoundation) getBody(apiUrl string, jar *cookiejar.Jar) ([]byte, error) {
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": r.opts.UserAgent,
 		},
 	})
 	resp, err := cli.Get(apiUrl)
@@ -4,14 +4,15 @@ import (
 	"bookget/config"
 	"bookget/model/ouroots"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
 	"bookget/pkg/progressbar"
 	"bookget/pkg/util"
+	"bookget/pkg/validate"
 	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"os"
@@ -26,28 +27,45 @@ type Ouroots struct {
 	dt      *DownloadTask
 	Counter int
 	bar     *progressbar.ProgressBar
+
+	ctx  context.Context
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:       "ouroots",
+		Name:     "[中国]中华寻根网-国图",
+		Country:  "CN",
+		Hosts:    []string{"ouroots.nlc.cn"},
+		Examples: []string{"http://ouroots.nlc.cn/gtzy/gtzyDetail.html?{id}"},
+		New:      func(ctx context.Context, opts *config.Input) RouterInit { return NewOuroots(ctx, opts) },
+	})
 }
 
-func NewOuroots() *Ouroots {
+func NewOuroots(ctx context.Context, opts *config.Input) *Ouroots {
 	return &Ouroots{
 		// 初始化字段
+		ctx:     ctx,
+		opts:    opts,
 		dt:      new(DownloadTask),
 		Counter: 0,
 	}
 }
 
-func (r *Ouroots) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Ouroots) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (r *Ouroots) Run(sUrl string) (msg string, err error) {
 	r.dt.UrlParsed, err = url.Parse(sUrl)
 	r.dt.Url = sUrl
 	r.dt.BookId = r.getBookId(r.dt.Url)
+	r.res.SetBook(r.dt.BookId, "")
 	if r.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -64,18 +82,19 @@ func (r *Ouroots) getBookId(sUrl string) (bookId string) {
 }
 
 func (r *Ouroots) download() (msg string, err error) {
-	log.Printf("Get %s\n", r.dt.Url)
+	r.res.Log().Info("获取图书信息", logger.KeyUrl, r.dt.Url)
 
 	respVolume, err := r.getVolumes(r.dt.BookId)
 	if err != nil || respVolume.StatusCode != "200" {
-		fmt.Println(err)
+		r.res.Log().Error("获取册列表失败", "error", err)
 		return "getVolumes", err
 	}
+	r.res.SetVolumes(len(respVolume.Volume))
 	//不按卷下载，所有图片存一个目录
-	r.dt.SavePath = config.Conf.Directory
+	r.dt.SavePath = r.opts.Directory
 	macCounter := 0
 	for i, vol := range respVolume.Volume {
-		if !config.VolumeRange(i) {
+		if !r.opts.VolumeRange(i) {
 			continue
 		}
 		macCounter += vol.Pages
@@ -83,7 +102,7 @@ func (r *Ouroots) download() (msg string, err error) {
 	fmt.Println()
 	r.bar = progressbar.Default(int64(macCounter), "downloading")
 	for i, vol := range respVolume.Volume {
-		if !config.VolumeRange(i) {
+		if !r.opts.VolumeRange(i) {
 			continue
 		}
 		r.do(vol.Pages, vol.VolumeId)
@@ -100,14 +119,18 @@ func (r *Ouroots) do(pageTotal int, volumeId int) (msg string, err error) {
 	for i := 1; i <= pageTotal; i++ {
 		sortId := fmt.Sprintf("%s.jpg", fmt.Sprintf("%04d", r.Counter+1))
 		dest := filepath.Join(r.dt.SavePath, sortId)
-		if util.FileExist(dest) {
+		if !r.res.Pending("", dest) {
 			r.Counter++
 			r.bar.Add(1)
 			time.Sleep(40 * time.Millisecond)
 			continue
 		}
 		respImage, err := r.getBase64Image(r.dt.BookId, volumeId, i, "", token)
-		if err != nil || respImage.StatusCode != "200" {
+		if err == nil && respImage.StatusCode != "200" {
+			err = fmt.Errorf("StatusCode: %s", respImage.StatusCode)
+		}
+		if err != nil {
+			r.res.Record(sortId, "", dest, err)
 			continue
 		}
 		if pos := strings.Index(respImage.ImagePath, "data:image/jpeg;base64,"); pos != -1 {
@@ -115,9 +138,13 @@ func (r *Ouroots) do(pageTotal int, volumeId int) (msg string, err error) {
 			bs, err := base64.StdEncoding.DecodeString(data)
 			if err != nil || bs == nil {
 				//log.Println(err)
+				r.res.Record(sortId, "", dest, err)
 				continue
 			}
-			_ = os.WriteFile(dest, bs, os.ModePerm)
+			if err = validate.Bytes(bs, filepath.Ext(dest), "image/jpeg"); err == nil {
+				err = util.WriteFileAtomic(dest, bs, os.ModePerm)
+			}
+			r.res.Record(sortId, "", dest, err)
 			r.Counter++
 			r.bar.Add(1)
 			time.Sleep(40 * time.Millisecond)
@@ -127,12 +154,12 @@ func (r *Ouroots) do(pageTotal int, volumeId int) (msg string, err error) {
 }
 
 func (r *Ouroots) getVolumes(catalogKey string) (ouroots.ResponseVolume, error) {
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  r.dt.Jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": r.opts.UserAgent,
 		},
 		Query: map[string]interface{}{
 			"catalogKey": catalogKey,
@@ -147,7 +174,7 @@ func (r *Ouroots) getVolumes(catalogKey string) (ouroots.ResponseVolume, error)
 
 	var respVolume ouroots.ResponseVolume
 	if err = json.Unmarshal(bs, &respVolume); err != nil {
-		log.Printf("json.Unmarshal failed: %s\n", err)
+		r.res.Log().Error("解析 JSON 失败", "error", err)
 		return respVolume, errors.New(resp.GetReasonPhrase())
 	}
 	return respVolume, nil
@@ -159,12 +186,12 @@ func (r *Ouroots) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []strin
 }
 
 func (r *Ouroots) getBody(sUrl string, jar *cookiejar.Jar) ([]byte, error) {
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": r.opts.UserAgent,
 		},
 	})
 	resp, err := cli.Get(sUrl)
@@ -195,12 +222,12 @@ func (r *Ouroots) getToken() (string, error) {
 	return respLoginAnonymousUser.Token, nil
 }
 func (r *Ouroots) getBase64Image(catalogKey string, volumeId, page int, userKey, token string) (respImage ouroots.ResponseCatalogImage, err error) {
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  r.dt.Jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": r.opts.UserAgent,
 		},
 		Query: map[string]interface{}{
 			"catalogKey": catalogKey,
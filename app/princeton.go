@@ -4,11 +4,11 @@ import (
 	"bookget/config"
 	"bookget/model/princeton"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"path"
@@ -19,27 +19,45 @@ import (
 
 type Princeton struct {
 	dt *DownloadTask
+
+	ctx  context.Context
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:           "princeton",
+		Name:         "[美国]普林斯顿大学图书馆",
+		Country:      "US",
+		Hosts:        []string{"catalog.princeton.edu", "dpul.princeton.edu"},
+		Capabilities: CapIIIF,
+		Examples:     []string{"https://catalog.princeton.edu/catalog/{id}"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewPrinceton(ctx, opts) },
+	})
 }
 
-func NewPrinceton() *Princeton {
+func NewPrinceton(ctx context.Context, opts *config.Input) *Princeton {
 	return &Princeton{
 		// 初始化字段
-		dt: new(DownloadTask),
+		ctx:  ctx,
+		opts: opts,
+		dt:   new(DownloadTask),
 	}
 }
 
-func (r *Princeton) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Princeton) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (r *Princeton) Run(sUrl string) (msg string, err error) {
 	r.dt.UrlParsed, err = url.Parse(sUrl)
 	r.dt.Url = sUrl
 	r.dt.BookId = r.getBookId(r.dt.Url)
+	r.res.SetBook(r.dt.BookId, "")
 	if r.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -56,25 +74,26 @@ func (r *Princeton) getBookId(sUrl string) (bookId string) {
 }
 
 func (r *Princeton) download() (msg string, err error) {
-	log.Printf("Get %s\n", r.dt.Url)
+	r.res.Log().Info("获取图书信息", logger.KeyUrl, r.dt.Url)
 
 	respVolume, err := r.getVolumes(r.dt.Url, r.dt.Jar)
+	r.res.SetVolumes(len(respVolume))
 	if err != nil {
-		fmt.Println(err)
+		r.res.Log().Error("获取册列表失败", "error", err)
 		return "getVolumes", err
 	}
 	for i, vol := range respVolume {
-		if !config.VolumeRange(i) {
+		if !r.opts.VolumeRange(i) {
 			continue
 		}
 		vid := fmt.Sprintf("%04d", i+1)
-		r.dt.SavePath = CreateDirectory(vid)
+		r.dt.SavePath = CreateDirectory(r.opts, vid)
 		canvases, err := r.getCanvases(vol, r.dt.Jar)
 		if err != nil || canvases == nil {
-			fmt.Println(err)
+			r.res.Log().Error("获取页面列表失败", "error", err)
 			continue
 		}
-		log.Printf(" %d/%d volume, %d pages \n", i+1, len(respVolume), len(canvases))
+		r.res.Log().Info("下载分册", logger.KeyVolume, i+1, "volumes", len(respVolume), "pages", len(canvases))
 		r.do(canvases)
 	}
 	return "", nil
@@ -88,36 +107,37 @@ func (r *Princeton) do(canvases []string) (msg string, err error) {
 	referer := r.dt.Url
 	size := len(canvases)
 	var wg sync.WaitGroup
-	q := QueueNew(int(config.Conf.Threads))
+	q := QueueNew(int(r.opts.Threads))
 	for i, uri := range canvases {
-		if uri == "" || !config.PageRange(i, size) {
+		if uri == "" || !r.opts.PageRange(i, size) {
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
-		filename := sortId + config.Conf.FileExt
+		filename := sortId + r.opts.FileExt
 		dest := path.Join(r.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !r.res.Pending(uri, dest) {
 			continue
 		}
 		imgUrl := uri
-		log.Printf("Get %d/%d page, URL: %s\n", i+1, size, imgUrl)
+		r.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", size, logger.KeyUrl, imgUrl)
 		wg.Add(1)
 		q.Go(func() {
 			defer wg.Done()
-			ctx := context.Background()
+			ctx := r.ctx
 			opts := gohttp.Options{
 				DestFile:    dest,
 				Overwrite:   false,
 				Concurrency: 1,
-				CookieFile:  config.Conf.CookieFile,
-				HeaderFile:  config.Conf.HeaderFile,
+				CookieFile:  r.opts.CookieFile,
+				HeaderFile:  r.opts.HeaderFile,
 				CookieJar:   r.dt.Jar,
 				Headers: map[string]interface{}{
-					"User-Agent": config.Conf.UserAgent,
+					"User-Agent": r.opts.UserAgent,
 					"Referer":    referer,
 				},
 			}
-			gohttp.FastGet(ctx, imgUrl, opts)
+			_, err := gohttp.FastGet(ctx, imgUrl, opts)
+			r.res.Record(sortId, imgUrl, dest, err)
 			fmt.Println()
 		})
 	}
@@ -154,7 +174,7 @@ func (r *Princeton) getVolumes(sUrl string, jar *cookiejar.Jar) (volumes []strin
 
 		}
 		if err = json.Unmarshal(bs, phql); err != nil {
-			log.Printf("json.Unmarshal failed: %s\n", err)
+			r.res.Log().Error("解析 JSON 失败", "error", err)
 			return nil, err
 		}
 		for _, v := range phql.Data.ResourcesByOrangelightIds {
@@ -173,7 +193,7 @@ func (r *Princeton) getVolumes(sUrl string, jar *cookiejar.Jar) (volumes []strin
 		return
 	}
 	if err = json.Unmarshal(body, manifest); err != nil {
-		log.Printf("json.Unmarshal failed: %s\n", err)
+		r.res.Log().Error("解析 JSON 失败", "error", err)
 		return
 	}
 
@@ -195,7 +215,7 @@ func (r *Princeton) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []str
 		return
 	}
 	if err = json.Unmarshal(body, manifest2); err != nil {
-		log.Printf("json.Unmarshal failed: %s\n", err)
+		r.res.Log().Error("解析 JSON 失败", "error", err)
 		return
 	}
 	i := len(manifest2.Sequences[0].Canvases)
@@ -206,7 +226,7 @@ func (r *Princeton) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []str
 		for _, canvase := range sequences.Canvases {
 			for _, image := range canvase.Images {
 				//JPEG URL
-				imgUrl := image.Resource.Service.Id + "/" + config.Conf.Format
+				imgUrl := image.Resource.Service.Id + "/" + r.opts.Format
 				canvases = append(canvases, imgUrl)
 			}
 		}
@@ -217,12 +237,12 @@ func (r *Princeton) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []str
 
 func (r *Princeton) getBody(apiUrl string, jar *cookiejar.Jar) ([]byte, error) {
 	referer := url.QueryEscape(apiUrl)
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": r.opts.UserAgent,
 			"Referer":    referer,
 		},
 	})
@@ -238,12 +258,12 @@ func (r *Princeton) getBody(apiUrl string, jar *cookiejar.Jar) ([]byte, error) {
 }
 
 func (r *Princeton) postBody(sUrl string, d []byte) ([]byte, error) {
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  r.dt.Jar,
 		Headers: map[string]interface{}{
-			"User-Agent":   config.Conf.UserAgent,
+			"User-Agent":   r.opts.UserAgent,
 			"Content-Type": "application/json",
 			"authority":    "figgy.princeton.edu",
 			"referer":      r.dt.Url,
@@ -0,0 +1,309 @@
+package app
+
+import (
+	"bookget/config"
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RouterInit 站点适配器入口
+type RouterInit interface {
+	GetRouterInit(sUrl string) (*Result, error)
+}
+
+// Site 站点适配器描述，由 app/ 下各适配器在 init() 中自行注册
+//
+// 匹配规则：
+//   - Hosts 为域名后缀，按标签边界匹配，忽略 "www." 前缀；带端口的条目须与 URL 的 host:port 完全一致
+//   - HostPatterns 为域名正则
+//   - Paths 为路径正则（匹配 path?query），非空时至少命中一条
+//   - 同一 ID 可多次注册，用于同一适配器的不同 host/path 组合
+type Site struct {
+	ID           string   // 唯一标识，如 "harvard"
+	Name         string   // 显示名称
+	Country      string   // 国家/地区代码，国际项目留空
+	Hosts        []string // 域名后缀
+	HostPatterns []string // 域名正则
+	Paths        []string // 路径正则
+	Priority     int      // 优先级，数值大者优先
+	Capabilities Capability
+	Examples     []string // 示例 URL，{id} 为书籍编号等占位符
+	New          func(ctx context.Context, opts *config.Input) RouterInit
+
+	hostRegexps []*regexp.Regexp
+	pathRegexps []*regexp.Regexp
+}
+
+// Capability 站点支持的下载能力
+type Capability uint
+
+const (
+	CapIIIF    Capability = 1 << iota // IIIF manifest / info.json
+	CapDZI                            // DeepZoom 瓦片（dezoomify-rs）
+	CapPDF                            // PDF 文件
+	CapCatalog                        // 目录/TOC
+	CapOCR                            // OCR 文本
+	CapCookie                         // 需要 cookie 登录
+	CapGUI                            // 需要图形界面（bookget-gui）
+)
+
+var capabilityNames = []struct {
+	c    Capability
+	name string
+}{
+	{CapIIIF, "IIIF"},
+	{CapDZI, "DZI"},
+	{CapPDF, "PDF"},
+	{CapCatalog, "catalog"},
+	{CapOCR, "OCR"},
+	{CapCookie, "cookie"},
+	{CapGUI, "GUI"},
+}
+
+// Names 返回能力名称列表
+func (c Capability) Names() []string {
+	names := make([]string, 0, len(capabilityNames))
+	for _, v := range capabilityNames {
+		if c&v.c != 0 {
+			names = append(names, v.name)
+		}
+	}
+	return names
+}
+
+func (c Capability) String() string {
+	return strings.Join(c.Names(), ",")
+}
+
+var (
+	sitesMu sync.RWMutex
+	sites   []*Site
+)
+
+// Register 注册站点适配器，非法正则直接 panic（属于编码错误）
+func Register(s Site) {
+	if s.ID == "" || s.New == nil {
+		panic("app.Register: site ID and New are required")
+	}
+	for _, p := range s.HostPatterns {
+		s.hostRegexps = append(s.hostRegexps, regexp.MustCompile(p))
+	}
+	for _, p := range s.Paths {
+		s.pathRegexps = append(s.pathRegexps, regexp.MustCompile(p))
+	}
+	for i, h := range s.Hosts {
+		s.Hosts[i] = normalizeHost(h)
+	}
+
+	sitesMu.Lock()
+	defer sitesMu.Unlock()
+	sites = append(sites, &s)
+}
+
+// LookupSite 按 ID 查找站点
+func LookupSite(id string) *Site {
+	sitesMu.RLock()
+	defer sitesMu.RUnlock()
+	for _, s := range sites {
+		if s.ID == id {
+			return s
+		}
+	}
+	return nil
+}
+
+// Sites 返回已注册站点，同一 ID 的多次注册合并为一条，按国家、ID 排序
+func Sites() []Site {
+	sitesMu.RLock()
+	defer sitesMu.RUnlock()
+
+	index := make(map[string]int)
+	list := make([]Site, 0, len(sites))
+	for _, s := range sites {
+		i, ok := index[s.ID]
+		if !ok {
+			index[s.ID] = len(list)
+			list = append(list, Site{
+				ID:       s.ID,
+				Name:     s.Name,
+				Country:  s.Country,
+				Priority: s.Priority,
+				New:      s.New,
+			})
+			i = len(list) - 1
+		}
+		m := &list[i]
+		m.Hosts = appendUnique(m.Hosts, s.Hosts...)
+		m.HostPatterns = appendUnique(m.HostPatterns, s.HostPatterns...)
+		m.Paths = appendUnique(m.Paths, s.Paths...)
+		m.Examples = appendUnique(m.Examples, s.Examples...)
+		m.Capabilities |= s.Capabilities
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Country != list[j].Country {
+			return list[i].Country < list[j].Country
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+func appendUnique(dst []string, src ...string) []string {
+	for _, v := range src {
+		found := false
+		for _, d := range dst {
+			if d == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			dst = append(dst, v)
+		}
+	}
+	return dst
+}
+
+// MatchSite 按 host + path 为 URL 选择适配器
+func MatchSite(rawUrl string) (*Site, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, fmt.Errorf("URL解析失败: %w", err)
+	}
+
+	sitesMu.RLock()
+	defer sitesMu.RUnlock()
+
+	var (
+		best               *Site
+		bestHost, bestPath int
+	)
+	for _, s := range sites {
+		hostScore, ok := s.matchHost(u)
+		if !ok {
+			continue
+		}
+		pathScore, ok := s.matchPath(u)
+		if !ok {
+			continue
+		}
+		//纯 ID 站点（如 "bookget"）不参与 URL 匹配
+		if hostScore == 0 && pathScore == 0 {
+			continue
+		}
+		if best != nil {
+			if s.Priority < best.Priority {
+				continue
+			}
+			if s.Priority == best.Priority {
+				if hostScore < bestHost || (hostScore == bestHost && pathScore <= bestPath) {
+					continue
+				}
+			}
+		}
+		best, bestHost, bestPath = s, hostScore, pathScore
+	}
+	if best != nil {
+		return best, nil
+	}
+	return nil, unsupportedError(rawUrl, u)
+}
+
+// matchHost 返回 host 匹配程度：后缀越长越具体；0 表示不限 host
+func (s *Site) matchHost(u *url.URL) (int, bool) {
+	if len(s.Hosts) == 0 && len(s.hostRegexps) == 0 {
+		return 0, true
+	}
+	hostPort := normalizeHost(u.Host)
+	hostname := normalizeHost(u.Hostname())
+
+	score, ok := 0, false
+	for _, h := range s.Hosts {
+		target := hostname
+		if strings.Contains(h, ":") {
+			target = hostPort
+		}
+		if target == h || strings.HasSuffix(target, "."+h) {
+			if len(h)+1 > score {
+				score, ok = len(h)+1, true
+			}
+		}
+	}
+	if ok {
+		return score, true
+	}
+	//正则视为匹配整个域名，比泛化的后缀更具体
+	for _, re := range s.hostRegexps {
+		if re.MatchString(hostname) {
+			return len(hostname) + 1, true
+		}
+	}
+	return 0, false
+}
+
+// matchPath 返回 path 匹配程度；未设置 Paths 视为命中
+func (s *Site) matchPath(u *url.URL) (int, bool) {
+	if len(s.pathRegexps) == 0 {
+		return 0, true
+	}
+	target := u.RequestURI()
+	for _, re := range s.pathRegexps {
+		if re.MatchString(target) {
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+// unsupportedError 未匹配到站点时，附上最接近的已支持域名
+func unsupportedError(rawUrl string, u *url.URL) error {
+	hostname := normalizeHost(u.Hostname())
+	var (
+		closest  string
+		name     string
+		distance = -1
+	)
+	for _, s := range sites {
+		for _, h := range s.Hosts {
+			h = strings.SplitN(h, ":", 2)[0]
+			d := levenshtein(hostname, h)
+			if distance < 0 || d < distance {
+				closest, name, distance = h, s.Name, d
+			}
+		}
+	}
+	if closest == "" || hostname == "" {
+		return fmt.Errorf("unsupported URL: %s", rawUrl)
+	}
+	return fmt.Errorf("unsupported URL: %s (closest match: %s %s)", rawUrl, closest, name)
+}
+
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	return strings.TrimPrefix(host, "www.")
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,61 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchSite(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://www.loc.gov/item/2014514123/", "loc"},
+		{"https://loc.gov/item/2014514123/", "loc"},
+		{"https://iiif.lib.harvard.edu/manifests/view/drs:53262215", "harvard"},
+		{"https://curiosity.lib.harvard.edu/chinese-rare-books/catalog/49-990080724750203941", "harvard"},
+		{"https://ids.si.edu/ids/manifest/FS-F1904.61", "siedu"},
+		{"https://iiif.si.edu/manifest/FS-F1904.61.json", "iiif"},
+		{"https://asia.si.edu/object/F1904.61/", "siedu"},
+		{"http://idp.bl.uk/database/oo_scroll_h.a4d?uid=1234", "idp"},
+		{"http://idp.korea.ac.kr/database/oo_scroll_h.a4d?uid=1234", "idp"},
+		{"http://111.7.82.29:8090/reader?id=1", "luoyang"},
+		{"https://guji.nlc.cn/resource/1", "nlcguji"},
+		{"http://read.nlc.cn/allSearch/searchDetail?searchType=1002", "nlc"},
+		{"https://www.digitale-sammlungen.de/en/view/bsb11129086", "sammlungen"},
+		{"https://www.digitale-sammlungen.de/iiif/presentation/v2/bsb11129086/manifest", "sammlungen-iiif"},
+		{"https://guji.sclib.org/medias/1122/tiles/infos.json", "dzicnlib"},
+	}
+	for _, tt := range tests {
+		site, err := MatchSite(tt.url)
+		if assert.NoError(t, err, tt.url) {
+			assert.Equal(t, tt.want, site.ID, tt.url)
+		}
+	}
+}
+
+func TestMatchSiteClosest(t *testing.T) {
+	_, err := MatchSite("https://babel.hathitrst.org/cgi/pt?id=1")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "closest match: babel.hathitrust.org")
+}
+
+func TestSites(t *testing.T) {
+	seen := make(map[string]bool)
+	var sammlungen *Site
+	for _, s := range Sites() {
+		assert.False(t, seen[s.ID], "duplicate site %q", s.ID)
+		seen[s.ID] = true
+		assert.NotEmpty(t, s.Name, s.ID)
+		if s.ID == "harvard" {
+			assert.NotZero(t, s.Capabilities&CapIIIF, "harvard: %s", s.Capabilities)
+		}
+		if s.ID == "sammlungen" {
+			sammlungen = &s
+		}
+	}
+	require.NotNil(t, sammlungen)
+	assert.NotEmpty(t, sammlungen.Hosts)
+}
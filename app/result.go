@@ -0,0 +1,285 @@
+package app
+
+import (
+	"bookget/config"
+	"bookget/pkg/downloader"
+	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
+	"bookget/pkg/manifest"
+	"bookget/pkg/retry"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Result 单个 URL 的下载结果，由适配器在下载过程中填写
+//
+// 所有方法均可在 nil 上调用，便于未经 GetRouterInit 直接调用 Run 的场景
+type Result struct {
+	Site       string        `json:"site,omitempty"`
+	Url        string        `json:"url"`
+	BookId     string        `json:"bookId,omitempty"`
+	Title      string        `json:"title,omitempty"`
+	Volumes    int           `json:"volumes"`
+	Planned    int           `json:"planned"` // 计划下载页数（含已存在跳过的）
+	Downloaded int           `json:"downloaded"`
+	Skipped    int           `json:"skipped"`
+	Failed     int           `json:"failed"`
+	Bytes      int64         `json:"bytes"`
+	Paths      []string      `json:"paths,omitempty"` // 输出目录
+	Errors     []PageError   `json:"errors,omitempty"`
+	Msg        string        `json:"msg,omitempty"`
+	Elapsed    time.Duration `json:"elapsed"`
+
+	Interrupted bool `json:"interrupted,omitempty"` // 因取消（如 Ctrl-C）未下载完
+
+	DryRun bool       `json:"dryRun,omitempty"`
+	Format string     `json:"format,omitempty"` // IIIF 图像请求参数或扩展名
+	Dzi    bool       `json:"dzi,omitempty"`    // 按瓦片拼图下载（IIIF/DeepZoom）
+	Items  []PlanItem `json:"items,omitempty"`  // dry-run 时的下载计划
+
+	retryMissing bool // --retry-missing-tiles：有缺失拼图的图像仍下载
+
+	mu    sync.Mutex
+	start time.Time
+	ctx   context.Context
+	log   *slog.Logger
+}
+
+// ErrInterrupted 任务被取消，未下载的页保留到下次运行
+var ErrInterrupted = errors.New("已中断")
+
+// PageError 单页下载失败记录
+type PageError struct {
+	Page   string `json:"page"`
+	Url    string `json:"url"`
+	Err    string `json:"error"`
+	Reason string `json:"reason"` // 最终失败原因：transient、auth 或 permanent
+}
+
+// PlanItem dry-run 计划中的单页
+type PlanItem struct {
+	Url    string `json:"url,omitempty"`
+	Dest   string `json:"dest"`
+	Exists bool   `json:"exists,omitempty"` // 目标文件已存在，实际下载时跳过
+}
+
+func NewResult(ctx context.Context, opts *config.Input, sUrl string) *Result {
+	format := opts.FileExt
+	if opts.Format != "" {
+		format = opts.Format
+	}
+	return &Result{
+		Url:    sUrl,
+		DryRun: opts.DryRun,
+		Format: format,
+		Dzi:    opts.UseDzi,
+		ctx:    ctx,
+		log:    logger.FromContext(ctx),
+		start:  time.Now(),
+
+		retryMissing: opts.RetryMissingTiles,
+	}
+}
+
+// SetBook 记录解析出的书籍编号与标题
+func (res *Result) SetBook(bookId, title string) {
+	if res == nil {
+		return
+	}
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	if bookId != "" && bookId != res.BookId {
+		res.BookId = bookId
+		res.log = res.log.With(logger.KeyBookId, bookId)
+	}
+	if title != "" {
+		res.Title = title
+	}
+}
+
+// Log 任务的 logger，带有站点、图书地址与书籍编号字段，供适配器输出按书筛选的日志
+func (res *Result) Log() *slog.Logger {
+	if res == nil {
+		return logger.FromContext(context.Background())
+	}
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	if res.log == nil {
+		return logger.FromContext(res.ctx)
+	}
+	return res.log
+}
+
+// SetVolumes 记录册数
+func (res *Result) SetVolumes(n int) {
+	if res == nil {
+		return
+	}
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	res.Volumes = n
+}
+
+// Pending 计入计划页数，返回是否需要下载；目标文件已存在时记为跳过
+// dry-run 时只记录计划，始终返回 false
+func (res *Result) Pending(sUrl, dest string) bool {
+	exist := FileExist(dest)
+	if res == nil {
+		return !exist
+	}
+	if exist && res.retryMissing && downloader.HasMissingTiles(dest) {
+		exist = false // 重新下载缺失的拼图，已有的拼图从缓存读取
+	}
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	//已取消时不再开始新的下载
+	if res.ctx != nil && res.ctx.Err() != nil && !exist {
+		res.Interrupted = true
+		return false
+	}
+	res.Planned++
+	if exist {
+		res.Skipped++
+		res.addPath(dest)
+	}
+	if res.DryRun {
+		res.Items = append(res.Items, PlanItem{Url: sUrl, Dest: dest, Exists: exist})
+		res.addPath(dest)
+		return false
+	}
+	return !exist
+}
+
+// Record 记录单页下载结果；err 为 nil 但文件未写入（如服务器返回非 200）同样计为失败
+func (res *Result) Record(page, sUrl, dest string, err error) {
+	var size int64
+	var merr error
+	if err == nil {
+		if fi, e := os.Stat(dest); e == nil && fi.Size() > 0 {
+			size = fi.Size()
+			// 写入所在目录的校验清单，供 bookget verify 检查
+			merr = manifest.Add(dest, sUrl)
+		} else {
+			err = errors.New("文件未写入")
+		}
+	}
+	if res == nil {
+		if merr != nil {
+			logger.FromContext(context.Background()).Warn("写入校验清单失败", "dest", dest, "error", merr)
+		}
+		return
+	}
+
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	attrs := []any{logger.KeyPage, page, logger.KeyUrl, sUrl, "dest", dest}
+	if merr != nil {
+		res.log.Warn("写入校验清单失败", append(attrs, "error", merr)...)
+	}
+	if vol := volumeOf(dest); vol != "" {
+		attrs = append(attrs, logger.KeyVolume, vol)
+	}
+	if err != nil {
+		res.Failed++
+		reason := retry.Classify(err).String()
+		res.Errors = append(res.Errors, PageError{Page: page, Url: sUrl, Err: err.Error(), Reason: reason})
+		res.log.Warn("页面下载失败", append(attrs, "error", err, "reason", reason)...)
+		return
+	}
+	res.log.Debug("页面下载完成", append(attrs, "bytes", size)...)
+	res.Downloaded++
+	res.Bytes += size
+	res.addPath(dest)
+}
+
+// RecordTasks 汇总 DownloadManager 执行完毕的任务
+func (res *Result) RecordTasks(tasks []*downloader.DownloadTask) {
+	for _, t := range tasks {
+		var err error
+		if !t.Success {
+			if t.ErrorMessage != "" {
+				err = errors.New(t.ErrorMessage)
+			} else {
+				err = errors.New("未完成")
+			}
+		}
+		res.Record(t.FileName, t.URL, filepath.Join(t.SaveDir, t.FileName), err)
+	}
+}
+
+// Finish 结束计时并返回结果；适配器只返回 msg 时将其视为错误
+func (res *Result) Finish(msg string, err error) (*Result, error) {
+	if res == nil {
+		return nil, err
+	}
+	if err == nil && msg != "" {
+		err = errors.New(msg)
+	}
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	if res.ctx != nil && res.ctx.Err() != nil && (res.Interrupted || res.Failed > 0 || err != nil) {
+		res.Interrupted = true
+		if err == nil {
+			err = ErrInterrupted
+		}
+	}
+	//未经 Pending 直接下载的页同样计入计划
+	if n := res.Downloaded + res.Skipped + res.Failed; res.Planned < n {
+		res.Planned = n
+	}
+	res.Msg = msg
+	if err != nil && res.Msg == "" {
+		res.Msg = err.Error()
+	}
+	res.Elapsed = time.Since(res.start)
+	attrs := []any{"planned", res.Planned, "downloaded", res.Downloaded, "skipped", res.Skipped,
+		"failed", res.Failed, "bytes", res.Bytes, "elapsed", res.Elapsed}
+	if err != nil {
+		res.log.Error("下载未完成", append(attrs, "error", err)...)
+	} else if res.Failed > 0 {
+		res.log.Warn("下载完成，部分页面失败", attrs...)
+	} else {
+		res.log.Info("下载完成", attrs...)
+	}
+	return res, err
+}
+
+// OK 无错误且没有失败页
+func (res *Result) OK() bool {
+	return res != nil && res.Failed == 0 && res.Msg == ""
+}
+
+func (res *Result) String() string {
+	if res == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s %s: 计划 %d, 下载 %d, 跳过 %d, 失败 %d, %s, 耗时 %v",
+		res.Site, res.BookId, res.Planned, res.Downloaded, res.Skipped, res.Failed,
+		gohttp.ByteUnitString(res.Bytes), res.Elapsed.Round(time.Millisecond))
+}
+
+// volumeOf 从 CreateDirectory 生成的 vol.xxx 目录名中取册号
+func volumeOf(dest string) string {
+	dir := filepath.Base(filepath.Dir(dest))
+	if strings.HasPrefix(dir, "vol.") {
+		return strings.TrimPrefix(dir, "vol.")
+	}
+	return ""
+}
+
+func (res *Result) addPath(dest string) {
+	dir := filepath.Dir(dest)
+	for _, p := range res.Paths {
+		if p == dir {
+			return
+		}
+	}
+	res.Paths = append(res.Paths, dir)
+}
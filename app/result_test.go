@@ -0,0 +1,94 @@
+package app
+
+import (
+	"bookget/config"
+	"bookget/pkg/logger"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResult(t *testing.T) {
+	dir := t.TempDir()
+	exist := filepath.Join(dir, "0001.jpg")
+	require.NoError(t, os.WriteFile(exist, []byte("jpeg"), 0644))
+	done := filepath.Join(dir, "0002.jpg")
+	missing := filepath.Join(dir, "0003.jpg")
+	failed := filepath.Join(dir, "0004.jpg")
+
+	res := NewResult(context.Background(), &config.Input{}, "https://example.org/book")
+	assert.False(t, res.Pending("u1", exist), "已存在的文件跳过")
+	for _, dest := range []string{done, missing, failed} {
+		assert.True(t, res.Pending("u", dest), dest)
+	}
+	require.NoError(t, os.WriteFile(done, []byte("jpeg data"), 0644))
+	res.Record("0002", "u2", done, nil)
+	res.Record("0003", "u3", missing, nil) // 服务器返回非 200，文件未写入
+	res.Record("0004", "u4", failed, errors.New("timeout"))
+
+	res, err := res.Finish("", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []int{4, 1, 1, 2}, []int{res.Planned, res.Skipped, res.Downloaded, res.Failed})
+	assert.Equal(t, int64(len("jpeg data")), res.Bytes)
+	require.Len(t, res.Errors, 2)
+	assert.Equal(t, "0004", res.Errors[1].Page)
+	assert.Equal(t, []string{dir}, res.Paths)
+	assert.False(t, res.OK())
+
+	_, err = NewResult(context.Background(), &config.Input{}, "u").Finish("requested URL was not found.", nil)
+	assert.Error(t, err)
+}
+
+func TestResultInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	res := NewResult(ctx, &config.Input{}, "https://example.org/book")
+	require.True(t, res.Pending("u1", filepath.Join(dir, "0001.jpg")))
+	res.Record("0001", "u1", filepath.Join(dir, "0001.jpg"), context.Canceled)
+
+	cancel()
+	assert.False(t, res.Pending("u2", filepath.Join(dir, "0002.jpg")), "取消后不再下载")
+	_, err := res.Finish("", nil)
+	assert.ErrorIs(t, err, ErrInterrupted)
+	assert.True(t, res.Interrupted)
+	assert.Equal(t, 1, res.Planned)
+	assert.Equal(t, 1, res.Failed)
+}
+
+func TestResultRetryMissingTiles(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "0001.jpg")
+	require.NoError(t, os.WriteFile(dest, []byte("jpeg"), 0644))
+	require.NoError(t, os.WriteFile(dest+".missing.json", []byte("{}"), 0644))
+
+	assert.False(t, NewResult(context.Background(), &config.Input{}, "u").Pending("u1", dest))
+	assert.True(t, NewResult(context.Background(), &config.Input{RetryMissingTiles: true}, "u").Pending("u1", dest),
+		"有缺失拼图的图像在 --retry-missing-tiles 时重新下载")
+}
+
+func TestResultLog(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := logger.New(&buf, slog.LevelInfo, "json")
+	require.NoError(t, err)
+	ctx := logger.With(logger.NewContext(context.Background(), l), logger.KeySite, "iiif")
+
+	res := NewResult(ctx, &config.Input{}, "https://example.org/book")
+	res.SetBook("b1", "")
+	res.Log().Info("下载页面", logger.KeyPage, 3)
+
+	var rec map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &rec))
+	assert.Equal(t, "iiif", rec[logger.KeySite])
+	assert.Equal(t, "b1", rec[logger.KeyBookId])
+	assert.EqualValues(t, 3, rec[logger.KeyPage])
+
+	assert.NotNil(t, (*Result)(nil).Log())
+}
@@ -4,11 +4,13 @@ import (
 	"bookget/config"
 	"bookget/model/rslru"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
+	"bookget/pkg/util"
+	"bookget/pkg/validate"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
@@ -22,21 +24,37 @@ import (
 type RslRu struct {
 	dt       *DownloadTask
 	response *rslru.Response
+
+	ctx  context.Context
+	opts *config.Input
+	res  *Result
 }
 
-func NewRslRu() *RslRu {
+func init() {
+	Register(Site{
+		ID:       "rslru",
+		Name:     "[俄罗斯]国家图书馆",
+		Country:  "RU",
+		Hosts:    []string{"viewer.rsl.ru"},
+		Examples: []string{"https://viewer.rsl.ru/ru/{id}"},
+		New:      func(ctx context.Context, opts *config.Input) RouterInit { return NewRslRu(ctx, opts) },
+	})
+}
+
+func NewRslRu(ctx context.Context, opts *config.Input) *RslRu {
 	return &RslRu{
 		// 初始化字段
-		dt: new(DownloadTask),
+		ctx:  ctx,
+		opts: opts,
+		dt:   new(DownloadTask),
 	}
 }
 
-func (r *RslRu) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *RslRu) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (r *RslRu) Run(sUrl string) (msg string, err error) {
@@ -45,6 +63,7 @@ func (r *RslRu) Run(sUrl string) (msg string, err error) {
 	r.dt.Url = sUrl
 
 	r.dt.BookId = r.getBookId(r.dt.Url)
+	r.res.SetBook(r.dt.BookId, "")
 	if r.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -61,19 +80,19 @@ func (r *RslRu) getBookId(sUrl string) (bookId string) {
 }
 
 func (r *RslRu) download() (msg string, err error) {
-	log.Printf("Get  %s\n", r.dt.Url)
+	r.res.Log().Info("获取图书信息", logger.KeyUrl, r.dt.Url)
 
 	r.response, err = r.getJsonResponse()
 	if err != nil {
 		return "requested URL was not found.", err
 	}
 	vid := regexp.MustCompile(`([\\/:：；\s]+)`).ReplaceAllString(r.response.Description.Title, "")
-	r.dt.SavePath = CreateDirectory(vid)
+	r.dt.SavePath = CreateDirectory(r.opts, vid)
 	canvases, err := r.getCanvases(r.dt.Url, r.dt.Jar)
 	if err != nil || canvases == nil {
 		return "requested URL was not found.", err
 	}
-	log.Printf(" %d pages \n", len(canvases))
+	r.res.Log().Info("解析完成", "pages", len(canvases))
 	return r.do(canvases)
 }
 
@@ -85,40 +104,45 @@ func (r *RslRu) do(canvases []string) (msg string, err error) {
 	//referer := r.dt.Url
 	size := len(canvases)
 	var wg sync.WaitGroup
-	q := QueueNew(int(config.Conf.Threads))
+	q := QueueNew(int(r.opts.Threads))
 	for i, uri := range canvases {
-		if uri == "" || !config.PageRange(i, size) {
+		if uri == "" || !r.opts.PageRange(i, size) {
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
-		filename := sortId + config.Conf.FileExt
+		filename := sortId + r.opts.FileExt
 		dest := path.Join(r.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !r.res.Pending(uri, dest) {
 			continue
 		}
 		imgUrl := uri
-		log.Printf("Get %d/%d page, URL: %s\n", i+1, size, imgUrl)
+		r.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", size, logger.KeyUrl, imgUrl)
 		wg.Add(1)
 		q.Go(func() {
 			defer wg.Done()
-			ctx := context.Background()
+			ctx := r.ctx
 			cli := gohttp.NewClient(ctx, gohttp.Options{
-				CookieFile: config.Conf.CookieFile,
+				CookieFile: r.opts.CookieFile,
 				CookieJar:  nil,
 				Headers: map[string]interface{}{
-					"User-Agent": config.Conf.UserAgent,
+					"User-Agent": r.opts.UserAgent,
 				},
 			})
 			resp, err := cli.Get(imgUrl)
 			if err != nil {
+				r.res.Record(sortId, imgUrl, dest, err)
 				return
 			}
 			bs, _ := resp.GetBody()
 			length, _ := strconv.Atoi(resp.GetHeaderLine("Content-Length"))
 			if bs == nil || length != len(bs) {
+				r.res.Record(sortId, imgUrl, dest, fmt.Errorf("Content-Length 不一致: %d != %d", length, len(bs)))
 				return
 			}
-			os.WriteFile(dest, bs, os.ModePerm)
+			if err = validate.Bytes(bs, path.Ext(dest), resp.GetHeaderLine("Content-Type")); err == nil {
+				err = util.WriteFileAtomic(dest, bs, os.ModePerm)
+			}
+			r.res.Record(sortId, imgUrl, dest, err)
 		})
 	}
 	wg.Wait()
@@ -146,18 +170,18 @@ func (r *RslRu) getJsonResponse() (resp *rslru.Response, err error) {
 		return
 	}
 	if err = json.Unmarshal(bs, resp); err != nil {
-		log.Printf("json.Unmarshal failed: %s\n", err)
+		r.res.Log().Error("解析 JSON 失败", "error", err)
 	}
 	return resp, err
 }
 
 func (r *RslRu) getBody(apiUrl string, jar *cookiejar.Jar) ([]byte, error) {
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": r.opts.UserAgent,
 		},
 	})
 	resp, err := cli.Get(apiUrl)
@@ -1,8 +1,10 @@
 package app
 
 import (
+	"bookget/config"
+	"bookget/pkg/logger"
+	"context"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"regexp"
@@ -10,21 +12,38 @@ import (
 
 type Sammlungen struct {
 	dt *DownloadTask
+
+	ctx  context.Context
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:           "sammlungen",
+		Name:         "[德国]巴伐利亞州立圖書館東亞數字資源庫",
+		Country:      "DE",
+		Hosts:        []string{"ostasien.digitale-sammlungen.de", "digitale-sammlungen.de"},
+		Capabilities: CapIIIF,
+		Examples:     []string{"https://www.digitale-sammlungen.de/view/{id}"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewSammlungen(ctx, opts) },
+	})
 }
 
-func NewSammlungen() *Sammlungen {
+func NewSammlungen(ctx context.Context, opts *config.Input) *Sammlungen {
 	return &Sammlungen{
 		// 初始化字段
-		dt: new(DownloadTask),
+		ctx:  ctx,
+		opts: opts,
+		dt:   new(DownloadTask),
 	}
 }
 
-func (r *Sammlungen) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Sammlungen) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (r *Sammlungen) Run(sUrl string) (msg string, err error) {
@@ -33,6 +52,7 @@ func (r *Sammlungen) Run(sUrl string) (msg string, err error) {
 	r.dt.Url = sUrl
 
 	r.dt.BookId = r.getBookId(r.dt.Url)
+	r.res.SetBook(r.dt.BookId, "")
 	if r.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -49,8 +69,9 @@ func (r *Sammlungen) getBookId(sUrl string) (bookId string) {
 }
 
 func (r *Sammlungen) download() (msg string, err error) {
-	log.Printf("Get %s\n", r.dt.Url)
+	r.res.Log().Info("获取图书信息", logger.KeyUrl, r.dt.Url)
 	manifestUrl := fmt.Sprintf("https://api.digitale-sammlungen.de/iiif/presentation/v2/%s/manifest", r.dt.BookId)
-	var iiif IIIF
+	iiif := NewIiifRouter(r.ctx, r.opts)
+	iiif.res = r.res
 	return iiif.InitWithId(r.dt.Index, manifestUrl, r.dt.BookId)
 }
@@ -5,21 +5,18 @@ import (
 	"bookget/model/sdlib"
 	"bookget/pkg/chttp"
 	"bookget/pkg/downloader"
+	"bookget/pkg/httpclient"
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
-	"net/http/cookiejar"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
-	"time"
 )
 
 type Sdlib struct {
@@ -38,39 +35,45 @@ type Sdlib struct {
 	parsedUrl *url.URL
 	savePath  string
 	bookId    string
+
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:       "sdlib",
+		Name:     "[中国]山东省古籍数字资源平台",
+		Country:  "CN",
+		Hosts:    []string{"guji.sdlib.com"},
+		Examples: []string{"https://guji.sdlib.com/ancientBooks/detail?resId={id}"},
+		New:      func(ctx context.Context, opts *config.Input) RouterInit { return NewSdlib(ctx, opts) },
+	})
 }
 
-func NewSdlib() *Sdlib {
-	ctx, cancel := context.WithCancel(context.Background())
-	dm := downloader.NewDownloadManager(ctx, cancel, config.Conf.MaxConcurrent)
+func NewSdlib(ctx context.Context, opts *config.Input) *Sdlib {
+	ctx, cancel := context.WithCancel(ctx)
+	dm := downloader.NewDownloadManager(ctx, cancel, opts.MaxConcurrent)
+	dm.SetRetries(opts.Retries)
 
-	// 创建自定义 Transport 忽略 SSL 验证
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-	}
-	jar, _ := cookiejar.New(nil)
 	return &Sdlib{
 		// 初始化字段
+		opts:   opts,
 		dm:     dm,
-		client: &http.Client{Timeout: config.Conf.Timeout * time.Second, Jar: jar, Transport: tr},
+		client: httpclient.ForSite(opts),
 		ctx:    ctx,
 		cancel: cancel,
 	}
 }
 
-func (r *Sdlib) GetRouterInit(rawUrl string) (map[string]interface{}, error) {
+func (r *Sdlib) GetRouterInit(rawUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, rawUrl)
 	r.rawUrl = rawUrl
 	r.parsedUrl, _ = url.Parse(rawUrl)
 	err := r.Run()
-	if err != nil {
-		return nil, err
-	}
-	return map[string]interface{}{
-		"type": "",
-		"url":  rawUrl,
-	}, nil
+	r.res.RecordTasks(r.dm.Tasks())
+	r.res.SetBook(r.bookId, "")
+	return r.res.Finish("", err)
 }
 
 func (r *Sdlib) getBookId(rawUrl string) (bookId string) {
@@ -93,10 +96,11 @@ func (r *Sdlib) getBookId(rawUrl string) (bookId string) {
 
 func (r *Sdlib) Run() (err error) {
 	r.bookId = r.getBookId(r.rawUrl)
+	r.res.SetBook(r.bookId, "")
 	if r.bookId == "" {
 		return err
 	}
-	r.savePath = config.Conf.Directory
+	r.savePath = r.opts.Directory
 	r.urlsFile = path.Join(r.savePath, "urls.txt")
 
 	r.canvases, err = r.getCanvases(r.rawUrl)
@@ -104,7 +108,7 @@ func (r *Sdlib) Run() (err error) {
 		return err
 	}
 
-	err = os.WriteFile(r.urlsFile, []byte(r.bufBuilder.String()), os.ModePerm)
+	err = writeFile(r.opts, r.urlsFile, []byte(r.bufBuilder.String()), os.ModePerm)
 	if err != nil {
 		return err
 	}
@@ -121,17 +125,17 @@ func (r *Sdlib) do(canvases []string) (err error) {
 	}
 
 	counter := 0
-	headers := BuildRequestHeader()
+	headers := BuildRequestHeader(r.opts)
 	for i, imgUrl := range canvases {
 		i++
 		sortId := fmt.Sprintf("%04d", i)
 		fileName := sortId + filepath.Ext(imgUrl)
 
-		if imgUrl == "" || !config.PageRange(i, sizeVol) {
+		if imgUrl == "" || !r.opts.PageRange(i, sizeVol) {
 			continue
 		}
 		//跳过存在的文件
-		if FileExist(path.Join(r.savePath, fileName)) {
+		if !r.res.Pending(imgUrl, path.Join(r.savePath, fileName)) {
 			continue
 		}
 		// 添加GET下载任务
@@ -142,7 +146,7 @@ func (r *Sdlib) do(canvases []string) (err error) {
 			nil,
 			r.savePath,
 			fileName,
-			config.Conf.Threads,
+			r.opts.Threads,
 		)
 		counter++
 	}
@@ -158,7 +162,7 @@ func (r *Sdlib) getVolumes(rawUrl string) (volumes []string, err error) {
 }
 
 func (r *Sdlib) getCanvases(rawUrl string) (canvases []string, err error) {
-	apiUrl := fmt.Sprintf("http://%s/dev-api/ancientbooks/front/getFileContentPage/3/", r.parsedUrl.Host, r.bookId)
+	apiUrl := fmt.Sprintf("http://%s/dev-api/ancientbooks/front/getFileContentPage/3/%s", r.parsedUrl.Host, r.bookId)
 	r.bufBody, err = r.getBody(apiUrl)
 	if err != nil {
 		return nil, err
@@ -181,12 +185,12 @@ func (r *Sdlib) getBody(rawUrl string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", config.Conf.UserAgent)
-	cookies, _ := chttp.ReadCookiesFromFile(config.Conf.CookieFile)
+	req.Header.Set("User-Agent", r.opts.UserAgent)
+	cookies, _ := chttp.ReadCookiesFromFile(r.opts.CookieFile)
 	if cookies != "" {
 		req.Header.Set("Cookie", cookies)
 	}
-	headers, err := chttp.ReadHeadersFromFile(config.Conf.HeaderFile)
+	headers, err := chttp.ReadHeadersFromFile(r.opts.HeaderFile)
 	if err == nil {
 		for key, value := range headers {
 			req.Header.Set(key, value)
@@ -198,7 +202,7 @@ func (r *Sdlib) getBody(rawUrl string) ([]byte, error) {
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			log.Printf("close body err=%v", err)
+			r.res.Log().Warn("关闭响应失败", "error", err)
 		}
 	}()
 
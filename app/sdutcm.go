@@ -5,11 +5,14 @@ import (
 	"bookget/model/sdutcm"
 	"bookget/pkg/crypt"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
+	"bookget/pkg/retry"
 	"bookget/pkg/util"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"net/http"
 	"net/http/cookiejar"
 	"net/url"
 	"path"
@@ -21,32 +24,50 @@ type Sdutcm struct {
 	dt    *DownloadTask
 	token string
 	body  []byte
+
+	ctx  context.Context
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:           "sdutcm",
+		Name:         "[中国]山东中医药大学古籍数字图书馆",
+		Country:      "CN",
+		Hosts:        []string{"gjsztsg.sdutcm.edu.cn"},
+		Capabilities: CapPDF | CapCookie,
+		Examples:     []string{"https://gjsztsg.sdutcm.edu.cn/sdutcm/ancient/book/read.jspx?id={id}&pageNum=1"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewSdutcm(ctx, opts) },
+	})
 }
 
-func NewSdutcm() *Sdutcm {
+func NewSdutcm(ctx context.Context, opts *config.Input) *Sdutcm {
 	return &Sdutcm{
 		// 初始化字段
-		dt: new(DownloadTask),
+		ctx:  ctx,
+		opts: opts,
+		dt:   new(DownloadTask),
 	}
 }
 
-func (r *Sdutcm) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Sdutcm) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (r *Sdutcm) Run(sUrl string) (msg string, err error) {
 	r.dt.UrlParsed, err = url.Parse(sUrl)
 	r.dt.Url = sUrl
 	r.dt.BookId = r.getBookId(r.dt.Url)
+	r.res.SetBook(r.dt.BookId, "")
 	if r.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
 	r.dt.Jar, _ = cookiejar.New(nil)
-	WaitNewCookie()
+	WaitNewCookie(r.ctx, r.opts)
 	return r.download()
 }
 
@@ -59,29 +80,30 @@ func (r *Sdutcm) getBookId(sUrl string) (bookId string) {
 }
 
 func (r *Sdutcm) download() (msg string, err error) {
-	log.Printf("Get %s\n", r.dt.Url)
+	r.res.Log().Info("获取图书信息", logger.KeyUrl, r.dt.Url)
 	r.body, err = r.getPageContent(r.dt.Url)
 	if err != nil {
 		return "requested URL was not found.", err
 	}
 	respVolume, err := r.getVolumes(r.dt.Url, r.dt.Jar)
+	r.res.SetVolumes(len(respVolume))
 	if err != nil {
-		fmt.Println(err)
+		r.res.Log().Error("获取册列表失败", "error", err)
 		return "getVolumes", err
 	}
-	config.Conf.FileExt = ".pdf"
+	r.opts.FileExt = ".pdf"
 	for i, vol := range respVolume {
-		if !config.VolumeRange(i) {
+		if !r.opts.VolumeRange(i) {
 			continue
 		}
 		vid := fmt.Sprintf("%04d", i+1)
-		r.dt.SavePath = CreateDirectory(vid)
+		r.dt.SavePath = CreateDirectory(r.opts, vid)
 		canvases, err := r.getCanvases(vol, r.dt.Jar)
 		if err != nil || canvases == nil {
-			fmt.Println(err)
+			r.res.Log().Error("获取页面列表失败", "error", err)
 			continue
 		}
-		log.Printf(" %d/%d volume, %d pages \n", i+1, len(respVolume), len(canvases))
+		r.res.Log().Info("下载分册", logger.KeyVolume, i+1, "volumes", len(respVolume), "pages", len(canvases))
 		r.do(canvases)
 	}
 	return "", nil
@@ -91,20 +113,20 @@ func (r *Sdutcm) do(imgUrls []string) (msg string, err error) {
 	fmt.Println()
 	referer := r.dt.Url
 	size := len(imgUrls)
-	ctx := context.Background()
+	ctx := r.ctx
 	for i, uri := range imgUrls {
-		if uri == "" || !config.PageRange(i, size) {
+		if uri == "" || !r.opts.PageRange(i, size) {
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
-		filename := sortId + config.Conf.FileExt
+		filename := sortId + r.opts.FileExt
 		dest := path.Join(r.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !r.res.Pending(uri, dest) {
 			continue
 		}
-		log.Printf("Get %d/%d,  URL: %s\n", i+1, size, uri)
+		r.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", size, logger.KeyUrl, uri)
 
-		bs, err := getBody(uri, r.dt.Jar)
+		bs, err := getBody(r.ctx, r.opts, uri, r.dt.Jar)
 		var respBody sdutcm.PagePicTxt
 		if err = json.Unmarshal(bs, &respBody); err != nil {
 			break
@@ -116,22 +138,27 @@ func (r *Sdutcm) do(imgUrls []string) (msg string, err error) {
 			DestFile:    dest,
 			Overwrite:   false,
 			Concurrency: 1,
-			CookieFile:  config.Conf.CookieFile,
-			HeaderFile:  config.Conf.HeaderFile,
+			CookieFile:  r.opts.CookieFile,
+			HeaderFile:  r.opts.HeaderFile,
 			CookieJar:   r.dt.Jar,
 			Headers: map[string]interface{}{
-				"User-Agent": config.Conf.UserAgent,
+				"User-Agent": r.opts.UserAgent,
 				"Referer":    referer,
 			},
 		}
-		for k := 0; k < 10; k++ {
+		// 下载失败或服务器没有返回 200 时等待新的 cookie 后重试
+		err = retryWithNewCookie(ctx, r.opts, uri, func() error {
 			resp, err := gohttp.FastGet(ctx, pdfUrl, opts)
-			if err == nil && resp.GetStatusCode() == 200 {
-				break
+			if err == nil && (resp == nil || resp.GetStatusCode() != http.StatusOK) {
+				err = errors.New("下载失败")
+				if resp != nil {
+					err = retry.Status(resp.GetStatusCode())
+				}
 			}
-			WaitNewCookieWithMsg(uri)
-		}
-		util.PrintSleepTime(config.Conf.Sleep)
+			return err
+		})
+		r.res.Record(path.Base(dest), pdfUrl, dest, err)
+		util.PrintSleepTime(r.opts.Sleep)
 		fmt.Println()
 	}
 	fmt.Println()
@@ -144,7 +171,7 @@ func (r *Sdutcm) getVolumes(sUrl string, jar *cookiejar.Jar) (volumes []string,
 		return nil, err
 	}
 	apiUrl := "https://" + r.dt.UrlParsed.Host + "/sdutcm/ancient/book/getVolume.jspx?lshh=" + ancientVolume
-	bs, err := getBody(apiUrl, jar)
+	bs, err := getBody(r.ctx, r.opts, apiUrl, jar)
 	var respBody sdutcm.VolumeList
 	if err = json.Unmarshal(bs, &respBody); err != nil {
 		return nil, err
@@ -193,7 +220,7 @@ func (r *Sdutcm) getVolumeId(bs []byte) string {
 }
 
 func (r *Sdutcm) getPageContent(sUrl string) (bs []byte, err error) {
-	r.body, err = getBody(sUrl, r.dt.Jar)
+	r.body, err = getBody(r.ctx, r.opts, sUrl, r.dt.Jar)
 	if err != nil {
 		return
 	}
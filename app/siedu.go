@@ -5,11 +5,11 @@ import (
 	"bookget/model/iiif"
 	"bookget/pkg/downloader"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
@@ -23,23 +23,37 @@ import (
 type SiEdu struct {
 	dt  *DownloadTask
 	ctx context.Context
+
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:           "siedu",
+		Name:         "[美国]Smithsonian Institution",
+		Country:      "US",
+		Hosts:        []string{"si.edu"},
+		Capabilities: CapIIIF,
+		Examples:     []string{"https://ids.si.edu/ids/manifest/{id}", "https://asia.si.edu/object/{id}/"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewSiEdu(ctx, opts) },
+	})
 }
 
-func NewSiEdu() *SiEdu {
+func NewSiEdu(ctx context.Context, opts *config.Input) *SiEdu {
 	return &SiEdu{
 		// 初始化字段
-		dt:  new(DownloadTask),
-		ctx: context.Background(),
+		opts: opts,
+		dt:   new(DownloadTask),
+		ctx:  ctx,
 	}
 }
 
-func (r *SiEdu) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *SiEdu) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"type": "iiif",
-		"url":  sUrl,
-		"msg":  msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (r *SiEdu) Run(sUrl string) (msg string, err error) {
@@ -48,6 +62,7 @@ func (r *SiEdu) Run(sUrl string) (msg string, err error) {
 	r.dt.Url = sUrl
 
 	r.dt.BookId = r.getBookId(r.dt.Url)
+	r.res.SetBook(r.dt.BookId, "")
 	if r.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -77,14 +92,14 @@ func (r *SiEdu) getBookId(sUrl string) (bookId string) {
 }
 
 func (r *SiEdu) download() (msg string, err error) {
-	log.Printf("Get %s\n", r.dt.Url)
-	r.dt.SavePath = config.Conf.Directory
+	r.res.Log().Info("获取图书信息", logger.KeyUrl, r.dt.Url)
+	r.dt.SavePath = r.opts.Directory
 	apiUrl := "https://" + r.dt.UrlParsed.Host + "/ids/manifest/" + r.dt.BookId
 	canvases, err := r.getCanvases(apiUrl, r.dt.Jar)
 	if err != nil || canvases == nil {
 		return "requested URL was not found.", err
 	}
-	log.Printf(" %d images \n", len(canvases))
+	r.res.Log().Info("解析完成", "images", len(canvases))
 	return r.do(canvases)
 }
 
@@ -99,13 +114,13 @@ func (r *SiEdu) do(iiifUrls []string) (msg string, err error) {
 		"-H", "Referer:" + referer,
 	}
 	size := len(iiifUrls)
-	iiifDownloader := downloader.NewIIIFDownloader(&config.Conf)
+	iiifDownloader := downloader.NewIIIFDownloader(r.opts)
 	for i, uri := range iiifUrls {
-		if uri == "" || !config.PageRange(i, size) {
+		if uri == "" || !r.opts.PageRange(i, size) {
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
-		filename := sortId + config.Conf.FileExt
+		filename := sortId + r.opts.FileExt
 		inputUri := filepath.Join(r.dt.SavePath, sortId+"_info.json")
 		bs, err := r.getBody(uri, r.dt.Jar)
 		if err != nil {
@@ -113,13 +128,15 @@ func (r *SiEdu) do(iiifUrls []string) (msg string, err error) {
 		}
 		body := strings.Replace(string(bs), `"http://iiif.io/api/image/2/level2.json",`, "", -1)
 		body = strings.Replace(body, `"sizeByH",`, "", -1)
-		os.WriteFile(inputUri, []byte(body), os.ModePerm)
+		writeFile(r.opts, inputUri, []byte(body), os.ModePerm)
 		dest := path.Join(r.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !r.res.Pending(uri, dest) {
 			continue
 		}
-		log.Printf("Get %s  %s\n", sortId, uri)
-		if err := iiifDownloader.Dezoomify(r.ctx, inputUri, dest, args); err == nil {
+		r.res.Log().Info("下载页面", logger.KeyPage, sortId, logger.KeyUrl, uri)
+		err = iiifDownloader.Dezoomify(r.ctx, inputUri, dest, args)
+		r.res.Record(sortId, uri, dest, err)
+		if err == nil {
 			os.Remove(inputUri)
 		}
 	}
@@ -133,7 +150,7 @@ func (r *SiEdu) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []string,
 		return nil, err
 	}
 	if err = json.Unmarshal(bs, manifest); err != nil {
-		log.Printf("json.Unmarshal failed: %s\n", err)
+		r.res.Log().Error("解析 JSON 失败", "error", err)
 		return
 	}
 	if len(manifest.Sequences) == 0 {
@@ -151,12 +168,12 @@ func (r *SiEdu) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []string,
 }
 
 func (r *SiEdu) getBody(apiUrl string, jar *cookiejar.Jar) ([]byte, error) {
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": r.opts.UserAgent,
 			"Referer":    r.dt.Url,
 			"authority":  "www.si.edu",
 			"origin":     "https://www.si.edu/",
@@ -4,6 +4,7 @@ import (
 	"bookget/config"
 	"bookget/pkg/gohttp"
 	xhash "bookget/pkg/hash"
+	"bookget/pkg/retry"
 	"bookget/pkg/util"
 	"bytes"
 	"context"
@@ -57,14 +58,13 @@ func getBookId(sUrl string) (bookId string) {
 	return bookId
 }
 
-func getBody(sUrl string, jar *cookiejar.Jar) ([]byte, error) {
+func getBody(ctx context.Context, opts *config.Input, sUrl string, jar *cookiejar.Jar) ([]byte, error) {
 	referer := url.QueryEscape(sUrl)
-	ctx := context.Background()
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": opts.UserAgent,
 			"Referer":    referer,
 		},
 	})
@@ -79,13 +79,12 @@ func getBody(sUrl string, jar *cookiejar.Jar) ([]byte, error) {
 	return bs, nil
 }
 
-func postBody(sUrl string, d []byte, jar *cookiejar.Jar) ([]byte, error) {
-	ctx := context.Background()
+func postBody(ctx context.Context, opts *config.Input, sUrl string, d []byte, jar *cookiejar.Jar) ([]byte, error) {
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent":   config.Conf.UserAgent,
+			"User-Agent":   opts.UserAgent,
 			"Content-Type": "application/x-www-form-urlencoded",
 		},
 		Body: d,
@@ -98,13 +97,12 @@ func postBody(sUrl string, d []byte, jar *cookiejar.Jar) ([]byte, error) {
 	return bs, err
 }
 
-func postJSON(sUrl string, d interface{}, jar *cookiejar.Jar) ([]byte, error) {
-	ctx := context.Background()
+func postJSON(ctx context.Context, opts *config.Input, sUrl string, d interface{}, jar *cookiejar.Jar) ([]byte, error) {
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent":   config.Conf.UserAgent,
+			"User-Agent":   opts.UserAgent,
 			"Content-Type": "application/json",
 		},
 		JSON: d,
@@ -125,17 +123,33 @@ func FileExist(path string) bool {
 	return false
 }
 
-func CreateDirectory(volumeId string) string {
-	dirPath := config.Conf.Directory
+func CreateDirectory(opts *config.Input, volumeId string) string {
+	dirPath := opts.Directory
 	if volumeId != "" {
-		dirPath = path.Join(config.Conf.Directory, "vol."+volumeId)
+		dirPath = path.Join(opts.Directory, "vol."+volumeId)
 	}
-	_ = os.MkdirAll(dirPath, os.ModePerm)
+	_ = mkdirAll(opts, dirPath)
 	return dirPath
 }
 
-func WaitNewCookie() {
-	if FileExist(config.Conf.CookieFile) {
+// mkdirAll 创建下载目录，dry-run 时不创建
+func mkdirAll(opts *config.Input, dirPath string) error {
+	if opts.DryRun {
+		return nil
+	}
+	return os.MkdirAll(dirPath, os.ModePerm)
+}
+
+// writeFile 写入 urls.txt、目录等辅助文件，dry-run 时不落盘
+func writeFile(opts *config.Input, name string, data []byte, perm os.FileMode) error {
+	if opts.DryRun {
+		return nil
+	}
+	return util.WriteFileAtomic(name, data, perm)
+}
+
+func WaitNewCookie(ctx context.Context, opts *config.Input) {
+	if FileExist(opts.CookieFile) {
 		return
 	}
 	var wg sync.WaitGroup
@@ -143,41 +157,56 @@ func WaitNewCookie() {
 	fmt.Println("请使用 bookget-gui 浏览器，打开图书网址，完成「真人验证 / 登录用户」，然后 「刷新」 网页.")
 	go func() {
 		defer wg.Done()
-		for i := 0; i < 3600*8; i++ {
-			if FileExist(config.Conf.CookieFile) {
+		for i := 0; i < 3600*8 && ctx.Err() == nil; i++ {
+			if FileExist(opts.CookieFile) {
 				break
 			}
-			util.PrintSleepTime(config.Conf.Sleep)
+			util.PrintSleepTime(opts.Sleep)
 		}
 	}()
 	wg.Wait()
 }
 
-func WaitNewCookieWithMsg(uri string) {
-	_ = os.Remove(config.Conf.CookieFile)
+func WaitNewCookieWithMsg(ctx context.Context, opts *config.Input, uri string) {
+	_ = os.Remove(opts.CookieFile)
 	var wg sync.WaitGroup
 	wg.Add(1)
 	fmt.Println("请使用 bookget-gui 浏览器打开下面 URL，完成「真人验证 / 登录用户」，然后 「刷新」 网页.")
 	fmt.Println(uri)
 	go func() {
 		defer wg.Done()
-		for i := 0; i < 3600*8; i++ {
-			if FileExist(config.Conf.CookieFile) {
+		for i := 0; i < 3600*8 && ctx.Err() == nil; i++ {
+			if FileExist(opts.CookieFile) {
 				break
 			}
-			util.PrintSleepTime(config.Conf.Sleep)
+			util.PrintSleepTime(opts.Sleep)
 		}
 	}()
 	wg.Wait()
 }
 
-func IsChinaIP(jar *cookiejar.Jar) bool {
-	ctx := context.Background()
+// cookieRetries 下载失败后等待新 cookie 的最多次数
+const cookieRetries = 10
+
+// retryWithNewCookie 执行 fn，失败时等待新的 cookie 后重试，最多 cookieRetries 次。
+// 验证码或登录失效时服务器不一定返回 401/403，所以任何失败都按需要新的 cookie 处理
+func retryWithNewCookie(ctx context.Context, opts *config.Input, uri string, fn func() error) error {
+	return retry.New(cookieRetries).WithAuth(func(ctx context.Context) {
+		WaitNewCookieWithMsg(ctx, opts, uri)
+	}).Do(ctx, func(attempt int) error {
+		if err := fn(); err != nil {
+			return &retry.Error{Class: retry.Auth, Attempts: attempt, Err: err}
+		}
+		return nil
+	})
+}
+
+func IsChinaIP(ctx context.Context, opts *config.Input, jar *cookiejar.Jar) bool {
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": opts.UserAgent,
 			"Referer":    "http://ip-api.com/",
 		},
 	})
@@ -3,24 +3,22 @@ package app
 import (
 	"bookget/config"
 	"bookget/pkg/chttp"
+	"bookget/pkg/httpclient"
 	"bookget/pkg/sharedmemory"
 	"bytes"
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
-	"net/http/cookiejar"
 	"net/url"
 	"strings"
 	"time"
 )
 
 type Downloader interface {
-	NewDownloader() *Downloader
-	GetRouterInit(rawUrl string) (map[string]interface{}, error)
+	NewDownloader(ctx context.Context, opts *config.Input) *Downloader
+	GetRouterInit(rawUrl string) (*Result, error)
 	getBookId(rawUrl string) (bookId string)
 	Run() (err error)
 	do(canvases []string) (err error)
@@ -47,38 +45,31 @@ type DownloaderImpl struct {
 	parsedUrl *url.URL
 	savePath  string
 	bookId    string
+
+	opts *config.Input
+	res  *Result
 }
 
 // Implement the NewDownloader method to satisfy the interface
-func (d *DownloaderImpl) NewDownloader() *DownloaderImpl {
-	ctx, cancel := context.WithCancel(context.Background())
+func (d *DownloaderImpl) NewDownloader(ctx context.Context, opts *config.Input) *DownloaderImpl {
+	ctx, cancel := context.WithCancel(ctx)
 
-	// 创建自定义 Transport 忽略 SSL 验证
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-	}
-	jar, _ := cookiejar.New(nil)
 	return &DownloaderImpl{
 		// 初始化字段
-		client: &http.Client{Timeout: config.Conf.Timeout * time.Second, Jar: jar, Transport: tr},
+		opts:   opts,
+		client: httpclient.ForSite(opts),
 		ctx:    ctx,
 		cancel: cancel,
 	}
 }
 
-func (d *DownloaderImpl) GetRouterInit(rawUrl string) (map[string]interface{}, error) {
+func (d *DownloaderImpl) GetRouterInit(rawUrl string) (*Result, error) {
+	d.res = NewResult(d.ctx, d.opts, rawUrl)
 	d.rawUrl = rawUrl
 	d.parsedUrl, _ = url.Parse(rawUrl)
 	err := d.Run()
-	if err != nil {
-		return nil, err
-	}
-	return map[string]interface{}{
-		"type": "",
-		"url":  rawUrl,
-	}, nil
+	d.res.SetBook(d.bookId, "")
+	return d.res.Finish("", err)
 }
 
 func (d *DownloaderImpl) Run() (err error) {
@@ -89,7 +80,7 @@ func (d *DownloaderImpl) Run() (err error) {
 func (d *DownloaderImpl) getBodyByGui(rawUrl string) (bs []byte, err error) {
 	err = sharedmemory.WriteURLToSharedMemory(rawUrl)
 	if err != nil {
-		fmt.Println("Failed to write to shared memory:", err)
+		d.res.Log().Error("写入共享内存失败", "error", err)
 		return
 	}
 	for i := 0; i < 300; i++ {
@@ -105,7 +96,7 @@ func (d *DownloaderImpl) getBodyByGui(rawUrl string) (bs []byte, err error) {
 func (d *DownloaderImpl) imageDownloader(imgUrl, targetFilePath string) (ok bool, err error) {
 	err = sharedmemory.WriteURLImagePathToSharedMemory(imgUrl, targetFilePath)
 	if err != nil {
-		fmt.Println("Failed to write to shared memory:", err)
+		d.res.Log().Error("写入共享内存失败", "error", err)
 		return
 	}
 	for i := 0; i < 300; i++ {
@@ -124,12 +115,12 @@ func (d *DownloaderImpl) getBody(rawUrl string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", config.Conf.UserAgent)
-	cookies, _ := chttp.ReadCookiesFromFile(config.Conf.CookieFile)
+	req.Header.Set("User-Agent", d.opts.UserAgent)
+	cookies, _ := chttp.ReadCookiesFromFile(d.opts.CookieFile)
 	if cookies != "" {
 		req.Header.Set("Cookie", cookies)
 	}
-	headers, err := chttp.ReadHeadersFromFile(config.Conf.HeaderFile)
+	headers, err := chttp.ReadHeadersFromFile(d.opts.HeaderFile)
 	if err == nil {
 		for key, value := range headers {
 			req.Header.Set(key, value)
@@ -141,7 +132,7 @@ func (d *DownloaderImpl) getBody(rawUrl string) ([]byte, error) {
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			log.Printf("close body err=%v", err)
+			d.res.Log().Warn("关闭响应失败", "error", err)
 		}
 	}()
 
@@ -180,7 +171,7 @@ func (d *DownloaderImpl) postBody(rawUrl string, postData interface{}) ([]byte,
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 	// 设置请求头
-	req.Header.Set("User-Agent", config.Conf.UserAgent)
+	req.Header.Set("User-Agent", d.opts.UserAgent)
 	if isJSON {
 		req.Header.Set("Content-Type", "application/json")
 	} else {
@@ -189,12 +180,12 @@ func (d *DownloaderImpl) postBody(rawUrl string, postData interface{}) ([]byte,
 	req.Header.Set("Origin", "https://"+d.parsedUrl.Host)
 	req.Header.Set("Referer", d.rawUrl)
 
-	cookies, _ := chttp.ReadCookiesFromFile(config.Conf.CookieFile)
+	cookies, _ := chttp.ReadCookiesFromFile(d.opts.CookieFile)
 	if cookies != "" {
 		req.Header.Set("Cookie", cookies)
 	}
 
-	headers, err := chttp.ReadHeadersFromFile(config.Conf.HeaderFile)
+	headers, err := chttp.ReadHeadersFromFile(d.opts.HeaderFile)
 	if err == nil {
 		for key, value := range headers {
 			req.Header.Set(key, value)
@@ -206,7 +197,7 @@ func (d *DownloaderImpl) postBody(rawUrl string, postData interface{}) ([]byte,
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			log.Printf("close body err=%v", err)
+			d.res.Log().Warn("关闭响应失败", "error", err)
 		}
 	}()
 
@@ -5,6 +5,8 @@ import (
 	"bookget/model/tianyige"
 	"bookget/pkg/gohttp"
 	xhash "bookget/pkg/hash"
+	"bookget/pkg/logger"
+	"bookget/pkg/retry"
 	"bookget/pkg/util"
 	"bytes"
 	"context"
@@ -18,7 +20,6 @@ import (
 	"golang.org/x/text/encoding/simplifiedchinese"
 	"golang.org/x/text/transform"
 	"io"
-	"log"
 	"math/rand"
 	"net/http/cookiejar"
 	"net/url"
@@ -42,28 +43,46 @@ type Tianyige struct {
 		authorization  string
 		authorizationu string
 	}
+
+	ctx  context.Context
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:           "tianyige",
+		Name:         "[中国]天一阁博物院古籍数字化平台",
+		Country:      "CN",
+		Hosts:        []string{"gj.tianyige.com.cn"},
+		Capabilities: CapCatalog | CapOCR | CapCookie,
+		Examples:     []string{"https://gj.tianyige.com.cn/#/searchpage/{id}"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewTianyige(ctx, opts) },
+	})
 }
 
-func NewTianyige() *Tianyige {
+func NewTianyige(ctx context.Context, opts *config.Input) *Tianyige {
 	return &Tianyige{
 		// 初始化字段
+		ctx:   ctx,
+		opts:  opts,
 		dt:    new(DownloadTask),
 		index: 0,
 	}
 }
 
-func (r *Tianyige) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Tianyige) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (r *Tianyige) Run(sUrl string) (msg string, err error) {
 	r.dt.UrlParsed, err = url.Parse(sUrl)
 	r.dt.Url = sUrl
 	r.dt.BookId = r.getBookId(r.dt.Url)
+	r.res.SetBook(r.dt.BookId, "")
 	if r.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -87,16 +106,17 @@ func (r *Tianyige) getBookId(sUrl string) (bookId string) {
 
 func (r *Tianyige) download() (msg string, err error) {
 	respVolume, err := r.getVolumes(r.dt.BookId, r.dt.Jar)
+	r.res.SetVolumes(len(respVolume))
 	if err != nil {
-		fmt.Println(err)
+		r.res.Log().Error("获取册列表失败", "error", err)
 		return "getVolumes", err
 	}
 	canvases, err := r.getCanvases(r.dt.BookId, r.dt.Jar)
 	if err != nil {
-		log.Println(err)
+		r.res.Log().Error("获取册列表失败", "error", err)
 		return
 	}
-	log.Printf(" %d volumes,  %d pages.\n", len(respVolume), len(canvases))
+	r.res.Log().Info("解析完成", "volumes", len(respVolume), "pages", len(canvases))
 	parts := make(tianyige.Parts)
 	for _, record := range canvases {
 		parts[record.FascicleId] = append(parts[record.FascicleId], record)
@@ -104,13 +124,13 @@ func (r *Tianyige) download() (msg string, err error) {
 	var bookmark = config.CatalogVersionInfo + "\r\n"
 	sizeVol := len(respVolume)
 	for i, vol := range respVolume {
-		if !config.VolumeRange(i) {
+		if !r.opts.VolumeRange(i) {
 			continue
 		}
 		vid := fmt.Sprintf("%04d", i+1)
-		r.dt.SavePath = CreateDirectory(vid)
+		r.dt.SavePath = CreateDirectory(r.opts, vid)
 		sizePage := len(parts[vol.FascicleId])
-		log.Printf(" %d/%d volume, %d pages \n", i+1, sizeVol, sizePage)
+		r.res.Log().Info("下载分册", logger.KeyVolume, i+1, "volumes", sizeVol, "pages", sizePage)
 		text, err := r.getCatalogById(vol.CatalogId, vol.FascicleId, r.index)
 		if err == nil {
 			bookmark += text
@@ -118,10 +138,10 @@ func (r *Tianyige) download() (msg string, err error) {
 		r.do(parts[vol.FascicleId])
 	}
 
-	savePath := config.Conf.Directory
+	savePath := r.opts.Directory
 	data, _ := io.ReadAll(transform.NewReader(bytes.NewReader([]byte(bookmark)), simplifiedchinese.GBK.NewEncoder()))
-	_ = os.WriteFile(path.Join(savePath, "catalog.txt"), []byte(bookmark), os.ModePerm)
-	_ = os.WriteFile(path.Join(savePath, "catalog-gbk.txt"), []byte(data), os.ModePerm)
+	_ = writeFile(r.opts, path.Join(savePath, "catalog.txt"), []byte(bookmark), os.ModePerm)
+	_ = writeFile(r.opts, path.Join(savePath, "catalog-gbk.txt"), []byte(data), os.ModePerm)
 	return msg, err
 }
 
@@ -136,38 +156,40 @@ func (r *Tianyige) do(records []tianyige.ImageRecord) (msg string, err error) {
 	i := 0
 	for _, record := range records {
 		uri, _, err := r.getImageById(record.ImageId)
-		if err != nil || uri == "" || !config.PageRange(i, size) {
+		if err != nil || uri == "" || !r.opts.PageRange(i, size) {
 			continue
 		}
 		i++
 		r.index++
 		sortId := fmt.Sprintf("%04d", i)
-		filename := sortId + config.Conf.FileExt
+		filename := sortId + r.opts.FileExt
 		dest := path.Join(r.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !r.res.Pending(uri, dest) {
 			continue
 		}
-		log.Printf("Get %d/%d  %s\n", i, size, uri)
+		r.res.Log().Info("下载页面", logger.KeyPage, i, "total", size, logger.KeyUrl, uri)
 		//下载时有验证码
-		ctx := context.Background()
+		ctx := r.ctx
 		opts := gohttp.Options{
 			DestFile:    dest,
 			Overwrite:   false,
 			Concurrency: 1,
-			CookieFile:  config.Conf.CookieFile,
-			HeaderFile:  config.Conf.HeaderFile,
+			CookieFile:  r.opts.CookieFile,
+			HeaderFile:  r.opts.HeaderFile,
 			CookieJar:   r.dt.Jar,
 			Headers: map[string]interface{}{
-				"User-Agent": config.Conf.UserAgent,
+				"User-Agent": r.opts.UserAgent,
 			},
 		}
-		for k := 0; k < 10; k++ {
-			_, err = gohttp.FastGet(ctx, uri, opts)
-			if err == nil && FileExist(dest) {
-				break
+		// 下载失败或没有写入文件时等待新的 cookie 后重试
+		err = retryWithNewCookie(ctx, r.opts, uri, func() error {
+			_, err := gohttp.FastGet(ctx, uri, opts)
+			if err == nil && !FileExist(dest) {
+				err = errors.New("没有写入文件")
 			}
-			WaitNewCookieWithMsg(uri)
-		}
+			return err
+		})
+		r.res.Record(sortId, uri, dest, err)
 
 		bs, _ := os.ReadFile(dest)
 		mh := xhash.NewMultiHasher()
@@ -180,7 +202,7 @@ func (r *Tianyige) do(records []tianyige.ImageRecord) (msg string, err error) {
 		} else {
 			idDict[kId] = uri
 		}
-		util.PrintSleepTime(config.Conf.Sleep)
+		util.PrintSleepTime(r.opts.Sleep)
 		fmt.Println()
 	}
 	wg.Wait()
@@ -228,18 +250,19 @@ func (r *Tianyige) getCanvases(bookId string, jar *cookiejar.Jar) (canvases []ti
 func (r *Tianyige) getImageById(imageId string) (imgUrl, ocrUrl string, err error) {
 	apiUrl := fmt.Sprintf("https://%s/g/sw-anb/api/queryOcrFileByimageId?imageId=%s", r.dt.UrlParsed.Host, imageId)
 	var bs []byte
-	for i := 0; i < 3; i++ {
+	err = retry.New(r.opts.Retries).Do(r.ctx, func(int) (err error) {
 		bs, err = r.getBody(apiUrl, r.dt.Jar)
-		if bs != nil {
-			break
+		if err == nil && bs == nil {
+			err = errors.New("响应为空")
 		}
-	}
+		return err
+	})
 	if err != nil {
 		return
 	}
 	var resObj tianyige.ResponseFile
 	if err = json.Unmarshal(bs, &resObj); err != nil {
-		fmt.Println(err)
+		r.res.Log().Error("请求失败", "error", err)
 		return
 	}
 
@@ -261,7 +284,7 @@ func (r *Tianyige) getCatalogById(catalogId, fascicleId string, indexStart int)
 	}
 	var resp tianyige.Catalog
 	if err = json.Unmarshal(bs, &resp); err != nil {
-		fmt.Println(err)
+		r.res.Log().Error("请求失败", "error", err)
 		return "", err
 	}
 	var bookmark string
@@ -281,13 +304,13 @@ func (r *Tianyige) getCatalogById(catalogId, fascicleId string, indexStart int)
 }
 
 func (r *Tianyige) getBody(sUrl string, jar *cookiejar.Jar) ([]byte, error) {
-	ctx := context.Background()
+	ctx := r.ctx
 	token := r.getToken()
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent":     config.Conf.UserAgent,
+			"User-Agent":     r.opts.UserAgent,
 			"Content-Type":   "application/json;charset=UTF-8",
 			"token":          token,
 			"appId":          TIANYIGE_ID,
@@ -301,8 +324,8 @@ func (r *Tianyige) getBody(sUrl string, jar *cookiejar.Jar) ([]byte, error) {
 	}
 	bs, _ := resp.GetBody()
 	if bs == nil || resp.GetStatusCode() != 200 {
-		msg := fmt.Sprintf("Please try again later.[%d %s]\n", resp.GetStatusCode(), resp.GetReasonPhrase())
-		fmt.Println(msg)
+		msg := fmt.Sprintf("Please try again later.[%d %s]", resp.GetStatusCode(), resp.GetReasonPhrase())
+		r.res.Log().Warn("请求失败", logger.KeyUrl, sUrl, "status", resp.GetStatusCode())
 		return nil, errors.New(msg)
 	}
 	return bs, err
@@ -310,12 +333,12 @@ func (r *Tianyige) getBody(sUrl string, jar *cookiejar.Jar) ([]byte, error) {
 
 func (r *Tianyige) postBody(sUrl string, d []byte, jar *cookiejar.Jar) ([]byte, error) {
 	token := r.getToken()
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent":     config.Conf.UserAgent,
+			"User-Agent":     r.opts.UserAgent,
 			"Content-Type":   "application/json;charset=UTF-8",
 			"token":          token,
 			"appId":          TIANYIGE_ID,
@@ -330,8 +353,8 @@ func (r *Tianyige) postBody(sUrl string, d []byte, jar *cookiejar.Jar) ([]byte,
 	}
 	bs, _ := resp.GetBody()
 	if bs == nil || resp.GetStatusCode() != 200 {
-		msg := fmt.Sprintf("Please try again later.[%d %s]\n", resp.GetStatusCode(), resp.GetReasonPhrase())
-		fmt.Println(msg)
+		msg := fmt.Sprintf("Please try again later.[%d %s]", resp.GetStatusCode(), resp.GetReasonPhrase())
+		r.res.Log().Warn("请求失败", logger.KeyUrl, sUrl, "status", resp.GetStatusCode())
 		return nil, errors.New(msg)
 	}
 	return bs, err
@@ -366,7 +389,7 @@ func (r *Tianyige) getToken() string {
 // const authorization = localStorage.getItem('authorization');
 // const authorizationu = localStorage.getItem('authorizationu');
 //func (r *Tianyige) getLocalStorage() (string, string, error) {
-//	bs, err := os.ReadFile(config.Conf.LocalStorage)
+//	bs, err := os.ReadFile(r.opts.LocalStorage)
 //	if bs == nil || err != nil {
 //		return "", "", err
 //	}
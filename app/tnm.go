@@ -4,11 +4,11 @@ import (
 	"bookget/config"
 	"bookget/pkg/downloader"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"path"
@@ -18,22 +18,37 @@ import (
 type Tnm struct {
 	dt  *DownloadTask
 	ctx context.Context
+
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:           "tnm",
+		Name:         "[日本]东京国立博物馆",
+		Country:      "JP",
+		Hosts:        []string{"webarchives.tnm.jp"},
+		Capabilities: CapDZI,
+		Examples:     []string{"https://webarchives.tnm.jp/dlib/detail/{id}"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewTnm(ctx, opts) },
+	})
 }
 
-func NewTnm() *Tnm {
+func NewTnm(ctx context.Context, opts *config.Input) *Tnm {
 	return &Tnm{
 		// 初始化字段
-		dt:  new(DownloadTask),
-		ctx: context.Background(),
+		opts: opts,
+		dt:   new(DownloadTask),
+		ctx:  ctx,
 	}
 }
 
-func (r *Tnm) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Tnm) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (r *Tnm) Run(sUrl string) (msg string, err error) {
@@ -42,6 +57,7 @@ func (r *Tnm) Run(sUrl string) (msg string, err error) {
 	r.dt.Url = sUrl
 
 	r.dt.BookId = r.getBookId(r.dt.Url)
+	r.res.SetBook(r.dt.BookId, "")
 	if r.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -58,16 +74,16 @@ func (r *Tnm) getBookId(sUrl string) (bookId string) {
 }
 
 func (r *Tnm) download() (msg string, err error) {
-	log.Printf("Get %s\n", r.dt.Url)
+	r.res.Log().Info("获取图书信息", logger.KeyUrl, r.dt.Url)
 
-	r.dt.SavePath = config.Conf.Directory
+	r.dt.SavePath = r.opts.Directory
 	apiUrl := fmt.Sprintf("%s://%s/dlib/pages/%s", r.dt.UrlParsed.Scheme, r.dt.UrlParsed.Host, r.dt.BookId)
 	canvases, err := r.getCanvases(apiUrl, r.dt.Jar)
 	if err != nil {
-		fmt.Println(err.Error())
+		r.res.Log().Error("获取页面列表失败", "error", err)
 		return
 	}
-	log.Printf(" %d pages \n", len(canvases))
+	r.res.Log().Info("解析完成", "pages", len(canvases))
 	return r.do(canvases)
 }
 
@@ -82,19 +98,20 @@ func (r *Tnm) do(dziUrls []string) (msg string, err error) {
 		"-H", "Referer:" + referer,
 	}
 	size := len(dziUrls)
-	iiifDownloader := downloader.NewIIIFDownloader(&config.Conf)
+	iiifDownloader := downloader.NewIIIFDownloader(r.opts)
 	for i, uri := range dziUrls {
-		if uri == "" || !config.PageRange(i, size) {
+		if uri == "" || !r.opts.PageRange(i, size) {
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
-		filename := sortId + config.Conf.FileExt
+		filename := sortId + r.opts.FileExt
 		dest := path.Join(r.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !r.res.Pending(uri, dest) {
 			continue
 		}
-		log.Printf("Get %s  %s\n", sortId, uri)
-		iiifDownloader.Dezoomify(r.ctx, uri, dest, args)
+		r.res.Log().Info("下载页面", logger.KeyPage, sortId, logger.KeyUrl, uri)
+		err := iiifDownloader.Dezoomify(r.ctx, uri, dest, args)
+		r.res.Record(sortId, uri, dest, err)
 	}
 	return "", err
 }
@@ -125,12 +142,12 @@ func (r *Tnm) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []string, e
 
 func (r *Tnm) getBody(apiUrl string, jar *cookiejar.Jar) ([]byte, error) {
 	referer := url.QueryEscape(apiUrl)
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": r.opts.UserAgent,
 			"Referer":    referer,
 		},
 	})
@@ -3,11 +3,11 @@ package app
 import (
 	"bookget/config"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
 	"bookget/pkg/util"
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"path/filepath"
@@ -16,27 +16,44 @@ import (
 
 type Utokyo struct {
 	dt *DownloadTask
+
+	ctx  context.Context
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:       "utokyo",
+		Name:     "[日本]东京大学东洋文化研究所（汉籍善本资料库）",
+		Country:  "JP",
+		Hosts:    []string{"shanben.ioc.u-tokyo.ac.jp"},
+		Examples: []string{"http://shanben.ioc.u-tokyo.ac.jp/main_p.php?nu={id}"},
+		New:      func(ctx context.Context, opts *config.Input) RouterInit { return NewUtokyo(ctx, opts) },
+	})
 }
 
-func NewUtokyo() *Utokyo {
+func NewUtokyo(ctx context.Context, opts *config.Input) *Utokyo {
 	return &Utokyo{
 		// 初始化字段
-		dt: new(DownloadTask),
+		ctx:  ctx,
+		opts: opts,
+		dt:   new(DownloadTask),
 	}
 }
 
-func (r *Utokyo) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Utokyo) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (p *Utokyo) Run(sUrl string) (msg string, err error) {
 	p.dt.UrlParsed, err = url.Parse(sUrl)
 	p.dt.Url = sUrl
 	p.dt.BookId = p.getBookId(p.dt.Url)
+	p.res.SetBook(p.dt.BookId, "")
 	if p.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -52,44 +69,46 @@ func (p *Utokyo) getBookId(sUrl string) (bookId string) {
 }
 
 func (p *Utokyo) download() (msg string, err error) {
-	log.Printf("Get %s\n", p.dt.Url)
+	p.res.Log().Info("获取图书信息", logger.KeyUrl, p.dt.Url)
 	respVolume, err := p.getVolumes(p.dt.Url, p.dt.Jar)
+	p.res.SetVolumes(len(respVolume))
 	if err != nil {
-		fmt.Println(err)
+		p.res.Log().Error("获取册列表失败", "error", err)
 		return "getVolumes", err
 	}
-	p.dt.SavePath = config.Conf.Directory
+	p.dt.SavePath = p.opts.Directory
 	for i, vol := range respVolume {
-		if !config.VolumeRange(i) {
+		if !p.opts.VolumeRange(i) {
 			continue
 		}
-		log.Printf(" %d/%d volume, %s \n", i+1, len(respVolume), vol)
+		p.res.Log().Info("下载分册", logger.KeyVolume, i+1, "volumes", len(respVolume), logger.KeyUrl, vol)
 		fName := util.FileName(vol)
 		sortId := fmt.Sprintf("%04d", i+1)
 		dest := filepath.Join(p.dt.SavePath, sortId+fName)
 		p.do(dest, vol)
-		util.PrintSleepTime(config.Conf.Sleep)
+		util.PrintSleepTime(p.opts.Sleep)
 	}
 	return msg, err
 }
 
 func (p *Utokyo) do(dest, pdfUrl string) (msg string, err error) {
-	ctx := context.Background()
+	if !p.res.Pending(pdfUrl, dest) {
+		return "", nil
+	}
+	ctx := p.ctx
 	opts := gohttp.Options{
 		DestFile:    dest,
 		Overwrite:   false,
-		Concurrency: config.Conf.Threads,
-		CookieFile:  config.Conf.CookieFile,
-		HeaderFile:  config.Conf.HeaderFile,
+		Concurrency: p.opts.Threads,
+		CookieFile:  p.opts.CookieFile,
+		HeaderFile:  p.opts.HeaderFile,
 		CookieJar:   p.dt.Jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": p.opts.UserAgent,
 		},
 	}
-	resp, err := gohttp.FastGet(ctx, pdfUrl, opts)
-	if err != nil || resp.GetStatusCode() != 200 {
-		fmt.Println(err)
-	}
+	_, err = gohttp.FastGet(ctx, pdfUrl, opts)
+	p.res.Record(filepath.Base(dest), pdfUrl, dest, err)
 	return "", err
 }
 
@@ -113,12 +132,12 @@ func (p *Utokyo) getVolumes(sUrl string, jar *cookiejar.Jar) (volumes []string,
 
 func (p *Utokyo) getBody(sUrl string, jar *cookiejar.Jar) ([]byte, error) {
 	referer := url.QueryEscape(sUrl)
-	ctx := context.Background()
+	ctx := p.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: p.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": p.opts.UserAgent,
 			"Referer":    referer,
 		},
 	})
@@ -5,11 +5,11 @@ import (
 	"bookget/model/war"
 	"bookget/pkg/downloader"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"os"
@@ -23,22 +23,37 @@ type War1931 struct {
 	fileCode        string
 	jsonUrlTemplate string
 	ctx             context.Context
+
+	opts *config.Input
+	res  *Result
 }
 
-func NewWar1931() *War1931 {
+func init() {
+	Register(Site{
+		ID:           "war1931",
+		Name:         "抗日战争与中日关系文献数据平台",
+		Country:      "CN",
+		Hosts:        []string{"modernhistory.org.cn"},
+		Capabilities: CapIIIF,
+		Examples:     []string{"https://www.modernhistory.org.cn/#/DocumentDetails_DA?fileCode={id}"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewWar1931(ctx, opts) },
+	})
+}
+
+func NewWar1931(ctx context.Context, opts *config.Input) *War1931 {
 	return &War1931{
 		// 初始化字段
-		dt:  new(DownloadTask),
-		ctx: context.Background(),
+		opts: opts,
+		dt:   new(DownloadTask),
+		ctx:  ctx,
 	}
 }
 
-func (r *War1931) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *War1931) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (r *War1931) Run(sUrl string) (msg string, err error) {
@@ -46,6 +61,7 @@ func (r *War1931) Run(sUrl string) (msg string, err error) {
 	r.dt.Url = sUrl
 	r.dt.Jar, _ = cookiejar.New(nil)
 	r.dt.BookId = r.getBookId(r.dt.Url)
+	r.res.SetBook(r.dt.BookId, "")
 	if r.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -73,33 +89,34 @@ func (r *War1931) mkdirAll(directory, vid string) (dirPath string) {
 		break
 	default:
 	}
-	r.dt.SavePath = config.Conf.Directory + string(os.PathSeparator) + r.dt.VolumeId
-	_ = os.MkdirAll(r.dt.SavePath, os.ModePerm)
+	r.dt.SavePath = r.opts.Directory + string(os.PathSeparator) + r.dt.VolumeId
+	_ = mkdirAll(r.opts, r.dt.SavePath)
 	return r.dt.SavePath
 }
 
 func (r *War1931) download() (msg string, err error) {
-	log.Printf("Get %s\n", r.dt.Url)
+	r.res.Log().Info("获取图书信息", logger.KeyUrl, r.dt.Url)
 	apiUrl := "https://" + r.dt.UrlParsed.Host + "/backend-prod/esBook/findDetailsInfo/" + r.dt.BookId
 	partialVolumes, err := r.getVolumes(apiUrl, r.dt.Jar)
+	r.res.SetVolumes(len(partialVolumes))
 	if err != nil {
-		fmt.Println(err)
+		r.res.Log().Error("获取册列表失败", "error", err)
 		return "getVolumes", err
 	}
 	for k, parts := range partialVolumes {
-		if !config.VolumeRange(k) {
+		if !r.opts.VolumeRange(k) {
 			continue
 		}
-		log.Printf(" %d/%d, %d volumes \n", k+1, len(partialVolumes), len(parts.Volumes))
+		r.res.Log().Info("下载分部", "part", k+1, "parts", len(partialVolumes), "volumes", len(parts.Volumes))
 		for i, vol := range parts.Volumes {
 			vid := fmt.Sprintf("%04d", i+1)
 			r.mkdirAll(parts.Directory, vid)
 			canvases, err := r.getCanvases(vol, r.dt.Jar)
 			if err != nil || canvases == nil {
-				fmt.Println(err)
+				r.res.Log().Error("获取页面列表失败", "error", err)
 				continue
 			}
-			log.Printf(" %d/%d volume, %d pages \n", i+1, len(parts.Volumes), len(canvases))
+			r.res.Log().Info("下载分册", logger.KeyVolume, i+1, "volumes", len(parts.Volumes), "pages", len(canvases))
 			r.do(canvases)
 		}
 	}
@@ -117,21 +134,20 @@ func (r *War1931) do(canvases []string) (msg string, err error) {
 		"-H", "Referer:" + referer,
 	}
 	size := len(canvases)
-	iiifDownloader := downloader.NewIIIFDownloader(&config.Conf)
+	iiifDownloader := downloader.NewIIIFDownloader(r.opts)
 	for i, uri := range canvases {
-		if uri == "" || !config.PageRange(i, size) {
+		if uri == "" || !r.opts.PageRange(i, size) {
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
-		filename := sortId + config.Conf.FileExt
+		filename := sortId + r.opts.FileExt
 		dest := filepath.Join(r.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !r.res.Pending(uri, dest) {
 			continue
 		}
-		log.Printf("Get %s  %s\n", sortId, uri)
-		if err := iiifDownloader.Dezoomify(r.ctx, uri, dest, args); err != nil {
-			log.Printf("Dezoomify %s %s\n", err, uri)
-		}
+		r.res.Log().Info("下载页面", logger.KeyPage, sortId, logger.KeyUrl, uri)
+		err := iiifDownloader.Dezoomify(r.ctx, uri, dest, args)
+		r.res.Record(sortId, uri, dest, err)
 	}
 	return "", err
 }
@@ -209,7 +225,7 @@ func (r *War1931) getVolumesForBz(sUrl string, jar *cookiejar.Jar) (volumes []wa
 			}
 			var resp = new(war.FindDirectoryByMonth)
 			if err := json.Unmarshal(bs, resp); err != nil {
-				log.Printf("json.Unmarshal failed: %s\n", err)
+				r.res.Log().Error("解析 JSON 失败", "error", err)
 				break
 			}
 			for _, item := range resp.Result {
@@ -239,7 +255,7 @@ func (r *War1931) findBzYear(fileCode string) (years []string, err error) {
 	}
 	var resp = new(Response)
 	if err = json.Unmarshal(bs, resp); err != nil {
-		log.Printf("json.Unmarshal failed: %s\n", err)
+		r.res.Log().Error("解析 JSON 失败", "error", err)
 		return
 	}
 	return resp.Result, err
@@ -258,7 +274,7 @@ func (r *War1931) findBzMonth(year string) (years []string, err error) {
 	}
 	var resp = new(Response)
 	if err = json.Unmarshal(bs, resp); err != nil {
-		log.Printf("json.Unmarshal failed: %s\n", err)
+		r.res.Log().Error("解析 JSON 失败", "error", err)
 		return
 	}
 	return resp.Result, err
@@ -272,7 +288,7 @@ func (r *War1931) getVolumesForQk(sUrl string, jar *cookiejar.Jar) (volumes []wa
 	}
 	var resp = new(war.Qk)
 	if err = json.Unmarshal(bs, resp); err != nil {
-		log.Printf("json.Unmarshal failed: %s\n", err)
+		r.res.Log().Error("解析 JSON 失败", "error", err)
 		return
 	}
 	for _, items := range resp.Result {
@@ -300,7 +316,7 @@ func (r *War1931) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []strin
 	}
 	var manifest = new(war.Manifest)
 	if err = json.Unmarshal(bs, manifest); err != nil {
-		log.Printf("json.Unmarshal failed: %s\n", err)
+		r.res.Log().Error("解析 JSON 失败", "error", err)
 		return
 	}
 	if len(manifest.Sequences) == 0 {
@@ -318,12 +334,12 @@ func (r *War1931) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []strin
 }
 
 func (r *War1931) getBody(sUrl string, jar *cookiejar.Jar) ([]byte, error) {
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent":      config.Conf.UserAgent,
+			"User-Agent":      r.opts.UserAgent,
 			"Accept-Language": "zh-CN,zh;q=0.8,zh-TW;q=0.7,zh-HK;q=0.5,en-US;q=0.3,en;q=0.2",
 			"Accept":          "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8",
 		},
@@ -3,11 +3,11 @@ package app
 import (
 	"bookget/config"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
 	"bookget/pkg/util"
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"path"
@@ -18,21 +18,38 @@ import (
 
 type Waseda struct {
 	dt *DownloadTask
+
+	ctx  context.Context
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:           "waseda",
+		Name:         "[日本]早稻田大学图书馆",
+		Country:      "JP",
+		Hosts:        []string{"archive.wul.waseda.ac.jp"},
+		Capabilities: CapPDF,
+		Examples:     []string{"https://archive.wul.waseda.ac.jp/kosho/{collection}/{id}/"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewWaseda(ctx, opts) },
+	})
 }
 
-func NewWaseda() *Waseda {
+func NewWaseda(ctx context.Context, opts *config.Input) *Waseda {
 	return &Waseda{
 		// 初始化字段
-		dt: new(DownloadTask),
+		ctx:  ctx,
+		opts: opts,
+		dt:   new(DownloadTask),
 	}
 }
 
-func (r *Waseda) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Waseda) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 func (r Waseda) Run(sUrl string) (msg string, err error) {
 
@@ -40,46 +57,48 @@ func (r Waseda) Run(sUrl string) (msg string, err error) {
 	r.dt.Url = sUrl
 
 	r.dt.BookId = getBookId(r.dt.Url)
+	r.res.SetBook(r.dt.BookId, "")
 	r.dt.Jar, _ = cookiejar.New(nil)
 	return r.download()
 }
 
 func (r Waseda) download() (msg string, err error) {
 	respVolume, err := r.getVolumes(r.dt.Url, r.dt.Jar)
+	r.res.SetVolumes(len(respVolume))
 	if err != nil {
-		fmt.Println(err)
+		r.res.Log().Error("获取册列表失败", "error", err)
 		return "getVolumes", err
 	}
-	if config.Conf.FileExt == ".pdf" {
+	if r.opts.FileExt == ".pdf" {
 		for i, vol := range respVolume {
-			if !config.VolumeRange(i) {
+			if !r.opts.VolumeRange(i) {
 				continue
 			}
 			sortId := fmt.Sprintf("%04d", i+1)
-			r.dt.SavePath = config.Conf.Directory
-			log.Printf(" %d/%d volume, URL:%s \n", i+1, len(respVolume), vol)
-			filename := sortId + config.Conf.FileExt
+			r.dt.SavePath = r.opts.Directory
+			r.res.Log().Info("下载分册", logger.KeyVolume, i+1, "volumes", len(respVolume), logger.KeyUrl, vol)
+			filename := sortId + r.opts.FileExt
 			dest := path.Join(r.dt.SavePath, filename)
 			r.doDownload(vol, dest)
 		}
 	} else {
 		for i, vol := range respVolume {
-			if !config.VolumeRange(i) {
+			if !r.opts.VolumeRange(i) {
 				continue
 			}
 			if len(respVolume) == 1 {
-				r.dt.SavePath = config.Conf.Directory
+				r.dt.SavePath = r.opts.Directory
 			} else {
 				vid := fmt.Sprintf("%04d", i+1)
-				r.dt.SavePath = CreateDirectory(vid)
+				r.dt.SavePath = CreateDirectory(r.opts, vid)
 			}
 			canvases, err := r.getCanvases(vol, r.dt.Jar)
 			if err != nil || canvases == nil {
-				fmt.Println(err)
+				r.res.Log().Error("获取页面列表失败", "error", err)
 				continue
 			}
 
-			log.Printf(" %d/%d volume, %d pages \n", i+1, len(respVolume), len(canvases))
+			r.res.Log().Info("下载分册", logger.KeyVolume, i+1, "volumes", len(respVolume), "pages", len(canvases))
 			r.do(canvases)
 		}
 	}
@@ -94,35 +113,36 @@ func (r Waseda) do(imgUrls []string) (msg string, err error) {
 	fmt.Println()
 	size := len(imgUrls)
 	var wg sync.WaitGroup
-	q := QueueNew(int(config.Conf.Threads))
+	q := QueueNew(int(r.opts.Threads))
 	for i, uri := range imgUrls {
-		if uri == "" || !config.PageRange(i, size) {
+		if uri == "" || !r.opts.PageRange(i, size) {
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
-		filename := sortId + config.Conf.FileExt
+		filename := sortId + r.opts.FileExt
 		dest := path.Join(r.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !r.res.Pending(uri, dest) {
 			continue
 		}
-		log.Printf("Get %d/%d page, URL: %s\n", i+1, size, uri)
+		r.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", size, logger.KeyUrl, uri)
 		imgUrl := uri
 		wg.Add(1)
 		q.Go(func() {
 			defer wg.Done()
-			ctx := context.Background()
+			ctx := r.ctx
 			opts := gohttp.Options{
 				DestFile:    dest,
 				Overwrite:   false,
 				Concurrency: 1,
-				CookieFile:  config.Conf.CookieFile,
-				HeaderFile:  config.Conf.HeaderFile,
+				CookieFile:  r.opts.CookieFile,
+				HeaderFile:  r.opts.HeaderFile,
 				CookieJar:   r.dt.Jar,
 				Headers: map[string]interface{}{
-					"User-Agent": config.Conf.UserAgent,
+					"User-Agent": r.opts.UserAgent,
 				},
 			}
-			gohttp.FastGet(ctx, imgUrl, opts)
+			_, err := gohttp.FastGet(ctx, imgUrl, opts)
+			r.res.Record(sortId, imgUrl, dest, err)
 			fmt.Println()
 		})
 	}
@@ -150,7 +170,7 @@ func (r Waseda) getVolumes(sUrl string, jar *cookiejar.Jar) (volumes []string, e
 	volumes = make([]string, 0, len(ids))
 	for _, v := range ids {
 		var htmlUrl string
-		if config.Conf.FileExt == ".pdf" {
+		if r.opts.FileExt == ".pdf" {
 			htmlUrl = sUrl + v + ".pdf"
 		} else {
 			htmlUrl = sUrl + v + ".html"
@@ -187,12 +207,12 @@ func (r Waseda) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []string,
 
 func (r Waseda) getBody(apiUrl string, jar *cookiejar.Jar) ([]byte, error) {
 	referer := url.QueryEscape(apiUrl)
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": r.opts.UserAgent,
 			"Referer":    referer,
 		},
 	})
@@ -208,29 +228,30 @@ func (r Waseda) getBody(apiUrl string, jar *cookiejar.Jar) ([]byte, error) {
 }
 
 func (r Waseda) doDownload(dUrl, dest string) bool {
-	if FileExist(dest) {
+	if !r.res.Pending(dUrl, dest) {
 		return false
 	}
 	referer := url.QueryEscape(r.dt.Url)
 	opts := gohttp.Options{
 		DestFile:    dest,
 		Overwrite:   false,
-		Concurrency: config.Conf.Threads,
-		CookieFile:  config.Conf.CookieFile,
-		HeaderFile:  config.Conf.HeaderFile,
+		Concurrency: r.opts.Threads,
+		CookieFile:  r.opts.CookieFile,
+		HeaderFile:  r.opts.HeaderFile,
 		CookieJar:   r.dt.Jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": r.opts.UserAgent,
 			"Referer":    referer,
 		},
 	}
-	ctx := context.Background()
+	ctx := r.ctx
 	_, err := gohttp.FastGet(ctx, dUrl, opts)
+	r.res.Record(path.Base(dest), dUrl, dest, err)
 	if err == nil {
 		fmt.Println()
 		return true
 	}
-	fmt.Println(err)
+	r.res.Log().Error("请求失败", "error", err)
 	fmt.Println()
 	return false
 }
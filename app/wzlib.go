@@ -4,10 +4,10 @@ import (
 	"bookget/config"
 	"bookget/model/wzlib"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"path"
@@ -16,27 +16,45 @@ import (
 
 type Wzlib struct {
 	dt *DownloadTask
+
+	ctx  context.Context
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:           "wzlib",
+		Name:         "[中国]温州市图书馆",
+		Country:      "CN",
+		Hosts:        []string{"oyjy.wzlib.cn", "db.wzlib.cn"},
+		Capabilities: CapPDF,
+		Examples:     []string{"https://oyjy.wzlib.cn/detail/?id={id}"},
+		New:          func(ctx context.Context, opts *config.Input) RouterInit { return NewWzlib(ctx, opts) },
+	})
 }
 
-func NewWzlib() *Wzlib {
+func NewWzlib(ctx context.Context, opts *config.Input) *Wzlib {
 	return &Wzlib{
 		// 初始化字段
-		dt: new(DownloadTask),
+		ctx:  ctx,
+		opts: opts,
+		dt:   new(DownloadTask),
 	}
 }
 
-func (r *Wzlib) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Wzlib) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (p *Wzlib) Run(sUrl string) (msg string, err error) {
 	p.dt.UrlParsed, err = url.Parse(sUrl)
 	p.dt.Url = sUrl
 	p.dt.BookId = p.getBookId(p.dt.Url)
+	p.res.SetBook(p.dt.BookId, "")
 	if p.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -54,21 +72,21 @@ func (p *Wzlib) getBookId(sUrl string) (bookId string) {
 }
 
 func (p *Wzlib) download() (msg string, err error) {
-	log.Printf("Get %s\n", p.dt.Url)
-	p.dt.SavePath = config.Conf.Directory
+	p.res.Log().Info("获取图书信息", logger.KeyUrl, p.dt.Url)
+	p.dt.SavePath = p.opts.Directory
 
 	//旧版：瓯越记忆
 	if p.dt.UrlParsed.Host == "oyjy.wzlib.cn" {
 		canvases, err := p.OyjyGetCanvases(p.dt.BookId)
 		if err != nil || canvases == nil {
-			fmt.Println(err)
+			p.res.Log().Error("获取页面列表失败", "error", err)
 		}
 		return p.do(canvases)
 	}
 	//新版温州图书馆
 	canvases, err := p.getCanvases(p.dt.Url, p.dt.Jar)
 	if err != nil || canvases == nil {
-		fmt.Println(err)
+		p.res.Log().Error("获取页面列表失败", "error", err)
 	}
 	return p.do(canvases)
 }
@@ -79,33 +97,33 @@ func (p *Wzlib) do(dUrls []string) (msg string, err error) {
 	}
 	fmt.Println()
 	size := len(dUrls)
-	log.Printf(" %d PDFs.\n", size)
-	ctx := context.Background()
+	p.res.Log().Info("解析完成", "pdfs", size)
+	ctx := p.ctx
 	for i, uri := range dUrls {
-		if !config.PageRange(i, size) {
+		if !p.opts.PageRange(i, size) {
 			continue
 		}
 		if uri == "" {
 			continue
 		}
-		log.Printf("Get %d/%d, URL: %s\n", i+1, size, uri)
+		p.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", size, logger.KeyUrl, uri)
 		sortId := fmt.Sprintf("%04d", i+1)
 		filename := sortId + ".pdf"
 		dest := path.Join(p.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !p.res.Pending(uri, dest) {
 			continue
 		}
 		opts := gohttp.Options{
 			DestFile:    dest,
 			Overwrite:   false,
-			Concurrency: config.Conf.Threads,
-			CookieFile:  config.Conf.CookieFile,
-			HeaderFile:  config.Conf.HeaderFile,
+			Concurrency: p.opts.Threads,
+			CookieFile:  p.opts.CookieFile,
+			HeaderFile:  p.opts.HeaderFile,
 			CookieJar:   p.dt.Jar,
 		}
 		_, err = gohttp.FastGet(ctx, uri, opts)
+		p.res.Record(sortId, uri, dest, err)
 		if err != nil {
-			fmt.Println(err)
 			continue
 		}
 		fmt.Println()
@@ -121,14 +139,14 @@ func (p *Wzlib) getVolumes(sUrl string, jar *cookiejar.Jar) (volumes []string, e
 
 func (p *Wzlib) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []string, err error) {
 	apiUrl := fmt.Sprintf("https://%s/search/juhe_detail/%s/true?Flag=s", p.dt.UrlParsed.Host, p.dt.BookId)
-	bs, err := getBody(apiUrl, jar)
+	bs, err := getBody(p.ctx, p.opts, apiUrl, jar)
 	if err != nil {
 		return
 	}
 
 	var resT = new(wzlib.Digital)
 	if err = json.Unmarshal(bs, &resT); err != nil {
-		log.Printf("json.Unmarshal failed: %s\n", err)
+		p.res.Log().Error("解析 JSON 失败", "error", err)
 		return
 	}
 	for _, ret := range resT.DigitalResourceData {
@@ -145,7 +163,7 @@ func (p *Wzlib) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []string,
 func (p *Wzlib) OyjyGetCanvases(bookId string) (canvases []string, err error) {
 	//一册
 	uri := fmt.Sprintf("https://oyjy.wzlib.cn/api/search/v1/resource/%s", bookId)
-	bs, err := getBody(uri, p.dt.Jar)
+	bs, err := getBody(p.ctx, p.opts, uri, p.dt.Jar)
 	if err == nil {
 		var result wzlib.ResultPdf
 		if err = json.Unmarshal(bs, &result); err == nil {
@@ -160,7 +178,7 @@ func (p *Wzlib) OyjyGetCanvases(bookId string) (canvases []string, err error) {
 
 	//多册
 	relatedUri := fmt.Sprintf("https://oyjy.wzlib.cn/api/search/v1/resource_related/%s", bookId)
-	bs, err = getBody(relatedUri, p.dt.Jar)
+	bs, err = getBody(p.ctx, p.opts, relatedUri, p.dt.Jar)
 	if err != nil {
 		return
 	}
@@ -4,11 +4,11 @@ import (
 	"bookget/config"
 	"bookget/model/yndfz"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
 	"bookget/pkg/util"
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"path"
@@ -17,21 +17,37 @@ import (
 
 type Yndfz struct {
 	dt *DownloadTask
+
+	ctx  context.Context
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:       "yndfz",
+		Name:     "[中国]云南数字方志馆",
+		Country:  "CN",
+		Hosts:    []string{"dfz.yn.gov.cn"},
+		Examples: []string{"http://dfz.yn.gov.cn/index.php?c=show&id={id}"},
+		New:      func(ctx context.Context, opts *config.Input) RouterInit { return NewYndfz(ctx, opts) },
+	})
 }
 
-func NewYndfz() *Yndfz {
+func NewYndfz(ctx context.Context, opts *config.Input) *Yndfz {
 	return &Yndfz{
 		// 初始化字段
-		dt: new(DownloadTask),
+		ctx:  ctx,
+		opts: opts,
+		dt:   new(DownloadTask),
 	}
 }
 
-func (r *Yndfz) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Yndfz) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (r *Yndfz) Run(sUrl string) (msg string, err error) {
@@ -40,6 +56,7 @@ func (r *Yndfz) Run(sUrl string) (msg string, err error) {
 	r.dt.Url = sUrl
 
 	r.dt.BookId = r.getBookId(r.dt.Url)
+	r.res.SetBook(r.dt.BookId, "")
 	if r.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -56,25 +73,26 @@ func (r *Yndfz) getBookId(sUrl string) (bookId string) {
 }
 
 func (r *Yndfz) download() (msg string, err error) {
-	log.Printf("Get %s\n", r.dt.Url)
+	r.res.Log().Info("获取图书信息", logger.KeyUrl, r.dt.Url)
 
 	respVolume, err := r.getVolumes(r.dt.Url, r.dt.Jar)
+	r.res.SetVolumes(len(respVolume))
 	if err != nil {
-		fmt.Println(err)
+		r.res.Log().Error("获取册列表失败", "error", err)
 		return "getVolumes", err
 	}
 	for i, vol := range respVolume {
-		if !config.VolumeRange(i) {
+		if !r.opts.VolumeRange(i) {
 			continue
 		}
 		vid := fmt.Sprintf("%04d", i+1)
-		r.dt.SavePath = CreateDirectory(vid)
+		r.dt.SavePath = CreateDirectory(r.opts, vid)
 		canvases, err := r.getCanvases(vol, r.dt.Jar)
 		if err != nil || canvases == nil {
-			fmt.Println(err)
+			r.res.Log().Error("获取页面列表失败", "error", err)
 			continue
 		}
-		log.Printf(" %d/%d volume, %d pages \n", i+1, len(respVolume), len(canvases))
+		r.res.Log().Info("下载分册", logger.KeyVolume, i+1, "volumes", len(respVolume), "pages", len(canvases))
 		r.do(canvases)
 	}
 	return "", nil
@@ -87,43 +105,43 @@ func (r *Yndfz) do(imgUrls []string) (msg string, err error) {
 	fmt.Println()
 	referer := url.QueryEscape(r.dt.Url)
 	size := len(imgUrls)
-	ctx := context.Background()
+	ctx := r.ctx
 	for i, uri := range imgUrls {
-		if !config.PageRange(i, size) {
+		if !r.opts.PageRange(i, size) {
 			continue
 		}
 		if uri == "" {
 			continue
 		}
 		sortId := fmt.Sprintf("%04d", i+1)
-		filename := sortId + config.Conf.FileExt
+		filename := sortId + r.opts.FileExt
 		dest := path.Join(r.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !r.res.Pending(uri, dest) {
 			continue
 		}
-		log.Printf("Get %d/%d page, URL: %s\n", i+1, size, uri)
+		r.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", size, logger.KeyUrl, uri)
 		opts := gohttp.Options{
 			DestFile:    dest,
 			Overwrite:   false,
 			Concurrency: 1,
-			CookieFile:  config.Conf.CookieFile,
-			HeaderFile:  config.Conf.HeaderFile,
+			CookieFile:  r.opts.CookieFile,
+			HeaderFile:  r.opts.HeaderFile,
 			CookieJar:   r.dt.Jar,
 			Headers: map[string]interface{}{
-				"User-Agent": config.Conf.UserAgent,
+				"User-Agent": r.opts.UserAgent,
 				"Referer":    referer,
 			},
 		}
 
 		imgUrl, err := r.getDownloadUrl(uri)
 		if err != nil {
-			fmt.Println(err)
+			r.res.Record(sortId, uri, dest, err)
 			break
 		}
 		_, err = gohttp.FastGet(ctx, imgUrl, opts)
+		r.res.Record(sortId, imgUrl, dest, err)
 		if err != nil {
-			fmt.Println(err)
-			util.PrintSleepTime(config.Conf.Sleep)
+			util.PrintSleepTime(r.opts.Sleep)
 		}
 		fmt.Println()
 	}
@@ -170,12 +188,12 @@ func (r *Yndfz) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []string,
 
 func (r *Yndfz) getBody(apiUrl string, jar *cookiejar.Jar) ([]byte, error) {
 	referer := url.QueryEscape(apiUrl)
-	ctx := context.Background()
+	ctx := r.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: r.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": r.opts.UserAgent,
 			"Referer":    referer,
 		},
 	})
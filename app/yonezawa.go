@@ -3,11 +3,11 @@ package app
 import (
 	"bookget/config"
 	"bookget/pkg/gohttp"
+	"bookget/pkg/logger"
 	"bookget/pkg/util"
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"net/http/cookiejar"
 	"net/url"
 	"path"
@@ -19,27 +19,44 @@ import (
 
 type Yonezawa struct {
 	dt *DownloadTask
+
+	ctx  context.Context
+	opts *config.Input
+	res  *Result
+}
+
+func init() {
+	Register(Site{
+		ID:       "yonezawa",
+		Name:     "[日本]市立米泽图书馆",
+		Country:  "JP",
+		Hosts:    []string{"library.yonezawa.yamagata.jp"},
+		Examples: []string{"https://www.library.yonezawa.yamagata.jp/dg/{id}_view.html"},
+		New:      func(ctx context.Context, opts *config.Input) RouterInit { return NewYonezawa(ctx, opts) },
+	})
 }
 
-func NewYonezawa() *Yonezawa {
+func NewYonezawa(ctx context.Context, opts *config.Input) *Yonezawa {
 	return &Yonezawa{
 		// 初始化字段
-		dt: new(DownloadTask),
+		ctx:  ctx,
+		opts: opts,
+		dt:   new(DownloadTask),
 	}
 }
 
-func (r *Yonezawa) GetRouterInit(sUrl string) (map[string]interface{}, error) {
+func (r *Yonezawa) GetRouterInit(sUrl string) (*Result, error) {
+	r.res = NewResult(r.ctx, r.opts, sUrl)
 	msg, err := r.Run(sUrl)
-	return map[string]interface{}{
-		"url": sUrl,
-		"msg": msg,
-	}, err
+	r.res.SetBook(r.dt.BookId, r.dt.Title)
+	return r.res.Finish(msg, err)
 }
 
 func (p *Yonezawa) Run(sUrl string) (msg string, err error) {
 	p.dt.UrlParsed, err = url.Parse(sUrl)
 	p.dt.Url = sUrl
 	p.dt.BookId = p.getBookId(p.dt.Url)
+	p.res.SetBook(p.dt.BookId, "")
 	if p.dt.BookId == "" {
 		return "requested URL was not found.", err
 	}
@@ -55,30 +72,31 @@ func (p *Yonezawa) getBookId(sUrl string) (bookId string) {
 }
 
 func (p *Yonezawa) download() (msg string, err error) {
-	log.Printf("Get %s\n", p.dt.Url)
+	p.res.Log().Info("获取图书信息", logger.KeyUrl, p.dt.Url)
 	respVolume, err := p.getVolumes(p.dt.Url, p.dt.Jar)
+	p.res.SetVolumes(len(respVolume))
 	if err != nil {
-		fmt.Println(err)
+		p.res.Log().Error("获取册列表失败", "error", err)
 		return "getVolumes", err
 	}
 	sizeVol := len(respVolume)
 	for i, vol := range respVolume {
-		if !config.VolumeRange(i) {
+		if !p.opts.VolumeRange(i) {
 			continue
 		}
 		if sizeVol == 1 {
-			p.dt.SavePath = config.Conf.Directory
+			p.dt.SavePath = p.opts.Directory
 		} else {
 			vid := fmt.Sprintf("%04d", i+1)
-			p.dt.SavePath = CreateDirectory(vid)
+			p.dt.SavePath = CreateDirectory(p.opts, vid)
 		}
 
 		canvases, err := p.getCanvases(vol, p.dt.Jar)
 		if err != nil || canvases == nil {
-			fmt.Println(err)
+			p.res.Log().Error("获取页面列表失败", "error", err)
 			continue
 		}
-		log.Printf(" %d/%d volume, %d pages \n", i+1, sizeVol, len(canvases))
+		p.res.Log().Info("下载分册", logger.KeyVolume, i+1, "volumes", sizeVol, "pages", len(canvases))
 		p.do(canvases)
 	}
 	return msg, err
@@ -91,37 +109,38 @@ func (p *Yonezawa) do(imgUrls []string) (msg string, err error) {
 	size := len(imgUrls)
 	fmt.Println()
 	var wg sync.WaitGroup
-	q := QueueNew(int(config.Conf.Threads))
+	q := QueueNew(int(p.opts.Threads))
 	for i, uri := range imgUrls {
-		if uri == "" || !config.PageRange(i, size) {
+		if uri == "" || !p.opts.PageRange(i, size) {
 			continue
 		}
 		ext := util.FileExt(uri)
 		sortId := fmt.Sprintf("%04d", i+1)
 		filename := sortId + ext
 		dest := path.Join(p.dt.SavePath, filename)
-		if FileExist(dest) {
+		if !p.res.Pending(uri, dest) {
 			continue
 		}
 		imgUrl := uri
 		fmt.Println()
-		log.Printf("Get %d/%d  %s\n", i+1, size, imgUrl)
+		p.res.Log().Info("下载页面", logger.KeyPage, i+1, "total", size, logger.KeyUrl, imgUrl)
 		wg.Add(1)
 		q.Go(func() {
 			defer wg.Done()
-			ctx := context.Background()
+			ctx := p.ctx
 			opts := gohttp.Options{
 				DestFile:    dest,
 				Overwrite:   false,
 				Concurrency: 1,
-				CookieFile:  config.Conf.CookieFile,
-				HeaderFile:  config.Conf.HeaderFile,
+				CookieFile:  p.opts.CookieFile,
+				HeaderFile:  p.opts.HeaderFile,
 				CookieJar:   p.dt.Jar,
 				Headers: map[string]interface{}{
-					"User-Agent": config.Conf.UserAgent,
+					"User-Agent": p.opts.UserAgent,
 				},
 			}
-			gohttp.FastGet(ctx, imgUrl, opts)
+			_, err := gohttp.FastGet(ctx, imgUrl, opts)
+			p.res.Record(sortId, imgUrl, dest, err)
 			fmt.Println()
 		})
 	}
@@ -164,12 +183,12 @@ func (p *Yonezawa) getCanvases(sUrl string, jar *cookiejar.Jar) (canvases []stri
 
 func (p *Yonezawa) getBody(sUrl string, jar *cookiejar.Jar) ([]byte, error) {
 	referer := url.QueryEscape(sUrl)
-	ctx := context.Background()
+	ctx := p.ctx
 	cli := gohttp.NewClient(ctx, gohttp.Options{
-		CookieFile: config.Conf.CookieFile,
+		CookieFile: p.opts.CookieFile,
 		CookieJar:  jar,
 		Headers: map[string]interface{}{
-			"User-Agent": config.Conf.UserAgent,
+			"User-Agent": p.opts.UserAgent,
 			"Referer":    referer,
 		},
 	})
@@ -3,6 +3,8 @@ package main
 import (
 	"bookget/app"
 	"bookget/config"
+	"bookget/pkg/logger"
+	"bookget/pkg/proxy"
 	"bookget/pkg/queue"
 	"bookget/pkg/version"
 	"bookget/router"
@@ -33,11 +35,98 @@ func main() {
 		return
 	}
 
+	// 结构化日志：--log-format/--log-file 时写入文件或 stderr，与进度条分开
+	logFile, err := logger.Setup(config.Conf.LogLevel, config.Conf.LogFormat, config.Conf.LogFile)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	defer logFile.Close()
+
+	// 同一站点的所有任务共享访问限制、限速与代理，收到 SIGHUP 时按配置文件更新
+	applyLimits(config.Settings, config.Conf)
+	if _, err := proxy.Parse(config.Conf.Proxy); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	applyTransport(config.Settings, config.Conf)
+	stopReload := watchReload()
+	defer stopReload()
+
+	// 子命令
+	if config.Conf.Command == "sites" {
+		if err := printSites(os.Stdout, config.Conf.Output); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+	if config.Conf.Command == "config" {
+		if err := printConfig(os.Stdout); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+	if config.Conf.Command == "verify" {
+		root := config.Conf.Directory
+		if len(config.Conf.Args) > 0 {
+			root = config.Conf.Args[0]
+		}
+		// 重新下载时的进度输出改写到 stderr，stdout 仅保留校验结果
+		stdout := os.Stdout
+		os.Stdout = os.Stderr
+		ctx, stop := notifyContext(ctx)
+		ok, err := runVerify(ctx, stdout, root, config.Conf.Refetch, config.Conf.Output)
+		stop()
+		os.Stdout = stdout
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// 只输出下载计划：执行过程中的提示信息改写到 stderr，stdout 仅保留计划
+	if config.Conf.DryRun {
+		stdout := os.Stdout
+		os.Stdout = os.Stderr
+		ctx, stop := notifyContext(ctx)
+		executeByRunMode(ctx)
+		stop()
+		os.Stdout = stdout
+		if !printPlan(os.Stdout, config.Conf.Output) {
+			os.Exit(1)
+		}
+		return
+	}
+
 	// 检查更新
 	checkForUpdates()
 
+	// Ctrl-C 时停止调度新任务，进行中的任务放弃未完成的文件
+	ctx, stop := notifyContext(ctx)
+	defer stop()
+
 	// 根据运行模式执行相应操作
 	executeByRunMode(ctx)
+
+	// 有失败的任务时返回非零退出码，便于脚本判断
+	ok := printSummary(os.Stdout)
+	if ctx.Err() != nil {
+		if batchJournal != nil {
+			log.Println("已中断，未完成的任务已记入任务日志，重新运行即可继续")
+		}
+		stop()
+		os.Exit(exitInterrupted)
+	}
+	if !ok {
+		stop()
+		os.Exit(1)
+	}
 }
 
 // initializeConfig 处理配置初始化
@@ -54,7 +143,7 @@ func executeByRunMode(ctx context.Context) {
 	case RunModeSingleURL:
 		executeSingleURL(ctx, config.Conf.DUrl)
 	case RunModeBatchURLs:
-		executeBatchURLs()
+		executeBatchURLs(ctx)
 	case RunModeInteractive:
 		runInteractiveMode(ctx)
 	case RunModeInteractiveImage:
@@ -101,18 +190,28 @@ func executeSingleURL(ctx context.Context, rawUrl string) {
 }
 
 // executeBatchURLs 处理批量URLs模式
-func executeBatchURLs() {
+func executeBatchURLs(ctx context.Context) {
 	allUrls, err := loadAndFilterURLs(config.Conf.UrlsFile)
 	if err != nil {
 		log.Println(err)
 		return
 	}
+	//已完成的 URL 不再下载，进度写入 URL 文件旁的任务日志
+	allUrls, err = openBatchJournal(config.Conf.UrlsFile, allUrls)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if len(allUrls) == 0 {
+		log.Println("URL文件中的任务均已完成")
+		return
+	}
 
 	q := queue.NewConcurrentQueue(int(config.Conf.Threads))
 	if config.Conf.DownloaderMode == 1 {
-		processURLsDownloaderMode(q, allUrls)
+		processURLsDownloaderMode(ctx, q, allUrls)
 	} else {
-		processURLsManual(q, allUrls)
+		processURLsManual(ctx, q, allUrls)
 	}
 	wg.Wait()
 }
@@ -120,7 +219,7 @@ func executeBatchURLs() {
 // runInteractiveMode 运行交互模式
 func runInteractiveMode(ctx context.Context) {
 	//cleanupCookieFile()
-	for {
+	for ctx.Err() == nil {
 		rawUrl, err := readURLFromInput()
 		if err != nil {
 			break
@@ -135,22 +234,26 @@ func runInteractiveMode(ctx context.Context) {
 // runInteractiveModeImage 运行交互模式：图片下载
 func runInteractiveModeImage(ctx context.Context) {
 	//cleanupCookieFile()
-	app.NewImageDownloader().Run("")
+	app.NewImageDownloader(ctx, &config.Conf).Run("")
 }
 
-// loadAndFilterURLs 加载并过滤URLs
-func loadAndFilterURLs(filename string) ([]string, error) {
+// loadAndFilterURLs 加载并过滤URLs，每行可附带 key=value 参数或写成 JSON，见 config.ParseURLLine
+func loadAndFilterURLs(filename string) ([]config.URLLine, error) {
 	content, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, fmt.Errorf("无法读取URL文件: %w", err)
 	}
 
 	lines := strings.Split(string(content), "\n")
-	var urls []string
-	for _, line := range lines {
-		sUrl := strings.TrimSpace(strings.Trim(line, "\r"))
-		if isValidURL(sUrl) {
-			urls = append(urls, sUrl)
+	var urls []config.URLLine
+	for i, line := range lines {
+		u, ok, err := config.ParseURLLine(line)
+		if err != nil {
+			log.Printf("URL文件第 %d 行: %v\n", i+1, err)
+			continue
+		}
+		if ok {
+			urls = append(urls, u)
 		}
 	}
 
@@ -167,44 +270,51 @@ func isValidURL(url string) bool {
 }
 
 // processURLsDownloaderMode 自动检测模式处理URLs
-func processURLsDownloaderMode(q *queue.ConcurrentQueue, allUrls []string) {
+func processURLsDownloaderMode(ctx context.Context, q *queue.ConcurrentQueue, allUrls []config.URLLine) {
 	for _, v := range allUrls {
 		wg.Add(1)
-		rawURL := v // 创建局部变量供闭包使用
+		line := v // 创建局部变量供闭包使用
 		q.Go(func() {
 			defer wg.Done()
-			processURLSet("bookget", rawURL)
+			processURLSet(ctx, "bookget", line)
 		})
 	}
 }
 
 // processURLsManual 手动模式处理URLs
-func processURLsManual(q *queue.ConcurrentQueue, allUrls []string) {
+func processURLsManual(ctx context.Context, q *queue.ConcurrentQueue, allUrls []config.URLLine) {
 	for _, v := range allUrls {
-		u, err := url.Parse(v)
+		u, err := url.Parse(v.Url)
 		if err != nil {
-			log.Printf("URL解析失败: %s, 错误: %v\n", v, err)
+			log.Printf("URL解析失败: %s, 错误: %v\n", v.Url, err)
+			journalFinish(v, nil, err)
 			continue
 		}
 
 		wg.Add(1)
-		rawURL := v // 创建局部变量供闭包使用
+		line := v // 创建局部变量供闭包使用
 		q.Go(func() {
 			defer wg.Done()
-			processURLSet(u.Host, rawURL)
+			processURLSet(ctx, u.Host, line)
 		})
 	}
 }
 
 // processURLSet 处理一组URLs
-func processURLSet(siteID string, rawUrl string) {
-	result, err := router.FactoryRouter(siteID, rawUrl)
+func processURLSet(ctx context.Context, siteID string, line config.URLLine) {
+	rawUrl := line.Url
+	//已中断：未开始的任务保持 pending，下次运行继续
+	if ctx.Err() != nil {
+		return
+	}
+	journalStart(line)
+	opts := jobOptions(hostOf(rawUrl), line.Overrides)
+	result, err := router.FactoryRouter(ctx, siteID, rawUrl, opts)
+	journalFinish(line, result, err)
+	addResult(rawUrl, result, err)
 	if err != nil {
 		log.Println(err)
-		return
 	}
-	// 使用result
-	_ = result
 }
 
 // readURLFromInput 从用户输入读取URL
@@ -224,22 +334,25 @@ func readURLFromInput() (string, error) {
 func processURL(ctx context.Context, rawUrl string) error {
 	rawURL := strings.TrimSpace(rawUrl)
 	if !isValidURL(rawURL) {
-		return fmt.Errorf("无效的URL: %s", rawUrl)
+		err := fmt.Errorf("无效的URL: %s", rawUrl)
+		addResult(rawURL, nil, err)
+		return err
 	}
 
 	u, err := url.Parse(rawURL)
 	if err != nil {
-		return fmt.Errorf("URL解析失败: %w", err)
+		err = fmt.Errorf("URL解析失败: %w", err)
+		addResult(rawURL, nil, err)
+		return err
 	}
 
-	result, err := router.FactoryRouter(u.Host, rawURL)
+	opts := jobOptions(u.Host, config.Overrides{})
+	result, err := router.FactoryRouter(ctx, u.Host, rawURL, opts)
+	addResult(rawURL, result, err)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	// 使用result
-	_ = result
-
 	return nil
 }
 
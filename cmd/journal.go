@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bookget/app"
+	"bookget/config"
+	"bookget/pkg/journal"
+	"fmt"
+	"log"
+)
+
+// batchJournal 批量模式（-I）的任务日志，dry-run 与单 URL 模式下为 nil
+var batchJournal *journal.Journal
+
+// openBatchJournal 打开 URL 文件旁的任务日志，按上次状态过滤待处理 URL：
+// 已完成的跳过；--retry-failed 时只保留失败的。记录按 URL 与行内参数区分
+func openBatchJournal(urlsFile string, allUrls []config.URLLine) ([]config.URLLine, error) {
+	jn, err := journal.Open(journal.Path(urlsFile))
+	if err != nil {
+		return nil, fmt.Errorf("无法读取任务日志: %w", err)
+	}
+
+	var urls []config.URLLine
+	var done, skipped int
+	for _, line := range allUrls {
+		state := jn.Add(line.Key())
+		switch {
+		case state == journal.Done:
+			done++
+		case config.Conf.RetryFailed && state != journal.Failed:
+			skipped++
+		default:
+			urls = append(urls, line)
+		}
+	}
+	if done > 0 || skipped > 0 {
+		log.Printf("任务日志 %s: 已完成 %d, 本次跳过 %d, 待处理 %d\n", journal.Path(urlsFile), done, skipped, len(urls))
+	}
+
+	if !config.Conf.DryRun {
+		if err = jn.Save(); err != nil {
+			return nil, fmt.Errorf("无法写入任务日志: %w", err)
+		}
+		batchJournal = jn
+	}
+	return urls, nil
+}
+
+// journalStart 标记 URL 开始处理
+func journalStart(line config.URLLine) {
+	if batchJournal == nil {
+		return
+	}
+	if err := batchJournal.Start(line.Key()); err != nil {
+		log.Printf("写入任务日志失败: %v\n", err)
+	}
+}
+
+// journalFinish 按下载结果标记 URL 完成或失败
+func journalFinish(line config.URLLine, res *app.Result, err error) {
+	if batchJournal == nil {
+		return
+	}
+	errText := ""
+	if err != nil {
+		errText = err.Error()
+	} else if !res.OK() {
+		errText = fmt.Sprintf("%d 页下载失败", res.Failed)
+		if len(res.Errors) > 0 {
+			errText += ": " + res.Errors[0].Err
+		}
+	}
+	if err = batchJournal.Finish(line.Key(), errText); err != nil {
+		log.Printf("写入任务日志失败: %v\n", err)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bookget/config"
+	"bookget/pkg/httpclient"
+	"bookget/pkg/logger"
+	"bookget/pkg/proxy"
+	"bookget/pkg/ratelimit"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+)
+
+// jobOptions 为单个任务生成独立的参数副本：依次应用配置文件中的 [site "host"] 与 URL 文件单行参数，
+// 并发任务之间互不影响
+func jobOptions(host string, o config.Overrides) *config.Input {
+	opts, err := config.Settings.ApplySite(config.Conf, host)
+	if err != nil {
+		log.Println(err)
+	}
+	if !o.IsZero() {
+		opts = o.Apply(opts)
+	}
+	if opts.Directory != config.Conf.Directory && !opts.DryRun {
+		_ = os.MkdirAll(opts.Directory, os.ModePerm)
+	}
+	return &opts
+}
+
+// applyLimits 按配置设置全局限速，并按 [site "host"] 取得各站点的访问限制，供 ratelimit 使用
+func applyLimits(p *config.Profile, in config.Input) {
+	ratelimit.SetBandwidth(in.LimitRate)
+	ratelimit.SetLimits(func(host string) ratelimit.Limits {
+		opts, err := p.ApplySite(in, host)
+		if err != nil {
+			log.Println(err)
+		}
+		return ratelimit.Limits{
+			RPS:         opts.RateLimit,
+			MaxInFlight: opts.MaxInFlight,
+			MinGap:      opts.MinGap,
+			Bandwidth:   opts.SiteLimitRate,
+		}
+	})
+}
+
+// applyTransport 按命令行参数与 [site "host"] 设置各站点的代理与证书校验
+func applyTransport(p *config.Profile, in config.Input) {
+	proxy.SetProxies(func(host string) string {
+		opts, _ := p.ApplySite(in, host)
+		return opts.Proxy
+	})
+	httpclient.SetTLS(func(host string) httpclient.TLS {
+		opts, _ := p.ApplySite(in, host)
+		return httpclient.TLS{Insecure: opts.Insecure, CAFile: opts.CAFile}
+	})
+}
+
+// reloadLimits 重新读取配置文件，更新限速、访问限制、代理与证书设置，进行中的下载随即按新的限制进行
+func reloadLimits() {
+	p, in, err := config.Reload()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	applyLimits(p, in)
+	applyTransport(p, in)
+	logger.FromContext(context.Background()).Info("已重新加载配置",
+		"limit_rate", in.LimitRate, "site_limit_rate", in.SiteLimitRate)
+}
+
+// hostOf 返回 URL 的 host，解析失败时为空
+func hostOf(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// printConfig 列出配置文件路径，用户级配置文件不存在时按模板创建
+func printConfig(w io.Writer) error {
+	for _, f := range config.ConfigFiles(config.Conf.ConfigFile) {
+		state := "不存在"
+		if _, err := os.Stat(f); err == nil {
+			state = "已加载"
+		}
+		fmt.Fprintf(w, "%s (%s)\n", f, state)
+	}
+	return config.CreateConfigIfNotExists(config.UserConfigFile())
+}
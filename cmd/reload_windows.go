@@ -0,0 +1,8 @@
+//go:build windows
+
+package main
+
+// watchReload Windows 没有 SIGHUP，限速只能在启动时设置
+func watchReload() func() {
+	return func() {}
+}
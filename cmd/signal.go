@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// exitInterrupted 被 Ctrl-C 中断时的退出码
+const exitInterrupted = 130
+
+// notifyContext 返回收到 SIGINT/SIGTERM 时取消的根 context：
+// 第一次中断停止调度新任务，进行中的写入完成或丢弃临时文件；第二次中断立即退出
+func notifyContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	ch := make(chan os.Signal, 2)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			signal.Stop(ch)
+			return
+		}
+		log.Println("收到中断信号，正在停止下载…（再按一次 Ctrl-C 强制退出）")
+		cancel()
+		<-ch
+		log.Println("强制退出")
+		os.Exit(exitInterrupted)
+	}()
+	return ctx, func() {
+		signal.Stop(ch)
+		cancel()
+	}
+}
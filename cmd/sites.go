@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bookget/app"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// siteInfo sites 子命令的输出条目
+type siteInfo struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	Country      string   `json:"country,omitempty"`
+	Hosts        []string `json:"hosts,omitempty"`
+	HostPatterns []string `json:"hostPatterns,omitempty"`
+	Paths        []string `json:"paths,omitempty"`
+	Capabilities []string `json:"capabilities"`
+	Examples     []string `json:"examples,omitempty"`
+}
+
+// printSites 列出所有已注册站点，format 为 text 或 json
+func printSites(w io.Writer, format string) error {
+	sites := app.Sites()
+	list := make([]siteInfo, 0, len(sites))
+	for _, s := range sites {
+		list = append(list, siteInfo{
+			ID:           s.ID,
+			Name:         s.Name,
+			Country:      s.Country,
+			Hosts:        s.Hosts,
+			HostPatterns: s.HostPatterns,
+			Paths:        s.Paths,
+			Capabilities: s.Capabilities.Names(),
+			Examples:     s.Examples,
+		})
+	}
+
+	switch strings.ToLower(format) {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(list)
+	case "", "text", "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tCOUNTRY\tNAME\tHOSTS\tCAPABILITIES\tEXAMPLE")
+		for _, s := range list {
+			hosts := append(append([]string{}, s.Hosts...), s.HostPatterns...)
+			if len(s.Paths) > 0 {
+				hosts = append(hosts, "path:"+strings.Join(s.Paths, ","))
+			}
+			example := ""
+			if len(s.Examples) > 0 {
+				example = s.Examples[0]
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", s.ID, dash(s.Country), s.Name,
+				dash(strings.Join(hosts, ",")), dash(strings.Join(s.Capabilities, ",")), dash(example))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "\n%d sites\n", len(list))
+		return nil
+	default:
+		return fmt.Errorf("unknown output format: %s", format)
+	}
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bookget/app"
+	"bookget/config"
+	"bookget/pkg/gohttp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxPrintErrors 每个 URL 汇总时最多列出的失败页数
+const maxPrintErrors = 5
+
+var (
+	resultsMu sync.Mutex
+	results   []*app.Result
+)
+
+// addResult 记录单个 URL 的下载结果；适配器未返回结果时按 err 补一条
+func addResult(rawUrl string, res *app.Result, err error) {
+	if res == nil {
+		res = app.NewResult(context.Background(), &config.Conf, rawUrl)
+		res.Finish("", err)
+	}
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+	results = append(results, res)
+}
+
+// printSummary 打印全部 URL 的下载汇总，全部成功时返回 true
+func printSummary(w io.Writer) bool {
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+	if len(results) == 0 {
+		return true
+	}
+
+	var (
+		ok, failed                      int
+		planned, downloaded, skipped, n int
+		bytes                           int64
+		elapsed                         time.Duration
+	)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "下载汇总:")
+	for _, res := range results {
+		status := "OK"
+		if res.OK() {
+			ok++
+		} else {
+			failed++
+			status = "FAIL"
+		}
+		fmt.Fprintf(w, "[%s] %s\n      %s\n", status, res.Url, res)
+		if res.Msg != "" {
+			fmt.Fprintf(w, "      %s\n", res.Msg)
+		}
+		for i, e := range res.Errors {
+			if i == maxPrintErrors {
+				fmt.Fprintf(w, "      ... 另有 %d 页失败\n", len(res.Errors)-maxPrintErrors)
+				break
+			}
+			fmt.Fprintf(w, "      %s %s: %s\n", e.Page, e.Url, e.Err)
+		}
+		planned += res.Planned
+		downloaded += res.Downloaded
+		skipped += res.Skipped
+		n += res.Failed
+		bytes += res.Bytes
+		elapsed += res.Elapsed
+	}
+	if len(results) > 1 {
+		fmt.Fprintf(w, "共 %d 个 URL，成功 %d，失败 %d；页数 计划 %d, 下载 %d, 跳过 %d, 失败 %d, %s, 耗时 %v\n",
+			len(results), ok, failed, planned, downloaded, skipped, n,
+			gohttp.ByteUnitString(bytes), elapsed.Round(time.Millisecond))
+	}
+	return failed == 0
+}
+
+// printPlan 输出 dry-run 的下载计划，format 为 text 或 json；全部解析成功时返回 true
+func printPlan(w io.Writer, format string) bool {
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+
+	ok := true
+	for _, res := range results {
+		if res.Msg != "" {
+			ok = false
+		}
+	}
+
+	if strings.ToLower(format) == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			fmt.Fprintln(w, err)
+			return false
+		}
+		return ok
+	}
+
+	for _, res := range results {
+		fmt.Fprintf(w, "%s\n", res.Url)
+		format := res.Format
+		if res.Dzi {
+			format += " (dzi)"
+		}
+		fmt.Fprintf(w, "  site: %s  bookId: %s  volumes: %d  format: %s\n", res.Site, res.BookId, res.Volumes, format)
+		if res.Msg != "" {
+			fmt.Fprintf(w, "  error: %s\n", res.Msg)
+			continue
+		}
+		fmt.Fprintf(w, "  pages: %d (已存在 %d)\n", res.Planned, res.Skipped)
+		dir := ""
+		for _, item := range res.Items {
+			if d := filepath.Dir(item.Dest); d != dir {
+				dir = d
+				fmt.Fprintf(w, "  %s\n", dir)
+			}
+			mark := " "
+			if item.Exists {
+				mark = "="
+			}
+			fmt.Fprintf(w, "   %s %s  %s\n", mark, filepath.Base(item.Dest), item.Url)
+		}
+	}
+	return ok
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bookget/config"
+	"bookget/pkg/downloader"
+	"bookget/pkg/gohttp"
+	"bookget/pkg/manifest"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// runVerify 按校验清单检查 root 及其子目录，refetch 时重新下载缺失与损坏的页面后再检查一次。
+// 返回是否全部通过（多余的文件只报告，不视为失败）
+func runVerify(ctx context.Context, w io.Writer, root string, refetch bool, format string) (bool, error) {
+	dirs, err := manifest.Dirs(root)
+	if err != nil {
+		return false, err
+	}
+	if len(dirs) == 0 {
+		return false, fmt.Errorf("%s 中没有校验清单 %s", root, manifest.Name)
+	}
+
+	ok := true
+	reports := make([]*manifest.Report, 0, len(dirs))
+	for _, dir := range dirs {
+		r, err := manifest.Verify(dir)
+		if err != nil {
+			return false, err
+		}
+		if refetch && len(r.Bad()) > 0 {
+			refetchPages(ctx, r)
+			if r, err = manifest.Verify(dir); err != nil {
+				return false, err
+			}
+		}
+		ok = ok && len(r.Bad()) == 0
+		reports = append(reports, r)
+	}
+
+	switch strings.ToLower(format) {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return ok, enc.Encode(reports)
+	case "", "text", "table":
+		for _, r := range reports {
+			fmt.Fprintf(w, "%s: 检查 %d, 正常 %d, 缺失 %d, 损坏 %d, 多余 %d\n",
+				r.Dir, r.Checked, r.OK, len(r.Missing), len(r.Corrupted), len(r.Extra))
+			for _, p := range r.Missing {
+				fmt.Fprintf(w, "  缺失 %s  %s\n", p.File, p.Url)
+			}
+			for _, p := range r.Corrupted {
+				fmt.Fprintf(w, "  损坏 %s (%s)  %s\n", p.File, p.Reason, p.Url)
+			}
+			for _, name := range r.Extra {
+				fmt.Fprintf(w, "  多余 %s\n", name)
+			}
+		}
+		return ok, nil
+	}
+	return ok, fmt.Errorf("不支持的输出格式: %s", format)
+}
+
+// refetchPages 按清单中记录的 URL 重新下载缺失与损坏的页面，使用对应站点的配置（cookie、header 等）
+func refetchPages(ctx context.Context, r *manifest.Report) {
+	for _, p := range r.Bad() {
+		if ctx.Err() != nil {
+			return
+		}
+		if p.Url == "" {
+			log.Printf("%s 没有记录来源 URL，无法重新下载\n", p.File)
+			continue
+		}
+		opts := jobOptions(hostOf(p.Url), config.Overrides{})
+		dest := filepath.Join(r.Dir, p.File)
+		err := refetch(ctx, opts, p.Url, dest)
+		if err == nil {
+			err = manifest.Add(dest, p.Url)
+		}
+		if err != nil {
+			log.Printf("重新下载 %s 失败: %v\n", p.File, err)
+			continue
+		}
+		log.Printf("已重新下载 %s\n", p.File)
+	}
+}
+
+// refetch 重新下载单页。来源为 IIIF info.json 的页面由拼图拼接而成，按 info.json 重新拼图
+func refetch(ctx context.Context, opts *config.Input, sUrl, dest string) error {
+	if strings.HasSuffix(sUrl, "/info.json") {
+		return downloader.NewIIIFDownloader(opts).Dezoomify(ctx, sUrl, dest, nil)
+	}
+	_, err := gohttp.Get(ctx, sUrl, gohttp.Options{
+		DestFile:   dest,
+		Overwrite:  true,
+		Retry:      opts.Retries,
+		CookieFile: opts.CookieFile,
+		HeaderFile: opts.HeaderFile,
+		Headers: map[string]interface{}{
+			"User-Agent": opts.UserAgent,
+		},
+	})
+	return err
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfilePrecedence(t *testing.T) {
+	dir := t.TempDir()
+	user := filepath.Join(dir, "user.ini")
+	project := filepath.Join(dir, "bookget.ini")
+	require.NoError(t, os.WriteFile(user, []byte(`
+[default]
+sleep = 1
+threads = 2
+retries = 4
+user-agent = user-ua
+
+[site "example.org"]
+threads = 9
+`), 0644))
+	require.NoError(t, os.WriteFile(project, []byte(`
+[default]
+sleep = 5
+concurrent = 3
+
+[site "example.org"]
+sleep = 20
+retries = 7
+user-agent = site-ua
+rate = 0.5
+min-gap = 2s
+site-limit-rate = 500K
+proxy = socks5://127.0.0.1:1080
+insecure = true
+best-effort-tiles = true
+`), 0644))
+
+	changed := map[string]bool{"retries": true}
+	p, err := LoadProfile([]string{user, filepath.Join(dir, "missing.ini"), project},
+		[]string{"BOOKGET_USER_AGENT=env-ua", "BOOKGET_CONCURRENT=6", "HOME=/"},
+		func(key string) bool { return changed[key] })
+	require.NoError(t, err)
+
+	in := Input{Retries: 3, Sleep: 3, Threads: 1, MaxConcurrent: 16}
+	require.NoError(t, p.Apply(&in))
+	assert.Equal(t, 5, in.Sleep)            // 项目级 [default] 覆盖用户级
+	assert.Equal(t, 2, in.Threads)          // 用户级 [default]
+	assert.Equal(t, 6, in.MaxConcurrent)    // 环境变量 > [default]
+	assert.Equal(t, "env-ua", in.UserAgent) // 环境变量 > [default]
+	assert.Equal(t, 3, in.Retries)          // 命令行 > [default]
+
+	site, err := p.ApplySite(in, "www.example.org:443")
+	require.NoError(t, err)
+	assert.Equal(t, 20, site.Sleep)           // [site] > [default]
+	assert.Equal(t, 9, site.Threads)          // 用户级 [site]
+	assert.Equal(t, "env-ua", site.UserAgent) // 环境变量 > [site]
+	assert.Equal(t, 3, site.Retries)          // 命令行 > [site]
+	assert.Equal(t, 5, in.Sleep)              // 原值不受影响
+	assert.Equal(t, 0.5, site.RateLimit)
+	assert.Equal(t, 2*time.Second, site.MinGap)
+	assert.EqualValues(t, 500<<10, site.SiteLimitRate)
+	assert.Equal(t, "socks5://127.0.0.1:1080", site.Proxy)
+	assert.Empty(t, in.Proxy)
+	assert.True(t, site.Insecure)
+	assert.False(t, in.Insecure)
+	assert.True(t, site.BestEffortTiles)
+
+	assert.True(t, p.HasSite("example.org"))
+	assert.False(t, p.HasSite("example.com"))
+}
+
+func TestParseBytes(t *testing.T) {
+	for v, want := range map[string]int64{"0": 0, "1024": 1024, "500K": 500 << 10, "2m": 2 << 20, "1.5M": 3 << 19, "1G/s": 1 << 30, "64KB": 64 << 10} {
+		n, err := ParseBytes(v)
+		require.NoError(t, err, v)
+		assert.Equal(t, want, n, v)
+	}
+	for _, v := range []string{"", "fast", "-1M"} {
+		_, err := ParseBytes(v)
+		assert.Error(t, err, v)
+	}
+}
+
+func TestProfileInvalid(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "bookget.ini")
+
+	require.NoError(t, os.WriteFile(file, []byte("[site \"example.org\"]\ndir = /tmp\n"), 0644))
+	_, err := LoadProfile([]string{file}, nil, nil)
+	assert.Error(t, err)
+
+	require.NoError(t, os.WriteFile(file, []byte("[default]\nsleep = abc\n"), 0644))
+	p, err := LoadProfile([]string{file}, nil, nil)
+	require.NoError(t, err)
+	assert.Error(t, p.Apply(&Input{}))
+}
@@ -8,81 +8,81 @@ import (
 var Conf Input
 
 // initSeq    false = 最小值 <= 当前页码 <=  最大值
-func initSeqRange() {
-	if Conf.Seq == "" || !strings.Contains(Conf.Seq, ":") {
+func (in *Input) initSeqRange() {
+	if in.Seq == "" || !strings.Contains(in.Seq, ":") {
 		return
 	}
-	m := strings.Split(Conf.Seq, ":")
+	m := strings.Split(in.Seq, ":")
 	if len(m) == 1 {
-		Conf.SeqStart, _ = strconv.Atoi(m[0])
-		Conf.SeqEnd = Conf.SeqStart
+		in.SeqStart, _ = strconv.Atoi(m[0])
+		in.SeqEnd = in.SeqStart
 	} else {
-		Conf.SeqStart, _ = strconv.Atoi(m[0])
-		Conf.SeqEnd, _ = strconv.Atoi(m[1])
+		in.SeqStart, _ = strconv.Atoi(m[0])
+		in.SeqEnd, _ = strconv.Atoi(m[1])
 	}
 	return
 }
 
 // initVolumeRange    false = 最小值 <= 当前页码 <=  最大值
-func initVolumeRange() {
-	m := strings.Split(Conf.Volume, ":")
+func (in *Input) initVolumeRange() {
+	m := strings.Split(in.Volume, ":")
 	if len(m) == 1 {
-		Conf.VolStart, _ = strconv.Atoi(m[0])
-		Conf.VolEnd = Conf.VolStart
+		in.VolStart, _ = strconv.Atoi(m[0])
+		in.VolEnd = in.VolStart
 	} else {
-		Conf.VolStart, _ = strconv.Atoi(m[0])
-		Conf.VolEnd, _ = strconv.Atoi(m[1])
+		in.VolStart, _ = strconv.Atoi(m[0])
+		in.VolEnd, _ = strconv.Atoi(m[1])
 	}
 	return
 }
 
 // PageRange    return true (最小值 <= 当前页码 <=  最大值)
-func PageRange(index, size int) bool {
+func (in *Input) PageRange(index, size int) bool {
 	//未设置
-	if Conf.SeqStart <= 0 {
+	if in.SeqStart <= 0 {
 		return true
 	}
 	//结束页负数
-	if Conf.SeqEnd < 0 && (index-size >= Conf.SeqEnd) {
+	if in.SeqEnd < 0 && (index-size >= in.SeqEnd) {
 		return false
 	}
 	//结束页
-	if Conf.SeqEnd > 0 {
+	if in.SeqEnd > 0 {
 		//结束了
-		if index >= Conf.SeqEnd {
+		if index >= in.SeqEnd {
 			return false
 		}
 		//起始页
-		if index+1 >= Conf.SeqStart {
+		if index+1 >= in.SeqStart {
 			return true
 		}
-	} else if index+1 >= Conf.SeqStart { //在起始页后
+	} else if index+1 >= in.SeqStart { //在起始页后
 		return true
 	}
 	return false
 }
 
 // VolumeRange    return true (最小值 <= 当前页码 <=  最大值)
-func VolumeRange(index int) bool {
+func (in *Input) VolumeRange(index int) bool {
 	//未设置
-	if Conf.VolStart <= 0 {
+	if in.VolStart <= 0 {
 		return true
 	}
 	//结束页负数
-	if Conf.VolEnd < 0 && index > Conf.VolStart {
+	if in.VolEnd < 0 && index > in.VolStart {
 		return false
 	}
 	//结束页
-	if Conf.VolEnd > 0 {
+	if in.VolEnd > 0 {
 		//结束了
-		if index >= Conf.VolEnd {
+		if index >= in.VolEnd {
 			return false
 		}
 		//起始页
-		if index+1 >= Conf.VolStart {
+		if index+1 >= in.VolStart {
 			return true
 		}
-	} else if index+1 >= Conf.VolStart { //在起始页后
+	} else if index+1 >= in.VolStart { //在起始页后
 		return true
 	}
 	return false
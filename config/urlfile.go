@@ -0,0 +1,144 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Overrides URL 文件中单行附带的参数，未设置的字段沿用命令行参数
+type Overrides struct {
+	Volume     string `json:"volume,omitempty"`  //册范围，同 -v
+	Seq        string `json:"seq,omitempty"`     //页面范围，同 -p
+	Directory  string `json:"dir,omitempty"`     //保存子目录，相对于 -O；绝对路径时直接使用
+	Format     string `json:"format,omitempty"`  //IIIF 图像请求URI，同 --format
+	Size       string `json:"size,omitempty"`    //只替换 Format 中的 size 段，如 2000,
+	FileExt    string `json:"ext,omitempty"`     //文件扩展名，同 --ext
+	CookieFile string `json:"cookies,omitempty"` //cookie 文件，同 -C
+	HeaderFile string `json:"headers,omitempty"` //header 文件，同 -H
+}
+
+// URLLine URL 文件中的一行
+type URLLine struct {
+	Url string `json:"url"`
+	Overrides
+}
+
+// overrideKeys 行内 key=value 支持的键名（含别名）
+var overrideKeys = map[string]func(o *Overrides) *string{
+	"volume":  func(o *Overrides) *string { return &o.Volume },
+	"v":       func(o *Overrides) *string { return &o.Volume },
+	"seq":     func(o *Overrides) *string { return &o.Seq },
+	"page":    func(o *Overrides) *string { return &o.Seq },
+	"p":       func(o *Overrides) *string { return &o.Seq },
+	"dir":     func(o *Overrides) *string { return &o.Directory },
+	"o":       func(o *Overrides) *string { return &o.Directory },
+	"format":  func(o *Overrides) *string { return &o.Format },
+	"size":    func(o *Overrides) *string { return &o.Size },
+	"ext":     func(o *Overrides) *string { return &o.FileExt },
+	"cookies": func(o *Overrides) *string { return &o.CookieFile },
+	"headers": func(o *Overrides) *string { return &o.HeaderFile },
+}
+
+// ParseURLLine 解析 URL 文件中的一行，支持两种写法：
+//
+//	https://example.org/book/1  volume=3:5  seq=1:20  dir=book1
+//	{"url": "https://example.org/book/1", "volume": "3:5", "dir": "book1"}
+//
+// 空行与 # 开头的注释行返回 ok=false
+func ParseURLLine(line string) (u URLLine, ok bool, err error) {
+	line = strings.TrimSpace(strings.Trim(line, "\r"))
+	if line == "" || strings.HasPrefix(line, "#") {
+		return u, false, nil
+	}
+	if strings.HasPrefix(line, "{") {
+		dec := json.NewDecoder(strings.NewReader(line))
+		dec.DisallowUnknownFields()
+		if err = dec.Decode(&u); err != nil {
+			return u, false, fmt.Errorf("无法解析: %s, %w", line, err)
+		}
+	} else {
+		fields := strings.Fields(line)
+		u.Url = fields[0]
+		for _, kv := range fields[1:] {
+			if strings.HasPrefix(kv, "#") {
+				break //行尾注释
+			}
+			k, v, found := strings.Cut(kv, "=")
+			field, known := overrideKeys[strings.ToLower(k)]
+			if !found || !known {
+				return u, false, fmt.Errorf("未知参数 %q: %s", kv, line)
+			}
+			*field(&u.Overrides) = v
+		}
+	}
+	if !strings.HasPrefix(u.Url, "http") {
+		return u, false, fmt.Errorf("无效的URL: %s", line)
+	}
+	return u, true, nil
+}
+
+// Key 任务日志中的记录键：URL 加上按固定顺序排列的覆盖参数，没有覆盖参数时即 URL。
+// 同一 URL 以不同参数出现在多行时各自记录
+func (u URLLine) Key() string {
+	var b strings.Builder
+	b.WriteString(u.Url)
+	for _, kv := range [][2]string{
+		{"volume", u.Volume}, {"seq", u.Seq}, {"dir", u.Directory}, {"format", u.Format},
+		{"size", u.Size}, {"ext", u.FileExt}, {"cookies", u.CookieFile}, {"headers", u.HeaderFile},
+	} {
+		if kv[1] != "" {
+			b.WriteString(" " + kv[0] + "=" + kv[1])
+		}
+	}
+	return b.String()
+}
+
+// IsZero 没有任何覆盖参数
+func (o Overrides) IsZero() bool {
+	return o == Overrides{}
+}
+
+// Apply 返回应用覆盖参数后的 Input 副本，并重新计算页/册范围
+func (o Overrides) Apply(in Input) Input {
+	if o.Volume != "" {
+		in.Volume = o.Volume
+	}
+	if o.Seq != "" {
+		in.Seq = o.Seq
+	}
+	if o.Directory != "" {
+		if filepath.IsAbs(o.Directory) {
+			in.Directory = o.Directory
+		} else {
+			in.Directory = filepath.Join(in.Directory, o.Directory)
+		}
+	}
+	if o.Format != "" {
+		in.Format = o.Format
+	}
+	if o.Size != "" {
+		//region/size/rotation/quality.format
+		if m := strings.Split(in.Format, "/"); len(m) == 4 {
+			m[1] = o.Size
+			in.Format = strings.Join(m, "/")
+		}
+	}
+	if o.FileExt != "" {
+		in.FileExt = o.FileExt
+		if !strings.HasPrefix(in.FileExt, ".") {
+			in.FileExt = "." + in.FileExt
+		}
+	}
+	if o.CookieFile != "" {
+		in.CookieFile = o.CookieFile
+	}
+	if o.HeaderFile != "" {
+		in.HeaderFile = o.HeaderFile
+	}
+	in.SeqStart, in.SeqEnd, in.VolStart, in.VolEnd = 0, 0, 0, 0
+	in.initSeqRange()
+	in.initVolumeRange()
+	return in
+}
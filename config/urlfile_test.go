@@ -0,0 +1,63 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseURLLine(t *testing.T) {
+	for _, line := range []string{"", "   ", "# comment", "\r"} {
+		_, ok, err := ParseURLLine(line)
+		assert.NoError(t, err)
+		assert.False(t, ok, line)
+	}
+
+	u, ok, err := ParseURLLine("https://example.org/a?id=1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "https://example.org/a?id=1", u.Url)
+	assert.True(t, u.IsZero())
+
+	u, ok, err = ParseURLLine("https://example.org/a\tv=3:5  seq=1:20 dir=book1 size=2000, # 备注")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, Overrides{Volume: "3:5", Seq: "1:20", Directory: "book1", Size: "2000,"}, u.Overrides)
+
+	u, ok, err = ParseURLLine(`{"url": "https://example.org/b", "volume": "2:2", "ext": "png"}`)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "https://example.org/b", u.Url)
+	assert.Equal(t, Overrides{Volume: "2:2", FileExt: "png"}, u.Overrides)
+
+	_, _, err = ParseURLLine("https://example.org/a color=red")
+	assert.Error(t, err)
+	_, _, err = ParseURLLine("example.org/a")
+	assert.Error(t, err)
+}
+
+func TestOverridesApply(t *testing.T) {
+	in := Input{Directory: "downloads", Format: "full/full/0/default.jpg", FileExt: ".jpg", Volume: "", Seq: ""}
+	in.initVolumeRange()
+
+	out := Overrides{Volume: "3:5", Seq: "2:10", Directory: "book1", Size: "1000,", FileExt: "png"}.Apply(in)
+	assert.Equal(t, filepath.Join("downloads", "book1"), out.Directory)
+	assert.Equal(t, "full/1000,/0/default.jpg", out.Format)
+	assert.Equal(t, ".png", out.FileExt)
+	assert.Equal(t, 3, out.VolStart)
+	assert.Equal(t, 5, out.VolEnd)
+	assert.Equal(t, 2, out.SeqStart)
+	assert.Equal(t, 10, out.SeqEnd)
+
+	assert.False(t, out.VolumeRange(1))
+	assert.True(t, out.VolumeRange(2))
+	assert.False(t, out.PageRange(0, 20))
+	assert.True(t, out.PageRange(1, 20))
+
+	// 原值不受影响
+	assert.Equal(t, "downloads", in.Directory)
+	assert.Equal(t, 0, in.VolStart)
+	assert.True(t, in.VolumeRange(1))
+}
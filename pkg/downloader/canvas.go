@@ -0,0 +1,184 @@
+package downloader
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+)
+
+// colorKind 画布的颜色模型，值越大能容纳的颜色越多
+type colorKind int
+
+const (
+	kindNone  colorKind = iota
+	kindGray            // 灰度扫描：*image.Gray，每像素 1 字节
+	kindYCbCr           // 彩色 JPEG：4:4:4 的 *image.YCbCr，每像素 3 字节，编码 JPEG 时无需转换
+	kindRGBA            // 其余（PNG、带透明度等）：*image.RGBA
+)
+
+func kindOf(img image.Image) colorKind {
+	switch img.(type) {
+	case *image.Gray:
+		return kindGray
+	case *image.YCbCr:
+		return kindYCbCr
+	}
+	return kindRGBA
+}
+
+// canvas 拼接的目标图像。颜色模型按拼图选择，出现颜色更多的拼图时整体转换；
+// 各拼图写入互不重叠的区域，put 可并发调用，只在转换颜色模型时互斥
+type canvas struct {
+	mu   sync.RWMutex
+	rect image.Rectangle
+	kind colorKind
+	img  draw.Image // 收到第一块拼图前为 nil
+}
+
+func newCanvas(width, height int) *canvas {
+	return &canvas{rect: image.Rect(0, 0, width, height)}
+}
+
+// put 将 tile 的左上角对齐 cell.Min，复制到 cell 内
+func (c *canvas) put(tile image.Image, cell image.Rectangle) error {
+	sb := tile.Bounds()
+	r := image.Rectangle{Min: cell.Min, Max: cell.Min.Add(sb.Size())}.Intersect(cell).Intersect(c.rect)
+	if r.Empty() {
+		return nil
+	}
+	kind := kindOf(tile)
+	c.mu.RLock()
+	if kind > c.kind {
+		c.mu.RUnlock()
+		c.promote(kind)
+		c.mu.RLock()
+	}
+	copyRect(c.img, r, tile, sb.Min)
+	c.mu.RUnlock()
+	return nil
+}
+
+// promote 将画布转换为能容纳 kind 的颜色模型
+func (c *canvas) promote(kind colorKind) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if kind <= c.kind {
+		return // 其他协程已转换
+	}
+	img := newImage(kind, c.rect)
+	if c.img != nil {
+		copyRect(img, c.rect, c.img, c.rect.Min)
+	}
+	c.img, c.kind = img, kind
+}
+
+// image 返回拼接结果，没有任何拼图时为空白的 RGBA 图像
+func (c *canvas) image() image.Image {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	switch img := c.img.(type) {
+	case nil:
+		return image.NewRGBA(c.rect)
+	case *ycbcr:
+		return img.YCbCr // jpeg.Encode 对 *image.YCbCr 直接编码
+	}
+	return c.img
+}
+
+func newImage(kind colorKind, r image.Rectangle) draw.Image {
+	switch kind {
+	case kindGray:
+		return image.NewGray(r)
+	case kindYCbCr:
+		img := image.NewYCbCr(r, image.YCbCrSubsampleRatio444)
+		// 色度置中，未写入的区域（缺失的拼图）为黑色而不是绿色
+		fill(img.Cb, 128)
+		fill(img.Cr, 128)
+		return &ycbcr{img}
+	}
+	return image.NewRGBA(r)
+}
+
+func fill(b []byte, v byte) {
+	if len(b) == 0 {
+		return
+	}
+	b[0] = v
+	for n := 1; n < len(b); n *= 2 {
+		copy(b[n:], b[:n])
+	}
+}
+
+// ycbcr 可写的 4:4:4 YCbCr 图像，只在 copyRect 的通用分支中用到 Set
+type ycbcr struct{ *image.YCbCr }
+
+func (p *ycbcr) Set(x, y int, c color.Color) {
+	if !(image.Point{X: x, Y: y}.In(p.Rect)) {
+		return
+	}
+	yc := color.YCbCrModel.Convert(c).(color.YCbCr)
+	p.Y[p.YOffset(x, y)] = yc.Y
+	i := p.COffset(x, y)
+	p.Cb[i], p.Cr[i] = yc.Cb, yc.Cr
+}
+
+// copyRect 将 src 中以 sp 为左上角的区域复制到 dst 的 r，常见的颜色模型组合按行整块复制
+func copyRect(dst draw.Image, r image.Rectangle, src image.Image, sp image.Point) {
+	switch d := dst.(type) {
+	case *image.Gray:
+		if s, ok := src.(*image.Gray); ok {
+			for y := 0; y < r.Dy(); y++ {
+				di := d.PixOffset(r.Min.X, r.Min.Y+y)
+				si := s.PixOffset(sp.X, sp.Y+y)
+				copy(d.Pix[di:di+r.Dx()], s.Pix[si:si+r.Dx()])
+			}
+			return
+		}
+	case *ycbcr:
+		switch s := src.(type) {
+		case *image.YCbCr:
+			copyYCbCr(d.YCbCr, r, s, sp)
+			return
+		case *image.Gray:
+			for y := 0; y < r.Dy(); y++ {
+				di := d.YOffset(r.Min.X, r.Min.Y+y)
+				si := s.PixOffset(sp.X, sp.Y+y)
+				copy(d.Y[di:di+r.Dx()], s.Pix[si:si+r.Dx()])
+				ci := d.COffset(r.Min.X, r.Min.Y+y)
+				fill(d.Cb[ci:ci+r.Dx()], 128)
+				fill(d.Cr[ci:ci+r.Dx()], 128)
+			}
+			return
+		}
+	case *image.RGBA:
+		if s, ok := src.(*ycbcr); ok {
+			src = s.YCbCr // 让 draw 使用 YCbCr 的快速路径
+		}
+	}
+	// *image.RGBA 目标由 draw 处理，对 RGBA、NRGBA、YCbCr、Gray 等来源有快速路径
+	draw.Draw(dst, r, src, sp, draw.Src)
+}
+
+// copyYCbCr 复制到 4:4:4 的 d，来源为 4:2:0 等色度抽样时逐像素展开色度
+func copyYCbCr(d *image.YCbCr, r image.Rectangle, s *image.YCbCr, sp image.Point) {
+	w := r.Dx()
+	for y := 0; y < r.Dy(); y++ {
+		di := d.YOffset(r.Min.X, r.Min.Y+y)
+		si := s.YOffset(sp.X, sp.Y+y)
+		copy(d.Y[di:di+w], s.Y[si:si+w])
+
+		ci := d.COffset(r.Min.X, r.Min.Y+y)
+		if s.SubsampleRatio == image.YCbCrSubsampleRatio444 {
+			sc := s.COffset(sp.X, sp.Y+y)
+			copy(d.Cb[ci:ci+w], s.Cb[sc:sc+w])
+			copy(d.Cr[ci:ci+w], s.Cr[sc:sc+w])
+			continue
+		}
+		for x := 0; x < w; x++ {
+			sc := s.COffset(sp.X+x, sp.Y+y)
+			d.Cb[ci+x] = s.Cb[sc]
+			d.Cr[ci+x] = s.Cr[sc]
+		}
+	}
+}
@@ -1,12 +1,17 @@
 package downloader
 
 import (
-	"bookget/pkg/progressbar"
+	"bookget/pkg/event"
+	"bookget/pkg/httpclient"
+	"bookget/pkg/logger"
+	"bookget/pkg/resume"
+	"bookget/pkg/retry"
+	"bookget/pkg/validate"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
 	"os"
@@ -18,6 +23,9 @@ import (
 	"time"
 )
 
+// client 所有下载任务共用，不设超时以免大文件被中断（由 ctx 取消）
+var client = httpclient.New(httpclient.Options{})
+
 // DownloadTask 下载任务
 type DownloadTask struct {
 	URL          string            // 下载URL
@@ -31,17 +39,20 @@ type DownloadTask struct {
 	ContentSize  int64             // 文件大小
 	Success      bool              // 是否成功
 	ErrorMessage string            // 错误信息
-	buffer       *bytes.Buffer     // 内存缓冲区
-	mu           sync.Mutex        // 互斥锁
 
 	supportsHEAD  bool // 是否支持HEAD请求
 	supportsRange bool // 是否支持Range请求
 	testedMethods bool // 是否已检测过支持的方法
+
+	validators resume.Validators // ETag/Last-Modified/Content-Length，用于跨次续传
+	counted    bool              // 大小已计入 totalSize，重试时不再重复
+	segmented  bool              // 已开始过分段下载：之后的重试续传的字节已由 copyBody 计入进度
 }
 
 type DownloadManager struct {
 	tasks         []*DownloadTask
 	maxConcurrent int
+	retries       int // 每个任务的尝试次数
 	successCount  int32
 	failCount     int32
 	totalTasks    int   // 总任务数
@@ -52,15 +63,14 @@ type DownloadManager struct {
 	cancel        context.CancelFunc
 	mu            sync.Mutex
 
-	started    bool      // 标记是否已开始
-	showPrompt bool      // 是否显示开始提示
-	allDone    bool      // 标记所有任务是否已完成
-	startTime  time.Time // 记录开始时间
+	started   bool      // 标记是否已开始
+	allDone   bool      // 标记所有任务是否已完成
+	startTime time.Time // 记录开始时间
 
-	bar        *progressbar.ProgressBar // 总进度条(基于任务数)
-	totalSize  int64                    // 总文件大小
-	downloaded int64                    // 已下载字节数
-	UseSizeBar bool                     //使用totalSize显示进度条
+	events     event.Bus // 进度事件，终端进度条是其中一个订阅者
+	totalSize  int64     // 总文件大小
+	downloaded int64     // 已下载字节数
+	UseSizeBar bool      //使用totalSize显示进度条
 }
 
 // NewDownloadManager 创建下载管理器
@@ -69,12 +79,33 @@ func NewDownloadManager(ctx context.Context, cancel context.CancelFunc, maxTasks
 	if maxTasks < 1 {
 		maxTasks = maxConcurrent
 	}
-	return &DownloadManager{
+	dm := &DownloadManager{
 		maxConcurrent: maxTasks,
+		retries:       maxRetries,
 		sem:           make(chan struct{}, maxConcurrent),
 		ctx:           ctx,
 		cancel:        cancel,
-		showPrompt:    true,
+	}
+	dm.events.Subscribe(&Terminal{Summary: true})
+	return dm
+}
+
+// Events 返回事件总线，可订阅任务开始、下载进度、完成、失败等事件
+func (dm *DownloadManager) Events() *event.Bus {
+	return &dm.events
+}
+
+func (dm *DownloadManager) emit(e event.Event) {
+	if e.Job == "" {
+		e.Job = "downloading"
+	}
+	dm.events.Emit(e)
+}
+
+// SetRetries 设置每个任务的尝试次数（--retries），小于 1 时不变
+func (dm *DownloadManager) SetRetries(n int) {
+	if n > 0 {
+		dm.retries = n
 	}
 }
 
@@ -97,37 +128,33 @@ func (dm *DownloadManager) AddTask(url, method string, headers map[string]string
 		SaveDir:  saveDir,
 		FileName: filename,
 		Threads:  threads,
-		buffer:   bytes.NewBuffer(nil),
 	}
 
 	dm.tasks = append(dm.tasks, task)
 }
 
-// SetBar 设置进度条
+// SetBar 预先告知任务总数（多次 AddTask + Start 时进度按总数显示）
 func (dm *DownloadManager) SetBar(maxTasks int) {
-	dm.mu.Lock()
-	defer dm.mu.Unlock()
-
-	dm.bar = progressbar.Default(int64(maxTasks), "downloading")
+	dm.emit(event.Event{Kind: event.JobQueued, Total: int64(maxTasks), InBytes: dm.UseSizeBar})
 }
 
-// Start 开始下载
+// Start 开始下载，已执行过的任务（多次 AddTask + Start 时）不再重复下载
 func (dm *DownloadManager) Start() {
-	dm.mu.Lock()
-	dm.startTime = time.Now()
-	// 初始化进度条
-	if dm.bar == nil {
-		dm.bar = progressbar.Default(int64(len(dm.tasks)), "downloading")
-	}
-	if dm.showPrompt {
-		fmt.Printf("\n开始下载任务 (最大并发数: %d)...\n", dm.maxConcurrent)
-		fmt.Printf("总任务数: %d\n", len(dm.tasks))
-		dm.showPrompt = false
+	tasks := dm.getTasksToProcess()
+	if len(tasks) == 0 {
+		dm.mu.Lock()
+		dm.allDone = true
+		dm.mu.Unlock()
+		return
 	}
 
+	dm.mu.Lock()
+	dm.startTime = time.Now()
 	dm.mu.Unlock()
+	dm.emit(event.Event{Kind: event.JobStarted, Total: int64(len(tasks)), InBytes: dm.UseSizeBar, Workers: dm.maxConcurrent})
+	var succeeded, failed int32
 
-	for _, task := range dm.tasks {
+	for _, task := range tasks {
 		dm.wg.Add(1)
 		go func(t *DownloadTask) {
 			dm.sem <- struct{}{}
@@ -136,34 +163,46 @@ func (dm *DownloadManager) Start() {
 				dm.wg.Done()
 			}()
 
-			err := t.Download(dm.ctx, dm) // 传入dm以更新总进度
+			// 已取消（如 Ctrl-C）时不再开始新任务
+			err := dm.ctx.Err()
+			if err == nil {
+				var last error
+				err = retry.New(dm.retries).Do(dm.ctx, func(attempt int) error {
+					if attempt > 1 {
+						dm.emit(event.Event{Kind: event.TaskRetried, Task: t.URL, File: t.path(), Attempt: attempt, Err: last})
+					}
+					last = t.Download(dm.ctx, dm) // 传入dm以更新总进度
+					return last
+				})
+			}
 
 			dm.mu.Lock()
 			if err != nil {
 				atomic.AddInt32(&dm.failCount, 1)
 				t.Success = false
 				t.ErrorMessage = err.Error()
-				fmt.Printf("下载失败: %s (%s)\n", t.FileName, err)
 			} else {
 				atomic.AddInt32(&dm.successCount, 1)
 				t.Success = true
-				if !dm.UseSizeBar {
-					_ = dm.bar.Add(1) // 每个任务完成时进度条+1
-				}
 			}
 			dm.mu.Unlock()
+
+			if err != nil {
+				atomic.AddInt32(&failed, 1)
+				logger.FromContext(dm.ctx).Warn("下载失败", logger.KeyUrl, t.URL, "file", t.FileName, "error", err)
+				dm.emit(event.Event{Kind: event.TaskFailed, Task: t.URL, File: t.path(), Err: err})
+			} else {
+				atomic.AddInt32(&succeeded, 1)
+				dm.emit(event.Event{Kind: event.TaskCompleted, Task: t.URL, File: t.path(), Bytes: t.ContentSize})
+			}
 		}(task)
 	}
 
 	dm.wg.Wait()
 
-	elapsed := time.Since(dm.startTime)
+	dm.emit(event.Event{Kind: event.JobFinished, Succeeded: int(succeeded), Failed: int(failed),
+		Elapsed: time.Since(dm.startTime)})
 	dm.mu.Lock()
-	if dm.bar != nil {
-		_ = dm.bar.Finish()
-	}
-	fmt.Printf("\n下载完成! 成功: %d, 失败: %d, 耗时: %v\n",
-		dm.successCount, dm.failCount, elapsed.Round(time.Millisecond))
 	dm.allDone = true
 	dm.mu.Unlock()
 }
@@ -197,27 +236,36 @@ func (dm *DownloadManager) WaitAll() {
 	}
 }
 
+// Tasks 返回全部任务，Start 结束后可据此汇总结果
+func (dm *DownloadManager) Tasks() []*DownloadTask {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	return append([]*DownloadTask(nil), dm.tasks...)
+}
+
 // Stop 停止所有下载
 func (dm *DownloadManager) Stop() {
 	dm.cancel()
 }
 
+// path 保存路径，文件名确定之前为目录
+func (task *DownloadTask) path() string {
+	return filepath.Join(task.SaveDir, task.FileName)
+}
+
 // Download 执行下载任务
 func (task *DownloadTask) Download(ctx context.Context, dm *DownloadManager) error {
 	// 1. 获取文件信息
 	if err := task.getFileInfo(ctx); err != nil {
-		log.Printf("警告: %v", err)
+		logger.FromContext(ctx).Debug("获取文件信息失败", logger.KeyUrl, task.URL, "error", err)
 		if task.FileName == "" {
 			task.FileName = getFileNameFromURL(task.URL)
 		}
 	}
-	atomic.AddInt64(&dm.totalSize, task.ContentSize)
-
-	if dm.UseSizeBar {
-		dm.mu.Lock()
-		defer dm.mu.Unlock()
-		// 更新进度条
-		dm.bar = progressbar.Default(dm.totalSize, "downloading")
+	if !task.counted {
+		task.counted = true
+		atomic.AddInt64(&dm.totalSize, task.ContentSize)
+		dm.emit(event.Event{Kind: event.TaskStarted, Task: task.URL, File: task.path(), Total: task.ContentSize})
 	}
 
 	// 2. 自动获取文件名
@@ -234,62 +282,166 @@ func (task *DownloadTask) Download(ctx context.Context, dm *DownloadManager) err
 		}
 	}
 
-	// 3. 多线程下载
-	if task.ContentSize > int64(minFileSize)*10 && task.Threads > 1 && task.supportsRange {
-		if err := task.multiThreadDownload(ctx, dm); err != nil {
-			return err
-		}
-	} else {
-		if err := task.singleThreadDownload(ctx, dm); err != nil {
-			return err
-		}
+	// 3. 下载到同目录下的 .part 文件，各分段按偏移写入，内存占用与文件大小无关
+	if err := os.MkdirAll(task.SaveDir, 0755); err != nil {
+		return fmt.Errorf("创建目录失败: %v", err)
 	}
+	filePath := filepath.Join(task.SaveDir, task.FileName)
+	partPath := filePath + ".part"
 
-	// 4. 保存文件
-	if task.buffer.Len() > 0 {
-		if err := os.MkdirAll(task.SaveDir, 0755); err != nil {
-			return fmt.Errorf("创建目录失败: %v", err)
-		}
-
-		filePath := filepath.Join(task.SaveDir, task.FileName)
-		if err := os.WriteFile(filePath, task.buffer.Bytes(), 0644); err != nil {
-			return fmt.Errorf("写入文件失败: %v", err)
-		}
+	// 支持 Range 时分段下载并记录 sidecar，下次运行只请求缺少的区间
+	if task.ContentSize > int64(minFileSize)*10 && task.supportsRange {
+		return task.segmentedDownload(ctx, dm, filePath, partPath)
 	}
 
+	f, err := os.OpenFile(partPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("创建文件失败: %v", err)
+	}
+	written, err := task.singleThreadDownload(ctx, dm, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && task.ContentSize > 0 && written != task.ContentSize {
+		err = fmt.Errorf("文件大小不符: 期望 %d 字节，实际 %d 字节", task.ContentSize, written)
+	}
+	if err == nil && written > 0 {
+		err = validate.File(partPath, filepath.Ext(task.FileName), task.ContentType)
+	}
+	// 4. 校验通过后 rename 为目标文件；失败、中断或无内容时不留下文件
+	if err != nil || written == 0 {
+		_ = os.Remove(partPath)
+		return err
+	}
+	if err := os.Rename(partPath, filePath); err != nil {
+		_ = os.Remove(partPath)
+		return fmt.Errorf("写入文件失败: %v", err)
+	}
 	return nil
 }
 
-// 多线程下载
-func (task *DownloadTask) multiThreadDownload(ctx context.Context, dm *DownloadManager) error {
-	// 确保文件大小已知且有效
-	if task.ContentSize <= 0 {
-		return fmt.Errorf("无法使用多线程下载: 文件大小未知")
+// segmentedDownload 分段下载到 partPath。失败或中断时保留 .part 与 sidecar 供下次续传，
+// 服务器上的文件变化（Validators 不一致）时从头下载
+func (task *DownloadTask) segmentedDownload(ctx context.Context, dm *DownloadManager, filePath, partPath string) error {
+	state, resumed := resume.Open(partPath, task.URL, task.validators)
+	flag := os.O_CREATE | os.O_RDWR
+	if !resumed {
+		flag |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(partPath, flag, 0644)
+	if err != nil {
+		return fmt.Errorf("创建文件失败: %v", err)
+	}
+	// 只有第一次尝试续传的字节来自上次运行，本次运行内重试时不再重复计入
+	if resumed && !task.segmented {
+		done := state.Completed()
+		logger.FromContext(ctx).Info("继续上次的下载", logger.KeyUrl, task.URL, "file", task.FileName, "bytes", done)
+		atomic.AddInt64(&dm.downloaded, done)
+		dm.emit(event.Event{Kind: event.Progress, Task: task.URL, Bytes: done})
+	}
+	task.segmented = true
+	if !resumed {
+		err = f.Truncate(task.ContentSize)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	state.Attach(f)
+
+	err = task.multiThreadDownload(ctx, dm, f, state)
+	if serr := state.Save(); err == nil {
+		err = serr
+	}
+	state.Attach(nil)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if errors.Is(err, resume.ErrChanged) {
+		// 已写入的内容属于旧文件，重试时从头下载
+		_ = os.Remove(partPath)
+		_ = state.Remove()
+	}
+	if err != nil {
+		return err
+	}
+	// 4. 全部区间完成后 rename 为目标文件
+	if done := state.Completed(); done != task.ContentSize {
+		_ = os.Remove(partPath)
+		_ = state.Remove()
+		return fmt.Errorf("文件大小不符: 期望 %d 字节，实际 %d 字节", task.ContentSize, done)
+	}
+	// 内容无效（错误页、损坏的图片）时从头重新下载
+	if err := validate.File(partPath, filepath.Ext(task.FileName), task.ContentType); err != nil {
+		_ = os.Remove(partPath)
+		_ = state.Remove()
+		return err
+	}
+	if err := os.Rename(partPath, filePath); err != nil {
+		return fmt.Errorf("写入文件失败: %v", err)
+	}
+	return state.Remove()
+}
+
+// copyBody 将 resp.Body 写入 w 并更新总进度，ctx 取消时返回 ctx.Err()
+func copyBody(ctx context.Context, dm *DownloadManager, task *DownloadTask, w io.Writer, body io.Reader) (int64, error) {
+	var written int64
+	buf := make([]byte, 32*1024) // 32KB缓冲区
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		n, readErr := body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return written, err
+			}
+			written += int64(n)
+			atomic.AddInt64(&dm.downloaded, int64(n))
+			dm.emit(event.Event{Kind: event.Progress, Task: task.URL, Bytes: int64(n)})
+		}
+		if readErr == io.EOF {
+			return written, nil
+		}
+		if readErr != nil {
+			return written, readErr
+		}
 	}
+}
+
+// 多线程下载 state 中缺少的区间，每个分段写入 f 中对应的偏移
+func (task *DownloadTask) multiThreadDownload(ctx context.Context, dm *DownloadManager, f *os.File, state *resume.State) error {
+	threads := max(task.Threads, 1)
+	segments := resume.Split(state.Missing(), threads, int64(minFileSize)*64)
 
-	chunkSize := task.ContentSize / int64(task.Threads)
-	lastChunkSize := task.ContentSize % int64(task.Threads)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	var wg sync.WaitGroup
-	wg.Add(task.Threads)
+	sem := make(chan struct{}, threads)
 
 	var firstErr error
 	var errOnce sync.Once
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel() // 任一分段失败即停止其余分段
+		})
+	}
 
-	for i := 0; i < task.Threads; i++ {
-		go func(threadID int) {
+	for _, seg := range segments {
+		wg.Add(1)
+		go func(start, end int64) {
 			defer wg.Done()
-
-			start := int64(threadID) * chunkSize
-			end := start + chunkSize - 1
-
-			if threadID == task.Threads-1 {
-				end += lastChunkSize
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				return
 			}
 
 			req, err := http.NewRequest(task.Method, task.URL, nil)
 			if err != nil {
-				errOnce.Do(func() { firstErr = err })
+				fail(err)
 				return
 			}
 
@@ -301,63 +453,52 @@ func (task *DownloadTask) multiThreadDownload(ctx context.Context, dm *DownloadM
 				req.Header.Set("User-Agent", userAgent)
 			}
 
-			// 设置Range头
+			// 设置Range头；If-Range 使服务器在文件变化时返回整个文件，而不是拼接新旧内容
 			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+			ifRange := task.validators.IfRange()
+			if ifRange != "" {
+				req.Header.Set("If-Range", ifRange)
+			}
 
-			client := &http.Client{}
 			resp, err := client.Do(req.WithContext(ctx))
 			if err != nil {
-				errOnce.Do(func() { firstErr = err })
+				fail(err)
 				return
 			}
 			defer resp.Body.Close()
 
-			if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
-				errOnce.Do(func() {
-					firstErr = fmt.Errorf("服务器返回错误状态码: %s", resp.Status)
-				})
+			// 返回 200 说明服务器忽略了 Range 或 If-Range 不再匹配，内容不能按偏移写入
+			if resp.StatusCode == http.StatusOK && ifRange != "" {
+				fail(resume.ErrChanged)
+				return
+			}
+			if resp.StatusCode != http.StatusPartialContent {
+				fail(retry.Status(resp.StatusCode))
 				return
 			}
 
-			// 读取数据到缓冲区
-			buf := make([]byte, 32*1024) // 32KB缓冲区
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				default:
-					n, readErr := resp.Body.Read(buf)
-					if n > 0 {
-						task.mu.Lock()
-						task.buffer.Write(buf[:n])
-						task.mu.Unlock()
-
-						atomic.AddInt64(&dm.downloaded, int64(n))
-						if dm.UseSizeBar {
-							_ = dm.bar.Add(n)
-						}
-					}
-
-					if readErr != nil {
-						if readErr != io.EOF {
-							errOnce.Do(func() { firstErr = readErr })
-						}
-						return
-					}
-				}
+			n, err := copyBody(ctx, dm, task, state.WriterAt(f, start), io.LimitReader(resp.Body, end-start+1))
+			if err == nil && n != end-start+1 {
+				err = fmt.Errorf("分段 %d-%d 不完整: %d 字节", start, end, n)
+			}
+			if err != nil {
+				fail(err)
 			}
-		}(i)
+		}(seg.Start, seg.End)
 	}
 
 	wg.Wait()
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
 	return firstErr
 }
 
 // 单线程下载
-func (task *DownloadTask) singleThreadDownload(ctx context.Context, dm *DownloadManager) error {
+func (task *DownloadTask) singleThreadDownload(ctx context.Context, dm *DownloadManager, f *os.File) (int64, error) {
 	req, err := http.NewRequest(task.Method, task.URL, bytes.NewReader(task.Body))
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	// 设置请求头
@@ -368,43 +509,33 @@ func (task *DownloadTask) singleThreadDownload(ctx context.Context, dm *Download
 		req.Header.Set("User-Agent", userAgent)
 	}
 
-	client := &http.Client{}
 	resp, err := client.Do(req.WithContext(ctx))
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("服务器返回错误状态码: %s", resp.Status)
-	}
-
-	buf := make([]byte, 32*1024)
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		default:
-			n, readErr := resp.Body.Read(buf)
-			if n > 0 {
-				task.mu.Lock()
-				task.buffer.Write(buf[:n])
-				task.mu.Unlock()
-
-				atomic.AddInt64(&dm.downloaded, int64(n))
-				if dm.UseSizeBar {
-					_ = dm.bar.Add(n)
-				}
-			}
-
-			if readErr != nil {
-				if readErr != io.EOF {
-					return readErr
-				}
-				return nil
-			}
+		return 0, retry.Status(resp.StatusCode)
+	}
+	// 以实际响应的长度为准（POST 等请求与探测到的大小可能不同）
+	if resp.ContentLength > 0 {
+		task.ContentSize = resp.ContentLength
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		task.ContentType = ct
+	}
+	// 期望文件时却返回网页，可能是人机验证或登录过期
+	var body io.Reader = resp.Body
+	if ct := resp.Header.Get("Content-Type"); strings.Contains(ct, "html") && !isHTMLFile(task.FileName) {
+		head := make([]byte, 4096)
+		n, _ := io.ReadFull(resp.Body, head)
+		if retry.IsChallenge(ct, head[:n]) {
+			return 0, retry.ErrChallenge
 		}
+		body = io.MultiReader(bytes.NewReader(head[:n]), resp.Body)
 	}
+	return copyBody(ctx, dm, task, f, body)
 }
 
 // 获取文件信息
@@ -439,7 +570,6 @@ func (task *DownloadTask) getFileInfo(ctx context.Context) error {
 		req.Header.Set("User-Agent", userAgent)
 	}
 
-	client := &http.Client{}
 	resp, err := client.Do(req.WithContext(ctx))
 	if err != nil {
 		return err
@@ -453,7 +583,7 @@ func (task *DownloadTask) getFileInfo(ctx context.Context) error {
 	}
 
 	if resp.StatusCode != expectedStatus {
-		return fmt.Errorf("服务器返回错误状态码: %s", resp.Status)
+		return retry.Status(resp.StatusCode)
 	}
 
 	// 处理分块传输的情况
@@ -485,6 +615,8 @@ func (task *DownloadTask) getFileInfo(ctx context.Context) error {
 		return fmt.Errorf("无法确定文件大小: 没有Content-Length头且不是分块传输")
 	}
 
+	task.validators = resume.FromHeader(resp.Header, task.ContentSize)
+
 	// 获取内容类型
 	task.ContentType = resp.Header.Get("Content-Type")
 
@@ -518,11 +650,11 @@ func (task *DownloadTask) detectSupportedMethods(ctx context.Context) error {
 		headReq.Header.Set("User-Agent", userAgent)
 	}
 
-	client := &http.Client{}
 	resp, err := client.Do(headReq.WithContext(ctx))
 
 	if err == nil && resp.StatusCode == http.StatusOK {
 		task.supportsHEAD = true
+		task.supportsRange = resp.Header.Get("Accept-Ranges") == "bytes"
 		resp.Body.Close()
 	} else {
 		// HEAD请求失败，尝试Range请求
@@ -555,6 +687,12 @@ func (task *DownloadTask) detectSupportedMethods(ctx context.Context) error {
 	return nil
 }
 
+// isHTMLFile 要下载的本身就是网页
+func isHTMLFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".html" || ext == ".htm"
+}
+
 // 辅助函数: 从URL获取文件名
 func getFileNameFromURL(rawURL string) string {
 	u, err := url.Parse(rawURL)
@@ -0,0 +1,241 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bookget/pkg/event"
+	"bookget/pkg/resume"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testPDF 生成 size 字节、首尾为 PDF 标记的随机内容
+func testPDF(size int, seed int64) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	copy(data, "%PDF-1.7\n")
+	copy(data[size-7:], "\n%%EOF\n")
+	return data
+}
+
+func TestMultiThreadDownload(t *testing.T) {
+	data := testPDF(300*1024, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "book.pdf", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dm := NewDownloadManager(ctx, cancel, 1)
+	dm.AddTask(srv.URL+"/book.pdf", "GET", nil, nil, dir, "book.pdf", 8)
+	dm.Start()
+
+	task := dm.Tasks()[0]
+	require.True(t, task.Success, task.ErrorMessage)
+	assert.True(t, task.supportsRange)
+	got, err := os.ReadFile(filepath.Join(dir, "book.pdf"))
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(data, got), "分段应按偏移写入")
+	assert.NoFileExists(t, filepath.Join(dir, "book.pdf.part"))
+}
+
+func TestDownloadEvents(t *testing.T) {
+	data := testPDF(4*1024, 3)
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// b.pdf 不存在；a.pdf 第一次 GET 返回 502，重试后成功
+		switch {
+		case r.URL.Path == "/b.pdf":
+			http.NotFound(w, r)
+			return
+		case r.Method == http.MethodGet && calls.Add(1) == 1:
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		http.ServeContent(w, r, "a.pdf", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dm := NewDownloadManager(ctx, cancel, 1)
+	dm.SetRetries(2)
+	var mu sync.Mutex
+	kinds := make(map[event.Kind]int)
+	var progressed int64
+	var finished event.Event
+	dm.Events().Subscribe(event.Func(func(e event.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		kinds[e.Kind]++
+		switch e.Kind {
+		case event.Progress:
+			progressed += e.Bytes
+		case event.JobFinished:
+			finished = e
+		}
+	}))
+	dm.AddTask(srv.URL+"/a.pdf", "GET", nil, nil, dir, "a.pdf", 1)
+	dm.AddTask(srv.URL+"/b.pdf", "GET", nil, nil, dir, "b.pdf", 1)
+	dm.Start()
+
+	assert.Equal(t, 1, kinds[event.JobStarted])
+	assert.Equal(t, 2, kinds[event.TaskStarted])
+	assert.Equal(t, 1, kinds[event.TaskRetried]) // 404 不重试
+	assert.Equal(t, 1, kinds[event.TaskCompleted])
+	assert.Equal(t, 1, kinds[event.TaskFailed])
+	assert.EqualValues(t, len(data), progressed)
+	assert.Equal(t, 1, finished.Succeeded)
+	assert.Equal(t, 1, finished.Failed)
+}
+
+func TestDownloadSizeMismatch(t *testing.T) {
+	// 声明的长度与实际内容不符（连接提前关闭）时不应生成目标文件
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "20000")
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(make([]byte, 1000))
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dm := NewDownloadManager(ctx, cancel, 1)
+	dm.SetRetries(1)
+	dm.AddTask(srv.URL+"/a.jpg", "GET", nil, nil, dir, "a.jpg", 1)
+	dm.Start()
+
+	assert.False(t, dm.Tasks()[0].Success)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestResumeDownload(t *testing.T) {
+	data := testPDF(200*1024, 2)
+	var mu sync.Mutex
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "book.pdf", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	// 模拟上次下载了前一半后中断
+	dir := t.TempDir()
+	part := filepath.Join(dir, "book.pdf.part")
+	half := int64(len(data) / 2)
+	require.NoError(t, os.WriteFile(part, append(append([]byte{}, data[:half]...), make([]byte, len(data)-int(half))...), 0644))
+	state, _ := resume.Open(part, srv.URL+"/book.pdf", resume.Validators{ETag: `"v1"`, ContentLength: int64(len(data))})
+	state.Add(0, half-1)
+	require.NoError(t, state.Save())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dm := NewDownloadManager(ctx, cancel, 1)
+	dm.AddTask(srv.URL+"/book.pdf", "GET", nil, nil, dir, "book.pdf", 4)
+	dm.Start()
+
+	task := dm.Tasks()[0]
+	require.True(t, task.Success, task.ErrorMessage)
+	got, err := os.ReadFile(filepath.Join(dir, "book.pdf"))
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(data, got))
+	assert.NoFileExists(t, resume.Path(part))
+	var segments int
+	for _, r := range ranges {
+		if !strings.HasPrefix(r, "bytes=") || r == "bytes=0-0" {
+			continue
+		}
+		segments++
+		start, err := strconv.ParseInt(strings.SplitN(r[len("bytes="):], "-", 2)[0], 10, 64)
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, start, half, "只应请求缺少的区间")
+	}
+	assert.Positive(t, segments)
+}
+
+func TestResumeChanged(t *testing.T) {
+	old, data := testPDF(200*1024, 2), testPDF(200*1024, 3)
+	var mu sync.Mutex
+	etag, body := `"v1"`, old
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		// 第一个续传分段请求到达时文件已被替换
+		if r.Header.Get("If-Range") != "" {
+			etag, body = `"v2"`, data
+		}
+		w.Header().Set("ETag", etag)
+		b := body
+		mu.Unlock()
+		http.ServeContent(w, r, "book.pdf", time.Time{}, bytes.NewReader(b))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	part := filepath.Join(dir, "book.pdf.part")
+	half := int64(len(old) / 2)
+	require.NoError(t, os.WriteFile(part, append(append([]byte{}, old[:half]...), make([]byte, len(old)-int(half))...), 0644))
+	state, _ := resume.Open(part, srv.URL+"/book.pdf", resume.Validators{ETag: `"v1"`, ContentLength: int64(len(old))})
+	state.Add(0, half-1)
+	require.NoError(t, state.Save())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dm := NewDownloadManager(ctx, cancel, 1)
+	dm.SetRetries(3)
+	dm.AddTask(srv.URL+"/book.pdf", "GET", nil, nil, dir, "book.pdf", 4)
+	dm.Start()
+
+	task := dm.Tasks()[0]
+	require.True(t, task.Success, task.ErrorMessage)
+	got, err := os.ReadFile(filepath.Join(dir, "book.pdf"))
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(data, got), "不应把新文件拼到旧文件的前一半后面")
+	assert.NoFileExists(t, resume.Path(part))
+}
+
+func TestDownloadInvalidContent(t *testing.T) {
+	// 返回 200 的错误页不应保存为页面
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("<!DOCTYPE html><html><body>image not available</body></html>"))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dm := NewDownloadManager(ctx, cancel, 1)
+	dm.SetRetries(1)
+	dm.AddTask(srv.URL+"/0001.jpg", "GET", nil, nil, dir, "0001.jpg", 1)
+	dm.Start()
+
+	task := dm.Tasks()[0]
+	assert.False(t, task.Success)
+	assert.Contains(t, task.ErrorMessage, "页面内容无效")
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
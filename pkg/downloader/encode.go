@@ -0,0 +1,267 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// tiffStripBytes 每个 strip 未压缩时的大致字节数
+const tiffStripBytes = 256 << 10
+
+// needBigTIFF 像素数据是否可能超出经典 TIFF 4GB 的偏移上限（压缩后的大小按未压缩估计）
+func needBigTIFF(width, height, ch int) bool {
+	return int64(width)*int64(height)*int64(ch)+1<<24 >= 1<<32
+}
+
+// countWriter 记录已写入的字节数，即下一个字节在文件中的偏移
+type countWriter struct {
+	w *bufio.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// tiffEntry IFD 中的一项，data 为小端序的值
+type tiffEntry struct {
+	tag, typ uint16
+	count    uint64
+	data     []byte
+}
+
+const (
+	tiffShort = 3
+	tiffLong  = 4
+	tiffLong8 = 16
+)
+
+// writeTIFF 将 src 按行编码为 8 位灰度或 RGB 的 TIFF：按 strip 分块，Deflate 压缩并使用水平差分预测。
+// big 时写为 BigTIFF。w 须实现 io.WriterAt（通常为 *os.File），最后回填 IFD 的偏移
+func writeTIFF(w io.Writer, src rowSource, big bool) error {
+	wa, ok := w.(io.WriterAt)
+	if !ok {
+		return errors.New("TIFF 编码需要可随机写入的文件")
+	}
+	b := src.Bounds()
+	width, height, ch := b.Dx(), b.Dy(), src.channels()
+	rowBytes := width * ch
+	rowsPerStrip := max(1, tiffStripBytes/rowBytes)
+
+	bw := bufio.NewWriterSize(w, 1<<20)
+	cw := &countWriter{w: bw}
+	le := binary.LittleEndian
+	if big {
+		cw.Write([]byte{'I', 'I', 43, 0, 8, 0, 0, 0})
+		cw.Write(make([]byte, 8)) // IFD 偏移，最后回填
+	} else {
+		cw.Write([]byte{'I', 'I', 42, 0})
+		cw.Write(make([]byte, 4))
+	}
+
+	// 像素数据
+	var offsets, counts []uint64
+	var strip bytes.Buffer
+	zw := zlib.NewWriter(&strip)
+	row := make([]byte, rowBytes)
+	for y := 0; y < height; y += rowsPerStrip {
+		strip.Reset()
+		zw.Reset(&strip)
+		for r := y; r < min(y+rowsPerStrip, height); r++ {
+			if err := src.readRow(r, row); err != nil {
+				return err
+			}
+			// 水平差分（Predictor 2），从右往左以免覆盖
+			for i := len(row) - 1; i >= ch; i-- {
+				row[i] -= row[i-ch]
+			}
+			zw.Write(row)
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		offsets = append(offsets, uint64(cw.n))
+		counts = append(counts, uint64(strip.Len()))
+		if _, err := cw.Write(strip.Bytes()); err != nil {
+			return err
+		}
+	}
+	if !big && cw.n >= 1<<32 {
+		return errors.New("TIFF 超出 4GB，需要使用 BigTIFF")
+	}
+
+	shorts := func(vs ...uint16) []byte {
+		p := make([]byte, 2*len(vs))
+		for i, v := range vs {
+			le.PutUint16(p[2*i:], v)
+		}
+		return p
+	}
+	long := func(v uint32) []byte { return le.AppendUint32(nil, v) }
+	offsetsEntry := func(tag uint16, vs []uint64) tiffEntry {
+		var p []byte
+		for _, v := range vs {
+			if big {
+				p = le.AppendUint64(p, v)
+			} else {
+				p = le.AppendUint32(p, uint32(v))
+			}
+		}
+		typ := uint16(tiffLong)
+		if big {
+			typ = tiffLong8
+		}
+		return tiffEntry{tag, typ, uint64(len(vs)), p}
+	}
+	bits := make([]uint16, ch)
+	photometric := uint16(1) // BlackIsZero
+	for i := range bits {
+		bits[i] = 8
+	}
+	if ch == 3 {
+		photometric = 2 // RGB
+	}
+	entries := []tiffEntry{ // 按 tag 升序
+		{256, tiffLong, 1, long(uint32(width))},
+		{257, tiffLong, 1, long(uint32(height))},
+		{258, tiffShort, uint64(ch), shorts(bits...)},
+		{259, tiffShort, 1, shorts(8)}, // Deflate
+		{262, tiffShort, 1, shorts(photometric)},
+		offsetsEntry(273, offsets),
+		{277, tiffShort, 1, shorts(uint16(ch))},
+		{278, tiffLong, 1, long(uint32(rowsPerStrip))},
+		offsetsEntry(279, counts),
+		{284, tiffShort, 1, shorts(1)}, // 像素交错存放
+		{317, tiffShort, 1, shorts(2)}, // 水平差分
+	}
+
+	// 放不进 IFD 的值写在 IFD 之前
+	inline := 4
+	if big {
+		inline = 8
+	}
+	valueAt := make([]uint64, len(entries))
+	for i, e := range entries {
+		if len(e.data) <= inline {
+			continue
+		}
+		if cw.n%2 == 1 {
+			cw.Write([]byte{0})
+		}
+		valueAt[i] = uint64(cw.n)
+		cw.Write(e.data)
+	}
+	if cw.n%2 == 1 {
+		cw.Write([]byte{0})
+	}
+	ifd := cw.n
+	var p []byte
+	if big {
+		p = le.AppendUint64(p, uint64(len(entries)))
+	} else {
+		p = le.AppendUint16(p, uint16(len(entries)))
+	}
+	for i, e := range entries {
+		p = le.AppendUint16(p, e.tag)
+		p = le.AppendUint16(p, e.typ)
+		value := make([]byte, inline)
+		if len(e.data) <= inline {
+			copy(value, e.data)
+		} else if big {
+			le.PutUint64(value, valueAt[i])
+		} else {
+			le.PutUint32(value, uint32(valueAt[i]))
+		}
+		if big {
+			p = le.AppendUint64(p, e.count)
+		} else {
+			p = le.AppendUint32(p, uint32(e.count))
+		}
+		p = append(p, value...)
+	}
+	p = append(p, make([]byte, inline)...) // 没有下一个 IFD
+	if _, err := cw.Write(p); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	if big {
+		_, err := wa.WriteAt(le.AppendUint64(nil, uint64(ifd)), 8)
+		return err
+	}
+	_, err := wa.WriteAt(le.AppendUint32(nil, uint32(ifd)), 4)
+	return err
+}
+
+// writePNG 将 src 按行编码为 8 位灰度或 RGB 的 PNG，每行使用 Up 过滤
+func writePNG(w io.Writer, src rowSource) error {
+	b := src.Bounds()
+	width, height, ch := b.Dx(), b.Dy(), src.channels()
+	bw := bufio.NewWriterSize(w, 1<<20)
+
+	bw.WriteString("\x89PNG\r\n\x1a\n")
+	ihdr := binary.BigEndian.AppendUint32(nil, uint32(width))
+	ihdr = binary.BigEndian.AppendUint32(ihdr, uint32(height))
+	colorType := byte(0) // 灰度
+	if ch == 3 {
+		colorType = 2 // RGB
+	}
+	ihdr = append(ihdr, 8, colorType, 0, 0, 0)
+	writePNGChunk(bw, "IHDR", ihdr)
+
+	// zlib 的输出每满 64KB 写为一个 IDAT
+	idat := bufio.NewWriterSize(pngChunkWriter{bw}, 64<<10)
+	zw := zlib.NewWriter(idat)
+	cur := make([]byte, 1+width*ch)
+	prev := make([]byte, 1+width*ch)
+	out := make([]byte, 1+width*ch)
+	out[0] = 2 // Up
+	for y := 0; y < height; y++ {
+		if err := src.readRow(y, cur[1:]); err != nil {
+			return err
+		}
+		for i := 1; i < len(cur); i++ {
+			out[i] = cur[i] - prev[i]
+		}
+		if _, err := zw.Write(out); err != nil {
+			return err
+		}
+		cur, prev = prev, cur
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := idat.Flush(); err != nil {
+		return err
+	}
+	writePNGChunk(bw, "IEND", nil)
+	return bw.Flush()
+}
+
+func writePNGChunk(w io.Writer, typ string, data []byte) error {
+	p := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	p = append(p, typ...)
+	p = append(p, data...)
+	p = binary.BigEndian.AppendUint32(p, crc32.ChecksumIEEE(p[4:]))
+	_, err := w.Write(p)
+	return err
+}
+
+// pngChunkWriter 每次 Write 写为一个 IDAT
+type pngChunkWriter struct{ w io.Writer }
+
+func (c pngChunkWriter) Write(p []byte) (int, error) {
+	if err := writePNGChunk(c.w, "IDAT", p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -3,10 +3,12 @@ package downloader
 import (
 	"bookget/config"
 	"bookget/pkg/chttp"
-	"bookget/pkg/progressbar"
+	"bookget/pkg/event"
+	"bookget/pkg/httpclient"
+	"bookget/pkg/retry"
+	"bookget/pkg/util"
 	"bytes"
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
@@ -16,14 +18,12 @@ import (
 	"io"
 	"math"
 	"net/http"
-	"net/http/cookiejar"
 	"net/url"
-	"os"
 	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"text/template"
 	"time"
 )
@@ -60,12 +60,18 @@ type IIIFInfo struct {
 		Overlap      int   `json:"overlap,omitempty"`
 	} `json:"tiles,omitempty"`
 
+	// v3 的尺寸限制在顶层，v2 在 profile 中
+	MaxWidth  int   `json:"maxWidth,omitempty"`
+	MaxHeight int   `json:"maxHeight,omitempty"`
+	MaxArea   int64 `json:"maxArea,omitempty"`
+
 	// 内部计算字段
 	// Computed fields
-	version  int    // 2 or 3
-	baseURL  string // base URL without info.json
-	maxArea  int64  // from profile
-	maxWidth int    // from profile
+	version   int    // 2 or 3
+	baseURL   string // base URL without info.json
+	maxArea   int64  // from profile or top level
+	maxWidth  int    // from profile or top level
+	maxHeight int    // 未给出时与 maxWidth 相同
 }
 
 type ProfileInfo struct {
@@ -130,29 +136,25 @@ type IIIFDownloader struct {
 	maxRetries    int
 	jpgQuality    int
 	maxConcurrent int
+	bestEffort    bool // 拼图重试后仍失败时留空保存，而不是整页失败
 
 	cookies []http.Cookie
 	headers http.Header
+
+	events event.Bus // 拼图下载进度，终端进度条是其中一个订阅者
 }
 
 func NewIIIFDownloader(c *config.Input) *IIIFDownloader {
-	// 创建自定义 Transport 忽略 SSL 验证
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-	}
-	jar, _ := cookiejar.New(nil)
-
-	cookies, _ := chttp.ReadHttpCookiesFromFile(config.Conf.CookieFile)
-	headers, _ := chttp.ReadHttpHeadersFromFile(config.Conf.HeaderFile)
+	cookies, _ := chttp.ReadHttpCookiesFromFile(c.CookieFile)
+	headers, _ := chttp.ReadHttpHeadersFromFile(c.HeaderFile)
 
 	dl := &IIIFDownloader{
-		client:        &http.Client{Jar: jar, Transport: tr},
+		client:        httpclient.New(httpclient.Options{}),
 		userAgent:     c.UserAgent,
 		maxRetries:    c.Retries,
 		jpgQuality:    c.Quality,
 		maxConcurrent: c.MaxConcurrent,
+		bestEffort:    c.BestEffortTiles,
 		cookies:       cookies,
 		headers:       headers,
 	}
@@ -171,21 +173,14 @@ func NewIIIFDownloader(c *config.Input) *IIIFDownloader {
 	//dl.SetIIIFTileFormat("{{.ID}}/{{.X}},{{.Y}},{{.Width}},{{.Height}}/{{if .sizeUpscaling}}^{{end}}{{.Width}},{{.Height}}/0/default.{{.Format}}")
 
 	dl.SetDeepZoomTileFormat("{{.URL}}_files/{{.Level}}/{{.X}}_{{.Y}}.{{.Format}}")
+	dl.events.Subscribe(&Terminal{})
 
 	return dl
 }
 
 func NewIIIFDownloaderDefault() *IIIFDownloader {
-	// 创建自定义 Transport 忽略 SSL 验证
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-	}
-	jar, _ := cookiejar.New(nil)
-
 	dl := &IIIFDownloader{
-		client:        &http.Client{Jar: jar, Transport: tr},
+		client:        httpclient.New(httpclient.Options{}),
 		userAgent:     userAgent,
 		maxRetries:    maxRetries,
 		jpgQuality:    JPGQuality,
@@ -207,6 +202,7 @@ func NewIIIFDownloaderDefault() *IIIFDownloader {
 	//dl.SetIIIFTileFormat("{{.ID}}/{{.X}},{{.Y}},{{.Width}},{{.Height}}/{{if .sizeUpscaling}}^{{end}}{{.Width}},{{.Height}}/0/default.{{.Format}}")
 
 	dl.SetDeepZoomTileFormat("{{.URL}}_files/{{.Level}}/{{.X}}_{{.Y}}.{{.Format}}")
+	dl.events.Subscribe(&Terminal{})
 
 	return dl
 }
@@ -237,26 +233,29 @@ func (d *IIIFDownloader) SetDeepZoomTileFormat(format string) error {
 	return nil
 }
 
+// SetCookieJar 使用 jar 发送与保存 cookie，如读取 manifest 时服务器设置的会话 cookie
+func (d *IIIFDownloader) SetCookieJar(jar http.CookieJar) {
+	d.client.Jar = jar
+}
+
 func (d *IIIFDownloader) Dezoomify(ctx context.Context, infoURL string, outputPath string, args []string) error {
 	headers, err := d.argsToHeaders(args)
 	if err != nil {
 		return fmt.Errorf("转换header失败: %v", err)
 	}
 	// 1. 获取IIIF信息（自动检测版本）
-	info, err := d.getIIIFInfoByURL(ctx, infoURL, headers)
-	if err != nil {
-		return fmt.Errorf("获取图像信息失败: %v", err)
-	}
-
-	// 2. 自动选择v2/v3下载器
-	finalImg, err := d.DownloadTiles(ctx, info, headers)
+	var info interface{}
+	err = retry.New(d.maxRetries).Do(ctx, func(int) error {
+		info, err = d.getIIIFInfoByURL(ctx, infoURL, headers)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("处理拼图失败: %v", err)
+		return fmt.Errorf("获取图像信息失败: %w", err)
 	}
 
-	// 3. 保存图像
-	if err := d.saveImage(finalImg, outputPath); err != nil {
-		return fmt.Errorf("保存图像失败: %v", err)
+	// 2. 自动选择v2/v3下载器，拼接并保存图像
+	if err := d.saveTiles(ctx, info, headers, outputPath); err != nil {
+		return err
 	}
 
 	fmt.Printf("\n图像合并完成，已保存到 %s\n", outputPath)
@@ -278,44 +277,48 @@ func (d *IIIFDownloader) DezoomifyWithContent(ctx context.Context, content strin
 			return fmt.Errorf("JSON内容中未找到拼图配置信息")
 		}
 
-		finalImg, err := d.DownloadTiles(ctx, &jsonInfo, headers)
-		if err != nil {
-			return fmt.Errorf("处理拼图失败: %v", err)
-		}
-
-		return d.saveImage(finalImg, outputPath)
+		return d.saveTiles(ctx, &jsonInfo, headers, outputPath)
 	}
 
 	// 尝试解析为XML
 	var xmlInfo IIIFXMLInfo
 	if err := xml.Unmarshal([]byte(content), &xmlInfo); err == nil {
-		finalImg, err := d.downloadAndMergeXMLTiles(ctx, &xmlInfo, headers)
-		if err != nil {
-			return fmt.Errorf("处理拼图失败: %v", err)
-		}
-
-		return d.saveImage(finalImg, outputPath)
+		return d.saveTiles(ctx, &xmlInfo, headers, outputPath)
 	}
 
 	return fmt.Errorf("内容既不是有效的JSON也不是有效的XML")
 }
 
+// DownloadTiles 下载全部拼图，在内存中拼接为一张图像
 func (d *IIIFDownloader) DownloadTiles(ctx context.Context, info interface{}, headers http.Header) (image.Image, error) {
+	plan, err := d.planTiles(info)
+	if err != nil {
+		return nil, err
+	}
+	c := newCanvas(plan.width, plan.height)
+	if _, err := d.stitch(ctx, plan, c, headers, nil); err != nil {
+		return nil, err
+	}
+	return c.image(), nil
+}
+
+// planTiles 按 info 的格式计算图像尺寸与各拼图的 URL、位置
+func (d *IIIFDownloader) planTiles(info interface{}) (*tilePlan, error) {
 	switch v := info.(type) {
 	case *IIIFInfo:
 		if v.version == 3 {
-			return d.downloadIIIFv3Tiles(ctx, v, headers)
+			return d.planIIIFv3Tiles(v)
 		}
-		return d.downloadIIIFv2Tiles(ctx, v, headers)
+		return d.planIIIFv2Tiles(v)
 	case *IIIFXMLInfo:
-		return d.downloadAndMergeXMLTiles(ctx, v, headers)
+		return d.planXMLTiles(v)
 	default:
 		return nil, fmt.Errorf("unsupported info format")
 	}
 }
 
-// IIIF v2 专用下载函数
-func (d *IIIFDownloader) downloadIIIFv2Tiles(ctx context.Context, info *IIIFInfo, headers http.Header) (image.Image, error) {
+// IIIF v2 专用
+func (d *IIIFDownloader) planIIIFv2Tiles(info *IIIFInfo) (*tilePlan, error) {
 	tileConfig := info.Tiles[0]
 	tileSize := tileConfig.Width // v2通常使用正方形瓦片
 	overlap := tileConfig.Overlap
@@ -324,111 +327,54 @@ func (d *IIIFDownloader) downloadIIIFv2Tiles(ctx context.Context, info *IIIFInfo
 	cols := int(math.Ceil(float64(info.Width) / float64(effectiveTileSize)))
 	rows := int(math.Ceil(float64(info.Height) / float64(effectiveTileSize)))
 
-	finalImg := image.NewRGBA(image.Rect(0, 0, info.Width, info.Height))
-	progressBar := progressbar.Default(int64(cols*rows), "downloading tiles")
-
-	sem := make(chan struct{}, d.maxConcurrent)
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-	errChan := make(chan error, 1)
-
+	bounds := image.Rect(0, 0, info.Width, info.Height)
 	quality := d.bestQuality(info)
 	format := d.bestFormat(info)
 	sizeFormat := d.preferredSizeFormat(info)
 
+	tiles := make([]tileSpec, 0, cols*rows)
 	for y := 0; y < rows; y++ {
 		for x := 0; x < cols; x++ {
-			wg.Add(1)
-			go func(x, y int) {
-				defer wg.Done()
-				sem <- struct{}{}
-				defer func() { <-sem }()
-
-				tileX := x * effectiveTileSize
-				tileY := y * effectiveTileSize
-				reqWidth := tileSize
-				reqHeight := tileSize
-
-				if tileX+reqWidth > info.Width {
-					reqWidth = info.Width - tileX
-				}
-				if tileY+reqHeight > info.Height {
-					reqHeight = info.Height - tileY
-				}
-
-				tileData := map[string]interface{}{
-					"ID":            info.ID, // v2使用ID字段
-					"ServerBaseURL": info.baseURL,
-					"X":             tileX,
-					"Y":             tileY,
-					"Width":         reqWidth,
-					"Height":        reqHeight,
-					//"Format":        "jpg",
-					//"Quality":       "default",
-					"Format":     format,
-					"Quality":    quality,
-					"SizeFormat": sizeFormat,
-					"Version":    2, // 明确使用v2版本
-				}
-				// 构建完整的瓦片URL
-				tileURL, err := d.buildIIIFv3TileURL(tileData)
-				if err != nil {
-					select {
-					case errChan <- fmt.Errorf("build tile URL error: %v", err):
-					default:
-					}
-					return
-				}
-
-				img, err := d.downloadImageWithRetry(ctx, tileURL, headers, d.maxRetries)
-				if err != nil {
-					select {
-					case errChan <- fmt.Errorf("tile(%d,%d)下载失败: %v", x, y, err):
-					default:
-					}
-					return
-				}
-
-				mu.Lock()
-				destX := tileX
-				destY := tileY
-				if x > 0 {
-					destX += overlap
-				}
-				if y > 0 {
-					destY += overlap
-				}
-
-				bounds := img.Bounds()
-				for py := bounds.Min.Y; py < bounds.Max.Y; py++ {
-					for px := bounds.Min.X; px < bounds.Max.X; px++ {
-						targetX := destX + (px - bounds.Min.X)
-						targetY := destY + (py - bounds.Min.Y)
-						if targetX < info.Width && targetY < info.Height {
-							finalImg.Set(targetX, targetY, img.At(px, py))
-						}
-					}
-				}
-				mu.Unlock()
-
-				progressBar.Add(1)
-			}(x, y)
-		}
-	}
+			tileX := x * effectiveTileSize
+			tileY := y * effectiveTileSize
+			reqWidth := tileSize
+			reqHeight := tileSize
 
-	go func() {
-		wg.Wait()
-		close(errChan)
-	}()
+			if tileX+reqWidth > info.Width {
+				reqWidth = info.Width - tileX
+			}
+			if tileY+reqHeight > info.Height {
+				reqHeight = info.Height - tileY
+			}
 
-	if err := <-errChan; err != nil {
-		return nil, err
+			tileData := map[string]interface{}{
+				"ID":            info.ID, // v2使用ID字段
+				"ServerBaseURL": info.baseURL,
+				"X":             tileX,
+				"Y":             tileY,
+				"Width":         reqWidth,
+				"Height":        reqHeight,
+				//"Format":        "jpg",
+				//"Quality":       "default",
+				"Format":     format,
+				"Quality":    quality,
+				"SizeFormat": sizeFormat,
+				"Version":    2, // 明确使用v2版本
+			}
+			// 构建完整的瓦片URL
+			tileURL, err := d.buildIIIFv3TileURL(tileData)
+			if err != nil {
+				return nil, fmt.Errorf("build tile URL error: %v", err)
+			}
+			// 除第一行/列外跳过重叠部分
+			tiles = append(tiles, tileSpec{x: x, y: y, url: tileURL, cell: overlapCell(x, y, effectiveTileSize, overlap, bounds)})
+		}
 	}
 
-	return finalImg, nil
+	return &tilePlan{width: info.Width, height: info.Height, tiles: tiles}, nil
 }
 
-func (d *IIIFDownloader) downloadIIIFv3Tiles(ctx context.Context, info *IIIFInfo, headers http.Header) (image.Image, error) {
+func (d *IIIFDownloader) planIIIFv3Tiles(info *IIIFInfo) (*tilePlan, error) {
 	if len(info.Tiles) == 0 {
 		return nil, fmt.Errorf("no tile configuration found")
 	}
@@ -446,97 +392,45 @@ func (d *IIIFDownloader) downloadIIIFv3Tiles(ctx context.Context, info *IIIFInfo
 	cols := int(math.Ceil(float64(info.Width) / float64(tileSize.x)))
 	rows := int(math.Ceil(float64(info.Height) / float64(tileSize.y)))
 
-	finalImg := image.NewRGBA(image.Rect(0, 0, info.Width, info.Height))
-	progressBar := progressbar.Default(int64(cols*rows), "downloading tiles")
-
-	sem := make(chan struct{}, d.maxConcurrent)
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-	errChan := make(chan error, 1)
-
 	quality := d.bestQuality(info)
 	format := d.bestFormat(info)
 	sizeFormat := d.preferredSizeFormat(info)
 
+	tiles := make([]tileSpec, 0, cols*rows)
 	for y := 0; y < rows; y++ {
 		for x := 0; x < cols; x++ {
-			wg.Add(1)
-
-			go func(x, y int) {
-				defer wg.Done()
-				sem <- struct{}{}
-				defer func() { <-sem }()
-
-				posX := x * tileSize.x
-				posY := y * tileSize.y
-				width := min(tileSize.x, info.Width-posX)
-				height := min(tileSize.y, info.Height-posY)
-
-				// 构建瓦片URL参数
-				tileData := map[string]interface{}{
-					"ID":         info.ID,
-					"X":          posX,
-					"Y":          posY,
-					"Width":      width,
-					"Height":     height,
-					"Format":     format,
-					"Quality":    quality,
-					"SizeFormat": sizeFormat,
-					"Version":    3, // 明确使用v3版本
-					//"sizeUpscaling": d.needsUpscale(info, width, height),
-				}
-
-				// 构建完整的瓦片URL
-				tileURL, err := d.buildIIIFv3TileURL(tileData)
-				if err != nil {
-					select {
-					case errChan <- fmt.Errorf("build tile URL error: %v", err):
-					default:
-					}
-					return
-				}
-
-				// 下载瓦片图像
-				img, err := d.downloadImageWithRetry(ctx, tileURL, headers, d.maxRetries)
-				if err != nil {
-					select {
-					case errChan <- fmt.Errorf("download tile(%d,%d) error: %v", x, y, err):
-					default:
-					}
-					return
-				}
-
-				mu.Lock()
-				bounds := img.Bounds()
-				for py := bounds.Min.Y; py < bounds.Max.Y; py++ {
-					for px := bounds.Min.X; px < bounds.Max.X; px++ {
-						targetX := posX + (px - bounds.Min.X)
-						targetY := posY + (py - bounds.Min.Y)
-						if targetX < info.Width && targetY < info.Height {
-							finalImg.Set(targetX, targetY, img.At(px, py))
-						}
-					}
-				}
-				mu.Unlock()
-
-				progressBar.Add(1)
-			}(x, y)
-		}
-	}
-
-	go func() {
-		wg.Wait()
-		close(errChan)
-	}()
+			posX := x * tileSize.x
+			posY := y * tileSize.y
+			width := min(tileSize.x, info.Width-posX)
+			height := min(tileSize.y, info.Height-posY)
+
+			// 构建瓦片URL参数
+			tileData := map[string]interface{}{
+				"ID":         info.ID,
+				"X":          posX,
+				"Y":          posY,
+				"Width":      width,
+				"Height":     height,
+				"Format":     format,
+				"Quality":    quality,
+				"SizeFormat": sizeFormat,
+				"Version":    3, // 明确使用v3版本
+				//"sizeUpscaling": d.needsUpscale(info, width, height),
+			}
 
-	if err := <-errChan; err != nil {
-		return nil, err
+			// 构建完整的瓦片URL
+			tileURL, err := d.buildIIIFv3TileURL(tileData)
+			if err != nil {
+				return nil, fmt.Errorf("build tile URL error: %v", err)
+			}
+			tiles = append(tiles, tileSpec{x: x, y: y, url: tileURL, cell: image.Rect(posX, posY, posX+width, posY+height)})
+		}
 	}
 
-	return finalImg, nil
+	return &tilePlan{width: info.Width, height: info.Height, tiles: tiles}, nil
 }
 
-func (d *IIIFDownloader) downloadAndMergeXMLTiles(ctx context.Context, info *IIIFXMLInfo, headers http.Header) (image.Image, error) {
+func (d *IIIFDownloader) planXMLTiles(info *IIIFXMLInfo) (*tilePlan, error) {
 	tileSize := info.TileSize
 	overlap := info.Overlap
 
@@ -547,101 +441,50 @@ func (d *IIIFDownloader) downloadAndMergeXMLTiles(ctx context.Context, info *III
 	cols := (info.Size.Width + effectiveTileSize - 1) / effectiveTileSize
 	rows := (info.Size.Height + effectiveTileSize - 1) / effectiveTileSize
 
-	finalImg := image.NewRGBA(image.Rect(0, 0, info.Size.Width, info.Size.Height))
-	progressBar := progressbar.Default(int64(cols*rows), "downloading tiles")
-
-	sem := make(chan struct{}, d.maxConcurrent)
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-	errChan := make(chan error, 1)
-
+	bounds := image.Rect(0, 0, info.Size.Width, info.Size.Height)
 	maxLevel := d.getMaxZoomLevel(info.Size.Width, info.Size.Height)
 
+	tiles := make([]tileSpec, 0, cols*rows)
 	for y := 0; y < rows; y++ {
 		for x := 0; x < cols; x++ {
-			wg.Add(1)
-			go func(x, y int) {
-				defer wg.Done()
-				sem <- struct{}{}
-				defer func() { <-sem }()
-
-				// 计算实际位置（考虑重叠）
-				posX := x * effectiveTileSize
-				posY := y * effectiveTileSize
-
-				// 构建包含重叠区域的请求
-				tileData := map[string]interface{}{
-					"URL":    info.URL,
-					"Level":  maxLevel,
-					"X":      x,
-					"Y":      y,
-					"Width":  tileSize, // 请求包含重叠的完整瓦片
-					"Height": tileSize,
-					"Format": info.Format,
-				}
-
-				tileURL, err := d.buildDeepZoomTileURL(tileData)
-				if err != nil {
-					select {
-					case errChan <- fmt.Errorf("构建 tileURL 失败: %v", err):
-					default:
-					}
-					return
-				}
-
-				img, err := d.downloadImage(ctx, tileURL, headers)
-				if err != nil {
-					select {
-					case errChan <- fmt.Errorf("下载拼图(%d,%d)失败: %v", x, y, err):
-					default:
-					}
-					return
-				}
-
-				mu.Lock()
-				// 计算目标位置（跳过重叠部分）
-				destX := posX
-				destY := posY
-				if x > 0 {
-					destX += overlap
-				} // 跳过左侧重叠
-				if y > 0 {
-					destY += overlap
-				} // 跳过上方重叠
-
-				// 复制有效像素区域
-				bounds := img.Bounds()
-				for py := bounds.Min.Y; py < bounds.Max.Y; py++ {
-					for px := bounds.Min.X; px < bounds.Max.X; px++ {
-						targetX := destX + (px - bounds.Min.X)
-						targetY := destY + (py - bounds.Min.Y)
-
-						// 确保不超出图像边界
-						if targetX < info.Size.Width && targetY < info.Size.Height {
-							finalImg.Set(targetX, targetY, img.At(px, py))
-						}
-					}
-				}
-				mu.Unlock()
-
-				progressBar.Add(1)
-			}(x, y)
+			// 构建包含重叠区域的请求
+			tileData := map[string]interface{}{
+				"URL":    info.URL,
+				"Level":  maxLevel,
+				"X":      x,
+				"Y":      y,
+				"Width":  tileSize, // 请求包含重叠的完整瓦片
+				"Height": tileSize,
+				"Format": info.Format,
+			}
+
+			tileURL, err := d.buildDeepZoomTileURL(tileData)
+			if err != nil {
+				return nil, fmt.Errorf("构建 tileURL 失败: %v", err)
+			}
+			// 目标位置跳过左侧、上方的重叠部分
+			tiles = append(tiles, tileSpec{x: x, y: y, url: tileURL, cell: overlapCell(x, y, effectiveTileSize, overlap, bounds)})
 		}
 	}
 
-	go func() {
-		wg.Wait()
-		close(errChan)
-	}()
+	return &tilePlan{width: info.Size.Width, height: info.Size.Height, tiles: tiles}, nil
+}
 
-	if err := <-errChan; err != nil {
+func (d *IIIFDownloader) getIIIFInfo(ctx context.Context, url string, headers http.Header) (*IIIFInfo, error) {
+	info, err := d.fetchIIIFInfo(ctx, url, headers)
+	if err != nil {
 		return nil, err
 	}
 
-	return finalImg, nil
+	if len(info.Tiles) == 0 {
+		return nil, fmt.Errorf("未找到拼图配置信息")
+	}
+
+	return info, nil
 }
 
-func (d *IIIFDownloader) getIIIFInfo(ctx context.Context, url string, headers http.Header) (*IIIFInfo, error) {
+// fetchIIIFInfo 下载并解析 info.json，不要求有拼图配置（整图下载时使用）
+func (d *IIIFDownloader) fetchIIIFInfo(ctx context.Context, url string, headers http.Header) (*IIIFInfo, error) {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
@@ -679,23 +522,17 @@ func (d *IIIFDownloader) getIIIFInfo(ctx context.Context, url string, headers ht
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("服务器返回错误状态码: %d", resp.StatusCode)
+		return nil, retry.Status(resp.StatusCode)
 	}
 
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("读取响应体失败: %v", err)
 	}
-	info, err := d.parseIIIFResponse(data)
-	if err != nil {
-		return nil, err
-	}
-
-	if len(info.Tiles) == 0 {
-		return nil, fmt.Errorf("未找到拼图配置信息")
+	if retry.IsChallenge(resp.Header.Get("Content-Type"), data) {
+		return nil, retry.ErrChallenge
 	}
-
-	return info, nil
+	return d.parseIIIFResponse(data)
 }
 
 func (d *IIIFDownloader) getIIIFXMLInfo(ctx context.Context, url string, headers http.Header) (*IIIFXMLInfo, error) {
@@ -735,13 +572,16 @@ func (d *IIIFDownloader) getIIIFXMLInfo(ctx context.Context, url string, headers
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("服务器返回错误状态码: %d", resp.StatusCode)
+		return nil, retry.Status(resp.StatusCode)
 	}
 
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("读取响应体失败: %v", err)
 	}
+	if retry.IsChallenge(resp.Header.Get("Content-Type"), data) {
+		return nil, retry.ErrChallenge
+	}
 
 	var info IIIFXMLInfo
 	if err := xml.Unmarshal(data, &info); err != nil {
@@ -758,10 +598,25 @@ func (d *IIIFDownloader) getIIIFXMLInfo(ctx context.Context, url string, headers
 }
 
 func (d *IIIFDownloader) downloadImage(ctx context.Context, url string, headers http.Header) (image.Image, error) {
-	req, err := http.NewRequest("GET", url, nil)
+	data, err := d.fetchTile(ctx, url, headers)
 	if err != nil {
 		return nil, err
 	}
+	return decodeTile(data)
+}
+
+// fetchTile 下载拼图的原始数据，不解码
+func (d *IIIFDownloader) fetchTile(ctx context.Context, url string, headers http.Header) ([]byte, error) {
+	data, _, err := d.fetch(ctx, url, headers)
+	return data, err
+}
+
+// fetch 下载 url 的原始数据，同时返回服务器声明的 Content-Type
+func (d *IIIFDownloader) fetch(ctx context.Context, url string, headers http.Header) ([]byte, string, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, "", err
+	}
 
 	for key, values := range headers {
 		for _, value := range values {
@@ -790,46 +645,50 @@ func (d *IIIFDownloader) downloadImage(ctx context.Context, url string, headers
 
 	resp, err := d.client.Do(req.WithContext(ctx))
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		//404 || 500
-		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusInternalServerError {
-			return nil, nil
-		}
-		return nil, fmt.Errorf("服务器返回错误状态码: %d", resp.StatusCode)
+		return nil, "", retry.Status(resp.StatusCode)
 	}
 
+	contentType := resp.Header.Get("Content-Type")
 	imgData, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("读取图像数据失败: %v", err)
+		return nil, "", fmt.Errorf("读取图像数据失败: %v", err)
+	}
+	if retry.IsChallenge(contentType, imgData) {
+		return nil, "", retry.ErrChallenge
 	}
+	return imgData, contentType, nil
+}
 
-	img, _, err := image.Decode(bytes.NewReader(imgData))
+func decodeTile(data []byte) (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
 	if err != nil {
 		return nil, fmt.Errorf("解码图像失败: %v", err)
 	}
-
 	return img, nil
 }
 
 func (d *IIIFDownloader) saveImage(img image.Image, path string) error {
-	outFile, err := os.Create(path)
-	if err != nil {
-		return fmt.Errorf("创建文件失败: %v", err)
-	}
-	defer outFile.Close()
-
+	var encode func(w io.Writer) error
 	switch ext := path[len(path)-4:]; ext {
 	case ".jpg", "jpeg":
-		return jpeg.Encode(outFile, img, &jpeg.Options{Quality: d.jpgQuality})
+		encode = func(w io.Writer) error { return jpeg.Encode(w, img, &jpeg.Options{Quality: d.jpgQuality}) }
 	case ".png":
-		return png.Encode(outFile, img)
+		encode = func(w io.Writer) error { return png.Encode(w, img) }
+	case ".tif", "tiff":
+		encode = func(w io.Writer) error {
+			rows := imageRows{img}
+			return writeTIFF(w, rows, needBigTIFF(img.Bounds().Dx(), img.Bounds().Dy(), rows.channels()))
+		}
 	default:
 		return fmt.Errorf("不支持的图像格式: %s", ext)
 	}
+	//先写临时文件，编码完成后再改名，避免中断时留下不完整的图片
+	return util.WriteFileAtomicFunc(path, 0644, encode)
 }
 
 func (d *IIIFDownloader) argsToHeaders(args []string) (http.Header, error) {
@@ -1017,8 +876,21 @@ func (d *IIIFDownloader) parseIIIFResponse(data []byte) (*IIIFInfo, error) {
 		if err == nil {
 			info.maxArea = profile.MaxArea
 			info.maxWidth = profile.MaxWidth
+			info.maxHeight = profile.MaxHeight
 		}
 	}
+	if info.MaxWidth > 0 && (info.maxWidth == 0 || info.MaxWidth < info.maxWidth) {
+		info.maxWidth = info.MaxWidth
+	}
+	if info.MaxHeight > 0 && (info.maxHeight == 0 || info.MaxHeight < info.maxHeight) {
+		info.maxHeight = info.MaxHeight
+	}
+	if info.MaxArea > 0 && (info.maxArea == 0 || info.MaxArea < info.maxArea) {
+		info.maxArea = info.MaxArea
+	}
+	if info.maxHeight == 0 {
+		info.maxHeight = info.maxWidth
+	}
 
 	// Remove test IDs (like example.com)
 	if matched, _ := regexp.MatchString(`^https?://((www\.)?example\.|localhost)`, info.ID); matched {
@@ -1079,30 +951,85 @@ func (d *IIIFDownloader) preferredSizeFormat(info *IIIFInfo) TileSizeFormat {
 }
 
 func (d *IIIFDownloader) cropTileSize(info *IIIFInfo, size Vec2d) Vec2d {
-	profile, _ := d.parseProfile(info.Profile)
-	if profile.MaxWidth > 0 {
-		size.x = min(size.x, profile.MaxWidth)
-		size.y = min(size.y, profile.MaxHeight)
+	if info.maxWidth > 0 {
+		size.x = min(size.x, info.maxWidth)
+		size.y = min(size.y, info.maxHeight)
 	}
-	if profile.MaxArea > 0 && int64(size.x*size.y) > profile.MaxArea {
-		sqrt := int(math.Sqrt(float64(profile.MaxArea)))
+	if info.maxArea > 0 && int64(size.x*size.y) > info.maxArea {
+		sqrt := int(math.Sqrt(float64(info.maxArea)))
 		size.x = min(size.x, sqrt)
 		size.y = min(size.y, sqrt)
 	}
 	return size
 }
 
+// Events 返回事件总线，可订阅拼图下载的进度
+func (d *IIIFDownloader) Events() *event.Bus {
+	return &d.events
+}
+
+const tilesJob = "downloading tiles"
+
+// tileProgress 一张图的拼图下载进度
+type tileProgress struct {
+	events    *event.Bus
+	start     time.Time
+	succeeded atomic.Int32
+	failed    atomic.Int32
+}
+
+func (d *IIIFDownloader) startTiles(total int) *tileProgress {
+	d.events.Emit(event.Event{Kind: event.JobStarted, Job: tilesJob, Total: int64(total), Workers: d.maxConcurrent})
+	return &tileProgress{events: &d.events, start: time.Now()}
+}
+
+func (p *tileProgress) started(url string) {
+	p.events.Emit(event.Event{Kind: event.TaskStarted, Job: tilesJob, Task: url})
+}
+
+func (p *tileProgress) done(url string, err error) {
+	if err != nil {
+		p.failed.Add(1)
+		p.events.Emit(event.Event{Kind: event.TaskFailed, Job: tilesJob, Task: url, Err: err})
+		return
+	}
+	p.succeeded.Add(1)
+	p.events.Emit(event.Event{Kind: event.TaskCompleted, Job: tilesJob, Task: url})
+}
+
+func (p *tileProgress) finish() {
+	p.events.Emit(event.Event{Kind: event.JobFinished, Job: tilesJob, Succeeded: int(p.succeeded.Load()),
+		Failed: int(p.failed.Load()), Elapsed: time.Since(p.start)})
+}
+
+// downloadImageWithRetry 按重试策略下载拼图
 func (d *IIIFDownloader) downloadImageWithRetry(ctx context.Context, url string, headers http.Header, maxRetries int) (image.Image, error) {
-	var lastErr error
-	for i := 0; i < maxRetries; i++ {
-		img, err := d.downloadImage(ctx, url, headers)
-		if err == nil {
-			return img, nil
+	var img image.Image
+	err := d.retryTile(ctx, url, maxRetries, func() (err error) {
+		img, err = d.downloadImage(ctx, url, headers)
+		return err
+	})
+	return img, err
+}
+
+// fetchTileWithRetry 同 downloadImageWithRetry，只下载不解码，解码交给 stitch 的解码协程
+func (d *IIIFDownloader) fetchTileWithRetry(ctx context.Context, url string, headers http.Header) ([]byte, error) {
+	var data []byte
+	err := d.retryTile(ctx, url, d.maxRetries, func() (err error) {
+		data, err = d.fetchTile(ctx, url, headers)
+		return err
+	})
+	return data, err
+}
+
+// retryTile 重试 fn。重试后仍失败时返回错误，由 stitch 决定记为缺失（404/500、--best-effort-tiles）还是整页失败
+func (d *IIIFDownloader) retryTile(ctx context.Context, url string, maxRetries int, fn func() error) error {
+	return retry.New(maxRetries).Do(ctx, func(attempt int) error {
+		if attempt > 1 {
+			d.events.Emit(event.Event{Kind: event.TaskRetried, Job: tilesJob, Task: url, Attempt: attempt})
 		}
-		lastErr = err
-		time.Sleep(time.Second * time.Duration(i+1))
-	}
-	return nil, lastErr
+		return fn()
+	})
 }
 
 // buildDeepZoomTileURL 根据模板构建 DeepZoom 格式的 tileURL
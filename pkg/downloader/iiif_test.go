@@ -0,0 +1,509 @@
+package downloader
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"bookget/pkg/event"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tileServer 本地 IIIF v3 图像服务，拼图预先编码，请求时只返回字节
+type tileServer struct {
+	*httptest.Server
+	src   image.Image
+	tiles map[string][]byte // region -> 编码后的拼图
+
+	fail     atomic.Value // 返回错误的 region
+	status   atomic.Int32 // fail 返回的状态码，0 时为 503
+	requests atomic.Int32 // 拼图请求数
+}
+
+func newTileServer(tb testing.TB, src image.Image, tileSize int, format string) *tileServer {
+	b := src.Bounds()
+	ts := &tileServer{src: src, tiles: make(map[string][]byte)}
+	for y := 0; y < b.Dy(); y += tileSize {
+		for x := 0; x < b.Dx(); x += tileSize {
+			r := image.Rect(x, y, x+tileSize, y+tileSize).Intersect(b)
+			var buf bytes.Buffer
+			sub := src.(interface {
+				SubImage(image.Rectangle) image.Image
+			}).SubImage(r)
+			var err error
+			if format == "png" {
+				err = png.Encode(&buf, sub)
+			} else {
+				err = jpeg.Encode(&buf, sub, &jpeg.Options{Quality: 90})
+			}
+			require.NoError(tb, err)
+			ts.tiles[fmt.Sprintf("%d,%d,%d,%d", r.Min.X, r.Min.Y, r.Dx(), r.Dy())] = buf.Bytes()
+		}
+	}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/iiif/img/"), "/")
+		if parts[0] == "info.json" {
+			fmt.Fprintf(w, `{"@context":"http://iiif.io/api/image/3/context.json","id":"%s/iiif/img","type":"ImageService3",
+"width":%d,"height":%d,"formats":["%s"],"tiles":[{"width":%d,"height":%d,"scaleFactors":[1]}]}`,
+				ts.URL, b.Dx(), b.Dy(), format, tileSize, tileSize)
+			return
+		}
+		ts.requests.Add(1)
+		if fail, _ := ts.fail.Load().(string); fail == parts[0] {
+			code := int(ts.status.Load())
+			if code == 0 {
+				code = http.StatusServiceUnavailable
+			}
+			w.WriteHeader(code)
+			return
+		}
+		data, ok := ts.tiles[parts[0]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	return ts
+}
+
+// testScan 生成有渐变与条纹的扫描图
+func testScan(w, h int, gray bool) image.Image {
+	r := image.Rect(0, 0, w, h)
+	if gray {
+		img := image.NewGray(r)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				img.Pix[img.PixOffset(x, y)] = uint8((x*7 + y*3) ^ (x / 16))
+			}
+		}
+		return img
+	}
+	img := image.NewRGBA(r)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), uint8(x ^ y), 255})
+		}
+	}
+	return img
+}
+
+func newTestIIIF() *IIIFDownloader {
+	// 不订阅终端进度条
+	return &IIIFDownloader{client: http.DefaultClient, maxRetries: 1, jpgQuality: JPGQuality, maxConcurrent: maxConcurrent}
+}
+
+func (ts *tileServer) stitch(tb testing.TB, d *IIIFDownloader) image.Image {
+	ctx := context.Background()
+	info, err := d.getIIIFInfo(ctx, ts.URL+"/iiif/img/info.json", nil)
+	require.NoError(tb, err)
+	img, err := d.DownloadTiles(ctx, info, nil)
+	require.NoError(tb, err)
+	return img
+}
+
+func TestStitchTiles(t *testing.T) {
+	// 灰度 PNG：无损，结果与原图逐字节相同，画布为 Gray
+	src := testScan(700, 500, true)
+	ts := newTileServer(t, src, 256, "png")
+	defer ts.Close()
+	d := newTestIIIF()
+	var mu sync.Mutex
+	kinds := make(map[event.Kind]int)
+	d.Events().Subscribe(event.Func(func(e event.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		kinds[e.Kind]++
+	}))
+	img := ts.stitch(t, d)
+	require.IsType(t, &image.Gray{}, img)
+	assert.Equal(t, src.(*image.Gray).Pix, img.(*image.Gray).Pix)
+	// 每块拼图开始与完成各一次
+	assert.Equal(t, 6, kinds[event.TaskStarted])
+	assert.Equal(t, 6, kinds[event.TaskCompleted])
+
+	// 彩色 JPEG：画布为 YCbCr，每块与单独解码的拼图一致
+	src = testScan(600, 300, false)
+	ts = newTileServer(t, src, 256, "jpg")
+	defer ts.Close()
+	img = ts.stitch(t, newTestIIIF())
+	require.IsType(t, &image.YCbCr{}, img)
+	for region, data := range ts.tiles {
+		var x, y, w, h int
+		fmt.Sscanf(region, "%d,%d,%d,%d", &x, &y, &w, &h)
+		tile, err := jpeg.Decode(bytes.NewReader(data))
+		require.NoError(t, err)
+		for py := 0; py < h; py++ {
+			for px := 0; px < w; px++ {
+				if tile.At(px, py) != img.At(x+px, y+py) {
+					t.Fatalf("拼图 %s 的 (%d,%d) 不一致", region, px, py)
+				}
+			}
+		}
+	}
+}
+
+func TestCanvasPromote(t *testing.T) {
+	c := newCanvas(4, 2)
+	gray := image.NewGray(image.Rect(0, 0, 2, 2))
+	fill(gray.Pix, 200)
+	c.put(gray, image.Rect(0, 0, 2, 2))
+	rgba := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	draw.Draw(rgba, rgba.Rect, image.NewUniform(color.RGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	c.put(rgba, image.Rect(2, 0, 4, 2))
+
+	img := c.image()
+	require.IsType(t, &image.RGBA{}, img)
+	assert.Equal(t, color.RGBA{200, 200, 200, 255}, img.At(1, 1))
+	assert.Equal(t, color.RGBA{255, 0, 0, 255}, img.At(3, 1))
+}
+
+func TestSpoolPromote(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newSpool(dir, 4, 2)
+	require.NoError(t, err)
+	gray := image.NewGray(image.Rect(0, 0, 2, 2))
+	fill(gray.Pix, 200)
+	require.NoError(t, s.put(gray, image.Rect(0, 0, 2, 2)))
+	rgba := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	draw.Draw(rgba, rgba.Rect, image.NewUniform(color.RGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	require.NoError(t, s.put(rgba, image.Rect(2, 0, 4, 2)))
+	require.NoError(t, s.put(gray, image.Rect(0, 0, 2, 1)))
+
+	assert.Equal(t, 3, s.channels())
+	assert.Equal(t, color.RGBA{200, 200, 200, 255}, s.At(1, 1))
+	assert.Equal(t, color.RGBA{255, 0, 0, 255}, s.At(3, 1))
+	require.NoError(t, s.err)
+	require.NoError(t, s.Close())
+
+	// 转换时的旧文件已删除
+	left, _ := filepath.Glob(filepath.Join(dir, ".bookget-*"))
+	assert.Empty(t, left)
+}
+
+func TestStitchToDisk(t *testing.T) {
+	old := maxCanvasPixels
+	maxCanvasPixels = 0 // 全部拼接到磁盘
+	defer func() { maxCanvasPixels = old }()
+
+	gray := testScan(700, 500, true).(*image.Gray)
+	ts := newTileServer(t, gray, 256, "png")
+	defer ts.Close()
+	color := testScan(600, 300, false).(*image.RGBA)
+	cs := newTileServer(t, color, 256, "png")
+	defer cs.Close()
+	rgb := make([]byte, 0, 600*300*3)
+	for i := 0; i < len(color.Pix); i += 4 {
+		rgb = append(rgb, color.Pix[i:i+3]...)
+	}
+
+	dir := t.TempDir()
+	d := newTestIIIF()
+	dezoomify := func(ts *tileServer, name string) string {
+		out := filepath.Join(dir, name)
+		require.NoError(t, d.Dezoomify(context.Background(), ts.URL+"/iiif/img/info.json", out, nil))
+		return out
+	}
+
+	f, err := os.Open(dezoomify(ts, "gray.png"))
+	require.NoError(t, err)
+	img, err := png.Decode(f)
+	f.Close()
+	require.NoError(t, err)
+	require.IsType(t, &image.Gray{}, img)
+	assert.Equal(t, gray.Pix, img.(*image.Gray).Pix)
+
+	w, h, ch, pix := readTestTIFF(t, dezoomify(ts, "gray.tif"))
+	assert.Equal(t, []int{700, 500, 1}, []int{w, h, ch})
+	assert.Equal(t, gray.Pix, pix)
+
+	w, h, ch, pix = readTestTIFF(t, dezoomify(cs, "color.tif"))
+	assert.Equal(t, []int{600, 300, 3}, []int{w, h, ch})
+	assert.Equal(t, rgb, pix)
+
+	f, err = os.Open(dezoomify(cs, "color.jpg"))
+	require.NoError(t, err)
+	cfg, err := jpeg.DecodeConfig(f)
+	f.Close()
+	require.NoError(t, err)
+	assert.Equal(t, []int{600, 300}, []int{cfg.Width, cfg.Height})
+
+	// BigTIFF
+	big := filepath.Join(dir, "big.tif")
+	f, err = os.Create(big)
+	require.NoError(t, err)
+	require.NoError(t, writeTIFF(f, imageRows{color}, true))
+	f.Close()
+	_, _, _, pix = readTestTIFF(t, big)
+	assert.Equal(t, rgb, pix)
+
+	// 临时文件已删除
+	left, _ := filepath.Glob(filepath.Join(dir, ".bookget-*"))
+	assert.Empty(t, left)
+}
+
+func TestMissingTiles(t *testing.T) {
+	src := testScan(700, 500, true).(*image.Gray)
+	ts := newTileServer(t, src, 256, "png") // 3×2 块
+	defer ts.Close()
+	infoURL := ts.URL + "/iiif/img/info.json"
+	dest := filepath.Join(t.TempDir(), "0001.png")
+	cache := newTileCache(dest)
+	cached := func() int {
+		entries, _ := os.ReadDir(cache.dir)
+		return len(entries)
+	}
+
+	// 一块拼图重试后仍失败：整页失败，已下载的拼图保留在缓存中
+	ts.fail.Store("256,256,256,244")
+	d := newTestIIIF()
+	d.maxConcurrent = 1
+	require.Error(t, d.Dezoomify(context.Background(), infoURL, dest, nil))
+	assert.NoFileExists(t, dest)
+	n := cached()
+	assert.Less(t, n, 6)
+
+	// 404/500：服务器上没有这块拼图，默认留空保存并记录
+	for _, code := range []int32{http.StatusNotFound, http.StatusInternalServerError} {
+		ts.status.Store(code)
+		require.NoError(t, d.Dezoomify(context.Background(), infoURL, dest, nil))
+		assert.FileExists(t, dest)
+		assert.True(t, HasMissingTiles(dest))
+		require.NoError(t, os.Remove(dest))
+		require.NoError(t, os.Remove(missingPath(dest)))
+	}
+	ts.status.Store(0)
+	n = cached()
+
+	// best effort：留空保存并记录缺失的拼图，只请求缓存中没有的拼图
+	d.bestEffort = true
+	ts.requests.Store(0)
+	require.NoError(t, d.Dezoomify(context.Background(), infoURL, dest, nil))
+	assert.EqualValues(t, 6-n, ts.requests.Load())
+	assert.True(t, HasMissingTiles(dest))
+	var m missingFile
+	data, err := os.ReadFile(missingPath(dest))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &m))
+	require.Len(t, m.Tiles, 1)
+	assert.Equal(t, [4]int{256, 256, 256, 244}, m.Tiles[0].Region)
+	assert.Equal(t, []int{1, 1}, []int{m.Tiles[0].X, m.Tiles[0].Y})
+	assert.Equal(t, 5, cached())
+
+	// 修补：只下载缺失的一块，完整后删除记录与缓存
+	ts.fail.Store("")
+	ts.requests.Store(0)
+	require.NoError(t, d.Dezoomify(context.Background(), infoURL, dest, nil))
+	assert.EqualValues(t, 1, ts.requests.Load())
+	assert.False(t, HasMissingTiles(dest))
+	assert.NoDirExists(t, cache.dir)
+	f, err := os.Open(dest)
+	require.NoError(t, err)
+	img, err := png.Decode(f)
+	f.Close()
+	require.NoError(t, err)
+	assert.Equal(t, src.Pix, img.(*image.Gray).Pix)
+}
+
+func TestSizeCandidates(t *testing.T) {
+	d := newTestIIIF()
+	parse := func(data string) *IIIFInfo {
+		info, err := d.parseIIIFResponse([]byte(data))
+		require.NoError(t, err)
+		return info
+	}
+
+	// v2：不超出限制时依次为 max、full，再是 sizes 中最大的，用 w,
+	v2 := parse(`{"@context":"http://iiif.io/api/image/2/context.json","@id":"https://a.org/iiif/1","width":2000,"height":1000,
+"profile":["http://iiif.io/api/image/2/level1.json",{"maxWidth":4000}],"sizes":[{"width":500,"height":250},{"width":1000,"height":500}]}`)
+	assert.Equal(t, []string{"max", "full", "1000,"}, v2.sizeCandidates("full"))
+	assert.Equal(t, []string{"1200,", "max", "full", "1000,"}, v2.sizeCandidates("1200,"))
+
+	// v2 超出 maxWidth（maxHeight 未给出时与之相同）：不再请求 full
+	v2.maxWidth, v2.maxHeight = 1500, 1500
+	assert.Equal(t, []string{"max", "1500,", "1000,"}, v2.sizeCandidates("full"))
+
+	// v3：限制在顶层，full 改为 max，用 w,h
+	v3 := parse(`{"@context":"http://iiif.io/api/image/3/context.json","id":"https://a.org/iiif/2","width":3000,"height":2000,
+"maxArea":1500000,"sizes":[{"width":750,"height":500}]}`)
+	assert.EqualValues(t, 1500000, v3.maxArea)
+	w, h := v3.fitSize()
+	assert.LessOrEqual(t, int64(w)*int64(h), int64(1500000))
+	assert.Equal(t, []string{"max", fmt.Sprintf("%d,%d", w, h), "750,500"}, v3.sizeCandidates("full"))
+
+	assert.Equal(t, imageRequest{"full", "max", "0", "default", "jpg"}, parseImageRequest(""))
+	assert.Equal(t, imageRequest{"full", "!2000,2000", "0", "gray", "png"}, parseImageRequest("full/!2000,2000/0/gray.png"))
+}
+
+func TestDownloadImage(t *testing.T) {
+	var body bytes.Buffer
+	require.NoError(t, jpeg.Encode(&body, testScan(1000, 500, false), nil))
+	var requested []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/iiif/img/"), "/")
+		if parts[0] == "info.json" {
+			fmt.Fprintf(w, `{"@context":"http://iiif.io/api/image/2/context.json","@id":"http://%s/iiif/img","protocol":"http://iiif.io/api/image",
+"width":2000,"height":1000,"profile":["http://iiif.io/api/image/2/level1.json",{"maxWidth":1000}]}`, r.Host)
+			return
+		}
+		requested = append(requested, parts[1])
+		switch parts[1] {
+		case "1000,":
+			w.Write(body.Bytes())
+		case "max":
+			// 被截断的图像（没有结束标记）不能当作完整的页面保存
+			w.Write(body.Bytes()[:body.Len()/2])
+		default:
+			http.Error(w, "size not allowed", http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	// max 返回的图像不完整：请求 maxWidth 内的最大尺寸
+	dest := filepath.Join(t.TempDir(), "0001.jpg")
+	d := newTestIIIF()
+	u, err := d.DownloadImage(context.Background(), srv.URL+"/iiif/img/info.json", dest, "full/full/0/default.jpg", nil)
+	require.NoError(t, err)
+	assert.Equal(t, srv.URL+"/iiif/img/full/1000,/0/default.jpg", u)
+	assert.Equal(t, "max", requested[0])
+	assert.Equal(t, "1000,", requested[len(requested)-1])
+	data, err := os.ReadFile(dest)
+	require.NoError(t, err)
+	assert.Equal(t, body.Bytes(), data)
+
+	// 整图请求均失败：改为拼图下载
+	src := testScan(700, 500, true).(*image.Gray)
+	ts := newTileServer(t, src, 256, "png")
+	defer ts.Close()
+	dest = filepath.Join(t.TempDir(), "0001.png")
+	u, err = d.DownloadImage(context.Background(), ts.URL+"/iiif/img/info.json", dest, "full/full/0/default.png", nil)
+	require.NoError(t, err)
+	assert.Equal(t, ts.URL+"/iiif/img/info.json", u)
+	f, err := os.Open(dest)
+	require.NoError(t, err)
+	img, err := png.Decode(f)
+	f.Close()
+	require.NoError(t, err)
+	assert.Equal(t, src.Pix, img.(*image.Gray).Pix)
+}
+
+// readTestTIFF 读取 writeTIFF 写出的（Big）TIFF，返回宽、高、通道数与像素
+func readTestTIFF(t *testing.T, path string) (width, height, ch int, pix []byte) {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	le := binary.LittleEndian
+	big := le.Uint16(data[2:]) == 43
+	var ifd uint64
+	if big {
+		ifd = le.Uint64(data[8:])
+	} else {
+		ifd = uint64(le.Uint32(data[4:]))
+	}
+	tags := make(map[uint16][]uint64)
+	var n, size, inline uint64 = uint64(le.Uint16(data[ifd:])), 12, 4
+	p := ifd + 2
+	if big {
+		n, size, inline, p = le.Uint64(data[ifd:]), 20, 8, ifd+8
+	}
+	for i := uint64(0); i < n; i++ {
+		e := data[p+i*size:]
+		tag, typ := le.Uint16(e), le.Uint16(e[2:])
+		count, value := uint64(le.Uint32(e[4:])), e[8:12]
+		if big {
+			count, value = le.Uint64(e[4:]), e[12:20]
+		}
+		width := map[uint16]uint64{tiffShort: 2, tiffLong: 4, tiffLong8: 8}[typ]
+		if count*width > inline {
+			off := uint64(le.Uint32(value))
+			if big {
+				off = le.Uint64(value)
+			}
+			value = data[off:]
+		}
+		for j := uint64(0); j < count; j++ {
+			switch typ {
+			case tiffShort:
+				tags[tag] = append(tags[tag], uint64(le.Uint16(value[2*j:])))
+			case tiffLong:
+				tags[tag] = append(tags[tag], uint64(le.Uint32(value[4*j:])))
+			case tiffLong8:
+				tags[tag] = append(tags[tag], le.Uint64(value[8*j:]))
+			}
+		}
+	}
+	width, height, ch = int(tags[256][0]), int(tags[257][0]), int(tags[277][0])
+	for i, off := range tags[273] {
+		zr, err := zlib.NewReader(bytes.NewReader(data[off : off+tags[279][i]]))
+		require.NoError(t, err)
+		strip, err := io.ReadAll(zr)
+		require.NoError(t, err)
+		for r := 0; r < len(strip); r += width * ch {
+			row := strip[r : r+width*ch]
+			for j := ch; j < len(row); j++ {
+				row[j] += row[j-ch]
+			}
+		}
+		pix = append(pix, strip...)
+	}
+	return
+}
+
+func benchmarkStitch(b *testing.B, gray bool) {
+	src := testScan(4096, 4096, gray)
+	ts := newTileServer(b, src, 512, "jpg")
+	defer ts.Close()
+	d := newTestIIIF()
+	b.SetBytes(4096 * 4096)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ts.stitch(b, d)
+	}
+}
+
+func BenchmarkStitchGray(b *testing.B)  { benchmarkStitch(b, true) }
+func BenchmarkStitchColor(b *testing.B) { benchmarkStitch(b, false) }
+
+// BenchmarkTileCopy 对比逐像素 Set 与按行整块复制一块 512×512 的彩色拼图
+func BenchmarkTileCopy(b *testing.B) {
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, testScan(512, 512, false), nil)
+	tile, _ := jpeg.Decode(&buf)
+	cell := image.Rect(1024, 1024, 1536, 1536)
+
+	b.Run("pixel", func(b *testing.B) {
+		dst := image.NewRGBA(image.Rect(0, 0, 2048, 2048))
+		for i := 0; i < b.N; i++ {
+			tb := tile.Bounds()
+			for py := tb.Min.Y; py < tb.Max.Y; py++ {
+				for px := tb.Min.X; px < tb.Max.X; px++ {
+					dst.Set(cell.Min.X+px, cell.Min.Y+py, tile.At(px, py))
+				}
+			}
+		}
+	})
+	b.Run("block", func(b *testing.B) {
+		c := newCanvas(2048, 2048)
+		for i := 0; i < b.N; i++ {
+			c.put(tile, cell)
+		}
+	})
+}
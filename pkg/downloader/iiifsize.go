@@ -0,0 +1,213 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"bookget/pkg/logger"
+	"bookget/pkg/retry"
+	"bookget/pkg/util"
+	"bookget/pkg/validate"
+)
+
+// imageRequest IIIF 图像请求 {region}/{size}/{rotation}/{quality}.{format} 的各段，size 只是偏好
+type imageRequest struct {
+	region, size, rotation, quality, format string
+}
+
+// parseImageRequest 解析 --format，缺少的段使用 full/max/0/default.jpg 中对应的值
+func parseImageRequest(s string) imageRequest {
+	r := imageRequest{region: "full", size: "max", rotation: "0", quality: "default", format: "jpg"}
+	m := strings.Split(strings.Trim(s, "/"), "/")
+	if len(m) != 4 {
+		return r
+	}
+	set := func(dst *string, v string) {
+		if v != "" {
+			*dst = v
+		}
+	}
+	set(&r.region, m[0])
+	set(&r.size, m[1])
+	set(&r.rotation, m[2])
+	quality, format, _ := strings.Cut(m[3], ".")
+	set(&r.quality, quality)
+	set(&r.format, format)
+	return r
+}
+
+// fitSize 不超出服务器 maxWidth、maxHeight、maxArea 的最大尺寸（保持宽高比）
+func (info *IIIFInfo) fitSize() (int, int) {
+	w, h := info.Width, info.Height
+	if w <= 0 || h <= 0 {
+		return 0, 0
+	}
+	s := 1.0
+	if info.maxWidth > 0 && w > info.maxWidth {
+		s = min(s, float64(info.maxWidth)/float64(w))
+	}
+	if info.maxHeight > 0 && h > info.maxHeight {
+		s = min(s, float64(info.maxHeight)/float64(h))
+	}
+	if info.maxArea > 0 && int64(w)*int64(h) > info.maxArea {
+		s = min(s, math.Sqrt(float64(info.maxArea)/(float64(w)*float64(h))))
+	}
+	if s == 1 {
+		return w, h
+	}
+	fw := max(1, int(float64(w)*s))
+	fh := max(1, int(float64(h)*s))
+	// 取整后仍可能略超 maxArea
+	for info.maxArea > 0 && int64(fw)*int64(fh) > info.maxArea && fw > 1 {
+		fw--
+		fh = max(1, fw*h/w)
+	}
+	return fw, fh
+}
+
+// sizeParam v2 用 w,（level 1 即支持），v3 用 w,h
+func (info *IIIFInfo) sizeParam(w, h int) string {
+	if info.version == 3 {
+		return fmt.Sprintf("%d,%d", w, h)
+	}
+	return fmt.Sprintf("%d,", w)
+}
+
+// sizeCandidates 依次尝试的 size 参数：用户指定的尺寸；max（v2.1 起支持，v3 只认 max）；v2 不超出限制时再试 full；
+// 超出服务器限制时为限制内的最大尺寸；sizes 中列出的最大尺寸
+func (info *IIIFInfo) sizeCandidates(pref string) []string {
+	var sizes []string
+	add := func(s string) {
+		if s != "" && !slices.Contains(sizes, s) {
+			sizes = append(sizes, s)
+		}
+	}
+	if pref != "full" && pref != "max" {
+		add(pref)
+	}
+	add("max")
+	w, h := info.fitSize()
+	if w == info.Width && h == info.Height {
+		if info.version != 3 {
+			add("full")
+		}
+	} else if w > 0 {
+		add(info.sizeParam(w, h))
+	}
+	var best struct{ w, h int }
+	for _, s := range info.Sizes {
+		if int64(s.Width)*int64(s.Height) > int64(best.w)*int64(best.h) {
+			best.w, best.h = s.Width, s.Height
+		}
+	}
+	if best.w > 0 && best.h > 0 {
+		add(info.sizeParam(best.w, best.h))
+	}
+	return sizes
+}
+
+// imageURLs 按 info 协商出的整图 URL，按顺序尝试。quality 不在服务器支持的列表中时改用 bestQuality
+func (d *IIIFDownloader) imageURLs(info *IIIFInfo, base string, req imageRequest) []string {
+	if info.baseURL != "" {
+		base = info.baseURL
+	}
+	profile, _ := d.parseProfile(info.Profile)
+	qualities := append(slices.Clone(info.Qualities), profile.Qualities...)
+	if len(qualities) > 0 && !slices.ContainsFunc(qualities, func(q string) bool { return strings.EqualFold(q, req.quality) }) {
+		req.quality = d.bestQuality(info)
+	}
+	sizes := info.sizeCandidates(req.size)
+	urls := make([]string, 0, len(sizes))
+	for _, size := range sizes {
+		urls = append(urls, fmt.Sprintf("%s/%s/%s/%s/%s.%s", base, req.region, size, req.rotation, req.quality, req.format))
+	}
+	return urls
+}
+
+// DownloadImage 下载 infoURL 描述的整张图像并保存到 outputPath，返回实际保存的图像 URL（改为拼图下载时为 infoURL）。
+// format 即 --format（如 full/full/0/default.jpg），其中的 size 只是偏好：先读取 info.json，按服务器的版本与
+// maxWidth、maxHeight、maxArea、sizes 依次请求允许的最大尺寸，都被拒绝时改为拼图下载。
+// 读取 info.json 失败时按 format 原样请求
+func (d *IIIFDownloader) DownloadImage(ctx context.Context, infoURL string, outputPath string, format string, args []string) (string, error) {
+	headers, err := d.argsToHeaders(args)
+	if err != nil {
+		return "", fmt.Errorf("转换header失败: %v", err)
+	}
+	log := logger.FromContext(ctx)
+	info, urls, err := d.negotiate(ctx, infoURL, format, headers)
+	if err != nil {
+		return "", err
+	}
+
+	var lastErr error
+	for _, u := range urls {
+		data, err := d.fetchImage(ctx, u, headers, filepath.Ext(outputPath))
+		if err == nil {
+			return u, util.WriteFileAtomic(outputPath, data, 0644)
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		log.Debug("服务器未接受该尺寸，尝试下一种", logger.KeyUrl, u, "error", err)
+		lastErr = err
+	}
+	if info != nil && len(info.Tiles) > 0 {
+		log.Info("整图请求均失败，改为拼图下载", logger.KeyUrl, infoURL, "error", lastErr)
+		return infoURL, d.saveTiles(ctx, info, headers, outputPath)
+	}
+	return "", lastErr
+}
+
+// ImageURL 按 info.json 协商出的首选整图 URL，不下载图像，供 dry-run 显示下载计划
+func (d *IIIFDownloader) ImageURL(ctx context.Context, infoURL string, format string, args []string) (string, error) {
+	headers, err := d.argsToHeaders(args)
+	if err != nil {
+		return "", fmt.Errorf("转换header失败: %v", err)
+	}
+	_, urls, err := d.negotiate(ctx, infoURL, format, headers)
+	if err != nil {
+		return "", err
+	}
+	return urls[0], nil
+}
+
+// negotiate 读取 info.json，返回依次尝试的整图 URL；读取失败时 info 为 nil，只有按 format 原样请求的 URL
+func (d *IIIFDownloader) negotiate(ctx context.Context, infoURL string, format string, headers http.Header) (*IIIFInfo, []string, error) {
+	base := strings.TrimSuffix(infoURL, "/info.json")
+	var info *IIIFInfo
+	err := retry.New(d.maxRetries).Do(ctx, func(int) (err error) {
+		info, err = d.fetchIIIFInfo(ctx, infoURL, headers)
+		return err
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		logger.FromContext(ctx).Warn("获取图像信息失败，按 --format 原样请求", logger.KeyUrl, infoURL, "error", err)
+		return nil, []string{base + "/" + strings.TrimPrefix(format, "/")}, nil
+	}
+	return info, d.imageURLs(info, base, parseImageRequest(format)), nil
+}
+
+// fetchImage 按重试策略下载整图，内容须为完整的图像（有的服务器对超限的请求返回 200 的错误页面，
+// 也可能在传输中途断开），ext 为保存的扩展名
+func (d *IIIFDownloader) fetchImage(ctx context.Context, url string, headers http.Header, ext string) ([]byte, error) {
+	var data []byte
+	err := retry.New(d.maxRetries).Do(ctx, func(int) error {
+		body, contentType, err := d.fetch(ctx, url, headers)
+		if err != nil {
+			return err
+		}
+		if err := validate.Bytes(body, ext, contentType); err != nil {
+			return err
+		}
+		data = body
+		return nil
+	})
+	return data, err
+}
@@ -0,0 +1,249 @@
+package downloader
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// spoolChunkRows At 每次从磁盘读入的行数，是 JPEG 编码块高度（8 或 16）的倍数
+const spoolChunkRows = 64
+
+// spool 磁盘上的拼接结果：逐行存放的 8 位灰度或 RGB 像素，文件为稀疏文件，未写入的区域为黑色。
+// 拼接时内存中只有正在处理的拼图，编码时按行读出
+type spool struct {
+	f    *os.File
+	rect image.Rectangle
+
+	mu sync.RWMutex // 写入拼图时读锁，转换像素格式时写锁
+	ch int          // 每像素字节数：1 灰度，3 RGB；由第一块拼图决定，之后出现彩色拼图时转为 RGB
+
+	// At 的读缓存，只在编码（单个协程）时使用
+	chunk  []byte
+	chunkY int
+	err    error
+}
+
+// newSpool 在 dir 中创建临时文件，通常为输出文件所在的目录
+func newSpool(dir string, width, height int) (*spool, error) {
+	f, err := os.CreateTemp(dir, ".bookget-*.raw")
+	if err != nil {
+		return nil, err
+	}
+	return &spool{f: f, rect: image.Rect(0, 0, width, height), chunkY: -1}, nil
+}
+
+// Close 关闭并删除临时文件
+func (s *spool) Close() error {
+	err := s.f.Close()
+	if rerr := os.Remove(s.f.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// setChannels 确定像素格式并分配文件大小；已是灰度而 ch 为 3 时转为 RGB，其他情况不做任何事
+func (s *spool) setChannels(ch int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ch >= ch {
+		return nil
+	}
+	if s.ch == 0 {
+		s.ch = ch
+		return s.f.Truncate(int64(s.rect.Dx()) * int64(s.rect.Dy()) * int64(ch))
+	}
+	return s.promote()
+}
+
+// promote 将灰度文件改写为 RGB 的新文件，调用方持有写锁。全黑（未写入）的行不写，保持稀疏
+func (s *spool) promote() error {
+	f, err := os.CreateTemp(filepath.Dir(s.f.Name()), ".bookget-*.raw")
+	if err != nil {
+		return err
+	}
+	w := s.rect.Dx()
+	if err := f.Truncate(int64(w) * int64(s.rect.Dy()) * 3); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	gray := make([]byte, w)
+	rgb := make([]byte, w*3)
+	for y := 0; y < s.rect.Dy(); y++ {
+		if _, err = s.f.ReadAt(gray, int64(y)*int64(w)); err != nil {
+			break
+		}
+		if !slices.ContainsFunc(gray, func(v byte) bool { return v != 0 }) {
+			continue
+		}
+		for x, v := range gray {
+			rgb[3*x], rgb[3*x+1], rgb[3*x+2] = v, v, v
+		}
+		if _, err = f.WriteAt(rgb, int64(y)*int64(w)*3); err != nil {
+			break
+		}
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	s.f.Close()
+	os.Remove(s.f.Name())
+	s.f, s.ch = f, 3
+	return nil
+}
+
+func (s *spool) offset(x, y int) int64 {
+	return (int64(y)*int64(s.rect.Dx()) + int64(x)) * int64(s.ch)
+}
+
+// put 同 canvas.put，逐行写入文件。灰度文件中出现彩色拼图时先转为 RGB，与拼图的解码顺序无关
+func (s *spool) put(tile image.Image, cell image.Rectangle) error {
+	sb := tile.Bounds()
+	r := image.Rectangle{Min: cell.Min, Max: cell.Min.Add(sb.Size())}.Intersect(cell).Intersect(s.rect)
+	if r.Empty() {
+		return nil
+	}
+	ch := 3
+	if kindOf(tile) == kindGray {
+		ch = 1
+	}
+	if err := s.setChannels(ch); err != nil {
+		return err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	buf := make([]byte, r.Dx()*s.ch)
+	for y := 0; y < r.Dy(); y++ {
+		encodeRow(buf, tile, sb.Min.X, sb.Min.Y+y, s.ch)
+		if _, err := s.f.WriteAt(buf, s.offset(r.Min.X, r.Min.Y+y)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *spool) Bounds() image.Rectangle { return s.rect }
+
+func (s *spool) channels() int {
+	s.mu.RLock()
+	ch := s.ch
+	s.mu.RUnlock()
+	if ch == 0 {
+		s.setChannels(3) // 没有任何拼图时为空白的 RGB 图像
+	}
+	return s.ch
+}
+
+func (s *spool) readRow(y int, buf []byte) error {
+	_, err := s.f.ReadAt(buf, s.offset(0, y))
+	return err
+}
+
+// ColorModel、At 使 spool 可直接交给 jpeg.Encode。读取错误记录在 s.err 中
+func (s *spool) ColorModel() color.Model {
+	if s.channels() == 1 {
+		return color.GrayModel
+	}
+	return color.RGBAModel
+}
+
+func (s *spool) At(x, y int) color.Color {
+	if !(image.Point{X: x, Y: y}.In(s.rect)) {
+		return color.Gray{}
+	}
+	ch := s.ch
+	if ch == 0 {
+		ch = s.channels()
+	}
+	if cy := y - y%spoolChunkRows; cy != s.chunkY {
+		n := min(spoolChunkRows, s.rect.Max.Y-cy) * s.rect.Dx() * ch
+		if cap(s.chunk) < n {
+			s.chunk = make([]byte, n)
+		}
+		s.chunk = s.chunk[:n]
+		if _, err := s.f.ReadAt(s.chunk, s.offset(0, cy)); err != nil && s.err == nil {
+			s.err = err
+		}
+		s.chunkY = cy
+	}
+	i := ((y-s.chunkY)*s.rect.Dx() + x) * ch
+	if ch == 1 {
+		return color.Gray{Y: s.chunk[i]}
+	}
+	return color.RGBA{R: s.chunk[i], G: s.chunk[i+1], B: s.chunk[i+2], A: 0xff}
+}
+
+// encodeRow 将 img 第 y 行自 x0 起的 len(dst)/ch 个像素写为 8 位灰度（ch 为 1）或 RGB（ch 为 3），透明度丢弃
+func encodeRow(dst []byte, img image.Image, x0, y, ch int) {
+	n := len(dst) / ch
+	switch m := img.(type) {
+	case *image.Gray:
+		i := m.PixOffset(x0, y)
+		if ch == 1 {
+			copy(dst, m.Pix[i:i+n])
+			return
+		}
+		for x := 0; x < n; x++ {
+			v := m.Pix[i+x]
+			dst[3*x], dst[3*x+1], dst[3*x+2] = v, v, v
+		}
+		return
+	case *image.YCbCr:
+		if ch == 1 {
+			i := m.YOffset(x0, y)
+			copy(dst, m.Y[i:i+n]) // 亮度即灰度
+			return
+		}
+		for x := 0; x < n; x++ {
+			ci := m.COffset(x0+x, y)
+			dst[3*x], dst[3*x+1], dst[3*x+2] = color.YCbCrToRGB(m.Y[m.YOffset(x0+x, y)], m.Cb[ci], m.Cr[ci])
+		}
+		return
+	case *image.RGBA:
+		if ch == 3 {
+			i := m.PixOffset(x0, y)
+			for x := 0; x < n; x++ {
+				copy(dst[3*x:3*x+3], m.Pix[i+4*x:i+4*x+3])
+			}
+			return
+		}
+	}
+	for x := 0; x < n; x++ {
+		c := img.At(x0+x, y)
+		if ch == 1 {
+			dst[x] = color.GrayModel.Convert(c).(color.Gray).Y
+			continue
+		}
+		r, g, b, _ := c.RGBA()
+		dst[3*x], dst[3*x+1], dst[3*x+2] = uint8(r>>8), uint8(g>>8), uint8(b>>8)
+	}
+}
+
+// rowSource 按行读出的图像，供 TIFF、PNG 的流式编码使用
+type rowSource interface {
+	Bounds() image.Rectangle
+	channels() int                   // 1 灰度，3 RGB
+	readRow(y int, buf []byte) error // 读出第 y 行，len(buf) 为宽度×channels
+}
+
+// imageRows 内存中的图像作为 rowSource
+type imageRows struct{ image.Image }
+
+func (m imageRows) channels() int {
+	if _, ok := m.Image.(*image.Gray); ok {
+		return 1
+	}
+	return 3
+}
+
+func (m imageRows) readRow(y int, buf []byte) error {
+	b := m.Bounds()
+	encodeRow(buf, m.Image, b.Min.X, b.Min.Y+y, m.channels())
+	return nil
+}
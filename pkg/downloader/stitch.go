@@ -0,0 +1,268 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"bookget/pkg/logger"
+	"bookget/pkg/retry"
+	"bookget/pkg/util"
+)
+
+// maxCanvasPixels 不超过此像素数的图像在内存中拼接，更大的（地图、长卷等）拼接到磁盘，
+// 内存中只保留正在处理的拼图
+var maxCanvasPixels int64 = 64 << 20
+
+// jpegMaxSize JPEG 的宽、高上限
+const jpegMaxSize = 65535
+
+// tileSpec 一块拼图：第 (x, y) 块，从 url 下载，放入画布的 cell 区域（各块互不重叠）
+type tileSpec struct {
+	x, y int
+	url  string
+	cell image.Rectangle
+}
+
+// tilePlan 一张图的尺寸与全部拼图
+type tilePlan struct {
+	width, height int
+	tiles         []tileSpec
+}
+
+// tileSink 拼接的目标：内存中的 canvas 或磁盘上的 spool。put 会被并发调用，各次的 cell 互不重叠
+type tileSink interface {
+	put(tile image.Image, cell image.Rectangle) error
+}
+
+type fetchedTile struct {
+	tileSpec
+	data []byte
+}
+
+// overlapCell 有重叠的网格中第 (x, y) 块负责的区域：除第一行/列外跳过 overlap 像素，到下一块的起点为止
+func overlapCell(x, y, step, overlap int, bounds image.Rectangle) image.Rectangle {
+	start := func(i int) int {
+		if i == 0 {
+			return 0
+		}
+		return i*step + overlap
+	}
+	return image.Rect(start(x), start(y), start(x+1), start(y+1)).Intersect(bounds)
+}
+
+// stitch 下载 plan 中的拼图并写入 dst，返回缺失的拼图。
+// maxConcurrent 个协程下载，GOMAXPROCS 个协程解码并按块复制到各自的区域，下载与解码互不阻塞。
+// 拼图优先从 cache 读取，下载后写入 cache。重试后仍为 404/500 的拼图（服务器上没有）记为缺失，留空保存；
+// 其它错误使整页失败，bestEffort 时同样记为缺失
+func (d *IIIFDownloader) stitch(parent context.Context, plan *tilePlan, dst tileSink, headers http.Header, cache *tileCache) ([]missingTile, error) {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	progress := d.startTiles(len(plan.tiles))
+	defer progress.finish()
+
+	var (
+		mu       sync.Mutex
+		missing  []missingTile
+		once     sync.Once
+		firstErr error
+	)
+	miss := func(t tileSpec, err error) {
+		progress.done(t.url, err)
+		mu.Lock()
+		missing = append(missing, missingTile{t, err})
+		mu.Unlock()
+	}
+	fail := func(t tileSpec, err error) {
+		if ctx.Err() != nil {
+			return // 已取消，后续的错误都是取消导致的
+		}
+		if d.bestEffort || tileGone(err) {
+			miss(t, err)
+			return
+		}
+		progress.done(t.url, err)
+		once.Do(func() {
+			firstErr = fmt.Errorf("下载拼图(%d,%d)失败: %w", t.x, t.y, err)
+			cancel()
+		})
+	}
+
+	jobs := make(chan tileSpec)
+	go func() {
+		defer close(jobs)
+		for _, t := range plan.tiles {
+			select {
+			case jobs <- t:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	decoders := runtime.GOMAXPROCS(0)
+	fetched := make(chan fetchedTile, decoders)
+	var fetchers sync.WaitGroup
+	for i := 0; i < max(d.maxConcurrent, 1); i++ {
+		fetchers.Add(1)
+		go func() {
+			defer fetchers.Done()
+			for t := range jobs {
+				progress.started(t.url)
+				data, ok := cache.load(t.url)
+				if !ok {
+					var err error
+					data, err = d.fetchTileWithRetry(ctx, t.url, headers)
+					if err != nil {
+						fail(t, err)
+						continue
+					}
+					if err := cache.store(t.url, data); err != nil {
+						logger.FromContext(ctx).Debug("缓存拼图失败", logger.KeyUrl, t.url, "error", err)
+					}
+				}
+				select {
+				case fetched <- fetchedTile{t, data}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		fetchers.Wait()
+		close(fetched)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < decoders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range fetched {
+				img, err := decodeTile(t.data)
+				if err != nil {
+					// 内容不完整等，重新下载
+					cache.remove(t.url)
+					img, err = d.downloadImageWithRetry(ctx, t.url, headers, d.maxRetries)
+				}
+				if err == nil {
+					err = dst.put(img, t.cell)
+				}
+				if err != nil {
+					fail(t.tileSpec, err)
+					continue
+				}
+				progress.done(t.url, nil)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := parent.Err(); err != nil {
+		return nil, err
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return missing, nil
+}
+
+// tileGone 重试后仍为 404/500：服务器上没有这块拼图，重新下载整页也不会有
+func tileGone(err error) bool {
+	var se *retry.StatusError
+	return errors.As(err, &se) && (se.Code == http.StatusNotFound || se.Code == http.StatusInternalServerError)
+}
+
+// saveTiles 下载、拼接 info 描述的图像并保存到 outputPath，按图像大小选择在内存或磁盘上拼接。
+// 有缺失的拼图时留空保存，缺失的拼图记录在 outputPath.missing.json 中，并保留拼图缓存供 --retry-missing-tiles 修补
+func (d *IIIFDownloader) saveTiles(ctx context.Context, info interface{}, headers http.Header, outputPath string) error {
+	plan, err := d.planTiles(info)
+	if err != nil {
+		return fmt.Errorf("处理拼图失败: %w", err)
+	}
+	cache := newTileCache(outputPath)
+	var missing []missingTile
+	if int64(plan.width)*int64(plan.height) <= maxCanvasPixels {
+		missing, err = d.saveInMemory(ctx, plan, headers, outputPath, cache)
+	} else {
+		missing, err = d.saveOnDisk(ctx, plan, headers, outputPath, cache)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := writeMissing(outputPath, plan, missing); err != nil {
+		return fmt.Errorf("记录缺失的拼图失败: %w", err)
+	}
+	if len(missing) > 0 {
+		logger.FromContext(ctx).Warn("部分拼图缺失，已留空保存", "dest", outputPath,
+			"missing", len(missing), "total", len(plan.tiles), "record", missingPath(outputPath))
+		return nil
+	}
+	return cache.clear()
+}
+
+func (d *IIIFDownloader) saveInMemory(ctx context.Context, plan *tilePlan, headers http.Header, outputPath string, cache *tileCache) ([]missingTile, error) {
+	c := newCanvas(plan.width, plan.height)
+	missing, err := d.stitch(ctx, plan, c, headers, cache)
+	if err != nil {
+		return nil, fmt.Errorf("处理拼图失败: %w", err)
+	}
+	if err := d.saveImage(c.image(), outputPath); err != nil {
+		return nil, fmt.Errorf("保存图像失败: %w", err)
+	}
+	return missing, nil
+}
+
+func (d *IIIFDownloader) saveOnDisk(ctx context.Context, plan *tilePlan, headers http.Header, outputPath string, cache *tileCache) ([]missingTile, error) {
+	encode, err := d.spoolEncoder(plan, outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("保存图像失败: %w", err)
+	}
+	logger.FromContext(ctx).Debug("图像较大，拼接到磁盘", "width", plan.width, "height", plan.height)
+	s, err := newSpool(filepath.Dir(outputPath), plan.width, plan.height)
+	if err != nil {
+		return nil, fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	defer s.Close()
+	missing, err := d.stitch(ctx, plan, s, headers, cache)
+	if err != nil {
+		return nil, fmt.Errorf("处理拼图失败: %w", err)
+	}
+	if err := util.WriteFileAtomicFunc(outputPath, 0644, func(w io.Writer) error { return encode(w, s) }); err != nil {
+		return nil, fmt.Errorf("保存图像失败: %w", err)
+	}
+	return missing, nil
+}
+
+// spoolEncoder 按扩展名返回从 spool 流式编码的函数，下载拼图前检查格式与尺寸
+func (d *IIIFDownloader) spoolEncoder(plan *tilePlan, path string) (func(w io.Writer, s *spool) error, error) {
+	switch ext := path[len(path)-4:]; ext {
+	case ".jpg", "jpeg":
+		if plan.width > jpegMaxSize || plan.height > jpegMaxSize {
+			return nil, fmt.Errorf("图像 %dx%d 超出 JPEG 的尺寸上限 %d，请使用 --ext .tif", plan.width, plan.height, jpegMaxSize)
+		}
+		return func(w io.Writer, s *spool) error {
+			if err := jpeg.Encode(w, s, &jpeg.Options{Quality: d.jpgQuality}); err != nil {
+				return err
+			}
+			return s.err
+		}, nil
+	case ".png":
+		return func(w io.Writer, s *spool) error { return writePNG(w, s) }, nil
+	case ".tif", "tiff":
+		return func(w io.Writer, s *spool) error {
+			return writeTIFF(w, s, needBigTIFF(plan.width, plan.height, s.channels()))
+		}, nil
+	}
+	return nil, fmt.Errorf("不支持的图像格式: %s", filepath.Ext(path))
+}
@@ -0,0 +1,100 @@
+package downloader
+
+import (
+	"bookget/pkg/event"
+	"bookget/pkg/progressbar"
+	"bookget/pkg/ratelimit"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Terminal 在终端显示进度条的事件订阅者，DownloadManager、IIIFDownloader 默认订阅
+type Terminal struct {
+	Summary bool // 开始时输出任务数与并发数，结束时输出成功、失败数与耗时
+
+	mu       sync.Mutex
+	bar      *progressbar.ProgressBar
+	desc     string
+	inBytes  bool
+	total    int64 // 按字节显示时已知的总大小
+	jobs     int   // 进行中的作业数，多页同时拼图时共用一个进度条
+	prompted bool
+}
+
+// barDescription 进度条说明，设置了全局限速时附上限速值；速度与剩余时间按实际（限速后）的速度计算
+func barDescription(job string) string {
+	if bw := ratelimit.BandwidthString(); bw != "" {
+		return job + " (" + bw + ")"
+	}
+	return job
+}
+
+func (t *Terminal) Notify(e event.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch e.Kind {
+	case event.JobQueued:
+		t.newBar(e)
+	case event.JobStarted:
+		switch {
+		case t.bar == nil:
+			t.newBar(e)
+		case t.jobs > 0 && !t.inBytes:
+			// 前一个作业未结束，任务数累加到同一个进度条
+			t.total += e.Total
+			t.bar.ChangeMax64(t.total)
+		}
+		t.jobs++
+		if t.Summary && !t.prompted {
+			fmt.Printf("\n开始下载任务 (最大并发数: %d)...\n", e.Workers)
+			fmt.Printf("总任务数: %d\n", e.Total)
+			t.prompted = true
+		}
+	case event.TaskStarted:
+		if t.inBytes && e.Total > 0 && t.bar != nil {
+			t.total += e.Total
+			t.bar.ChangeMax64(t.total)
+		}
+	case event.Progress:
+		if t.inBytes && t.bar != nil {
+			_ = t.bar.Add64(e.Bytes)
+		}
+	case event.TaskCompleted:
+		if t.bar == nil {
+			return
+		}
+		if !t.inBytes {
+			_ = t.bar.Add(1) // 每个任务完成时进度条+1
+		}
+		// 运行中可能调整了限速
+		if desc := barDescription(e.Job); desc != t.desc {
+			t.desc = desc
+			t.bar.Describe(desc)
+		}
+	case event.JobFinished:
+		if t.jobs--; t.jobs > 0 {
+			return // 其他作业仍在进行
+		}
+		t.jobs = 0
+		if t.bar != nil {
+			_ = t.bar.Finish()
+			t.bar = nil
+		}
+		if t.Summary {
+			fmt.Printf("\n下载完成! 成功: %d, 失败: %d, 耗时: %v\n",
+				e.Succeeded, e.Failed, e.Elapsed.Round(time.Millisecond))
+		}
+	}
+}
+
+func (t *Terminal) newBar(e event.Event) {
+	t.inBytes = e.InBytes
+	t.total = 0 // 按字节显示时，总大小在各任务开始时才知道
+	if !t.inBytes {
+		t.total = e.Total
+	}
+	t.desc = barDescription(e.Job)
+	t.bar = progressbar.Default(t.total, t.desc)
+}
@@ -0,0 +1,118 @@
+package downloader
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"bookget/pkg/util"
+)
+
+// tileCache 一页的拼图缓存：已下载的拼图按 URL 存在输出文件旁的 .<文件名>.tiles 目录中。
+// 页面失败或有缺失的拼图时保留，再次下载该页只请求缓存中没有的拼图；页面完整保存后删除
+type tileCache struct {
+	dir string
+}
+
+func newTileCache(outputPath string) *tileCache {
+	return &tileCache{dir: filepath.Join(filepath.Dir(outputPath), "."+filepath.Base(outputPath)+".tiles")}
+}
+
+func (c *tileCache) path(url string) string {
+	sum := sha1.Sum([]byte(url))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// load 返回缓存的拼图，nil 缓存总是未命中
+func (c *tileCache) load(url string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	data, err := os.ReadFile(c.path(url))
+	return data, err == nil && len(data) > 0
+}
+
+func (c *tileCache) store(url string, data []byte) error {
+	if c == nil {
+		return nil
+	}
+	if err := os.MkdirAll(c.dir, os.ModePerm); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(c.path(url), data, 0644)
+}
+
+func (c *tileCache) remove(url string) {
+	if c != nil {
+		os.Remove(c.path(url))
+	}
+}
+
+func (c *tileCache) clear() error {
+	if c == nil {
+		return nil
+	}
+	return os.RemoveAll(c.dir)
+}
+
+// missingTile 未能写入图像的拼图
+type missingTile struct {
+	tileSpec
+	err error
+}
+
+// missingFile 记录缺失拼图的文件，与图像同名加 .missing.json
+type missingFile struct {
+	Width  int           `json:"width"`
+	Height int           `json:"height"`
+	Tiles  []missingJSON `json:"tiles"`
+}
+
+type missingJSON struct {
+	X      int    `json:"x"` // 拼图的列、行
+	Y      int    `json:"y"`
+	Url    string `json:"url"`
+	Region [4]int `json:"region"` // 在图像中的位置：x, y, 宽, 高
+	Error  string `json:"error"`
+}
+
+func missingPath(outputPath string) string {
+	return outputPath + ".missing.json"
+}
+
+// HasMissingTiles 图像是否有缺失的拼图（404/500 或 --best-effort-tiles 时留空保存），可用 --retry-missing-tiles 修补
+func HasMissingTiles(outputPath string) bool {
+	_, err := os.Stat(missingPath(outputPath))
+	return err == nil
+}
+
+// writeMissing 按行列顺序写入缺失的拼图；没有缺失时删除旧的记录
+func writeMissing(outputPath string, plan *tilePlan, missing []missingTile) error {
+	if len(missing) == 0 {
+		if err := os.Remove(missingPath(outputPath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		if missing[i].y != missing[j].y {
+			return missing[i].y < missing[j].y
+		}
+		return missing[i].x < missing[j].x
+	})
+	f := missingFile{Width: plan.width, Height: plan.height}
+	for _, m := range missing {
+		r := m.cell
+		f.Tiles = append(f.Tiles, missingJSON{X: m.x, Y: m.y, Url: m.url,
+			Region: [4]int{r.Min.X, r.Min.Y, r.Dx(), r.Dy()}, Error: m.err.Error()})
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(missingPath(outputPath), data, 0644)
+}
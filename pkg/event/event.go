@@ -0,0 +1,99 @@
+package event
+
+import (
+	"sync"
+	"time"
+)
+
+// Kind 事件类型
+type Kind int
+
+const (
+	JobQueued     Kind = iota // 已知任务总数，尚未开始
+	JobStarted                // 开始执行一批任务
+	TaskStarted               // 单个任务开始（首次尝试），Total 为文件大小
+	Progress                  // 已下载 Bytes 字节
+	TaskCompleted             // 单个任务成功
+	TaskFailed                // 单个任务用完重试次数后失败
+	TaskRetried               // 单个任务失败后开始第 Attempt 次尝试
+	JobFinished               // 一批任务全部结束
+)
+
+var kindNames = [...]string{"job_queued", "job_started", "task_started", "progress",
+	"task_completed", "task_failed", "task_retried", "job_finished"}
+
+func (k Kind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return "unknown"
+	}
+	return kindNames[k]
+}
+
+// Event 下载过程中的事件，未用到的字段为零值
+type Event struct {
+	Kind Kind
+	Job  string // 作业说明，如 downloading、downloading tiles
+	Task string // 任务标识，通常为 URL
+	File string // 保存路径
+
+	Total   int64 // JobQueued/JobStarted：任务数；TaskStarted：文件大小，未知为 0
+	Bytes   int64 // Progress：本次新增的字节数；TaskCompleted：文件大小
+	InBytes bool  // JobQueued/JobStarted：进度按字节而不是任务数计
+	Workers int   // JobStarted：并发数
+
+	Attempt int   // TaskRetried：第几次尝试
+	Err     error // TaskFailed：最终错误；TaskRetried：上一次的错误
+
+	Succeeded int           // JobFinished：成功的任务数
+	Failed    int           // JobFinished：失败的任务数
+	Elapsed   time.Duration // JobFinished：耗时
+}
+
+// Observer 事件订阅者。Notify 在下载协程中同步调用，须并发安全并尽快返回
+type Observer interface {
+	Notify(e Event)
+}
+
+// Func 将函数用作 Observer
+type Func func(e Event)
+
+func (f Func) Notify(e Event) { f(e) }
+
+// Bus 将事件分发给所有订阅者，零值可用，nil 时 Emit 不做任何事
+type Bus struct {
+	mu   sync.RWMutex
+	subs []*sub
+}
+
+type sub struct{ Observer }
+
+// Subscribe 添加订阅者，返回取消订阅的函数
+func (b *Bus) Subscribe(o Observer) (unsubscribe func()) {
+	s := &sub{o}
+	b.mu.Lock()
+	b.subs = append(b.subs, s)
+	b.mu.Unlock()
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for i, v := range b.subs {
+			if v == s {
+				b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Emit 按订阅顺序通知所有订阅者
+func (b *Bus) Emit(e Event) {
+	if b == nil {
+		return
+	}
+	b.mu.RLock()
+	subs := b.subs
+	b.mu.RUnlock()
+	for _, s := range subs {
+		s.Notify(e)
+	}
+}
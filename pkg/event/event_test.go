@@ -0,0 +1,23 @@
+package event
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBus(t *testing.T) {
+	var b Bus
+	var got []string
+	unsubscribe := b.Subscribe(Func(func(e Event) { got = append(got, "a:"+e.Kind.String()) }))
+	b.Subscribe(Func(func(e Event) { got = append(got, "b:"+e.Kind.String()) }))
+
+	b.Emit(Event{Kind: JobStarted})
+	unsubscribe()
+	b.Emit(Event{Kind: JobFinished})
+	assert.Equal(t, []string{"a:job_started", "b:job_started", "b:job_finished"}, got)
+
+	var nilBus *Bus
+	nilBus.Emit(Event{Kind: Progress}) // 不应 panic
+	assert.Equal(t, "unknown", Kind(99).String())
+}
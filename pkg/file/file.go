@@ -5,9 +5,9 @@ import (
 	"strings"
 )
 
-func Ext(uri string) string {
-	if config.Conf.FileExt != "" && config.Conf.FileExt[0] == '.' {
-		return config.Conf.FileExt
+func Ext(uri string, c *config.Input) string {
+	if c.FileExt != "" && c.FileExt[0] == '.' {
+		return c.FileExt
 	}
 	return Extention(uri)
 }
@@ -1,12 +1,19 @@
 package gohttp
 
 import (
+	"bookget/pkg/ratelimit"
+	"bookget/pkg/resume"
+	"bookget/pkg/retry"
+	"bookget/pkg/validate"
+	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -31,8 +38,10 @@ func ByteUnitString(n int64) string {
 
 // Info holds downloadable file info.
 type Info struct {
-	Size      uint64
-	Rangeable bool
+	Size        uint64
+	Rangeable   bool
+	Validators  resume.Validators // ETag/Last-Modified，用于判断能否续传
+	ContentType string            // 用于检查下载的内容
 }
 
 // Download holds downloadable file config and infos.
@@ -50,6 +59,8 @@ type Download struct {
 	Interval, ChunkSize, MinChunkSize, MaxChunkSize uint64
 	opts                                            Options
 	mutex                                           *sync.RWMutex
+	state                                           *resume.State // .downloading 文件的续传记录
+	resumed                                         bool
 }
 
 // TotalSize returns file total size (0 if unknown).
@@ -77,6 +88,15 @@ func (d *Download) AvgSleep() uint64 {
 	return 0
 }
 
+// ETA 按平均速度估算的剩余时间，限速时即按限速后的速度估算
+func (d *Download) ETA() time.Duration {
+	avg := d.AvgSleep()
+	if avg == 0 || d.Size() >= d.TotalSize() {
+		return 0
+	}
+	return time.Duration(float64(d.TotalSize()-d.Size()) / float64(avg) * float64(time.Second)).Round(time.Second)
+}
+
 // TotalCost returns download duration.
 func (d *Download) TotalCost() time.Duration {
 	return time.Now().Sub(d.startedAt)
@@ -101,18 +121,47 @@ func (r *Response) dlFile(d *Download) (size int64, err error) {
 	//if r.resp.StatusCode != 200 || r.resp.ContentLength == -1 {
 	//	return 0, errors.New(r.resp.Status)
 	//}
+	// 期望文件时却返回网页，可能是人机验证或登录过期
+	var body io.Reader = r.resp.Body
+	if ct := r.resp.Header.Get("Content-Type"); strings.Contains(ct, "html") && !isHTMLFile(d.Dest) {
+		head := make([]byte, 4096)
+		n, _ := io.ReadFull(r.resp.Body, head)
+		if retry.IsChallenge(ct, head[:n]) {
+			return 0, retry.ErrChallenge
+		}
+		body = io.MultiReader(bytes.NewReader(head[:n]), r.resp.Body)
+	}
 	var destTemp = fmt.Sprintf("%s.downloading", d.Dest)
 	file, err := os.Create(destTemp)
-	defer os.Rename(destTemp, d.Dest)
-	defer file.Close()
 	if err != nil {
 		return
 	}
-	// Allocate the file completely so that we can write concurrently
-	file.Truncate(r.resp.ContentLength)
-	size, err = io.Copy(file, io.TeeReader(r.resp.Body, d))
+	size, err = io.Copy(file, io.TeeReader(body, d))
+	if err == nil && r.resp.ContentLength > 0 && size != r.resp.ContentLength {
+		err = fmt.Errorf("incomplete download: %d/%d bytes", size, r.resp.ContentLength)
+	}
+	// 只有完整下载才改名为目标文件，中断或出错时删除临时文件
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = validate.File(destTemp, filepath.Ext(d.Dest), r.resp.Header.Get("Content-Type"))
+	}
+	if err == nil {
+		err = os.Rename(destTemp, d.Dest)
+	}
+	if err != nil {
+		os.Remove(destTemp)
+	}
 	return
 }
+
+// isHTMLFile 要保存的本身就是网页
+func isHTMLFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".html" || ext == ".htm"
+}
+
 func dlProgressBar(wg *sync.WaitGroup, d *Download) {
 	defer wg.Done()
 	// Set default interval.
@@ -136,8 +185,12 @@ func dlProgressBar(wg *sync.WaitGroup, d *Download) {
 		for k := 0; k < after; k++ {
 			Sleep += " "
 		}
-		fmt.Fprintf(os.Stdout, "\r%d%%[%s]  %s/%s  %s/s    in %s", pd, Sleep, ByteUnitString(int64(d.Size())),
-			ByteUnitString(int64(d.TotalSize())), ByteUnitString(int64(d.AvgSleep())), d.TotalCost())
+		limit := ""
+		if bw := ratelimit.BandwidthString(); bw != "" {
+			limit = "  " + bw
+		}
+		fmt.Fprintf(os.Stdout, "\r%d%%[%s]  %s/%s  %s/s    in %s  eta %s%s", pd, Sleep, ByteUnitString(int64(d.Size())),
+			ByteUnitString(int64(d.TotalSize())), ByteUnitString(int64(d.AvgSleep())), d.TotalCost(), d.ETA(), limit)
 
 		// Update last size
 		atomic.StoreUint64(&d.lastSize, atomic.LoadUint64(&d.size))
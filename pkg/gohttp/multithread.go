@@ -1,10 +1,16 @@
 package gohttp
 
 import (
+	"bookget/pkg/resume"
+	"bookget/pkg/retry"
+	"bookget/pkg/validate"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -25,19 +31,39 @@ func (r *Request) FastGet(uri string, opts ...Options) (resp *Response, err erro
 			return Get(r.ctx, uri, opts...)
 		}
 	}
-	d := &Download{
-		ctx:         r.ctx,
-		URL:         uri,
-		Dest:        r.opts.DestFile,
-		opts:        r.opts,
-		Concurrency: r.opts.Concurrency,
+	retries := r.opts.Retry
+	if retries == 0 {
+		retries = 3
 	}
-	d.mutex = new(sync.RWMutex)
-	//多线程下载
-	if err = d.ChunkInit(); err != nil {
-		return nil, err
-	}
-	err = d.ChunkStart()
+	//多线程下载。各分段自己按 retries 重试网络错误，这里只在获取文件信息失败、
+	//内容无效（错误页、损坏的图片）或文件已变化时重新下载整个文件，不与分段的重试叠加
+	err = retry.New(retries).Do(r.ctx, func(int) error {
+		d := &Download{
+			ctx:         r.ctx,
+			URL:         uri,
+			Dest:        r.opts.DestFile,
+			opts:        r.opts,
+			Concurrency: r.opts.Concurrency,
+		}
+		d.mutex = new(sync.RWMutex)
+		if err := d.ChunkInit(); err != nil {
+			return err
+		}
+		err := d.ChunkStart()
+		var verr *validate.Error
+		var rerr *retry.Error
+		switch {
+		case err == nil:
+			return nil
+		case errors.Is(err, resume.ErrChanged):
+			// 分段已按 Permanent 结束，这里去掉包装以便重新下载整个文件
+			return resume.ErrChanged
+		case errors.As(err, &verr), errors.As(err, &rerr):
+			// 分段的 *retry.Error 原样返回，Do 不会再重试
+			return err
+		}
+		return &retry.Error{Class: retry.Permanent, Attempts: 1, Err: err}
+	})
 	resp = &Response{
 		resp: nil,
 		req:  r.req,
@@ -77,22 +103,18 @@ func (d *Download) ChunkInit() (err error) {
 		d.ChunkSize = getDefaultChunkSize(d.info.Size, d.MinChunkSize, d.MaxChunkSize, uint64(d.Concurrency))
 	}
 
-	chunksLen := d.info.Size / d.ChunkSize
-	d.chunks = make([]*Chunk, 0, chunksLen)
-
-	// Set chunk ranges.
-	for i := uint64(0); i < chunksLen; i++ {
-
-		chunk := new(Chunk)
-		d.chunks = append(d.chunks, chunk)
-
-		chunk.Start = (d.ChunkSize * i) + i
-		chunk.End = chunk.Start + d.ChunkSize
-		if chunk.End >= d.info.Size || i == chunksLen-1 {
-			chunk.End = d.info.Size - 1
-			// Break on last chunk if i < chunksLen
-			break
-		}
+	// 上次中断留下的 .downloading 与续传记录一致时只下载缺少的区间
+	var resumed bool
+	d.state, resumed = resume.Open(d.Path()+".downloading", d.URL, d.info.Validators)
+	d.resumed = resumed
+	chunksLen := max(int(d.info.Size/d.ChunkSize), 1)
+	ranges := resume.Split(d.state.Missing(), chunksLen, 1)
+	d.chunks = make([]*Chunk, 0, len(ranges))
+	for _, r := range ranges {
+		d.chunks = append(d.chunks, &Chunk{Start: uint64(r.Start), End: uint64(r.End)})
+	}
+	if resumed {
+		atomic.AddUint64(&d.size, uint64(d.state.Completed()))
 	}
 
 	return nil
@@ -109,6 +131,9 @@ func (d *Download) GetInfoOrDownload() (*Info, error) {
 	r := NewClient(d.ctx)
 	r.Request("GET", d.URL, d.opts)
 	_resp, err := r.cli.Do(r.req)
+	if err != nil {
+		return nil, err
+	}
 	defer _resp.Body.Close()
 
 	info := &Info{}
@@ -122,28 +147,19 @@ func (d *Download) GetInfoOrDownload() (*Info, error) {
 
 	// Set content disposition non trusted name
 	d.unsafeName = _resp.Header.Get("content-disposition")
-	//只使用临时文件
-	var destTemp = fmt.Sprintf("%s.downloading", d.Path())
-	dest, err := os.Create(destTemp)
-	if err != nil {
-		return info, err
-	}
-	defer dest.Close()
-
-	if _, err = io.Copy(dest, io.TeeReader(_resp.Body, d)); err != nil {
-		return info, err
-	}
 
 	// Get content length from content-range response header,
 	// if content-range exists, that means partial content is supported.
+	// 此时不写入任何文件，以免覆盖上次未完成的 .downloading
 	if cr := _resp.Header.Get("content-range"); cr != "" && _resp.ContentLength == 1 {
 		l := strings.Split(cr, "/")
 		if len(l) == 2 {
 			if length, err := strconv.ParseUint(l[1], 10, 64); err == nil {
-
 				return &Info{
-					Size:      length,
-					Rangeable: true,
+					Size:        length,
+					Rangeable:   true,
+					Validators:  resume.FromHeader(_resp.Header, int64(length)),
+					ContentType: _resp.Header.Get("Content-Type"),
 				}, nil
 			}
 		}
@@ -151,7 +167,25 @@ func (d *Download) GetInfoOrDownload() (*Info, error) {
 		return info, fmt.Errorf("Response includes content-range header which is invalid: %s", cr)
 	}
 
-	return info, nil
+	// 不支持分段下载时整个文件在此下载完成，之前的续传记录不再有效
+	var destTemp = fmt.Sprintf("%s.downloading", d.Path())
+	_ = os.Remove(resume.Path(destTemp))
+	dest, err := os.Create(destTemp)
+	if err != nil {
+		return info, err
+	}
+	_, err = io.Copy(dest, io.TeeReader(_resp.Body, d))
+	if cerr := dest.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = validate.File(destTemp, filepath.Ext(d.Path()), _resp.Header.Get("Content-Type"))
+	}
+	if err != nil {
+		os.Remove(destTemp)
+		return info, err
+	}
+	return info, os.Rename(destTemp, d.Path())
 }
 
 // Start downloads the file chunks, and merges them.
@@ -169,29 +203,73 @@ func (d *Download) ChunkStart() (err error) {
 
 	// Otherwise there are always at least 2 chunks
 	var destTemp = fmt.Sprintf("%s.downloading", d.Path())
-	file, err := os.Create(destTemp)
+	flag := os.O_CREATE | os.O_RDWR
+	if !d.resumed {
+		flag |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(destTemp, flag, 0644)
 	if err != nil {
 		return err
 	}
+	d.state.Attach(file)
 	defer func() {
-		err = file.Close()
-		if err == nil {
-			os.Rename(destTemp, d.Path())
+		// 只有全部分段完成才改名为目标文件；中断或出错时保留临时文件与续传记录，下次继续
+		serr := d.state.Save()
+		d.state.Attach(nil)
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			// 服务器上的文件已变化时已写入的分段不能再用
+			if serr != nil || d.state.Completed() == 0 || errors.Is(err, resume.ErrChanged) {
+				os.Remove(destTemp)
+				d.state.Remove()
+			}
+			return
+		}
+		if done := d.state.Completed(); done != int64(d.TotalSize()) {
+			err = fmt.Errorf("incomplete download: %d/%d bytes", done, d.TotalSize())
+			os.Remove(destTemp)
+			d.state.Remove()
+			return
+		}
+		// 内容无效时丢弃，重试时从头下载
+		if err = validate.File(destTemp, filepath.Ext(d.Path()), d.info.ContentType); err != nil {
+			os.Remove(destTemp)
+			d.state.Remove()
+			return
+		}
+		if err = os.Rename(destTemp, d.Path()); err == nil {
+			err = d.state.Remove()
 		}
 	}()
-	size := d.TotalSize()
-	// Allocate the file completely so that we can write concurrently
-	file.Truncate(int64(size))
+	if !d.resumed {
+		// Allocate the file completely so that we can write concurrently
+		if err = file.Truncate(int64(d.TotalSize())); err != nil {
+			return err
+		}
+	}
 
-	// Download chunks.
+	// Download chunks. 任一分段失败时取消其余分段，等全部分段退出后再保存续传记录、关闭文件，
+	// 避免重试时与仍在写入的分段共用临时文件与访问限制
+	parent := d.ctx
+	ctx, cancel := context.WithCancel(parent)
+	d.ctx = ctx
+	defer func() { d.ctx = parent }()
 	errs := make(chan error, 1)
-	go d.dl(file, errs)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.dl(file, errs)
+	}()
 
 	select {
 	case err = <-errs:
-	case <-d.ctx.Done():
-		err = d.ctx.Err()
+	case <-parent.Done():
+		err = parent.Err()
 	}
+	cancel()
+	<-done
 	return
 }
 
@@ -209,29 +287,51 @@ func (d *Download) dl(dest io.WriterAt, errC chan error) {
 		d.opts.Headers = make(map[string]interface{})
 	}
 
+	retries := d.opts.Retry
+	if retries == 0 {
+		retries = 3
+	}
+
 	wg.Add(1)
 	go dlProgressBar(&wg, d)
 
 	for i := 0; i < len(d.chunks); i++ {
 
 		max <- 1
+		if d.ctx.Err() != nil {
+			break
+		}
 		wg.Add(1)
 
 		go func(i int) {
-			defer wg.Done()
-
-			// Concurrently download and write chunk
-			if err := d.DownloadChunk(d.chunks[i], &OffsetWriter{dest, int64(d.chunks[i].Start)}); err != nil {
-				errC <- err
-				return
+			defer func() {
+				<-max
+				wg.Done()
+			}()
+
+			// Concurrently download and write chunk，失败时按重试策略从分段起点重新下载
+			err := retry.New(retries).Do(d.ctx, func(int) error {
+				err := d.DownloadChunk(d.chunks[i], d.state.WriterAt(dest, int64(d.chunks[i].Start)))
+				if errors.Is(err, resume.ErrChanged) {
+					// 分段重试无用，交给 FastGet 从头下载
+					return &retry.Error{Class: retry.Permanent, Attempts: 1, Err: err}
+				}
+				return err
+			})
+			if err != nil {
+				select {
+				case errC <- err:
+				default:
+				}
 			}
-
-			<-max
 		}(i)
 	}
 
 	wg.Wait()
-	errC <- nil
+	select {
+	case errC <- d.ctx.Err():
+	default:
+	}
 }
 
 // DownloadChunk downloads a file chunk.
@@ -239,14 +339,25 @@ func (d *Download) DownloadChunk(c *Chunk, dest io.Writer) error {
 	contentRange := fmt.Sprintf("bytes=%d-%d", c.Start, c.End)
 	d.mutex.Lock()
 	d.opts.Headers["Range"] = contentRange
+	// 文件变化时服务器返回整个文件，而不是把新内容拼到旧的分段后面
+	ifRange := d.info.Validators.IfRange()
+	if ifRange != "" {
+		d.opts.Headers["If-Range"] = ifRange
+	}
 	r := NewClient(d.ctx)
 	r.Request("GET", d.URL, d.opts)
 	d.mutex.Unlock()
 	resp, err := r.cli.Do(r.req)
-	defer resp.Body.Close()
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK && ifRange != "" {
+		return resume.ErrChanged
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return retry.Status(resp.StatusCode)
+	}
 	// Verify the length
 	if resp.ContentLength != int64(c.End-c.Start+1) {
 		return fmt.Errorf(
@@ -2,9 +2,12 @@ package gohttp
 
 import (
 	"bookget/pkg/chttp"
+	"bookget/pkg/httpclient"
+	"bookget/pkg/logger"
+	"bookget/pkg/ratelimit"
+	"bookget/pkg/retry"
 	"bytes"
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -67,6 +70,9 @@ func (r *Request) Options(uri string, opts ...Options) (*Response, error) {
 
 // Request send request
 func (r *Request) Request(method, uri string, opts ...Options) (*Request, error) {
+	if r.ctx == nil {
+		r.ctx = context.Background()
+	}
 	if len(opts) > 0 {
 		r.opts = opts[0]
 	}
@@ -80,8 +86,7 @@ func (r *Request) Request(method, uri string, opts ...Options) (*Request, error)
 		if err != nil {
 			return nil, err
 		}
-		req.WithContext(r.ctx)
-		r.req = req
+		r.req = req.WithContext(r.ctx)
 	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodOptions:
 		// parse body
 		r.parseBody()
@@ -90,8 +95,7 @@ func (r *Request) Request(method, uri string, opts ...Options) (*Request, error)
 		if err != nil {
 			return nil, err
 		}
-		req.WithContext(r.ctx)
-		r.req = req
+		r.req = req.WithContext(r.ctx)
 	default:
 		return nil, errors.New("invalid request method")
 	}
@@ -123,14 +127,35 @@ func (r *Request) Request(method, uri string, opts ...Options) (*Request, error)
 }
 
 func (r *Request) do() (*Response, error) {
-	var _resp = new(http.Response)
-	var err error
-	for i := 0; i < r.opts.Retry; i++ {
-		_resp, err = r.cli.Do(r.req)
-		if err == nil && _resp != nil {
-			break
+	var _resp *http.Response
+	var saveErr error
+	err := retry.New(r.opts.Retry).Do(r.ctx, func(attempt int) error {
+		if attempt > 1 && r.req.GetBody != nil {
+			body, err := r.req.GetBody()
+			if err != nil {
+				return err
+			}
+			r.req.Body = body
 		}
-	}
+		resp, err := r.cli.Do(r.req)
+		if err != nil {
+			logger.FromContext(r.ctx).Debug("请求失败", logger.KeyUrl, r.req.URL.String(), "attempt", attempt, "error", err)
+			return err
+		}
+		// 5xx 等可重试的状态码关闭后重试，最后一次仍把响应交给调用方
+		if resp.StatusCode != http.StatusOK && retry.ClassifyStatus(resp.StatusCode) == retry.Transient && attempt < r.opts.Retry {
+			resp.Body.Close()
+			return retry.Status(resp.StatusCode)
+		}
+		_resp = resp
+		// 保存文件时连接中断、内容无效（错误页、截断的图片）也重新请求
+		if r.opts.DestFile != "" && resp.StatusCode == http.StatusOK {
+			saveErr = r.saveFile(resp)
+			resp.Body.Close()
+			return saveErr
+		}
+		return nil
+	})
 	if _resp == nil || _resp.Body == nil {
 		return nil, err
 	}
@@ -140,32 +165,23 @@ func (r *Request) do() (*Response, error) {
 		req:  r.req,
 		err:  err,
 	}
-	if err != nil || _resp.StatusCode != http.StatusOK {
+	if _resp.StatusCode != http.StatusOK {
 		if r.opts.Debug {
 			// print response err
-			fmt.Println(err)
+			fmt.Println(_resp.Status)
+		}
+		// 保存文件时非 200 视为失败，否则调用方会误以为已下载
+		if r.opts.DestFile != "" {
+			resp.err = retry.Status(_resp.StatusCode)
+			return resp, resp.err
 		}
-		return resp, err
+		return resp, nil
 	}
 
 	if r.opts.DestFile != "" {
-		dl := &Download{
-			startedAt: time.Now(),
-			ctx:       context.Background(),
-			mutex:     new(sync.RWMutex),
-			info: &Info{
-				Size:      uint64(_resp.ContentLength),
-				Rangeable: false,
-			},
-			Dest: r.opts.DestFile,
+		if saveErr != nil {
+			return resp, err
 		}
-		// Wait group.
-		var wg sync.WaitGroup
-		wg.Add(1)
-		go dlProgressBar(&wg, dl)
-		_, err := resp.dlFile(dl)
-		wg.Wait()
-		resp.err = err
 	} else {
 		body, err := io.ReadAll(_resp.Body)
 		resp.body = body
@@ -180,6 +196,28 @@ func (r *Request) do() (*Response, error) {
 	return resp, nil
 }
 
+// saveFile 将响应保存到 DestFile，完整且内容有效时才生成目标文件
+func (r *Request) saveFile(_resp *http.Response) error {
+	dl := &Download{
+		startedAt: time.Now(),
+		ctx:       r.ctx,
+		mutex:     new(sync.RWMutex),
+		info: &Info{
+			Size:      uint64(_resp.ContentLength),
+			Rangeable: false,
+		},
+		Dest: r.opts.DestFile,
+	}
+	// Wait group.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go dlProgressBar(&wg, dl)
+	resp := &Response{resp: _resp, req: r.req}
+	_, err := resp.dlFile(dl)
+	wg.Wait()
+	return err
+}
+
 func (r *Request) parseOptions() {
 	r.opts.timeout = time.Duration(r.opts.Timeout*1000) * time.Millisecond
 
@@ -189,24 +227,22 @@ func (r *Request) parseOptions() {
 }
 
 func (r *Request) parseClient() {
-	tr := &http.Transport{
-		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
-		DisableKeepAlives: true,
-	}
+	r.cli = httpclient.New(httpclient.Options{Timeout: r.opts.timeout, Jar: r.opts.CookieJar})
 
-	if r.opts.Proxy != "" {
-		proxy, err := url.Parse(r.opts.Proxy)
-		if err == nil {
-			tr.Proxy = http.ProxyURL(proxy)
-		}
+	// 指定 Proxy 时不使用 --proxy 与站点配置的代理
+	if r.opts.Proxy == "" {
+		return
 	}
-	r.cli = &http.Client{
-		Timeout:   r.opts.timeout,
-		Transport: tr,
+	u, err := url.Parse(r.opts.Proxy)
+	if err != nil {
+		return
 	}
-	if r.opts.CookieJar != nil {
-		r.cli.Jar = r.opts.CookieJar
+	// 无法取得（如 CA 证书读取失败）时仍用共享的 Transport，请求会返回同样的错误
+	tr, err := httpclient.ViaProxy(r.req.URL.Hostname(), u)
+	if err != nil {
+		return
 	}
+	r.cli.Transport = ratelimit.Transport(tr)
 }
 
 func (r *Request) parseQuery() {
@@ -0,0 +1,253 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"bookget/config"
+	"bookget/pkg/chttp"
+	"bookget/pkg/proxy"
+	"bookget/pkg/ratelimit"
+	"bookget/pkg/retry"
+)
+
+// Options 客户端参数，零值可用：不限超时、新建 cookie jar、传输层不重试
+type Options struct {
+	Timeout time.Duration     // 整个请求（含读取响应）的超时，0 不限；下载大文件时不要设置
+	Jar     http.CookieJar    // nil 时新建
+	Header  map[string]string // 默认请求头，请求中已设置的不覆盖，Cookie 则合并
+	Retries int               // GET/HEAD 遇到连接错误、429、5xx 时的总尝试次数，0 或 1 不重试
+
+	// Wrap 额外的中间件，依次包在外层，如记录请求、改写 URL
+	Wrap []func(http.RoundTripper) http.RoundTripper
+}
+
+// New 返回使用共享连接池的客户端。所有客户端按请求的 host 选择 TLS 设置、代理与访问限制
+func New(o Options) *http.Client {
+	var rt http.RoundTripper = Transport()
+	if o.Retries > 1 {
+		rt = &retrying{next: rt, attempts: o.Retries}
+	}
+	if len(o.Header) > 0 {
+		rt = &defaults{next: rt, header: o.Header}
+	}
+	for _, wrap := range o.Wrap {
+		rt = wrap(rt)
+	}
+	jar := o.Jar
+	if jar == nil {
+		jar, _ = cookiejar.New(nil)
+	}
+	return &http.Client{Timeout: o.Timeout, Jar: jar, Transport: rt}
+}
+
+// ForSite 按站点参数创建客户端：超时，以及 user-agent、cookies、headers 文件作为默认请求头
+func ForSite(opts *config.Input) *http.Client {
+	return New(Options{Timeout: opts.Timeout * time.Second, Header: Headers(opts)})
+}
+
+// Headers 按 user-agent、cookies 与 headers 文件生成请求头，headers 文件中的同名项优先
+func Headers(opts *config.Input) map[string]string {
+	httpHeaders := map[string]string{"User-Agent": opts.UserAgent}
+	cookies, _ := chttp.ReadCookiesFromFile(opts.CookieFile)
+	if cookies != "" {
+		httpHeaders["Cookie"] = cookies
+	}
+
+	headers, err := chttp.ReadHeadersFromFile(opts.HeaderFile)
+	if err == nil {
+		for key, value := range headers {
+			httpHeaders[key] = value
+		}
+	}
+	return httpHeaders
+}
+
+// TLS 单个 host 的证书校验设置
+type TLS struct {
+	Insecure bool   // 不校验证书，仅用于证书配置有误的站点
+	CAFile   string // 额外信任的 CA 证书（PEM），如自签名证书或企业代理的根证书
+}
+
+// proxyKey 指定代理的连接池按证书校验设置与代理地址区分
+type proxyKey struct {
+	tls   TLS
+	proxy string
+}
+
+var (
+	mu         sync.Mutex
+	tlsOf      = func(host string) TLS { return TLS{} }
+	hosts      = make(map[string]TLS)
+	transports = make(map[TLS]*http.Transport) // 相同设置的 host 共用连接池
+	proxied    = make(map[proxyKey]*http.Transport)
+
+	sharedOnce sync.Once
+	shared     http.RoundTripper
+)
+
+// SetTLS 设置按 host 取得证书校验设置的函数，通常为 --insecure、--ca-file 与配置文件中的 [site "host"]。
+// 默认校验证书
+func SetTLS(fn func(host string) TLS) {
+	mu.Lock()
+	defer mu.Unlock()
+	tlsOf = fn
+	hosts = make(map[string]TLS)
+	for _, tr := range transports {
+		tr.CloseIdleConnections()
+	}
+	for _, tr := range proxied {
+		tr.CloseIdleConnections()
+	}
+	transports = make(map[TLS]*http.Transport)
+	proxied = make(map[proxyKey]*http.Transport)
+}
+
+// Transport 所有客户端共用的 RoundTripper：按 host 的 TLS 设置选择连接池，经过代理与访问限制。
+// 访问限制在代理轮换之内，换代理重试的每次请求同样计入该 host 的限制
+func Transport() http.RoundTripper {
+	sharedOnce.Do(func() {
+		shared = proxy.Transport(ratelimit.Transport(router{}))
+	})
+	return shared
+}
+
+// ViaProxy 返回 host 经指定代理 proxyURL 访问时使用的 *http.Transport，供自行指定代理的调用方使用。
+// 证书校验设置与代理都相同的调用共用一个连接池
+func ViaProxy(host string, proxyURL *url.URL) (*http.Transport, error) {
+	tr, err := transportFor(host)
+	if err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	t, ok := hosts[strings.ToLower(host)]
+	if !ok {
+		t = tlsOf(host) // SetTLS 刚重置过
+	}
+	key := proxyKey{tls: t, proxy: proxyURL.String()}
+	if p, ok := proxied[key]; ok {
+		return p, nil
+	}
+	p := tr.Clone()
+	p.Proxy = http.ProxyURL(proxyURL)
+	proxied[key] = p
+	return p, nil
+}
+
+func transportFor(host string) (*http.Transport, error) {
+	host = strings.ToLower(host)
+	mu.Lock()
+	defer mu.Unlock()
+	t, ok := hosts[host]
+	if !ok {
+		t = tlsOf(host)
+		hosts[host] = t
+	}
+	if tr, ok := transports[t]; ok {
+		return tr, nil
+	}
+	tr, err := newTransport(t)
+	if err != nil {
+		return nil, err
+	}
+	transports[t] = tr
+	return tr, nil
+}
+
+// newTransport 在 http.DefaultTransport 的基础上（连接与 TLS 握手超时、空闲连接复用、HTTP/2）设置证书校验与代理
+func newTransport(t TLS) (*http.Transport, error) {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.Proxy = proxy.ForRequest
+	tr.MaxIdleConnsPerHost = 16
+	tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: t.Insecure}
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取 CA 证书失败: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("读取 CA 证书失败: %s 中没有 PEM 格式的证书", t.CAFile)
+		}
+		tr.TLSClientConfig.RootCAs = pool
+	}
+	return tr, nil
+}
+
+// router 按请求的 host 转给对应的连接池
+type router struct{}
+
+func (router) RoundTrip(req *http.Request) (*http.Response, error) {
+	tr, err := transportFor(req.URL.Hostname())
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return tr.RoundTrip(req)
+}
+
+// defaults 补充请求中没有的默认请求头
+type defaults struct {
+	next   http.RoundTripper
+	header map[string]string
+}
+
+func (t *defaults) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for k, v := range t.header {
+		if v == "" {
+			continue
+		}
+		old := req.Header.Get(k)
+		switch {
+		case old == "":
+			req.Header.Set(k, v)
+		case http.CanonicalHeaderKey(k) == "Cookie" && !strings.Contains(old, v):
+			req.Header.Set(k, old+"; "+v)
+		}
+	}
+	return t.next.RoundTrip(req)
+}
+
+// retrying 幂等请求遇到临时错误时退避重试，最后一次的响应交给调用方
+type retrying struct {
+	next     http.RoundTripper
+	attempts int
+}
+
+func (t *retrying) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead || req.Body != nil && req.Body != http.NoBody {
+		return t.next.RoundTrip(req)
+	}
+	var resp *http.Response
+	err := retry.New(t.attempts).Do(req.Context(), func(attempt int) error {
+		r, err := t.next.RoundTrip(req)
+		if err != nil {
+			return err
+		}
+		if r.StatusCode >= 400 && retry.ClassifyStatus(r.StatusCode) == retry.Transient && attempt < t.attempts {
+			r.Body.Close()
+			return retry.Status(r.StatusCode)
+		}
+		resp = r
+		return nil
+	})
+	if resp != nil {
+		return resp, nil
+	}
+	return nil, err
+}
@@ -0,0 +1,129 @@
+package httpclient
+
+import (
+	"encoding/pem"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bookget/pkg/proxy"
+	"bookget/pkg/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLS(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0644))
+
+	get := func() error {
+		resp, err := New(Options{}).Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	defer SetTLS(func(string) TLS { return TLS{} })
+
+	// 默认校验证书，自签名证书失败
+	SetTLS(func(string) TLS { return TLS{} })
+	assert.Error(t, get())
+
+	SetTLS(func(host string) TLS { return TLS{Insecure: host == u.Hostname()} })
+	assert.NoError(t, get())
+
+	SetTLS(func(string) TLS { return TLS{CAFile: caFile} })
+	assert.NoError(t, get())
+
+	SetTLS(func(string) TLS { return TLS{CAFile: filepath.Join(t.TempDir(), "missing.pem")} })
+	assert.ErrorContains(t, get(), "CA 证书")
+}
+
+func TestOptions(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: "1"})
+		io.WriteString(w, r.Header.Get("User-Agent")+"|"+r.Header.Get("Cookie")+"|"+r.Header.Get("Referer"))
+	}))
+	defer srv.Close()
+
+	cli := New(Options{
+		Retries: 2,
+		Header:  map[string]string{"User-Agent": "bookget-test", "Cookie": "token=abc", "Referer": "default"},
+	})
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Referer", "page")
+	resp, err := cli.Do(req)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "bookget-test|token=abc|page", string(body))
+	assert.EqualValues(t, 2, calls.Load()) // 502 后重试
+
+	// cookie jar 中的与默认 Cookie 合并
+	resp, err = cli.Get(srv.URL)
+	require.NoError(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "bookget-test|sid=1; token=abc|default", string(body))
+}
+
+func TestProxyRotateLimited(t *testing.T) {
+	// 第一个代理已关闭：换代理重试的请求同样按 host 的最小间隔发起
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "via proxy")
+	}))
+	defer live.Close()
+	proxy.SetProxies(func(string) string { return dead.URL + "," + live.URL })
+	defer proxy.SetProxies(func(string) string { return "" })
+	ratelimit.SetLimits(func(string) ratelimit.Limits { return ratelimit.Limits{MinGap: 200 * time.Millisecond} })
+	defer ratelimit.SetLimits(func(string) ratelimit.Limits { return ratelimit.Limits{} })
+
+	start := time.Now()
+	resp, err := (&http.Client{Transport: Transport()}).Get("http://rotate.example/")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "via proxy", string(body))
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+}
+
+func TestViaProxy(t *testing.T) {
+	a, _ := url.Parse("http://127.0.0.1:8080")
+	b, _ := url.Parse("socks5://127.0.0.1:1080")
+	tr, err := ViaProxy("book.example", a)
+	require.NoError(t, err)
+	// 同一代理共用连接池，不为每个请求复制 Transport
+	same, err := ViaProxy("other.example", a)
+	require.NoError(t, err)
+	assert.Same(t, tr, same)
+	other, err := ViaProxy("book.example", b)
+	require.NoError(t, err)
+	assert.NotSame(t, tr, other)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://book.example/", nil)
+	u, err := other.Proxy(req)
+	require.NoError(t, err)
+	assert.Equal(t, b, u)
+}
@@ -0,0 +1,174 @@
+package journal
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// State 单个 URL 的处理状态
+type State string
+
+const (
+	Pending State = "pending"
+	Running State = "running"
+	Done    State = "done"
+	Failed  State = "failed"
+)
+
+// Entry 单个 URL 的日志记录
+type Entry struct {
+	Url      string    `json:"url"`
+	State    State     `json:"state"`
+	Attempts int       `json:"attempts,omitempty"`
+	Started  time.Time `json:"started,omitempty"`
+	Finished time.Time `json:"finished,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Journal 批量下载日志，每次状态变化都整体写回文件（先写临时文件再 rename）
+type Journal struct {
+	path    string
+	mu      sync.Mutex
+	entries []*Entry
+	index   map[string]*Entry
+}
+
+// Path 返回 URL 文件对应的日志路径
+func Path(urlsFile string) string {
+	return urlsFile + ".journal.json"
+}
+
+// Open 读取已有日志，文件不存在时返回空日志。
+// 上次未正常结束而停留在 running 的记录视为 pending
+func Open(path string) (*Journal, error) {
+	j := &Journal{
+		path:  path,
+		index: make(map[string]*Entry),
+	}
+	bs, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []*Entry
+	if err = json.Unmarshal(bs, &entries); err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.State == Running {
+			e.State = Pending
+		}
+		if _, ok := j.index[e.Url]; ok {
+			continue
+		}
+		j.entries = append(j.entries, e)
+		j.index[e.Url] = e
+	}
+	return j, nil
+}
+
+// Add 登记 URL，已存在时保留原状态，返回当前状态
+func (j *Journal) Add(url string) State {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if e, ok := j.index[url]; ok {
+		return e.State
+	}
+	e := &Entry{Url: url, State: Pending}
+	j.entries = append(j.entries, e)
+	j.index[url] = e
+	return Pending
+}
+
+// State 返回 URL 的当前状态，未登记时为 pending
+func (j *Journal) State(url string) State {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if e, ok := j.index[url]; ok {
+		return e.State
+	}
+	return Pending
+}
+
+// Start 标记 URL 开始处理
+func (j *Journal) Start(url string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e := j.entry(url)
+	e.State = Running
+	e.Attempts++
+	e.Started = time.Now()
+	e.Finished = time.Time{}
+	e.Error = ""
+	return j.save()
+}
+
+// Finish 标记 URL 处理结束，errText 为空表示成功
+func (j *Journal) Finish(url string, errText string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e := j.entry(url)
+	e.Finished = time.Now()
+	e.Error = errText
+	if errText == "" {
+		e.State = Done
+	} else {
+		e.State = Failed
+	}
+	return j.save()
+}
+
+// Save 写回日志文件
+func (j *Journal) Save() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.save()
+}
+
+// Entries 返回全部记录的副本
+func (j *Journal) Entries() []Entry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	list := make([]Entry, 0, len(j.entries))
+	for _, e := range j.entries {
+		list = append(list, *e)
+	}
+	return list
+}
+
+func (j *Journal) entry(url string) *Entry {
+	e, ok := j.index[url]
+	if !ok {
+		e = &Entry{Url: url, State: Pending}
+		j.entries = append(j.entries, e)
+		j.index[url] = e
+	}
+	return e
+}
+
+func (j *Journal) save() error {
+	bs, err := json.MarshalIndent(j.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(bs); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), j.path)
+}
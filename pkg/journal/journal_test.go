@@ -0,0 +1,81 @@
+package journal_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"bookget/config"
+	"bookget/pkg/journal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournal(t *testing.T) {
+	path := journal.Path(filepath.Join(t.TempDir(), "urls.txt"))
+
+	j, err := journal.Open(path)
+	require.NoError(t, err)
+	assert.Equal(t, journal.Pending, j.Add("http://a"))
+	assert.Equal(t, journal.Pending, j.Add("http://b"))
+	assert.Equal(t, journal.Pending, j.Add("http://c"))
+
+	require.NoError(t, j.Start("http://a"))
+	require.NoError(t, j.Finish("http://a", ""))
+	require.NoError(t, j.Start("http://b"))
+	require.NoError(t, j.Finish("http://b", "timeout"))
+	require.NoError(t, j.Start("http://c"))
+
+	// 重新打开：中断时 running 的任务回到 pending
+	j, err = journal.Open(path)
+	require.NoError(t, err)
+	assert.Equal(t, journal.Done, j.Add("http://a"))
+	assert.Equal(t, journal.Failed, j.State("http://b"))
+	assert.Equal(t, journal.Pending, j.State("http://c"))
+
+	entries := j.Entries()
+	require.Len(t, entries, 3)
+	assert.Equal(t, "timeout", entries[1].Error)
+	assert.Equal(t, 1, entries[1].Attempts)
+	assert.False(t, entries[0].Finished.IsZero())
+
+	require.NoError(t, j.Start("http://b"))
+	require.NoError(t, j.Finish("http://b", ""))
+	assert.Equal(t, journal.Done, j.State("http://b"))
+	assert.Equal(t, 2, j.Entries()[1].Attempts)
+	assert.Empty(t, j.Entries()[1].Error)
+}
+
+func TestJournalOverrides(t *testing.T) {
+	path := journal.Path(filepath.Join(t.TempDir(), "urls.txt"))
+	var keys []string
+	for _, text := range []string{
+		"http://a seq=1:10 dir=part1",
+		"http://a dir=part2 seq=11:20",
+		`{"url": "http://a", "seq": "1:10", "dir": "part1"}`,
+		"http://a",
+	} {
+		line, ok, err := config.ParseURLLine(text)
+		require.NoError(t, err)
+		require.True(t, ok)
+		keys = append(keys, line.Key())
+	}
+	assert.Equal(t, "http://a seq=1:10 dir=part1", keys[0])
+	assert.Equal(t, keys[0], keys[2]) // 写法不同，参数相同
+	assert.Equal(t, "http://a", keys[3])
+
+	j, err := journal.Open(path)
+	require.NoError(t, err)
+	j.Add(keys[0])
+	j.Add(keys[1])
+	require.NoError(t, j.Start(keys[0]))
+	require.NoError(t, j.Finish(keys[0], ""))
+	require.NoError(t, j.Start(keys[1]))
+	require.NoError(t, j.Finish(keys[1], "timeout"))
+
+	// 同一 URL 的两行各自记录：第一行完成不影响第二行
+	j, err = journal.Open(path)
+	require.NoError(t, err)
+	assert.Equal(t, journal.Done, j.Add(keys[0]))
+	assert.Equal(t, journal.Failed, j.Add(keys[1]))
+	assert.Equal(t, journal.Pending, j.Add(keys[3]))
+}
@@ -0,0 +1,101 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// 每条日志的公共字段，便于按书筛选批量任务的日志
+const (
+	KeySite   = "site"
+	KeyBookId = "bookId"
+	KeyVolume = "volume"
+	KeyPage   = "page"
+	KeyUrl    = "url"    // 页面/请求地址
+	KeySource = "source" // 任务的图书地址
+)
+
+type ctxKey struct{}
+
+// ParseLevel 解析日志级别 debug|info|warn|error
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	err := level.UnmarshalText([]byte(s))
+	return level, err
+}
+
+// New 创建 logger，format 为 logfmt（key=value）或 json
+func New(w io.Writer, level slog.Level, format string) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(format) {
+	case "", "logfmt", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("不支持的日志格式: %s", format)
+}
+
+// Setup 按命令行参数设置默认 logger，返回需在退出前关闭的日志文件。
+//
+// format 与 file 均为空时保持原有的终端输出，只调整级别；
+// 否则标准库 log 的输出同样转为结构化日志，写入 file（为空时写入 stderr），与进度条分开
+func Setup(level, format, file string) (io.Closer, error) {
+	lv, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	if format == "" && file == "" {
+		slog.SetLogLoggerLevel(lv)
+		return nopCloser{}, nil
+	}
+
+	var w io.Writer = os.Stderr
+	var closer io.Closer = nopCloser{}
+	if file != "" {
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		w, closer = f, f
+	}
+	l, err := New(w, lv, format)
+	if err != nil {
+		closer.Close()
+		return nil, err
+	}
+	slog.SetDefault(l)
+	return closer, nil
+}
+
+// nopCloser 不写入日志文件时 Setup 返回的 Closer
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+// NewContext 返回携带 l 的 context
+func NewContext(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext 返回 context 中的 logger，没有时为默认 logger
+func FromContext(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+			return l
+		}
+	}
+	return slog.Default()
+}
+
+// With 在 context 的 logger 上追加字段，如 With(ctx, KeySite, "iiif", KeyUrl, u)
+func With(ctx context.Context, args ...any) context.Context {
+	return NewContext(ctx, FromContext(ctx).With(args...))
+}
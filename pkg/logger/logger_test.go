@@ -0,0 +1,54 @@
+package logger_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"bookget/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWith(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := logger.New(&buf, slog.LevelInfo, "json")
+	require.NoError(t, err)
+
+	ctx := logger.NewContext(context.Background(), l)
+	ctx = logger.With(ctx, logger.KeySite, "iiif", logger.KeyBookId, "b1")
+	logger.FromContext(ctx).Debug("hidden")
+	logger.FromContext(ctx).Warn("page failed", logger.KeyPage, "0003")
+
+	var rec map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &rec))
+	assert.Equal(t, "WARN", rec["level"])
+	assert.Equal(t, "page failed", rec["msg"])
+	assert.Equal(t, "iiif", rec[logger.KeySite])
+	assert.Equal(t, "b1", rec[logger.KeyBookId])
+	assert.Equal(t, "0003", rec[logger.KeyPage])
+}
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := logger.New(&buf, slog.LevelDebug, "logfmt")
+	require.NoError(t, err)
+	l.Debug("start", logger.KeyUrl, "https://example.org/a b")
+	assert.Contains(t, buf.String(), `level=DEBUG msg=start url="https://example.org/a b"`)
+
+	_, err = logger.New(&buf, slog.LevelInfo, "xml")
+	assert.Error(t, err)
+	_, err = logger.ParseLevel("verbose")
+	assert.Error(t, err)
+
+	assert.Equal(t, slog.Default(), logger.FromContext(context.Background()))
+}
+
+func TestSetupNoFile(t *testing.T) {
+	closer, err := logger.Setup("warn", "", "")
+	require.NoError(t, err)
+	defer slog.SetLogLoggerLevel(slog.LevelInfo)
+	assert.NoError(t, closer.Close())
+}
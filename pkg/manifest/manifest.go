@@ -0,0 +1,201 @@
+package manifest
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"bookget/pkg/hash"
+)
+
+// Name 每个下载目录中的校验清单，每完成一页追加一行 JSON，同一文件以最后一行为准
+const Name = "manifest-sha256.jsonl"
+
+// Entry 单个文件的校验记录，File 为相对清单所在目录的文件名
+type Entry struct {
+	File   string    `json:"file"`
+	Size   int64     `json:"size"`
+	SHA256 string    `json:"sha256"`
+	Url    string    `json:"url,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// 同一进程内对清单的追加串行进行
+var mu sync.Mutex
+
+// Sum 计算文件的大小与 SHA-256
+func Sum(path string) (size int64, sum string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	mh, err := hash.NewMultiHasherTypes(hash.NewHashSet(hash.SHA256))
+	if err != nil {
+		return 0, "", err
+	}
+	if _, err = f.WriteTo(mh); err != nil {
+		return 0, "", err
+	}
+	return mh.Size(), mh.Sums()[hash.SHA256], nil
+}
+
+// Add 计算已下载文件的校验值，追加到同目录的清单中
+func Add(path, url string) error {
+	size, sum, err := Sum(path)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(Entry{
+		File:   filepath.Base(path),
+		Size:   size,
+		SHA256: sum,
+		Url:    url,
+		Time:   time.Now().UTC().Truncate(time.Second),
+	})
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	f, err := os.OpenFile(filepath.Join(filepath.Dir(path), Name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Read 读取 dir 中的清单，按文件名排序；同一文件多次记录时取最后一条
+func Read(dir string) ([]Entry, error) {
+	f, err := os.Open(filepath.Join(dir, Name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	latest := make(map[string]Entry)
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		var e Entry
+		// 写入时被中断的最后一行无法解析，跳过
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil || e.File == "" {
+			continue
+		}
+		latest[e.File] = e
+	}
+	if err = sc.Err(); err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(latest))
+	for _, e := range latest {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].File < entries[j].File })
+	return entries, nil
+}
+
+// Problem 校验未通过的文件
+type Problem struct {
+	Entry
+	Reason string `json:"reason"`
+}
+
+// Report 单个目录的校验结果
+type Report struct {
+	Dir       string    `json:"dir"`
+	Checked   int       `json:"checked"`
+	OK        int       `json:"ok"`
+	Missing   []Problem `json:"missing,omitempty"`
+	Corrupted []Problem `json:"corrupted,omitempty"`
+	Extra     []string  `json:"extra,omitempty"` // 目录中存在但清单中没有的文件
+}
+
+// Bad 缺失与损坏的文件，即需要重新下载的
+func (r *Report) Bad() []Problem {
+	return append(append([]Problem{}, r.Missing...), r.Corrupted...)
+}
+
+// Clean 全部文件均通过校验且没有多余文件
+func (r *Report) Clean() bool {
+	return len(r.Missing) == 0 && len(r.Corrupted) == 0 && len(r.Extra) == 0
+}
+
+// Verify 按清单重新计算 dir 中每个文件的大小与 SHA-256
+func Verify(dir string) (*Report, error) {
+	entries, err := Read(dir)
+	if err != nil {
+		return nil, err
+	}
+	r := &Report{Dir: dir}
+	listed := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		listed[e.File] = true
+		r.Checked++
+		size, sum, err := Sum(filepath.Join(dir, e.File))
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			r.Missing = append(r.Missing, Problem{Entry: e, Reason: "文件不存在"})
+		case err != nil:
+			r.Corrupted = append(r.Corrupted, Problem{Entry: e, Reason: err.Error()})
+		case size != e.Size:
+			r.Corrupted = append(r.Corrupted, Problem{Entry: e, Reason: "大小不符"})
+		case !strings.EqualFold(sum, e.SHA256):
+			r.Corrupted = append(r.Corrupted, Problem{Entry: e, Reason: "SHA-256 不符"})
+		default:
+			r.OK++
+		}
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.IsDir() || listed[f.Name()] || ignored(f.Name()) {
+			continue
+		}
+		r.Extra = append(r.Extra, f.Name())
+	}
+	return r, nil
+}
+
+// ignored 清单本身与下载过程中的临时文件
+func ignored(name string) bool {
+	if name == Name {
+		return true
+	}
+	for _, suffix := range []string{".part", ".downloading", ".resume.json", ".tmp"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Dirs 返回 root 及其子目录中所有包含清单的目录
+func Dirs(root string) ([]string, error) {
+	var dirs []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && d.Name() == Name {
+			dirs = append(dirs, filepath.Dir(path))
+		}
+		return nil
+	})
+	return dirs, err
+}
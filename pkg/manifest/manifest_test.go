@@ -0,0 +1,56 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(p, []byte(data), 0644))
+		return p
+	}
+	require.NoError(t, Add(write("0001.jpg", "page one"), "https://example.org/1"))
+	require.NoError(t, Add(write("0002.jpg", "page two"), "https://example.org/2"))
+	require.NoError(t, Add(write("0003.jpg", "page three"), "https://example.org/3"))
+	// 重新下载后以最后一条记录为准
+	require.NoError(t, Add(write("0002.jpg", "page 2"), "https://example.org/2"))
+
+	entries, err := Read(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "0001.jpg", entries[0].File)
+	assert.EqualValues(t, 8, entries[0].Size)
+	assert.Equal(t, "08e548c038b1608847f6285d147959da2c6632aca2cda9fd1166ec8f32b460e7", entries[0].SHA256)
+
+	r, err := Verify(dir)
+	require.NoError(t, err)
+	assert.True(t, r.Clean())
+	assert.Equal(t, 3, r.OK)
+
+	// 损坏、缺失与多余的文件
+	write("0001.jpg", "page 0ne")
+	require.NoError(t, os.Remove(filepath.Join(dir, "0003.jpg")))
+	write("notes.txt", "x")
+	write("0004.jpg.part", "partial")
+
+	r, err = Verify(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 1, r.OK)
+	require.Len(t, r.Corrupted, 1)
+	assert.Equal(t, "0001.jpg", r.Corrupted[0].File)
+	require.Len(t, r.Missing, 1)
+	assert.Equal(t, "https://example.org/3", r.Missing[0].Url)
+	assert.Equal(t, []string{"notes.txt"}, r.Extra)
+	assert.Len(t, r.Bad(), 2)
+
+	dirs, err := Dirs(filepath.Dir(dir))
+	require.NoError(t, err)
+	assert.Contains(t, dirs, dir)
+}
@@ -0,0 +1,125 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// readChunk 每次读取的最大字节数，限速时让等待更平滑
+const readChunk = 16 << 10
+
+// bucket 按字节计的令牌桶，rate 为 0 时不限速
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64 // 字节/秒
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(bps int64) *bucket {
+	b := &bucket{}
+	b.setRate(bps)
+	return b
+}
+
+// burst 允许的突发量：约 1/4 秒的流量，至少 readChunk
+func (b *bucket) burst() float64 {
+	return max(b.rate/4, readChunk)
+}
+
+func (b *bucket) setRate(bps int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rate = float64(max(bps, 0))
+	b.tokens = min(b.tokens, b.burst())
+}
+
+func (b *bucket) getRate() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return int64(b.rate)
+}
+
+// take 取走 n 个令牌，返回需要等待的时间
+func (b *bucket) take(n int, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rate <= 0 {
+		return 0
+	}
+	if b.last.IsZero() {
+		b.tokens = b.burst()
+	} else if now.After(b.last) {
+		b.tokens = min(b.burst(), b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+var global = newBucket(0)
+
+// SetBandwidth 设置所有下载合计的限速（字节/秒），0 为不限，运行中可随时调整
+func SetBandwidth(bps int64) {
+	global.setRate(bps)
+}
+
+// Bandwidth 返回当前的全局限速
+func Bandwidth() int64 {
+	return global.getRate()
+}
+
+// BandwidthString 用于进度条的限速说明，不限速时为空
+func BandwidthString() string {
+	bps := Bandwidth()
+	if bps <= 0 {
+		return ""
+	}
+	return "限速 " + FormatBytes(bps) + "/s"
+}
+
+// FormatBytes 以 KB/MB/GB（1024 进制）显示字节数
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGT"[exp])
+}
+
+// Reader 返回受全局与 host 限速约束的 Reader
+func Reader(ctx context.Context, host string, r io.Reader) io.Reader {
+	return &limitedReader{ctx: ctx, r: r, host: get(host).bw}
+}
+
+type limitedReader struct {
+	ctx  context.Context
+	r    io.Reader
+	host *bucket
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if global.getRate() > 0 || lr.host.getRate() > 0 {
+		p = p[:min(len(p), readChunk)]
+	}
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		now := time.Now()
+		d := max(global.take(n, now), lr.host.take(n, now))
+		if serr := sleep(lr.ctx, d); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	return n, err
+}
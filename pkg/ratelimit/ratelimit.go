@@ -0,0 +1,296 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bookget/pkg/logger"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+	maxWait    = 5 * time.Minute // Retry-After 超过此值时不再自动重试，交给调用方
+	maxRetries = 3               // 429/503 时自动重试次数
+)
+
+// Limits 单个 host 的访问限制，零值表示不限制
+type Limits struct {
+	RPS         float64       // 每秒请求数，允许 ceil(RPS) 个请求的突发
+	MaxInFlight int           // 同时进行的请求数（到响应 Body 关闭为止）
+	MinGap      time.Duration // 相邻两次请求开始的最小间隔
+	Bandwidth   int64         // 该 host 响应内容的下载速度上限（字节/秒）
+}
+
+// limiter 单个 host 的调度状态，所有任务共享
+type limiter struct {
+	bw *bucket
+
+	mu        sync.Mutex
+	limits    Limits
+	sem       chan struct{}
+	tokens    float64
+	last      time.Time // 上次补充令牌的时间
+	lastStart time.Time // 上一个请求的开始时间
+	blocked   time.Time // Retry-After / 退避期间不发起请求
+	backoff   time.Duration
+}
+
+var (
+	mu       sync.Mutex
+	hosts    = make(map[string]*limiter)
+	limitsOf = func(host string) Limits { return Limits{} }
+)
+
+// SetLimits 设置按 host 取得限制的函数，通常由配置文件中的 [site "host"] 决定。
+// 已访问过的 host 立即按新的限制调度，退避状态保留
+func SetLimits(fn func(host string) Limits) {
+	mu.Lock()
+	defer mu.Unlock()
+	limitsOf = fn
+	for host, l := range hosts {
+		l.update(fn(host))
+	}
+}
+
+func get(host string) *limiter {
+	host = strings.ToLower(host)
+	mu.Lock()
+	defer mu.Unlock()
+	l, ok := hosts[host]
+	if !ok {
+		l = &limiter{bw: newBucket(0)}
+		l.update(limitsOf(host))
+		hosts[host] = l
+	}
+	return l
+}
+
+// update 应用新的限制；进行中的请求仍归还到原来的 in-flight 名额
+func (l *limiter) update(limits Limits) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limits.MaxInFlight != l.limits.MaxInFlight {
+		l.sem = nil
+		if limits.MaxInFlight > 0 {
+			l.sem = make(chan struct{}, limits.MaxInFlight)
+		}
+	}
+	if limits.RPS != l.limits.RPS {
+		l.tokens = burst(limits.RPS)
+	}
+	l.limits = limits
+	l.bw.setRate(limits.Bandwidth)
+}
+
+func burst(rps float64) float64 {
+	if rps <= 0 {
+		return 0
+	}
+	return float64(int(rps + 0.999))
+}
+
+// Wait 等待轮到 host 发起请求，返回的 release 在请求结束后调用
+func Wait(ctx context.Context, host string) (release func(), err error) {
+	return get(host).acquire(ctx)
+}
+
+// Block 在 d 内暂停向 host 发起新请求，如站点提示访问过快时
+func Block(host string, d time.Duration) {
+	get(host).block(d)
+}
+
+func (l *limiter) acquire(ctx context.Context) (func(), error) {
+	release := func() {}
+	l.mu.Lock()
+	sem := l.sem
+	l.mu.Unlock()
+	if sem != nil {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		var once sync.Once
+		release = func() { once.Do(func() { <-sem }) }
+	}
+	for {
+		start, ok := l.reserve(time.Now())
+		if err := sleep(ctx, time.Until(start)); err != nil {
+			release()
+			return nil, err
+		}
+		if ok {
+			return release, nil
+		}
+	}
+}
+
+// reserve 预约下一个可用的开始时间；处于退避期时只返回退避结束时间，ok 为 false
+func (l *limiter) reserve(now time.Time) (start time.Time, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.blocked.After(now) {
+		return l.blocked, false
+	}
+	start = now
+	if !l.lastStart.IsZero() && l.lastStart.Add(l.limits.MinGap).After(start) {
+		start = l.lastStart.Add(l.limits.MinGap)
+	}
+	if rps := l.limits.RPS; rps > 0 {
+		if !l.last.IsZero() && start.After(l.last) {
+			l.tokens = min(burst(rps), l.tokens+start.Sub(l.last).Seconds()*rps)
+		}
+		if l.tokens < 1 {
+			start = start.Add(time.Duration((1 - l.tokens) / rps * float64(time.Second)))
+			l.tokens = 1
+		}
+		l.tokens--
+		l.last = start
+	}
+	l.lastStart = start
+	return start, true
+}
+
+func (l *limiter) block(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := time.Now().Add(d); until.After(l.blocked) {
+		l.blocked = until
+	}
+}
+
+// throttled 收到 429/503 时调用，返回等待时间：有 Retry-After 时按其等待，否则指数退避
+func (l *limiter) throttled(retryAfter time.Duration) time.Duration {
+	l.mu.Lock()
+	d := retryAfter
+	if d <= 0 {
+		l.backoff = min(max(l.backoff*2, minBackoff), maxBackoff)
+		d = l.backoff
+	}
+	l.mu.Unlock()
+	l.block(d)
+	return d
+}
+
+func (l *limiter) succeeded() {
+	l.mu.Lock()
+	l.backoff = 0
+	l.mu.Unlock()
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RetryAfter 解析 Retry-After（秒数或 HTTP 日期），无法解析时为 0
+func RetryAfter(h http.Header, now time.Time) time.Duration {
+	v := strings.TrimSpace(h.Get("Retry-After"))
+	if v == "" {
+		return 0
+	}
+	if n, err := strconv.Atoi(v); err == nil {
+		return time.Duration(max(n, 0)) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// Transport 返回按 host 限流的 RoundTripper，next 为 nil 时使用 http.DefaultTransport。
+// 收到 429/503 时暂停该 host 的所有请求，可重放的请求自动重试；响应内容按全局与 host 限速读取
+func Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if t, ok := next.(*transport); ok {
+		return t
+	}
+	return &transport{next: next}
+}
+
+type transport struct {
+	next http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	l := get(req.URL.Hostname())
+	for attempt := 0; ; attempt++ {
+		release, err := l.acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := t.next.RoundTrip(req)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+			if resp.StatusCode < 400 {
+				l.succeeded()
+			}
+			resp.Body = &releaseBody{ReadCloser: resp.Body, r: &limitedReader{ctx: ctx, r: resp.Body, host: l.bw}, release: release}
+			return resp, nil
+		}
+
+		wait := l.throttled(RetryAfter(resp.Header, time.Now()))
+		logger.FromContext(ctx).Warn("服务器限流，暂停访问", "host", req.URL.Host,
+			"status", resp.StatusCode, "wait", wait, "attempt", attempt+1)
+		if attempt >= maxRetries || wait > maxWait || !rewindable(req) {
+			resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+			return resp, nil
+		}
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		release()
+		if req.Body != nil && req.Body != http.NoBody {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+	}
+}
+
+func rewindable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// releaseBody 限速读取，在 Body 关闭时释放 in-flight 名额
+type releaseBody struct {
+	io.ReadCloser
+	r       io.Reader
+	release func()
+}
+
+func (b *releaseBody) Read(p []byte) (int, error) {
+	if b.r == nil {
+		return b.ReadCloser.Read(p)
+	}
+	return b.r.Read(p)
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
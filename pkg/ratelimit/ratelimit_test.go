@@ -0,0 +1,148 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMinGap(t *testing.T) {
+	SetLimits(func(host string) Limits { return Limits{MinGap: 30 * time.Millisecond} })
+	defer SetLimits(func(string) Limits { return Limits{} })
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		release, err := Wait(context.Background(), "a.example")
+		require.NoError(t, err)
+		release()
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+
+	// 其它 host 不受影响
+	start = time.Now()
+	release, err := Wait(context.Background(), "b.example")
+	require.NoError(t, err)
+	release()
+	assert.Less(t, time.Since(start), 20*time.Millisecond)
+}
+
+func TestRPSBurst(t *testing.T) {
+	l := &limiter{limits: Limits{RPS: 2}, tokens: burst(2)}
+	now := time.Now()
+	s1, _ := l.reserve(now)
+	s2, _ := l.reserve(now)
+	s3, _ := l.reserve(now)
+	assert.Equal(t, now, s1)
+	assert.Equal(t, now, s2)
+	assert.Equal(t, now.Add(500*time.Millisecond), s3)
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Now()
+	h := http.Header{}
+	h.Set("Retry-After", "7")
+	assert.Equal(t, 7*time.Second, RetryAfter(h, now))
+	h.Set("Retry-After", now.Add(time.Minute).UTC().Format(http.TimeFormat))
+	assert.InDelta(t, float64(time.Minute), float64(RetryAfter(h, now)), float64(time.Second))
+	h.Set("Retry-After", "soon")
+	assert.Zero(t, RetryAfter(h, now))
+}
+
+func TestTransport429(t *testing.T) {
+	SetLimits(func(string) Limits { return Limits{} })
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: Transport(nil)}
+	start := time.Now()
+	resp, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "ok", string(body))
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+}
+
+func TestMaxInFlight(t *testing.T) {
+	SetLimits(func(string) Limits { return Limits{MaxInFlight: 2} })
+	defer SetLimits(func(string) Limits { return Limits{} })
+
+	var cur, peak int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&cur, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&cur, -1)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: Transport(nil)}
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(srv.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, atomic.LoadInt32(&peak), int32(2))
+}
+
+func TestBucket(t *testing.T) {
+	b := newBucket(64 << 10)
+	now := time.Now()
+	// 首次允许约 1/4 秒的突发
+	assert.Zero(t, b.take(16<<10, now))
+	// 超出后按速率等待
+	assert.Equal(t, 250*time.Millisecond, b.take(16<<10, now))
+	// 调整速率后立即生效
+	b.setRate(0)
+	assert.Zero(t, b.take(1<<20, now))
+}
+
+func TestBandwidth(t *testing.T) {
+	SetLimits(func(string) Limits { return Limits{} })
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(make([]byte, 96<<10))
+	}))
+	defer srv.Close()
+
+	SetBandwidth(128 << 10)
+	defer SetBandwidth(0)
+	client := &http.Client{Transport: Transport(nil)}
+	start := time.Now()
+	resp, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	n, _ := io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	assert.EqualValues(t, 96<<10, n)
+	// 突发 32KB，其余 64KB 按 128KB/s 约需 0.5 秒
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+	assert.Equal(t, "限速 128.0KB/s", BandwidthString())
+}
@@ -0,0 +1,257 @@
+package resume
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// saveInterval 下载过程中 sidecar 的最短保存间隔
+const saveInterval = time.Second
+
+// Range 已完成的字节区间，End 包含在内
+type Range struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// Validators 判断服务器上的文件是否变化
+type Validators struct {
+	ETag          string `json:"etag,omitempty"`
+	LastModified  string `json:"lastModified,omitempty"`
+	ContentLength int64  `json:"contentLength"`
+}
+
+// FromHeader 从响应头取得 Validators，size 为文件总大小
+func FromHeader(h http.Header, size int64) Validators {
+	return Validators{
+		ETag:          h.Get("ETag"),
+		LastModified:  h.Get("Last-Modified"),
+		ContentLength: size,
+	}
+}
+
+// ErrChanged 带 If-Range 的分段请求返回了整个文件（200），服务器上的文件已变化，须从头下载
+var ErrChanged = errors.New("服务器上的文件已变化，从头下载")
+
+// Match 文件大小一致，且双方有相同的强校验值：非弱 ETag，或 Last-Modified。
+// 任一方缺少校验值时无法判断文件是否变化，不续传
+func (v Validators) Match(o Validators) bool {
+	if v.ContentLength <= 0 || v.ContentLength != o.ContentLength {
+		return false
+	}
+	if etag := v.strongETag(); etag != "" && etag == o.strongETag() {
+		return true
+	}
+	return v.LastModified != "" && v.LastModified == o.LastModified
+}
+
+// IfRange 分段请求的 If-Range 值：强 ETag，否则为 Last-Modified；都没有时为空，不发送
+func (v Validators) IfRange() string {
+	if etag := v.strongETag(); etag != "" {
+		return etag
+	}
+	return v.LastModified
+}
+
+// strongETag 弱 ETag（W/ 开头）不能用于 If-Range，返回空
+func (v Validators) strongETag() string {
+	if strings.HasPrefix(v.ETag, "W/") {
+		return ""
+	}
+	return v.ETag
+}
+
+// State .part 文件旁的续传记录（sidecar），记录 URL、Validators 与已完成的区间
+type State struct {
+	Url        string     `json:"url"`
+	Validators Validators `json:"validators"`
+	Done       []Range    `json:"done"`
+
+	path     string
+	file     *os.File
+	mu       sync.Mutex
+	lastSave time.Time
+}
+
+// Path 返回 .part 文件对应的 sidecar 路径
+func Path(partFile string) string {
+	return partFile + ".resume.json"
+}
+
+// Open 读取 partFile 的续传记录。记录与 url、v 一致且 partFile 仍存在时返回之前的进度，
+// 否则删除旧记录并返回空进度，调用方应从头下载（截断 partFile）
+func Open(partFile, url string, v Validators) (s *State, resumed bool) {
+	s = &State{Url: url, Validators: v, path: Path(partFile)}
+	bs, err := os.ReadFile(s.path)
+	if err != nil {
+		return s, false
+	}
+	var old State
+	if json.Unmarshal(bs, &old) == nil && old.Url == url && old.Validators.Match(v) && len(old.Done) > 0 {
+		if fi, err := os.Stat(partFile); err == nil && fi.Size() == v.ContentLength {
+			s.Done = old.Done
+			return s, true
+		}
+	}
+	_ = os.Remove(s.path)
+	return s, false
+}
+
+// Attach 设置保存前需要 Sync 的文件，保证 sidecar 记录的区间已落盘
+func (s *State) Attach(f *os.File) {
+	s.mu.Lock()
+	s.file = f
+	s.mu.Unlock()
+}
+
+// Add 记录已写入的区间 [start, end]，距上次保存超过 saveInterval 时写回 sidecar
+func (s *State) Add(start, end int64) {
+	if end < start {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Done = merge(append(s.Done, Range{start, end}))
+	if time.Since(s.lastSave) >= saveInterval {
+		_ = s.save()
+	}
+}
+
+// Completed 已完成的字节数
+func (s *State) Completed() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for _, r := range s.Done {
+		n += r.End - r.Start + 1
+	}
+	return n
+}
+
+// Missing 返回尚未完成的区间
+func (s *State) Missing() []Range {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var list []Range
+	var next int64
+	for _, r := range s.Done {
+		if r.Start > next {
+			list = append(list, Range{next, r.Start - 1})
+		}
+		next = r.End + 1
+	}
+	if size := s.Validators.ContentLength; next < size {
+		list = append(list, Range{next, size - 1})
+	}
+	return list
+}
+
+// Save 写回 sidecar（先写临时文件再 rename）
+func (s *State) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save()
+}
+
+// Remove 下载完成后删除 sidecar
+func (s *State) Remove() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := os.Remove(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *State) save() error {
+	s.lastSave = time.Now()
+	if s.file != nil {
+		if err := s.file.Sync(); err != nil {
+			return err
+		}
+	}
+	bs, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(bs)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// Split 将 ranges 切分为不超过 n 份大致相等、且不小于 minSize 的区间
+func Split(ranges []Range, n int, minSize int64) []Range {
+	var total int64
+	for _, r := range ranges {
+		total += r.End - r.Start + 1
+	}
+	if n < 1 {
+		n = 1
+	}
+	size := (total + int64(n) - 1) / int64(n)
+	if size < minSize {
+		size = minSize
+	}
+	var list []Range
+	for _, r := range ranges {
+		for start := r.Start; start <= r.End; start += size {
+			list = append(list, Range{start, min(start+size-1, r.End)})
+		}
+	}
+	return list
+}
+
+// WriterAt 返回从 off 处顺序写入 w 并记录进度的 io.Writer
+func (s *State) WriterAt(w io.WriterAt, off int64) *Writer {
+	return &Writer{s: s, w: w, off: off}
+}
+
+// Writer 见 State.WriterAt
+type Writer struct {
+	s   *State
+	w   io.WriterAt
+	off int64
+}
+
+func (w *Writer) Write(b []byte) (int, error) {
+	n, err := w.w.WriteAt(b, w.off)
+	if n > 0 {
+		w.s.Add(w.off, w.off+int64(n)-1)
+	}
+	w.off += int64(n)
+	return n, err
+}
+
+func merge(list []Range) []Range {
+	sort.Slice(list, func(i, j int) bool { return list[i].Start < list[j].Start })
+	out := list[:0]
+	for _, r := range list {
+		if k := len(out) - 1; k >= 0 && r.Start <= out[k].End+1 {
+			out[k].End = max(out[k].End, r.End)
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
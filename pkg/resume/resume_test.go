@@ -0,0 +1,61 @@
+package resume_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bookget/pkg/resume"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestState(t *testing.T) {
+	part := filepath.Join(t.TempDir(), "a.pdf.part")
+	require.NoError(t, os.WriteFile(part, make([]byte, 100), 0644))
+	v := resume.Validators{ETag: `"abc"`, ContentLength: 100}
+
+	s, resumed := resume.Open(part, "http://x/a.pdf", v)
+	assert.False(t, resumed)
+	s.Add(50, 59)
+	s.Add(0, 9)
+	s.Add(10, 19)
+	assert.Equal(t, []resume.Range{{0, 19}, {50, 59}}, s.Done)
+	assert.Equal(t, []resume.Range{{20, 49}, {60, 99}}, s.Missing())
+	require.NoError(t, s.Save())
+
+	s, resumed = resume.Open(part, "http://x/a.pdf", v)
+	assert.True(t, resumed)
+	assert.Equal(t, int64(30), s.Completed())
+
+	// ETag 变化时从头下载，旧记录被删除
+	v.ETag = `"def"`
+	s, resumed = resume.Open(part, "http://x/a.pdf", v)
+	assert.False(t, resumed)
+	assert.Empty(t, s.Done)
+	assert.NoFileExists(t, resume.Path(part))
+}
+
+func TestSplit(t *testing.T) {
+	list := resume.Split([]resume.Range{{0, 9}, {20, 49}}, 4, 1)
+	assert.Equal(t, []resume.Range{{0, 9}, {20, 29}, {30, 39}, {40, 49}}, list)
+	assert.Len(t, resume.Split([]resume.Range{{0, 99}}, 8, 60), 2)
+}
+
+func TestMatch(t *testing.T) {
+	v := resume.Validators{ETag: `"abc"`, LastModified: "Mon, 01 Jan 2024 00:00:00 GMT", ContentLength: 100}
+	assert.True(t, v.Match(v))
+	assert.Equal(t, `"abc"`, v.IfRange())
+
+	// 缺少校验值时无法确认文件未变化
+	assert.False(t, v.Match(resume.Validators{ContentLength: 100}))
+	assert.False(t, resume.Validators{ContentLength: 100}.Match(resume.Validators{ContentLength: 100}))
+
+	// 弱 ETag 不能作为依据，退回 Last-Modified
+	weak := resume.Validators{ETag: `W/"abc"`, ContentLength: 100}
+	assert.False(t, weak.Match(weak))
+	assert.Empty(t, weak.IfRange())
+	weak.LastModified = v.LastModified
+	assert.True(t, weak.Match(weak))
+	assert.Equal(t, v.LastModified, weak.IfRange())
+}
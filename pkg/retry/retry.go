@@ -0,0 +1,194 @@
+package retry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Class 失败分类
+type Class int
+
+const (
+	Transient Class = iota // 超时、连接重置、5xx、429 等，退避后重试
+	Auth                   // 401/403 或人机验证页面，需要刷新 cookie 后重试
+	Permanent              // 404/410 等，不再重试
+)
+
+func (c Class) String() string {
+	switch c {
+	case Transient:
+		return "transient"
+	case Auth:
+		return "auth"
+	}
+	return "permanent"
+}
+
+// StatusError 服务器返回的非成功状态码
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("服务器返回错误状态码: %d %s", e.Code, http.StatusText(e.Code))
+}
+
+// Status 返回状态码对应的错误
+func Status(code int) error {
+	return &StatusError{Code: code}
+}
+
+// ErrChallenge 返回的是人机验证或登录页面而不是所需的内容
+var ErrChallenge = errors.New("服务器返回了人机验证/登录页面")
+
+// Error 重试结束后的最终错误，记录分类与尝试次数
+type Error struct {
+	Class    Class
+	Attempts int
+	Err      error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v (%s, 尝试 %d 次)", e.Err, e.Class, e.Attempts)
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Classify 判断错误类别，状态码以外的错误（超时、连接重置等）视为 Transient
+func Classify(err error) Class {
+	var re *Error
+	if errors.As(err, &re) {
+		return re.Class
+	}
+	if errors.Is(err, context.Canceled) {
+		return Permanent
+	}
+	if errors.Is(err, ErrChallenge) {
+		return Auth
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return ClassifyStatus(se.Code)
+	}
+	// 超时、连接重置、响应不完整等网络错误
+	return Transient
+}
+
+// ClassifyStatus 按 HTTP 状态码分类
+func ClassifyStatus(code int) Class {
+	switch {
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return Auth
+	case code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500:
+		return Transient
+	}
+	return Permanent
+}
+
+// challengeMarks 常见人机验证/WAF 页面的特征
+var challengeMarks = [][]byte{
+	[]byte("captcha"),
+	[]byte("challenge-platform"),
+	[]byte("cf-chl-"),
+	[]byte("awsWafCookieDomainList"),
+	[]byte("真人验证"),
+	[]byte("安全验证"),
+}
+
+// IsChallenge 判断期望二进制内容时返回的 HTML 是否为人机验证页面，body 只需前几 KB
+func IsChallenge(contentType string, body []byte) bool {
+	if !strings.Contains(strings.ToLower(contentType), "html") &&
+		!bytes.HasPrefix(bytes.TrimSpace(body), []byte("<")) {
+		return false
+	}
+	lower := bytes.ToLower(body)
+	for _, m := range challengeMarks {
+		if bytes.Contains(lower, bytes.ToLower(m)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy 重试策略：Transient 指数退避（带抖动）后重试，Auth 调用 OnAuth（如等待新 cookie）后重试，
+// Permanent 立即返回
+type Policy struct {
+	Attempts int           // 总尝试次数，至少 1
+	Base     time.Duration // 第一次退避时间
+	Max      time.Duration // 退避上限
+	OnAuth   func(ctx context.Context)
+}
+
+// New 返回 attempts 次尝试的默认策略
+func New(attempts int) *Policy {
+	return &Policy{Attempts: attempts, Base: time.Second, Max: 30 * time.Second}
+}
+
+// WithAuth 设置遇到 401/403、人机验证页面时的处理，如打开浏览器等待新 cookie
+func (p *Policy) WithAuth(fn func(ctx context.Context)) *Policy {
+	p.OnAuth = fn
+	return p
+}
+
+// Do 执行 fn 直到成功、遇到不可重试的错误或用完次数；失败时返回 *Error
+func (p *Policy) Do(ctx context.Context, fn func(attempt int) error) error {
+	attempts := max(p.Attempts, 1)
+	var err error
+	var class Class
+	for attempt := 1; ; attempt++ {
+		if err = fn(attempt); err == nil {
+			return nil
+		}
+		class = Classify(err)
+		if ctx.Err() != nil {
+			return &Error{Class: Permanent, Attempts: attempt, Err: err}
+		}
+		var re *Error
+		if errors.As(err, &re) && class != Auth {
+			return err // 内层已按策略重试过，不再叠加
+		}
+		if attempt >= attempts || class == Permanent || class == Auth && p.OnAuth == nil {
+			if re != nil {
+				return err
+			}
+			return &Error{Class: class, Attempts: attempt, Err: err}
+		}
+		if class == Auth {
+			p.OnAuth(ctx)
+			continue
+		}
+		if serr := sleep(ctx, Backoff(attempt, p.Base, p.Max)); serr != nil {
+			return &Error{Class: Permanent, Attempts: attempt, Err: err}
+		}
+	}
+}
+
+// Backoff 第 attempt 次失败后的等待时间：base*2^(attempt-1)，不超过 max，取其一半加随机抖动
+func Backoff(attempt int, base, max time.Duration) time.Duration {
+	d := base
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	d = min(d, max)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,81 @@
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"bookget/pkg/retry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassify(t *testing.T) {
+	assert.Equal(t, retry.Transient, retry.Classify(io.ErrUnexpectedEOF))
+	assert.Equal(t, retry.Transient, retry.Classify(retry.Status(503)))
+	assert.Equal(t, retry.Transient, retry.Classify(retry.Status(429)))
+	assert.Equal(t, retry.Auth, retry.Classify(fmt.Errorf("page: %w", retry.Status(403))))
+	assert.Equal(t, retry.Auth, retry.Classify(retry.ErrChallenge))
+	assert.Equal(t, retry.Permanent, retry.Classify(retry.Status(404)))
+	assert.Equal(t, retry.Permanent, retry.Classify(retry.Status(410)))
+	assert.Equal(t, retry.Permanent, retry.Classify(context.Canceled))
+}
+
+func TestIsChallenge(t *testing.T) {
+	assert.True(t, retry.IsChallenge("text/html; charset=utf-8", []byte(`<html><div class="g-recaptcha">`)))
+	assert.True(t, retry.IsChallenge("", []byte(`<!DOCTYPE html><title>请完成真人验证</title>`)))
+	assert.False(t, retry.IsChallenge("text/html", []byte(`<html><body>目录</body></html>`)))
+	assert.False(t, retry.IsChallenge("image/jpeg", []byte{0xff, 0xd8, 0xff}))
+}
+
+func TestPolicy(t *testing.T) {
+	p := &retry.Policy{Attempts: 3, Base: time.Millisecond, Max: 5 * time.Millisecond}
+	ctx := context.Background()
+
+	// Transient 重试后成功
+	calls := 0
+	err := p.Do(ctx, func(int) error {
+		calls++
+		if calls < 3 {
+			return retry.Status(500)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	// Permanent 不重试，返回分类与次数
+	calls = 0
+	err = p.Do(ctx, func(int) error {
+		calls++
+		return retry.Status(404)
+	})
+	var re *retry.Error
+	assert.True(t, errors.As(err, &re))
+	assert.Equal(t, retry.Permanent, re.Class)
+	assert.Equal(t, 1, calls)
+
+	// Auth 调用 OnAuth 后重试
+	refreshed := 0
+	calls = 0
+	err = p.WithAuth(func(context.Context) { refreshed++ }).Do(ctx, func(int) error {
+		calls++
+		if calls == 1 {
+			return retry.Status(401)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, refreshed)
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 1; attempt < 10; attempt++ {
+		d := retry.Backoff(attempt, time.Second, 8*time.Second)
+		want := min(time.Second<<(attempt-1), 8*time.Second)
+		assert.GreaterOrEqual(t, d, want/2)
+		assert.LessOrEqual(t, d, want)
+	}
+}
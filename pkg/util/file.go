@@ -36,6 +36,36 @@ func FileExist(path string) bool {
 	return false
 }
 
+// WriteFileAtomic 先写入同目录下的临时文件再 rename，中断时不会留下不完整的目标文件
+func WriteFileAtomic(name string, data []byte, perm os.FileMode) error {
+	return WriteFileAtomicFunc(name, perm, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// WriteFileAtomicFunc 同 WriteFileAtomic，内容由 write 写入
+func WriteFileAtomicFunc(name string, perm os.FileMode, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	err = write(tmp)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
+
 func FileWrite(b []byte, filename string) (err error) {
 	if len(b) <= 0 {
 		return nil
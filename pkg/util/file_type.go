@@ -2,7 +2,7 @@ package util
 
 import (
 	"bookget/config"
-	"crypto/tls"
+	"bookget/pkg/httpclient"
 	"log"
 	"net/http"
 	"path/filepath"
@@ -28,7 +28,7 @@ var (
 	rangeRegex = regexp.MustCompile(`$(\d+)-(\d+)$`)
 )
 
-func GetHeaderContentType(sUrl string) string {
+func GetHeaderContentType(sUrl string, c *config.Input) string {
 	// 检查缓存
 	if cached, ok := contentTypeCache.Load(sUrl); ok {
 		return cached.(string)
@@ -45,7 +45,7 @@ func GetHeaderContentType(sUrl string) string {
 	}
 
 	// 3. 最后通过HTTP请求获取Content-Type
-	return determineContentTypeByRequest(sUrl)
+	return determineContentTypeByRequest(sUrl, c)
 }
 
 func hasJSONExtension(url string) bool {
@@ -57,15 +57,8 @@ func isRangeFormat(url string) bool {
 	return rangeRegex.MatchString(url)
 }
 
-func determineContentTypeByRequest(url string) string {
-	// 创建一次性使用的HTTP客户端
-	client := &http.Client{
-		Timeout: config.Conf.Timeout * time.Second,
-		Transport: &http.Transport{
-			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
-			DisableKeepAlives: true,
-		},
-	}
+func determineContentTypeByRequest(url string, c *config.Input) string {
+	client := httpclient.New(httpclient.Options{Timeout: c.Timeout * time.Second})
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -73,7 +66,7 @@ func determineContentTypeByRequest(url string) string {
 		return "bookget"
 	}
 
-	req.Header.Set("User-Agent", config.Conf.UserAgent)
+	req.Header.Set("User-Agent", c.UserAgent)
 	req.Header.Set("Range", "bytes=0-0") // 只请求头信息
 
 	resp, err := client.Do(req)
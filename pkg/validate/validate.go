@@ -0,0 +1,292 @@
+package validate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+
+	"bookget/pkg/retry"
+)
+
+// maxDecodePixels 超过此像素数的图片只检查结构与结束标记，不完整解码，避免并发时占用过多内存
+const maxDecodePixels = 40 << 20
+
+// sniffLen 判断 HTML/JSON 时读取的开头字节数
+const sniffLen = 1024
+
+// Error 下载的内容不是有效的页面，如 HTML 错误页、被截断的图片。重试时按 Transient 处理
+type Error struct {
+	Reason string
+	Err    error // 如 retry.ErrChallenge，决定重试时的分类
+}
+
+func (e *Error) Error() string {
+	return "页面内容无效: " + e.Reason
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+func invalid(format string, a ...any) error {
+	return &Error{Reason: fmt.Sprintf(format, a...)}
+}
+
+// textExts 本身就是文本的扩展名，不做内容检查
+var textExts = map[string]bool{
+	".html": true, ".htm": true, ".json": true, ".xml": true, ".txt": true, ".csv": true,
+}
+
+// imageExts 期望为图片的扩展名
+var imageExts = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".tif": true, ".tiff": true,
+	".jp2": true, ".j2k": true, ".gif": true, ".webp": true, ".bmp": true,
+}
+
+// File 检查已下载的文件，ext 为最终保存的扩展名（文件本身可能是 .part）
+func File(path, ext, contentType string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return Check(f, fi.Size(), ext, contentType)
+}
+
+// Bytes 检查内存中的页面内容
+func Bytes(data []byte, ext, contentType string) error {
+	return Check(bytes.NewReader(data), int64(len(data)), ext, contentType)
+}
+
+// Check 检查页面内容：Content-Type 与扩展名是否相符，是否为 HTML/JSON，
+// JPEG/PNG 完整解码，JPEG/PNG/TIFF/PDF 等文件头与结束标记是否完整
+func Check(r io.ReaderAt, size int64, ext, contentType string) error {
+	ext = strings.ToLower(ext)
+	if ext != "" && ext[0] != '.' {
+		ext = "." + ext
+	}
+	if textExts[ext] {
+		return nil
+	}
+	if size == 0 {
+		return invalid("文件为空")
+	}
+
+	head := make([]byte, min(size, sniffLen))
+	if _, err := r.ReadAt(head, 0); err != nil && err != io.EOF {
+		return err
+	}
+	if err := sniffText(head, contentType, ext); err != nil {
+		return err
+	}
+	if err := checkContentType(contentType, ext); err != nil {
+		return err
+	}
+
+	switch {
+	case bytes.HasPrefix(head, []byte{0xff, 0xd8, 0xff}):
+		return checkJPEG(r, size)
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return checkPNG(r, size)
+	case bytes.HasPrefix(head, []byte("II*\x00")), bytes.HasPrefix(head, []byte("MM\x00*")):
+		return checkTIFF(r, size)
+	case bytes.HasPrefix(head, []byte("II+\x00")), bytes.HasPrefix(head, []byte("MM\x00+")):
+		return nil // BigTIFF 只检查文件头
+	case bytes.Contains(head, []byte("%PDF-")):
+		return checkTail(r, size, 2048, []byte("%%EOF"), "PDF 缺少 %%EOF，文件不完整")
+	case bytes.HasPrefix(head, []byte("\x00\x00\x00\x0cjP  \r\n\x87\n")), bytes.HasPrefix(head, []byte{0xff, 0x4f, 0xff, 0x51}):
+		return checkTail(r, size, 64, []byte{0xff, 0xd9}, "JPEG 2000 缺少结束标记，文件不完整")
+	case bytes.HasPrefix(head, []byte("GIF8")):
+		return checkTail(r, size, 1, []byte{0x3b}, "GIF 缺少结束标记，文件不完整")
+	case len(head) >= 12 && bytes.HasPrefix(head, []byte("RIFF")) && string(head[8:12]) == "WEBP":
+		if n := int64(binary.LittleEndian.Uint32(head[4:8])) + 8; n > size {
+			return invalid("WebP 文件不完整：%d/%d 字节", size, n)
+		}
+		return nil
+	}
+	if ext == ".pdf" {
+		return invalid("不是 PDF 文件")
+	}
+	return nil
+}
+
+// sniffText 期望二进制内容时拒绝 HTML/JSON/纯文本，人机验证页面返回 retry.ErrChallenge
+func sniffText(head []byte, contentType, ext string) error {
+	trimmed := bytes.TrimLeft(head, " \t\r\n\ufeff")
+	if retry.IsChallenge(contentType, head) {
+		return &Error{Reason: "返回的是人机验证/登录页面", Err: retry.ErrChallenge}
+	}
+	detected := http.DetectContentType(head)
+	switch {
+	case strings.HasPrefix(detected, "text/html"), strings.HasPrefix(detected, "text/xml") && ext != ".svg":
+		return invalid("返回的是 HTML/XML 页面")
+	case len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && strings.HasPrefix(detected, "text/plain"):
+		return invalid("返回的是 JSON")
+	case strings.HasPrefix(detected, "text/plain") && (imageExts[ext] || ext == ".pdf"):
+		return invalid("返回的是文本")
+	}
+	return nil
+}
+
+// checkContentType 服务器声明的类型须与扩展名相符；未声明或为 octet-stream 时以内容为准
+func checkContentType(contentType, ext string) error {
+	if contentType == "" {
+		return nil
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+	switch mt {
+	case "application/octet-stream", "binary/octet-stream", "application/force-download", "application/download":
+		return nil
+	}
+	switch {
+	case imageExts[ext] && !strings.HasPrefix(mt, "image/"):
+		return invalid("Content-Type %s 与扩展名 %s 不符", mt, ext)
+	case ext == ".pdf" && mt != "application/pdf" && mt != "application/x-pdf":
+		return invalid("Content-Type %s 与扩展名 %s 不符", mt, ext)
+	}
+	return nil
+}
+
+func checkJPEG(r io.ReaderAt, size int64) error {
+	if err := checkTail(r, size, 1024, []byte{0xff, 0xd9}, "JPEG 缺少 EOI 结束标记，文件不完整"); err != nil {
+		return err
+	}
+	return decode(r, size, jpeg.DecodeConfig, jpeg.Decode, "JPEG")
+}
+
+func checkPNG(r io.ReaderAt, size int64) error {
+	if err := checkTail(r, size, 64, []byte("IEND"), "PNG 缺少 IEND，文件不完整"); err != nil {
+		return err
+	}
+	return decode(r, size, png.DecodeConfig, png.Decode, "PNG")
+}
+
+// decode 完整解码，能发现数据中间的损坏；像素过多时只读取图片信息
+func decode(r io.ReaderAt, size int64, config func(io.Reader) (image.Config, error),
+	full func(io.Reader) (image.Image, error), name string) error {
+	cfg, err := config(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return invalid("%s 解码失败: %v", name, err)
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxDecodePixels {
+		return nil
+	}
+	if _, err = full(io.NewSectionReader(r, 0, size)); err != nil {
+		return invalid("%s 解码失败: %v", name, err)
+	}
+	return nil
+}
+
+// checkTail 在文件末尾 n 字节内查找结束标记（允许其后有少量填充）
+func checkTail(r io.ReaderAt, size, n int64, mark []byte, reason string) error {
+	n = min(max(n, int64(len(mark))), size)
+	tail := make([]byte, n)
+	if _, err := r.ReadAt(tail, size-n); err != nil && err != io.EOF {
+		return err
+	}
+	if !bytes.Contains(tail, mark) {
+		return invalid("%s", reason)
+	}
+	return nil
+}
+
+// TIFF 中记录图像数据位置的标签
+const (
+	tagStripOffsets    = 273
+	tagStripByteCounts = 279
+	tagTileOffsets     = 324
+	tagTileByteCounts  = 325
+)
+
+// checkTIFF 读取第一个 IFD，检查所有条带/瓦片的数据都在文件范围内
+func checkTIFF(r io.ReaderAt, size int64) error {
+	var order binary.ByteOrder = binary.LittleEndian
+	hdr := make([]byte, 8)
+	if _, err := r.ReadAt(hdr, 0); err != nil {
+		return invalid("TIFF 文件头不完整")
+	}
+	if hdr[0] == 'M' {
+		order = binary.BigEndian
+	}
+	ifd := int64(order.Uint32(hdr[4:]))
+	cnt := make([]byte, 2)
+	if _, err := r.ReadAt(cnt, ifd); err != nil {
+		return invalid("TIFF 目录不完整")
+	}
+	entries := make([]byte, 12*int(order.Uint16(cnt)))
+	if _, err := r.ReadAt(entries, ifd+2); err != nil {
+		return invalid("TIFF 目录不完整")
+	}
+
+	values := map[uint16][]int64{}
+	for i := 0; i+12 <= len(entries); i += 12 {
+		e := entries[i : i+12]
+		tag := order.Uint16(e)
+		switch tag {
+		case tagStripOffsets, tagStripByteCounts, tagTileOffsets, tagTileByteCounts:
+		default:
+			continue
+		}
+		v, err := tiffValues(r, size, order, e)
+		if err != nil {
+			return err
+		}
+		values[tag] = v
+	}
+	offsets, counts := values[tagStripOffsets], values[tagStripByteCounts]
+	if len(offsets) == 0 {
+		offsets, counts = values[tagTileOffsets], values[tagTileByteCounts]
+	}
+	if len(offsets) == 0 || len(offsets) != len(counts) {
+		return invalid("TIFF 缺少图像数据")
+	}
+	for i := range offsets {
+		if offsets[i]+counts[i] > size {
+			return invalid("TIFF 文件不完整：数据需要 %d 字节，实际 %d 字节", offsets[i]+counts[i], size)
+		}
+	}
+	return nil
+}
+
+// tiffValues 读取 SHORT/LONG 类型的标签值
+func tiffValues(r io.ReaderAt, size int64, order binary.ByteOrder, e []byte) ([]int64, error) {
+	typ, n := order.Uint16(e[2:]), int64(order.Uint32(e[4:]))
+	width := int64(4)
+	if typ == 3 { // SHORT
+		width = 2
+	} else if typ != 4 { // LONG
+		return nil, invalid("TIFF 标签类型 %d 不支持", typ)
+	}
+	if n*width > size {
+		return nil, invalid("TIFF 目录损坏")
+	}
+	data := e[8:12]
+	if n*width > 4 {
+		data = make([]byte, n*width)
+		if _, err := r.ReadAt(data, int64(order.Uint32(e[8:]))); err != nil {
+			return nil, invalid("TIFF 目录不完整")
+		}
+	}
+	v := make([]int64, n)
+	for i := range v {
+		if width == 2 {
+			v[i] = int64(order.Uint16(data[2*i:]))
+		} else {
+			v[i] = int64(order.Uint32(data[4*i:]))
+		}
+	}
+	return v, nil
+}
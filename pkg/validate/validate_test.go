@@ -0,0 +1,95 @@
+package validate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"bookget/pkg/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 4), uint8(y * 5), 128, 255})
+		}
+	}
+	return img
+}
+
+// testTIFF 一个条带、未压缩的最小 TIFF
+func testTIFF(data []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("II*\x00")
+	_ = binary.Write(&b, binary.LittleEndian, uint32(8))
+	_ = binary.Write(&b, binary.LittleEndian, uint16(2))
+	entry := func(tag, typ uint16, n, v uint32) {
+		for _, x := range []any{tag, typ, n, v} {
+			_ = binary.Write(&b, binary.LittleEndian, x)
+		}
+	}
+	entry(tagStripOffsets, 4, 1, 8+2+2*12+4)
+	entry(tagStripByteCounts, 4, 1, uint32(len(data)))
+	_ = binary.Write(&b, binary.LittleEndian, uint32(0))
+	b.Write(data)
+	return b.Bytes()
+}
+
+func TestCheck(t *testing.T) {
+	var jpg, pngData bytes.Buffer
+	require.NoError(t, jpeg.Encode(&jpg, testImage(), nil))
+	require.NoError(t, png.Encode(&pngData, testImage()))
+	tiff := testTIFF(make([]byte, 100))
+
+	valid := []struct {
+		data []byte
+		ext  string
+		ct   string
+	}{
+		{jpg.Bytes(), ".jpg", "image/jpeg"},
+		{jpg.Bytes(), ".jpg", "application/octet-stream"},
+		{pngData.Bytes(), ".png", ""},
+		{pngData.Bytes(), ".jpg", "image/png"}, // 按内容检查，不要求扩展名一致
+		{tiff, ".tif", "image/tiff"},
+		{[]byte("%PDF-1.7\n1 0 obj\n<<>>\nendobj\ntrailer\n%%EOF\n"), ".pdf", "application/pdf"},
+		{[]byte(`{"a":1}`), ".json", "application/json"},
+	}
+	for _, c := range valid {
+		assert.NoError(t, Bytes(c.data, c.ext, c.ct), c.ext+" "+c.ct)
+	}
+
+	jb := jpg.Bytes()
+	invalidCases := []struct {
+		data []byte
+		ext  string
+		ct   string
+	}{
+		{jb[:len(jb)-200], ".jpg", "image/jpeg"},                        // 截断，缺少 EOI
+		{append(append([]byte{}, jb[:600]...), 0xff, 0xd9), ".jpg", ""}, // 数据不完整，解码失败
+		{pngData.Bytes()[:pngData.Len()-20], ".png", "image/png"},
+		{tiff[:len(tiff)-10], ".tif", ""},
+		{[]byte("%PDF-1.7\n1 0 obj\n<<>>\n"), ".pdf", ""},
+		{[]byte("<!DOCTYPE html><html><body>Not Found</body></html>"), ".jpg", "text/html"},
+		{[]byte(`{"error":"image not available"}`), ".jpg", "application/json"},
+		{jb, ".jpg", "text/html"}, // Content-Type 与扩展名不符
+		{[]byte{}, ".jpg", ""},
+	}
+	for i, c := range invalidCases {
+		err := Bytes(c.data, c.ext, c.ct)
+		var ve *Error
+		assert.True(t, errors.As(err, &ve), "case %d: %v", i, err)
+		assert.Equal(t, retry.Transient, retry.Classify(err), "case %d", i)
+	}
+
+	err := Bytes([]byte(`<html><div class="g-recaptcha"></div></html>`), ".jpg", "text/html")
+	assert.ErrorIs(t, err, retry.ErrChallenge)
+	assert.Equal(t, retry.Auth, retry.Classify(err))
+}